@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// handleAdminDomains lists every domain with how much it has and when it
+// was last used (GET), and acts on one with its name and an action of
+// public, private, reset, with an optional new password, or delete (POST).
+// Deleting without a confirm token is a dry run, which is also given to a
+// GET with the domain and the action delete. The admin token is given as
+// ?token= or as a bearer token.
+func (tr *TemplateRender) handleAdminDomains(w http.ResponseWriter, r *http.Request) (err error) {
+	token, ok := checkAdminToken(w, r)
+	if !ok {
+		return
+	}
+	if r.Method == "GET" && r.FormValue("action") == "delete" {
+		plan, errPlan := fs.PlanDeleteDomain(utils.NormalizeDomain(r.FormValue("domain")))
+		if errPlan != nil {
+			return writeJSON(w, http.StatusBadRequest, map[string]interface{}{"success": false, "message": errPlan.Error()})
+		}
+		return writeJSON(w, http.StatusOK, map[string]interface{}{"success": true, "plan": plan})
+	}
+	if r.Method == "GET" {
+		domains, errList := fs.ListDomains()
+		if errList != nil {
+			return writeJSON(w, http.StatusInternalServerError, map[string]interface{}{"success": false, "message": errList.Error()})
+		}
+		return writeJSON(w, http.StatusOK, map[string]interface{}{"success": true, "domains": domains})
+	}
+	if r.Method != "POST" {
+		http.Error(w, "list domains with a GET, or change one with a POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var message string
+	domain := utils.NormalizeDomain(r.FormValue("domain"))
+	if domain == "public" {
+		err = errors.New("cannot modify public")
+	} else if _, _, errGet := fs.GetDomainFromName(domain); errGet != nil {
+		err = fmt.Errorf("domain %s does not exist", domain)
+	}
+	if err == nil {
+		switch action := r.FormValue("action"); action {
+		case "public", "private":
+			err = fs.UpdateDomain(domain, "", action == "public")
+			message = domain + " is " + action
+			if err == nil {
+				audit(r, "domain.updated", domain, "", "made "+action+" by the admin")
+			}
+		case "reset":
+			err = fs.ResetDomainKeys(domain, r.FormValue("password"))
+			message = "everybody was logged out of " + domain
+			if r.FormValue("password") != "" {
+				message += ", which has a new password"
+			}
+			if err == nil {
+				audit(r, "domain.reset", domain, "", message)
+			}
+		case "delete":
+			confirm := strings.TrimSpace(r.FormValue("confirm"))
+			var plan db.DeletePlan
+			plan, err = fs.PlanDeleteDomain(domain)
+			if err == nil && confirm == "" {
+				message = fmt.Sprintf("deleting %s removes %d pages, %d in the trash and %d bytes of history, and logs out %d keys; confirm with the token %s",
+					domain, plan.Pages, plan.Trash, plan.PageBytes, plan.Keys, plan.Token)
+				break
+			}
+			if err == nil && confirm != plan.Token {
+				err = errors.New("the domain changed since the dry run, check it again")
+			}
+			var file string
+			if err == nil {
+				file, err = exportDomain(domain)
+			}
+			if err == nil {
+				err = fs.DeleteDomain(domain, confirm)
+			}
+			message = "deleted " + domain + ", which was exported to " + file
+			if err == nil {
+				audit(r, "domain.deleted", domain, "", file)
+				sendEvent("domain.deleted", "deleted "+domain, map[string]string{
+					"domain": domain,
+					"file":   file,
+				})
+			}
+		default:
+			err = errors.New("action should be public, private, reset or delete")
+		}
+	}
+	if err != nil {
+		message = err.Error()
+	}
+
+	// the form of the report goes back to the report
+	if r.FormValue("token") != "" {
+		http.Redirect(w, r, "/admin/report?token="+url.QueryEscape(token)+"&m="+url.QueryEscape(message), 302)
+		return nil
+	}
+	if err != nil {
+		return writeJSON(w, http.StatusBadRequest, map[string]interface{}{"success": false, "message": message})
+	}
+	return writeJSON(w, http.StatusOK, map[string]interface{}{"success": true, "message": message})
+}
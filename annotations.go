@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// AnnotationRequest is the body of a request to annotate a page
+type AnnotationRequest struct {
+	db.Annotation
+	Domain    string `json:"domain"`
+	DomainKey string `json:"domain_key,omitempty"`
+	Page      string `json:"page"`
+}
+
+// canRead returns whether the domain can be read with the key, or with
+// the keys in the cookie, which can be the keys of readers
+func (tr *TemplateRender) canRead(domain, domainKey string) bool {
+	if _, ispublic, err := fs.GetDomainFromName(domain); err == nil && ispublic {
+		return true
+	}
+	if domainKey == "" {
+		domainKey = tr.DomainKeys[domain]
+	}
+	if domainFound, err := fs.CheckReadKey(domainKey); err == nil && domainFound == domain {
+		return true
+	}
+	return tr.canWrite(domain, domainKey)
+}
+
+// canWrite returns whether the domain can be edited with the key, or
+// with the keys in the cookie
+func (tr *TemplateRender) canWrite(domain, domainKey string) bool {
+	if domainKey == "" {
+		domainKey = tr.DomainKeys[domain]
+	}
+	return checkDomainKey(domain, domainKey)
+}
+
+// handleAnnotations lists (GET), adds (POST) and removes (DELETE) the
+// annotations of a page. Anyone who can read a page can annotate it.
+func (tr *TemplateRender) handleAnnotations(w http.ResponseWriter, r *http.Request) (err error) {
+	var req AnnotationRequest
+	if r.Method == "POST" {
+		err = json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			return writeJSON(w, http.StatusBadRequest, Payload{Message: err.Error()})
+		}
+	} else {
+		req.Domain = r.FormValue("domain")
+		req.DomainKey = r.FormValue("domain_key")
+		req.Page = r.FormValue("page")
+	}
+	req.Domain = utils.NormalizeDomain(req.Domain)
+	req.Page = utils.NormalizeSlug(req.Page)
+	if req.Domain == "" {
+		req.Domain = "public"
+	}
+	if !tr.canRead(req.Domain, req.DomainKey) {
+		return writeJSON(w, http.StatusForbidden, Payload{Message: "need to be logged in"})
+	}
+
+	switch r.Method {
+	case "POST":
+		req.Annotation.FileID = req.Page
+		annotation, errAdd := fs.AddAnnotation(req.Domain, req.Annotation)
+		if errAdd != nil {
+			return writeJSON(w, http.StatusBadRequest, Payload{Message: errAdd.Error()})
+		}
+		return writeJSON(w, http.StatusOK, annotation)
+	case "DELETE":
+		// only those who can edit can remove annotations
+		if !tr.canWrite(req.Domain, req.DomainKey) {
+			return writeJSON(w, http.StatusForbidden, Payload{Message: "need to be logged in"})
+		}
+		id, errID := strconv.Atoi(r.FormValue("id"))
+		if errID != nil {
+			return writeJSON(w, http.StatusBadRequest, Payload{Message: errID.Error()})
+		}
+		err = fs.DeleteAnnotation(req.Domain, id)
+		if err != nil {
+			return writeJSON(w, http.StatusInternalServerError, Payload{Message: err.Error()})
+		}
+		return writeJSON(w, http.StatusOK, Payload{Success: true})
+	default:
+		annotations, errGet := fs.GetAnnotations(req.Page, req.Domain)
+		if errGet != nil {
+			return writeJSON(w, http.StatusNotFound, Payload{Message: errGet.Error()})
+		}
+		return writeJSON(w, http.StatusOK, annotations)
+	}
+}
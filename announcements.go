@@ -0,0 +1,145 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/schollz/rwtxt/src/db"
+)
+
+// announcementsRefresh is how long the announcements are kept before they
+// are loaded again, so that every page does not read them
+const announcementsRefresh = time.Minute
+
+// announcements are the announcements that have not ended, as they were
+// last loaded
+var announcements struct {
+	sync.Mutex
+	list   []db.Announcement
+	loaded time.Time
+}
+
+// announcementTimes are the layouts of the start and end of announcements
+// that are understood, the ones without a zone in the zone of the server
+var announcementTimes = []string{
+	time.RFC3339,
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// activeAnnouncements returns the announcements that are shown now
+func activeAnnouncements() (active []db.Announcement) {
+	announcements.Lock()
+	defer announcements.Unlock()
+	if time.Since(announcements.loaded) > announcementsRefresh {
+		list, err := fs.GetAnnouncements()
+		if err != nil {
+			log.Debug(err)
+		} else {
+			announcements.list = list
+			announcements.loaded = time.Now()
+		}
+	}
+	now := time.Now()
+	for _, a := range announcements.list {
+		if a.Active(now) {
+			active = append(active, a)
+		}
+	}
+	return
+}
+
+// forgetAnnouncements has the announcements loaded again, after they are
+// changed
+func forgetAnnouncements() {
+	announcements.Lock()
+	announcements.loaded = time.Time{}
+	announcements.Unlock()
+}
+
+// parseAnnouncementTime returns the start or end of an announcement, which
+// is zero when it is not given
+func parseAnnouncementTime(value string) (t time.Time, err error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return
+	}
+	for _, layout := range announcementTimes {
+		t, err = time.ParseInLocation(layout, value, time.Local)
+		if err == nil {
+			return
+		}
+	}
+	return t, err
+}
+
+// handleAnnouncements lists the announcements that have not ended (GET),
+// and adds one, with a message, a severity and when it starts and ends,
+// or removes one with action=remove and its id (POST). The admin token is
+// given as ?token= or as a bearer token.
+func (tr *TemplateRender) handleAnnouncements(w http.ResponseWriter, r *http.Request) (err error) {
+	token, ok := checkAdminToken(w, r)
+	if !ok {
+		return
+	}
+	if r.Method == "GET" {
+		list, errList := fs.GetAnnouncements()
+		if errList != nil {
+			return writeJSON(w, http.StatusInternalServerError, map[string]interface{}{"success": false, "message": errList.Error()})
+		}
+		return writeJSON(w, http.StatusOK, map[string]interface{}{"success": true, "announcements": list})
+	}
+	if r.Method != "POST" {
+		http.Error(w, "list announcements with a GET, or change them with a POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var message string
+	var id int
+	if r.FormValue("action") == "remove" {
+		id, err = strconv.Atoi(r.FormValue("id"))
+		if err == nil {
+			err = fs.DeleteAnnouncement(id)
+		}
+		if err == nil {
+			message = "removed the announcement"
+			audit(r, "announcement.removed", "", "", strconv.Itoa(id))
+		}
+	} else {
+		a := db.Announcement{
+			Message:  r.FormValue("message"),
+			Severity: r.FormValue("severity"),
+		}
+		a.Start, err = parseAnnouncementTime(r.FormValue("start"))
+		if err == nil {
+			a.End, err = parseAnnouncementTime(r.FormValue("end"))
+		}
+		if err == nil {
+			id, err = fs.AddAnnouncement(a)
+		}
+		if err == nil {
+			message = "added the announcement"
+			audit(r, "announcement.added", "", "", strconv.Itoa(id)+" "+a.Severity)
+		}
+	}
+	if err != nil {
+		message = err.Error()
+	}
+	forgetAnnouncements()
+
+	// the form of the report goes back to the report
+	if r.FormValue("token") != "" {
+		http.Redirect(w, r, "/admin/report?token="+url.QueryEscape(token)+"&m="+url.QueryEscape(message), 302)
+		return nil
+	}
+	if err != nil {
+		return writeJSON(w, http.StatusBadRequest, map[string]interface{}{"success": false, "message": message})
+	}
+	return writeJSON(w, http.StatusOK, map[string]interface{}{"success": true, "message": message, "id": id})
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/schollz/rwtxt/src/db"
+)
+
+// auditLog is "syslog" or the path of a JSON lines file that the audit log
+// is written to before the database, so that it survives restoring it
+var auditLog string
+
+var auditWriter io.Writer
+var auditLock sync.Mutex
+
+// openAudit opens the syslog or the file to write the audit log to
+func openAudit() (err error) {
+	if auditLog == "" {
+		return
+	}
+	if auditLog == "syslog" {
+		auditWriter, err = openSyslog()
+		return
+	}
+	auditWriter, err = os.OpenFile(auditLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	return
+}
+
+// audit records a security event, first to the syslog or file and then to
+// the database
+func audit(r *http.Request, event, domain, page, detail string) {
+	e := db.AuditEvent{
+		Created: time.Now().UTC(),
+		Event:   event,
+		Domain:  domain,
+		Page:    page,
+		Detail:  detail,
+	}
+	if r != nil {
+		e.Source = remoteIP(r)
+	}
+
+	if auditWriter != nil {
+		b, err := json.Marshal(e)
+		if err == nil {
+			auditLock.Lock()
+			_, err = auditWriter.Write(append(b, '\n'))
+			if f, ok := auditWriter.(*os.File); ok && err == nil {
+				err = f.Sync()
+			}
+			auditLock.Unlock()
+		}
+		if err != nil {
+			log.Errorf("could not write audit log: %s", err.Error())
+		}
+	}
+
+	err := fs.AddAudit(e)
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+// auditSave records a save of a page, which is a delete when it is empty
+func auditSave(r *http.Request, f db.File) {
+	event := "page.saved"
+	if f.Data == "" {
+		event = "page.deleted"
+	}
+	audit(r, event, f.Domain, f.ID, f.Slug)
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/crewjam/saml"
+	"github.com/crewjam/saml/samlsp"
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/utils"
+	ldap "gopkg.in/ldap.v2"
+)
+
+// Account is a user that was authenticated by an auth provider, with the
+// groups they are in
+type Account struct {
+	Name   string
+	Groups []string
+}
+
+// AuthProvider authenticates accounts some other way than with the
+// password of a domain, like with a directory or single sign on
+type AuthProvider interface {
+	// Name is the name of the provider, for the audit log
+	Name() string
+	// Authenticate returns the account that made a request
+	Authenticate(r *http.Request) (account Account, err error)
+}
+
+// authDomains maps the groups of accounts to the domains they can use,
+// like "engineering=eng;cn=support,ou=groups,dc=example,dc=com=support".
+// The group "*" is every account.
+var authDomains string
+
+var ldapAuth *ldapProvider
+var samlAuth *samlProvider
+
+// setupAuth makes the auth providers that were asked for
+func setupAuth(ldapURL, ldapUser, samlIDP, samlURL, samlCert, samlKey, samlGroups string) (err error) {
+	if ldapURL != "" {
+		if !strings.Contains(ldapUser, "%s") {
+			return errors.New("--ldap-user needs a %s for the username")
+		}
+		ldapAuth = &ldapProvider{URL: ldapURL, UserDN: ldapUser}
+		log.Infof("authenticating with %s", ldapURL)
+	}
+	if samlIDP != "" {
+		samlAuth, err = newSAMLProvider(samlIDP, samlURL, samlCert, samlKey, samlGroups)
+		if err != nil {
+			return
+		}
+		log.Infof("authenticating with %s", samlIDP)
+	}
+	return
+}
+
+// accountDomains returns the domains that an account can use, with its
+// role in each. Groups make the account an editor, and a user that was
+// provisioned with the same name has the roles they were given.
+func accountDomains(account Account) (roles map[string]string, userid int, err error) {
+	roles = make(map[string]string)
+	for _, mapping := range strings.Split(authDomains, ";") {
+		i := strings.LastIndex(mapping, "=")
+		if i < 0 {
+			continue
+		}
+		group := strings.TrimSpace(mapping[:i])
+		domain := utils.NormalizeDomain(mapping[i+1:])
+		if group == "" || domain == "" || domain == "public" {
+			continue
+		}
+		for _, g := range append([]string{"*"}, account.Groups...) {
+			if strings.EqualFold(g, group) || strings.EqualFold(groupName(g), group) {
+				roles[domain] = db.RoleEditor
+				break
+			}
+		}
+	}
+
+	user, errUser := fs.GetUser(account.Name)
+	if errUser != nil {
+		return
+	}
+	if !user.Active {
+		err = errors.New(user.Name + " is disabled")
+		return
+	}
+	userid = user.ID
+	for domain, role := range user.Roles {
+		roles[domain] = role
+	}
+	return
+}
+
+// groupName returns the common name of a group that is a distinguished
+// name, like "engineering" for "cn=engineering,ou=groups,dc=example,dc=com"
+func groupName(group string) string {
+	dn, err := ldap.ParseDN(group)
+	if err != nil || len(dn.RDNs) == 0 || len(dn.RDNs[0].Attributes) == 0 {
+		return group
+	}
+	return dn.RDNs[0].Attributes[0].Value
+}
+
+// handleAccountLogin logs an account into every domain it can use, making
+// the domains that do not exist yet
+func (tr *TemplateRender) handleAccountLogin(w http.ResponseWriter, r *http.Request, provider AuthProvider) (err error) {
+	account, err := provider.Authenticate(r)
+	if err != nil {
+		audit(r, "login.failed", tr.Domain, "", provider.Name()+": "+err.Error())
+		tr.Domain = "public"
+		return tr.handleMain(w, r, "could not log in: "+err.Error())
+	}
+	roles, userid, err := accountDomains(account)
+	if err != nil {
+		audit(r, "login.failed", tr.Domain, "", provider.Name()+": "+err.Error())
+		tr.Domain = "public"
+		return tr.handleMain(w, r, err.Error())
+	}
+	if len(roles) == 0 {
+		audit(r, "login.failed", tr.Domain, "", provider.Name()+": "+account.Name+" has no domains")
+		tr.Domain = "public"
+		return tr.handleMain(w, r, account.Name+" does not have any domains")
+	}
+	domains := make([]string, 0, len(roles))
+	for domain := range roles {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+
+	for _, domain := range domains {
+		if _, _, errDomain := fs.GetDomainFromName(domain); errDomain != nil {
+			// nobody knows the password, so the domain is only used
+			// through the provider until someone sets one
+			err = fs.SetDomain(domain, utils.UUID())
+			if err != nil {
+				return tr.handleMain(w, r, err.Error())
+			}
+			audit(r, "domain.created", domain, "", provider.Name()+": "+account.Name)
+		}
+		tr.DomainKeys[domain], err = fs.NewKey(domain, userid, roles[domain])
+		if err != nil {
+			return tr.handleMain(w, r, err.Error())
+		}
+		audit(r, "login.succeeded", domain, "", provider.Name()+": "+account.Name)
+	}
+
+	// go to the domain that was asked for, if it can be used
+	if tr.DomainKeys[tr.Domain] == "" || tr.Domain == "public" {
+		tr.Domain = domains[0]
+	}
+	tr.DomainKey = tr.DomainKeys[tr.Domain]
+	tr.rememberKeys(r.FormValue("remember") == "on")
+	cookie := tr.updateDomainCookie(w, r)
+	http.SetCookie(w, &cookie)
+	http.Redirect(w, r, "/"+tr.Domain, 302)
+	return nil
+}
+
+// userProvider authenticates users that were provisioned with a password
+type userProvider struct{}
+
+// Name returns the name of the provider
+func (p userProvider) Name() string {
+	return "user"
+}
+
+// Authenticate checks the username and password of a login form
+func (p userProvider) Authenticate(r *http.Request) (account Account, err error) {
+	user, err := fs.CheckUser(r.FormValue("username"), r.FormValue("password"))
+	account.Name = user.Name
+	return
+}
+
+// ldapProvider authenticates accounts by binding to an LDAP directory as
+// them, and maps the groups they are a member of to domains
+type ldapProvider struct {
+	// URL is the directory, like ldaps://ldap.example.com
+	URL string
+	// UserDN is the distinguished name of a user, with %s for the
+	// username, like uid=%s,ou=people,dc=example,dc=com
+	UserDN string
+}
+
+// Name returns the name of the provider
+func (p *ldapProvider) Name() string {
+	return "ldap"
+}
+
+// Authenticate binds with the username and password of a login form
+func (p *ldapProvider) Authenticate(r *http.Request) (account Account, err error) {
+	username := strings.TrimSpace(r.FormValue("username"))
+	password := r.FormValue("password")
+	if username == "" || password == "" {
+		// an empty password is an unauthenticated bind, which always works
+		err = errors.New("need a username and password")
+		return
+	}
+
+	u, err := url.Parse(p.URL)
+	if err != nil {
+		return
+	}
+	var l *ldap.Conn
+	if u.Scheme == "ldaps" {
+		host := u.Host
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "636")
+		}
+		l, err = ldap.DialTLS("tcp", host, &tls.Config{ServerName: u.Hostname()})
+	} else {
+		host := u.Host
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "389")
+		}
+		l, err = ldap.Dial("tcp", host)
+	}
+	if err != nil {
+		return
+	}
+	defer l.Close()
+	l.SetTimeout(10 * time.Second)
+
+	dn := fmt.Sprintf(p.UserDN, escapeDN(username))
+	err = l.Bind(dn, password)
+	if err != nil {
+		log.Debug(err)
+		err = errors.New("incorrect username or password")
+		return
+	}
+	result, err := l.Search(ldap.NewSearchRequest(dn, ldap.ScopeBaseObject, ldap.NeverDerefAliases, 1, 10, false,
+		"(objectClass=*)", []string{"memberOf"}, nil))
+	if err != nil {
+		return
+	}
+	account.Name = username
+	for _, entry := range result.Entries {
+		account.Groups = append(account.Groups, entry.GetAttributeValues("memberOf")...)
+	}
+	return
+}
+
+// escapeDN escapes a value to be used in a distinguished name
+func escapeDN(s string) string {
+	var b strings.Builder
+	for i, c := range s {
+		if strings.ContainsRune(`,+"\<>;=`, c) || (i == 0 && (c == '#' || c == ' ')) || (i == len(s)-1 && c == ' ') {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// samlProvider authenticates accounts with a SAML identity provider, and
+// maps the groups in an attribute of the assertion to domains
+type samlProvider struct {
+	middleware *samlsp.Middleware
+	// Groups is the attribute with the groups of the account
+	Groups string
+}
+
+// newSAMLProvider makes a service provider at a url, with the certificate
+// and key that the identity provider knows it by
+func newSAMLProvider(idpMetadata, rootURL, certFile, keyFile, groups string) (p *samlProvider, err error) {
+	keyPair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return
+	}
+	key, ok := keyPair.PrivateKey.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("the saml key needs to be an rsa key")
+	}
+	cert, err := x509.ParseCertificate(keyPair.Certificate[0])
+	if err != nil {
+		return
+	}
+	idpURL, err := url.Parse(idpMetadata)
+	if err != nil {
+		return
+	}
+	var opts samlsp.Options
+	if idpURL.Scheme == "http" || idpURL.Scheme == "https" {
+		opts.IDPMetadataURL = idpURL
+	} else {
+		// the metadata can be a file for identity providers that do not
+		// serve it
+		var b []byte
+		b, err = ioutil.ReadFile(idpMetadata)
+		if err != nil {
+			return
+		}
+		opts.IDPMetadata = &saml.EntityDescriptor{}
+		err = xml.Unmarshal(b, opts.IDPMetadata)
+		if err != nil {
+			return
+		}
+	}
+	root, err := url.Parse(rootURL)
+	if err != nil {
+		return
+	}
+	if root.Host == "" {
+		return nil, errors.New("--saml-url needs to be the url of rwtxt, like https://notes.example.com/")
+	}
+	opts.URL = *root
+	opts.Key = key
+	opts.Certificate = cert
+	opts.CookieSecure = root.Scheme == "https"
+	p = &samlProvider{Groups: groups}
+	p.middleware, err = samlsp.New(opts)
+	return
+}
+
+// Name returns the name of the provider
+func (p *samlProvider) Name() string {
+	return "saml"
+}
+
+// Authenticate reads the account from the assertion the identity provider
+// sent, which the middleware keeps in a signed cookie
+func (p *samlProvider) Authenticate(r *http.Request) (account Account, err error) {
+	token := p.middleware.GetAuthorizationToken(r)
+	if token == nil {
+		err = errors.New("not signed in with saml")
+		return
+	}
+	account.Name = token.StandardClaims.Subject
+	account.Groups = token.Attributes[p.Groups]
+	return
+}
+
+// handleSAML serves the metadata of the service provider, receives
+// assertions and logs the accounts in
+func (tr *TemplateRender) handleSAML(w http.ResponseWriter, r *http.Request) (err error) {
+	tr.Domain = "public"
+	if samlAuth == nil {
+		return tr.handleMain(w, r, "single sign on is not set up")
+	}
+	if r.URL.Path != "/saml/login" {
+		samlAuth.middleware.ServeHTTP(w, r)
+		return
+	}
+	if samlAuth.middleware.GetAuthorizationToken(r) == nil {
+		// go to the identity provider, which comes back to here
+		samlAuth.middleware.RequireAccountHandler(w, r)
+		return
+	}
+	return tr.handleAccountLogin(w, r, samlAuth)
+}
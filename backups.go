@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metricsToken is the bearer token that Prometheus scrapes /metrics with
+var metricsToken string
+
+// backupStale is how old the newest dump can get before the webhook is
+// told that backups are stale
+var backupStale = time.Hour
+
+// backups is what is known about the dumps of the database
+var backups struct {
+	// dumped is when the newest dump that could be read was made
+	dumped time.Time
+	size   int64
+	// validated is when the newest dump was last restored, and whether it
+	// could be
+	validated     time.Time
+	restored      bool
+	restoredPages int
+	staleWarned   bool
+	restoreWarned bool
+	sync.Mutex
+}
+
+// loadBackups starts from the dump that is already next to the database,
+// so that a server that never manages to dump still looks stale
+func loadBackups() {
+	stat, err := os.Stat(dbName + ".sql.gz")
+	backups.Lock()
+	defer backups.Unlock()
+	if err == nil {
+		backups.dumped = stat.ModTime()
+		backups.size = stat.Size()
+	} else {
+		backups.dumped = time.Now()
+	}
+}
+
+// recordDump remembers a dump that was checked
+func recordDump(size int64) {
+	backups.Lock()
+	defer backups.Unlock()
+	backups.dumped = time.Now()
+	backups.size = size
+	backups.staleWarned = false
+}
+
+// validateDump restores the newest dump to make sure that it can be, and
+// sends an event when it cannot, once until it can again
+func validateDump() {
+	pages, err := fs.ValidateDump()
+	backups.Lock()
+	defer backups.Unlock()
+	backups.validated = time.Now()
+	backups.restored = err == nil
+	backups.restoredPages = pages
+	if err == nil {
+		backups.restoreWarned = false
+		return
+	}
+	if !backups.restoreWarned {
+		sendEvent("restore.failed", "could not restore the dump of "+dbName, map[string]string{
+			"file":  dbName + ".sql.gz",
+			"error": err.Error(),
+		})
+		backups.restoreWarned = true
+	}
+}
+
+// checkBackups sends an event when the newest dump is older than
+// backupStale, once until there is a newer dump
+func checkBackups() {
+	if !dumpDatabase || backupStale <= 0 {
+		return
+	}
+	backups.Lock()
+	defer backups.Unlock()
+	age := time.Since(backups.dumped)
+	if age > backupStale && !backups.staleWarned {
+		sendEvent("backup.stale", fmt.Sprintf("the newest dump of %s is %s old", dbName, age.Round(time.Second)), map[string]string{
+			"file":   dbName + ".sql.gz",
+			"dumped": backups.dumped.UTC().Format(time.RFC3339),
+		})
+		backups.staleWarned = true
+	}
+}
+
+// handleMetrics serves the age of the backups, and whether they can be
+// restored, for Prometheus
+func (tr *TemplateRender) handleMetrics(w http.ResponseWriter, r *http.Request) (err error) {
+	if metricsToken == "" {
+		http.Error(w, "metrics are not enabled", http.StatusNotFound)
+		return
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(metricsToken)) != 1 {
+		http.Error(w, "need the metrics token", http.StatusUnauthorized)
+		return
+	}
+
+	backups.Lock()
+	defer backups.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	var b strings.Builder
+	metric := func(name, help string, value interface{}) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n%s %v\n", name, help, name, name, value)
+	}
+	if dumpDatabase {
+		metric("rwtxt_dump_timestamp_seconds", "When the newest dump that could be read was made.", backups.dumped.Unix())
+		metric("rwtxt_dump_age_seconds", "How old the newest dump that could be read is.", int64(time.Since(backups.dumped).Seconds()))
+		metric("rwtxt_dump_size_bytes", "Size of the newest dump.", backups.size)
+		if !backups.validated.IsZero() {
+			restored := 0
+			if backups.restored {
+				restored = 1
+			}
+			metric("rwtxt_restore_timestamp_seconds", "When the newest dump was last restored to check it.", backups.validated.Unix())
+			metric("rwtxt_restore_success", "Whether the newest dump could be restored.", restored)
+			metric("rwtxt_restore_pages", "How many pages the restored dump has.", backups.restoredPages)
+		}
+	}
+	if stat, errStat := os.Stat(dbName); errStat == nil {
+		metric("rwtxt_database_size_bytes", "Size of the database.", stat.Size())
+	}
+	_, err = w.Write([]byte(b.String()))
+	return
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// egressWriter counts the bytes of a response
+type egressWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (w *egressWriter) Write(p []byte) (n int, err error) {
+	n, err = w.ResponseWriter.Write(p)
+	w.n += int64(n)
+	return
+}
+
+// overBandwidth answers a request for an upload of a domain that served
+// all that its cap allows this month to the public, with its placeholder,
+// or with 429 until the next month. It returns whether it answered.
+func overBandwidth(w http.ResponseWriter, r *http.Request, domain string) bool {
+	quota, over, err := fs.OverBandwidth(domain)
+	if err != nil {
+		log.Debug(err)
+		return false
+	}
+	if !over {
+		return false
+	}
+	if err = fs.AddEgress(domain, 0, true); err != nil {
+		log.Debug(err)
+	}
+	if quota.Placeholder != "" {
+		w.Header().Set("Cache-Control", "no-store")
+		http.Redirect(w, r, quota.Placeholder, http.StatusFound)
+		return true
+	}
+	now := time.Now().UTC()
+	nextMonth := time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+	w.Header().Set("Retry-After", strconv.Itoa(int(nextMonth.Sub(now).Seconds())+1))
+	http.Error(w, "this domain served all the uploads it can this month", http.StatusTooManyRequests)
+	return true
+}
+
+// handleBandwidth changes how many megabytes of uploads a domain serves to
+// the public each month, and where uploads asked for after that go
+func (tr *TemplateRender) handleBandwidth(w http.ResponseWriter, r *http.Request) (err error) {
+	tr.DomainKey = strings.TrimSpace(strings.ToLower(r.FormValue("domain_key")))
+	tr.Domain = utils.NormalizeDomain(r.FormValue("domain"))
+	if tr.Domain == "public" || tr.Domain == "" {
+		tr.Domain = "public"
+		return tr.handleMain(w, r, "cannot modify public")
+	}
+
+	// check that the key is valid
+	domainFound, err := fs.CheckKey(tr.DomainKey)
+	if err != nil || tr.Domain != domainFound {
+		return tr.handleMain(w, r, "need to be logged in to change bandwidth")
+	}
+	if fs.KeyRole(tr.DomainKey) != db.RoleAdmin {
+		return tr.handleMain(w, r, "need to be an admin to change settings")
+	}
+
+	megabytes, _ := strconv.ParseInt(r.FormValue("cap"), 10, 64)
+	err = fs.SetBandwidthQuota(tr.Domain, db.BandwidthQuota{
+		Cap:         megabytes << 20,
+		Placeholder: r.FormValue("placeholder"),
+	})
+	if err != nil {
+		log.Debug(err)
+		return tr.handleMain(w, r, err.Error())
+	}
+	audit(r, "domain.updated", tr.Domain, "", "bandwidth updated")
+	return tr.handleMain(w, r, "bandwidth updated")
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/schollz/rwtxt/src/db"
+)
+
+// moveBlobs moves the uploads that are kept in the database to the store
+// that is given with --blobs, so that the database and its dumps get
+// smaller
+func moveBlobs() (err error) {
+	if dbOptions.Blobs == nil {
+		return fmt.Errorf("usage: rwtxt --blobs dir:/path move-blobs")
+	}
+	fs, err = db.New(dbName, dbOptions)
+	if err != nil {
+		return
+	}
+	defer fs.Close()
+
+	moved, err := fs.MoveBlobs()
+	fmt.Printf("moved %d uploads to the %s store\n", moved, dbOptions.Blobs.Name())
+	if err != nil {
+		return
+	}
+	audit(nil, "blobs.moved", "", "", fmt.Sprintf("moved %d uploads to the %s store", moved, dbOptions.Blobs.Name()))
+	return
+}
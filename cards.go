@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// handleCards shows the next card in a domain that is due for review
+func (tr *TemplateRender) handleCards(w http.ResponseWriter, r *http.Request) (err error) {
+	if !tr.SignedIn {
+		return tr.handleMain(w, r, "need to log in to review")
+	}
+	cards, err := fs.GetDueCards(tr.Domain)
+	if err != nil {
+		return tr.handleMain(w, r, err.Error())
+	}
+	tr.Title = "Review"
+	tr.NumResults = len(cards)
+	if len(cards) > 0 {
+		tr.Card = &cards[0]
+		tr.CardFront = utils.RenderMarkdownToHTML(cards[0].Front)
+		tr.CardBack = utils.RenderMarkdownToHTML(cards[0].Back)
+	}
+
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Set("Content-Type", "text/html")
+	gz := gzip.NewWriter(w)
+	defer gz.Close()
+	return cardsTemplate.Execute(gz, tr)
+}
+
+// handleGradeCard schedules the next review of a card from how well it
+// was remembered, and goes on to the next card
+func (tr *TemplateRender) handleGradeCard(w http.ResponseWriter, r *http.Request) (err error) {
+	tr.DomainKey = strings.TrimSpace(strings.ToLower(r.FormValue("domain_key")))
+	tr.Domain = utils.NormalizeDomain(r.FormValue("domain"))
+	grade, _ := strconv.Atoi(r.FormValue("grade"))
+
+	// check that the key is valid
+	domainFound, err := fs.CheckKey(tr.DomainKey)
+	if err != nil || tr.Domain != domainFound {
+		return tr.handleMain(w, r, "need to be logged in to review")
+	}
+
+	_, err = fs.GradeCard(tr.Domain, r.FormValue("id"), grade)
+	if err != nil {
+		return tr.handleMain(w, r, err.Error())
+	}
+	http.Redirect(w, r, "/"+tr.Domain+"/review", 302)
+	return nil
+}
+
+// handleExportCards downloads the cards in a domain as a tab separated
+// file that Anki can import as basic notes
+func (tr *TemplateRender) handleExportCards(w http.ResponseWriter, r *http.Request) (err error) {
+	domain := utils.NormalizeDomain(r.FormValue("domain"))
+	if domain == "public" || !tr.canWrite(domain, r.FormValue("domain_key")) {
+		return tr.handleMain(w, r, "need to be logged in to export")
+	}
+	cards, err := fs.GetCards(domain)
+	if err != nil {
+		return tr.handleMain(w, r, err.Error())
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("#separator:tab\n#html:true\n")
+	for _, c := range cards {
+		fmt.Fprintf(&buf, "%s\t%s\n", ankiField(c.Front), ankiField(c.Back))
+	}
+	w.Header().Set("Content-Type", "text/tab-separated-values; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-cards.txt"`, domain))
+	_, err = w.Write(buf.Bytes())
+	return
+}
+
+// ankiField renders a side of a card as html on a single line
+func ankiField(markdown string) string {
+	s := strings.TrimSpace(string(utils.RenderMarkdownToHTML(markdown)))
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"html/template"
+	"math"
+	"regexp"
+	"strings"
+
+	log "github.com/cihub/seelog"
+)
+
+var chartBlock = regexp.MustCompile("(?s)```chart[ \\t]*\\n(.*?)```")
+var renderedChartBlock = regexp.MustCompile(`(?s)<pre><code class="language-chart">.*?</code></pre>`)
+
+var chartColors = []string{"#4e79a7", "#f28e2b", "#e15759", "#76b7b2", "#59a14f", "#edc948", "#b07aa1", "#ff9da7"}
+
+const (
+	chartWidth  = 600
+	chartHeight = 300
+	chartMargin = 40
+)
+
+type chartSeries struct {
+	Name string    `json:"name"`
+	Data []float64 `json:"data"`
+}
+
+type chart struct {
+	Type   string        `json:"type"`
+	Title  string        `json:"title"`
+	Labels []string      `json:"labels"`
+	Series []chartSeries `json:"series"`
+}
+
+// parseChart reads a chart block, which is either JSON like
+//
+//	{"type": "line", "labels": ["Jan", "Feb"], "series": [{"name": "visits", "data": [3, 5]}]}
+//
+// or some options followed by CSV, where the first column has the labels
+// and every other column is a series
+//
+//	type: bar
+//	title: Fruit
+//	month,apples,pears
+//	Jan,3,4
+//	Feb,5,2
+func parseChart(text string) (c chart, err error) {
+	text = strings.TrimSpace(text)
+	if strings.HasPrefix(text, "{") {
+		err = json.Unmarshal([]byte(text), &c)
+	} else {
+		lines := strings.Split(text, "\n")
+		for len(lines) > 0 {
+			parts := strings.SplitN(lines[0], ":", 2)
+			if len(parts) != 2 || strings.Contains(parts[0], ",") {
+				break
+			}
+			switch strings.ToLower(strings.TrimSpace(parts[0])) {
+			case "type":
+				c.Type = strings.TrimSpace(parts[1])
+			case "title":
+				c.Title = strings.TrimSpace(parts[1])
+			}
+			lines = lines[1:]
+		}
+		r := csv.NewReader(strings.NewReader(strings.Join(lines, "\n")))
+		r.FieldsPerRecord = -1
+		r.TrimLeadingSpace = true
+		var records [][]string
+		records, err = r.ReadAll()
+		if err == nil && len(records) > 0 {
+			for _, name := range records[0][1:] {
+				c.Series = append(c.Series, chartSeries{Name: name})
+			}
+			for _, record := range records[1:] {
+				c.Labels = append(c.Labels, record[0])
+				for i := range c.Series {
+					var v float64
+					if i+1 < len(record) {
+						v, _ = parseNumber(record[i+1])
+					}
+					c.Series[i].Data = append(c.Series[i].Data, v)
+				}
+			}
+		}
+	}
+	if err != nil {
+		return
+	}
+	c.Type = strings.ToLower(c.Type)
+	if c.Type == "" {
+		c.Type = "line"
+	}
+	if c.Type != "line" && c.Type != "bar" && c.Type != "pie" {
+		err = errors.New("unknown chart type " + c.Type)
+	} else if len(c.Series) == 0 {
+		err = errors.New("chart has no data")
+	}
+	return
+}
+
+// addCharts replaces the chart blocks in a rendered page with SVG charts
+func addCharts(rendered template.HTML, markdown string) template.HTML {
+	blocks := chartBlock.FindAllStringSubmatch(markdown, -1)
+	if len(blocks) == 0 {
+		return rendered
+	}
+	i := 0
+	return template.HTML(renderedChartBlock.ReplaceAllStringFunc(string(rendered), func(s string) string {
+		if i >= len(blocks) {
+			return s
+		}
+		c, err := parseChart(blocks[i][1])
+		i++
+		if err != nil {
+			log.Debugf("could not parse chart: %s", err.Error())
+			return s
+		}
+		return renderChart(c)
+	}))
+}
+
+// renderChart draws a chart as SVG
+func renderChart(c chart) string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<figure class="chart"><svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" role="img">`, chartWidth, chartHeight)
+	if c.Title != "" {
+		fmt.Fprintf(&buf, `<title>%s</title>`, html.EscapeString(c.Title))
+	}
+	switch c.Type {
+	case "pie":
+		renderPie(&buf, c)
+	default:
+		renderAxes(&buf, c)
+	}
+	buf.WriteString(`</svg>`)
+	if c.Title != "" {
+		fmt.Fprintf(&buf, `<figcaption>%s</figcaption>`, html.EscapeString(c.Title))
+	}
+	buf.WriteString(`</figure>`)
+	return buf.String()
+}
+
+// renderAxes draws line and bar charts
+func renderAxes(buf *bytes.Buffer, c chart) {
+	lo, hi := 0.0, 0.0
+	n := 0
+	for _, s := range c.Series {
+		for _, v := range s.Data {
+			lo = math.Min(lo, v)
+			hi = math.Max(hi, v)
+		}
+		if len(s.Data) > n {
+			n = len(s.Data)
+		}
+	}
+	if n == 0 {
+		return
+	}
+	step := niceStep((hi - lo) / 5)
+	lo = math.Floor(lo/step) * step
+	hi = math.Ceil(hi/step) * step
+	if hi == lo {
+		hi = lo + step
+	}
+
+	legend := 0
+	if len(c.Series) > 1 {
+		legend = 20
+	}
+	left, right := float64(chartMargin), float64(chartWidth-chartMargin/2)
+	top, bottom := float64(chartMargin/2), float64(chartHeight-chartMargin-legend)
+	y := func(v float64) float64 {
+		return bottom - (v-lo)/(hi-lo)*(bottom-top)
+	}
+
+	// grid lines with their values
+	for v := lo; v <= hi+step/2; v += step {
+		fmt.Fprintf(buf, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="#ddd"/>`, left, y(v), right, y(v))
+		fmt.Fprintf(buf, `<text x="%.1f" y="%.1f" font-size="10" text-anchor="end" dominant-baseline="middle">%s</text>`, left-4, y(v), formatNumber(v))
+	}
+
+	slot := (right - left) / float64(n)
+	for i, label := range c.Labels {
+		if i >= n {
+			break
+		}
+		fmt.Fprintf(buf, `<text x="%.1f" y="%.1f" font-size="10" text-anchor="middle">%s</text>`, left+slot*(float64(i)+0.5), bottom+14, html.EscapeString(label))
+	}
+
+	for si, s := range c.Series {
+		color := chartColors[si%len(chartColors)]
+		if c.Type == "bar" {
+			width := slot * 0.8 / float64(len(c.Series))
+			for i, v := range s.Data {
+				x := left + slot*float64(i) + slot*0.1 + width*float64(si)
+				y1, y2 := y(math.Max(v, 0)), y(math.Min(v, 0))
+				fmt.Fprintf(buf, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"><title>%s</title></rect>`,
+					x, y1, width, y2-y1, color, html.EscapeString(s.Name+": "+formatNumber(v)))
+			}
+			continue
+		}
+		points := make([]string, len(s.Data))
+		for i, v := range s.Data {
+			points[i] = fmt.Sprintf("%.1f,%.1f", left+slot*(float64(i)+0.5), y(v))
+		}
+		fmt.Fprintf(buf, `<polyline points="%s" fill="none" stroke="%s" stroke-width="2"/>`, strings.Join(points, " "), color)
+		for i, v := range s.Data {
+			fmt.Fprintf(buf, `<circle cx="%.1f" cy="%.1f" r="3" fill="%s"><title>%s</title></circle>`,
+				left+slot*(float64(i)+0.5), y(v), color, html.EscapeString(s.Name+": "+formatNumber(v)))
+		}
+	}
+
+	if legend > 0 {
+		names := make([]string, len(c.Series))
+		for i, s := range c.Series {
+			names[i] = s.Name
+		}
+		renderLegend(buf, names, float64(chartHeight-legend/2))
+	}
+}
+
+// renderPie draws the first series as a pie chart
+func renderPie(buf *bytes.Buffer, c chart) {
+	data := c.Series[0].Data
+	total := 0.0
+	for _, v := range data {
+		if v > 0 {
+			total += v
+		}
+	}
+	if total == 0 {
+		return
+	}
+	cx, cy := float64(chartWidth/2), float64(chartHeight/2-10)
+	radius := float64(chartHeight/2 - chartMargin)
+	angle := -math.Pi / 2
+	names := make([]string, len(data))
+	for i, v := range data {
+		if i < len(c.Labels) {
+			names[i] = c.Labels[i]
+		}
+		if v <= 0 {
+			continue
+		}
+		color := chartColors[i%len(chartColors)]
+		label := html.EscapeString(names[i] + ": " + formatNumber(v))
+		if v == total {
+			fmt.Fprintf(buf, `<circle cx="%.1f" cy="%.1f" r="%.1f" fill="%s"><title>%s</title></circle>`, cx, cy, radius, color, label)
+			continue
+		}
+		next := angle + v/total*2*math.Pi
+		large := 0
+		if next-angle > math.Pi {
+			large = 1
+		}
+		fmt.Fprintf(buf, `<path d="M%.1f,%.1f L%.1f,%.1f A%.1f,%.1f 0 %d 1 %.1f,%.1f Z" fill="%s"><title>%s</title></path>`,
+			cx, cy, cx+radius*math.Cos(angle), cy+radius*math.Sin(angle),
+			radius, radius, large, cx+radius*math.Cos(next), cy+radius*math.Sin(next), color, label)
+		angle = next
+	}
+	renderLegend(buf, names, float64(chartHeight-15))
+}
+
+func renderLegend(buf *bytes.Buffer, names []string, y float64) {
+	x := float64(chartMargin)
+	for i, name := range names {
+		fmt.Fprintf(buf, `<rect x="%.1f" y="%.1f" width="10" height="10" fill="%s"/>`, x, y-5, chartColors[i%len(chartColors)])
+		fmt.Fprintf(buf, `<text x="%.1f" y="%.1f" font-size="10" dominant-baseline="middle">%s</text>`, x+14, y, html.EscapeString(name))
+		x += 24 + 6*float64(len(name))
+	}
+}
+
+// niceStep rounds a step between grid lines to 1, 2 or 5 times a power of ten
+func niceStep(raw float64) float64 {
+	if raw <= 0 {
+		return 1
+	}
+	magnitude := math.Pow(10, math.Floor(math.Log10(raw)))
+	for _, m := range []float64{1, 2, 5} {
+		if raw <= m*magnitude {
+			return m * magnitude
+		}
+	}
+	return 10 * magnitude
+}
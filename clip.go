@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+const maxClipSize = 10 * 1024 * 1024
+
+// clipClient fetches the web pages and images that people clip. It only
+// connects to public addresses, so that clipping can not read the services
+// on the network of the server.
+var clipClient = publicClient(20 * time.Second)
+
+// privateNetworks are the addresses that are not on the internet, besides
+// the loopback, link-local and unspecified ones that net.IP knows about
+var privateNetworks = func() (networks []*net.IPNet) {
+	for _, cidr := range []string{"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "172.16.0.0/12", "192.168.0.0/16", "198.18.0.0/15", "fc00::/7"} {
+		_, network, _ := net.ParseCIDR(cidr)
+		networks = append(networks, network)
+	}
+	return
+}()
+
+// publicIP returns whether an address is on the internet
+func publicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// dialPublic connects to an address only when every address of its host
+// is public, and connects to the address it checked so that the host can
+// not resolve to another one in between
+func dialPublic(ctx context.Context, network, addr string) (conn net.Conn, err error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return
+	}
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return
+	}
+	if len(ips) == 0 {
+		return nil, errors.New("no addresses for " + host)
+	}
+	for _, ip := range ips {
+		if !publicIP(ip.IP) {
+			return nil, errors.New("refusing to connect to " + host + ", which is not a public address")
+		}
+	}
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	return dialer.DialContext(ctx, network, net.JoinHostPort(ips[0].IP.String(), port))
+}
+
+// publicClient returns a client for fetching the URLs that people give,
+// which only connects to public addresses, without a proxy, and only
+// follows redirects to other http and https URLs
+func publicClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:           dialPublic,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: timeout,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       90 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			return checkPublicURL(req.URL)
+		},
+	}
+}
+
+// checkPublicURL refuses URLs that are not http or https, and the ones
+// whose host is an address that is not public
+func checkPublicURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.New("only http and https URLs can be fetched")
+	}
+	if ip := net.ParseIP(u.Hostname()); ip != nil && !publicIP(ip) {
+		return errors.New("refusing to fetch " + u.Hostname() + ", which is not a public address")
+	}
+	return nil
+}
+
+// ClipRequest is the body of a request to clip a web page
+type ClipRequest struct {
+	URL       string `json:"url"`
+	Domain    string `json:"domain,omitempty"`
+	DomainKey string `json:"domain_key,omitempty"`
+}
+
+// handleClip saves the readable content of a web page as a new page
+func handleClip(w http.ResponseWriter, r *http.Request) (err error) {
+	if r.Method != "POST" {
+		return writeJSON(w, http.StatusMethodNotAllowed, Payload{Message: "must POST"})
+	}
+	var req ClipRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return writeJSON(w, http.StatusBadRequest, Payload{Message: err.Error()})
+	}
+	req.Domain = utils.NormalizeDomain(req.Domain)
+	if req.Domain == "" || req.Domain == "public" {
+		return writeJSON(w, http.StatusForbidden, Payload{Message: "need to log in to a domain to clip"})
+	}
+	if !checkDomainKey(req.Domain, req.DomainKey) {
+		return writeJSON(w, http.StatusForbidden, Payload{Message: "need to be logged in"})
+	}
+
+	f, err := clipPage(req.URL, req.Domain)
+	if err != nil {
+		return writeJSON(w, http.StatusBadRequest, Payload{Message: err.Error()})
+	}
+	f.Source = remoteIP(r)
+	err = fs.Save(f)
+	if err != nil {
+		return writeJSON(w, http.StatusInternalServerError, Payload{Message: err.Error()})
+	}
+	auditSave(r, f)
+	return writeJSON(w, http.StatusOK, Payload{
+		ID:      f.ID,
+		Domain:  f.Domain,
+		Slug:    f.Slug,
+		Success: true,
+	})
+}
+
+// clipPage fetches a web page and makes a page from its readable content,
+// saving its images as uploads
+func clipPage(pageURL, domain string) (f db.File, err error) {
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return
+	}
+	err = checkPublicURL(base)
+	if err != nil {
+		return
+	}
+	resp, err := clipClient.Get(base.String())
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	title, content, err := utils.ExtractArticle(io.LimitReader(resp.Body, maxClipSize))
+	if err != nil {
+		return
+	}
+	markdown := utils.NodeToMarkdown(content, func(tag, link string) string {
+		u, errParse := base.Parse(link)
+		if errParse != nil {
+			return link
+		}
+		if tag != "img" {
+			return u.String()
+		}
+		id, name, errImage := clipImage(u, domain)
+		if errImage != nil {
+			log.Debugf("could not save image %s: %s", u, errImage.Error())
+			return u.String()
+		}
+		return "/uploads/" + id + "?filename=" + url.QueryEscape(name)
+	})
+	if title == "" {
+		title = base.Host
+	}
+	if !strings.HasPrefix(markdown, "# ") {
+		markdown = "# " + title + "\n\n" + markdown
+	}
+
+	f = db.File{
+		ID:       utils.UUID(),
+		Slug:     utils.Slugify(title),
+		Data:     markdown + "\n\n*Clipped from <" + base.String() + ">*",
+		Domain:   domain,
+		Created:  time.Now(),
+		Modified: time.Now(),
+	}
+	f.Slug = pageSlug(f, title)
+	return
+}
+
+// clipImage downloads an image and saves it as an upload to a domain
+func clipImage(u *url.URL, domain string) (id string, name string, err error) {
+	err = checkPublicURL(u)
+	if err != nil {
+		return
+	}
+	resp, err := clipClient.Get(u.String())
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxClipSize))
+	if err != nil {
+		return
+	}
+	name = path.Base(u.Path)
+	if name == "/" || name == "." {
+		name = "image"
+	}
+	id, err = storeBlob(domain, name, bytes.NewReader(data))
+	return
+}
+
+// handleClipping appends a quote of a selection on another web page to
+// the clippings page of a domain. It is used by the bookmarklet, which
+// opens it in a new window so that the domain cookie signs it in.
+func (tr *TemplateRender) handleClipping(w http.ResponseWriter, r *http.Request) (err error) {
+	tr.Domain = utils.NormalizeDomain(r.FormValue("domain"))
+	if tr.Domain == "" {
+		tr.Domain = tr.DefaultDomain
+	}
+	domainKey := r.FormValue("domain_key")
+	if domainKey == "" {
+		domainKey = tr.DomainKeys[tr.Domain]
+	}
+	tr.DomainKey = domainKey
+	tr.SignedIn = checkDomainKey(tr.Domain, domainKey)
+	if !tr.SignedIn {
+		return tr.handleMain(w, r, "need to be logged in to clip")
+	}
+
+	page := utils.NormalizeSlug(r.FormValue("page"))
+	if page == "" {
+		page = "clippings"
+	}
+	title := strings.TrimSpace(r.FormValue("title"))
+	source := strings.TrimSpace(r.FormValue("url"))
+	if title == "" {
+		title = source
+	}
+	quote, err := utils.HTMLToMarkdown(r.FormValue("html"))
+	if err != nil {
+		return tr.handleMain(w, r, err.Error())
+	}
+
+	var f db.File
+	files, errGet := fs.GetCtx(db.AsMember(r.Context()), page, tr.Domain)
+	if errGet == nil {
+		f = files[0]
+	} else {
+		f = db.File{
+			ID:      utils.UUID(),
+			Slug:    page,
+			Data:    "# " + strings.Title(page),
+			Created: time.Now(),
+		}
+	}
+	f.Domain = tr.Domain
+	f.Source = remoteIP(r)
+	f.Data = strings.TrimSpace(f.Data) + "\n\n" + clippingEntry(title, source, quote)
+	err = fs.Save(f)
+	if err != nil {
+		return tr.handleMain(w, r, err.Error())
+	}
+	auditSave(r, f)
+	http.Redirect(w, r, "/"+tr.Domain+"/"+page, 302)
+	return nil
+}
+
+// clippingEntry formats a quote and its source
+func clippingEntry(title, source, quote string) string {
+	var b strings.Builder
+	b.WriteString("## " + title + "\n\n")
+	if quote != "" {
+		for _, line := range strings.Split(quote, "\n") {
+			b.WriteString(strings.TrimRight("> "+line, " ") + "\n")
+		}
+		b.WriteString("\n")
+	}
+	if source != "" {
+		b.WriteString("— [" + title + "](" + source + "), ")
+	}
+	b.WriteString(time.Now().Format("Mon Jan 2 2006"))
+	return b.String()
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/gorilla/websocket"
+	"github.com/schollz/rwtxt/src/db"
+)
+
+// collabWriteTimeout is how long sending a change to an editor can take
+// before it is given up on, so a slow editor does not hold up the others
+const collabWriteTimeout = 10 * time.Second
+
+// wsConn is a websocket that more than one goroutine writes to, like the
+// changes of other editors and the replies to its own messages
+type wsConn struct {
+	*websocket.Conn
+	sync.Mutex
+}
+
+// WriteJSON writes a message to the websocket, one at a time
+func (c *wsConn) WriteJSON(v interface{}) error {
+	c.Lock()
+	defer c.Unlock()
+	return c.Conn.WriteJSON(v)
+}
+
+// writeJSONTimeout writes a message to the websocket, giving up after a
+// while
+func (c *wsConn) writeJSONTimeout(v interface{}, timeout time.Duration) error {
+	c.Lock()
+	defer c.Unlock()
+	c.SetWriteDeadline(time.Now().Add(timeout))
+	defer c.SetWriteDeadline(time.Time{})
+	return c.Conn.WriteJSON(v)
+}
+
+// editors are the websockets that are editing each page, by its domain
+// and id. Each page is locked while a change to it is saved and sent, so
+// every editor gets the changes in the order they were saved.
+var editors = struct {
+	sync.Mutex
+	pages map[string]*editedPage
+}{pages: make(map[string]*editedPage)}
+
+// editedPage is a page that is being edited, with its editors
+type editedPage struct {
+	sync.Mutex
+	conns map[*wsConn]bool
+}
+
+// editedKey is the key of a page in editors, so that the editors of a
+// page are only the ones that opened it in its domain
+func editedKey(domain, id string) string {
+	return domain + "/" + id
+}
+
+// joinPage adds a websocket to the editors of a page
+func joinPage(domain, id string, c *wsConn) *editedPage {
+	editors.Lock()
+	defer editors.Unlock()
+	page, ok := editors.pages[editedKey(domain, id)]
+	if !ok {
+		page = &editedPage{conns: make(map[*wsConn]bool)}
+		editors.pages[editedKey(domain, id)] = page
+	}
+	page.conns[c] = true
+	return page
+}
+
+// leavePage removes a websocket from the editors of a page
+func leavePage(domain, id string, c *wsConn) {
+	editors.Lock()
+	defer editors.Unlock()
+	page, ok := editors.pages[editedKey(domain, id)]
+	if !ok {
+		return
+	}
+	delete(page.conns, c)
+	if len(page.conns) == 0 {
+		delete(editors.pages, editedKey(domain, id))
+	}
+}
+
+// handleEdit saves a change that an editor made, merged with the changes
+// of the other editors of the page that it has not seen yet. The editor
+// is sent the page as it was saved, and the other editors are sent it as
+// a change. It only returns the errors of writing to the editor.
+func handleEdit(r *http.Request, c *wsConn, f db.File, base string) (saved db.File, err error) {
+	page := joinPage(f.Domain, f.ID, c)
+	page.Lock()
+	defer page.Unlock()
+
+	saved, merged, err := fs.EditPage(f, base)
+	if err != nil {
+		log.Error(err)
+		leavePage(f.Domain, f.ID, c)
+		return f, c.WriteJSON(Payload{Message: "not saving"})
+	}
+	auditSave(r, saved)
+	files, _ := fs.GetCtx(db.AsMember(r.Context()), saved.Slug, saved.Domain)
+	err = c.WriteJSON(Payload{
+		ID:      saved.ID,
+		Slug:    saved.Slug,
+		Data:    saved.Data,
+		Message: "unique_slug",
+		Success: len(files) < 2,
+	})
+	if err != nil {
+		return
+	}
+	if merged {
+		log.Debugf("merged the changes of editors of /%s/%s", saved.Domain, saved.ID)
+	}
+
+	editors.Lock()
+	others := make([]*wsConn, 0, len(page.conns))
+	for other := range page.conns {
+		if other != c {
+			others = append(others, other)
+		}
+	}
+	editors.Unlock()
+	for _, other := range others {
+		errWrite := other.writeJSONTimeout(Payload{
+			ID:      saved.ID,
+			Slug:    saved.Slug,
+			Data:    saved.Data,
+			Message: "changed",
+		}, collabWriteTimeout)
+		if errWrite != nil {
+			log.Debug("write:", errWrite)
+		}
+	}
+	return
+}
+
+// joinEdit adds a websocket to the editors of a page that it can read
+// with the context, before it makes any changes, and sends it the page as
+// it is now, so an editor that opened the page or reconnected has every
+// change. It only returns the errors of writing to the editor.
+func joinEdit(ctx context.Context, c *wsConn, id, domain string) (joined bool, err error) {
+	files, errGet := fs.GetCtx(ctx, id, domain)
+	if errGet != nil || files[0].ID != id {
+		return
+	}
+
+	page := joinPage(domain, id, c)
+	page.Lock()
+	defer page.Unlock()
+	joined = true
+
+	// the page is read again in case it changed before it was joined
+	files, errGet = fs.GetCtx(ctx, id, domain)
+	if errGet != nil || files[0].ID != id {
+		return
+	}
+	return joined, c.WriteJSON(Payload{
+		ID:      id,
+		Slug:    files[0].Slug,
+		Data:    files[0].Data,
+		Message: "changed",
+	})
+}
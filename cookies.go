@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+)
+
+// cookieSameSite is the SameSite attribute of cookies: "lax", "strict" or
+// "none"
+var cookieSameSite = "lax"
+
+// cookieSecure makes cookies only be sent over https, which they always
+// are when the request came over https
+var cookieSecure bool
+
+// sessionLifetime is how long a key that is not remembered lasts without
+// being used, for shared computers
+var sessionLifetime = 12 * time.Hour
+
+// rememberLifetime is how long a remembered key lasts without being used
+var rememberLifetime = 30 * 24 * time.Hour
+
+// rotateAfter is how old a remembered key gets before it is replaced
+var rotateAfter = 24 * time.Hour
+
+// newCookie makes a cookie with the attributes that were configured. A
+// cookie that is not remembered is deleted when the browser closes.
+func newCookie(r *http.Request, name, value string, remember bool) *http.Cookie {
+	c := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   cookieSecure || r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+	}
+	switch strings.ToLower(cookieSameSite) {
+	case "strict":
+		c.SameSite = http.SameSiteStrictMode
+	case "none":
+		// browsers only accept SameSite=None on secure cookies
+		c.SameSite = http.SameSiteNoneMode
+		c.Secure = true
+	default:
+		c.SameSite = http.SameSiteLaxMode
+	}
+	if remember {
+		c.Expires = time.Now().Add(rememberLifetime)
+	}
+	return c
+}
+
+// rememberKeys sets whether the keys of the domains that are logged into
+// are remembered, or only last a session
+func (tr *TemplateRender) rememberKeys(remember bool) {
+	tr.Remember = remember
+	keys := []string{tr.DomainKey}
+	for _, key := range tr.DomainKeys {
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+	err := fs.RememberKeys(keys, remember)
+	if err != nil {
+		log.Error(err)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// DocumentList is a page of the documents of a domain, with how many
+// documents there are in all
+type DocumentList struct {
+	Documents []db.Page `json:"documents"`
+	Offset    int       `json:"offset"`
+	Total     int       `json:"total"`
+}
+
+// documentKey is the key of a request to the documents of a domain, given
+// as ?domain_key= or as a bearer token
+func documentKey(r *http.Request) string {
+	if key := r.URL.Query().Get("domain_key"); key != "" {
+		return key
+	}
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+// documentPage is a file as a document, without its history
+func documentPage(domain string, f db.File) db.Page {
+	return db.Page{
+		ID:       f.ID,
+		Domain:   domain,
+		Slug:     f.Slug,
+		Created:  f.Created,
+		Modified: f.Modified,
+		Archived: f.Archived,
+		Data:     f.Data,
+	}
+}
+
+// handleDocuments lists (GET) and creates (POST) the documents of a
+// domain at /api/v1/DOMAIN/documents, and returns (GET), updates (PUT)
+// and puts in the trash (DELETE) a document, by its id or slug, at
+// /api/v1/DOMAIN/documents/ID
+func (tr *TemplateRender) handleDocuments(w http.ResponseWriter, r *http.Request) (err error) {
+	fields := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	domain := utils.NormalizeDomain(fields[2])
+	id := ""
+	if len(fields) > 4 {
+		id = fields[4]
+	}
+	domainKey := documentKey(r)
+
+	if r.Method == "GET" {
+		if !tr.canRead(domain, domainKey) {
+			return writeJSON(w, http.StatusForbidden, Payload{Message: "need to be logged in"})
+		}
+	} else if !tr.canWrite(domain, domainKey) {
+		return writeJSON(w, http.StatusForbidden, Payload{Message: "need to be logged in"})
+	}
+
+	if id == "" {
+		switch r.Method {
+		case "GET":
+			offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+			if offset < 0 {
+				offset = 0
+			}
+			limit, errLimit := strconv.Atoi(r.URL.Query().Get("limit"))
+			if errLimit != nil || limit <= 0 || limit > listPageSize {
+				limit = listPageSize
+			}
+			files, total, errGet := fs.GetTopXOffsetCtx(tr.readContext(r, domain, domainKey), domain, limit, offset)
+			if errGet != nil {
+				return writeJSON(w, http.StatusInternalServerError, Payload{Message: errGet.Error()})
+			}
+			list := DocumentList{Documents: []db.Page{}, Offset: offset, Total: total}
+			for _, f := range files {
+				list.Documents = append(list.Documents, documentPage(domain, f))
+			}
+			return writeJSON(w, http.StatusOK, list)
+		case "POST":
+			var p db.Page
+			err = json.NewDecoder(r.Body).Decode(&p)
+			if err != nil {
+				return writeJSON(w, http.StatusBadRequest, Payload{Message: err.Error()})
+			}
+			if p.ID == "" {
+				p.ID = utils.UUID()
+			} else if exists, _ := fs.Exists(p.ID, domain); exists {
+				return writeJSON(w, http.StatusConflict, Payload{Message: "document already exists"})
+			}
+			f := db.File{
+				ID:       p.ID,
+				Domain:   domain,
+				Slug:     p.Slug,
+				Data:     p.Data,
+				Created:  time.Now(),
+				Modified: time.Now(),
+				Author:   tr.author(domain, domainKey),
+			}
+			if f.Slug == "" {
+				f.Slug = pageSlug(f, f.Data)
+			}
+			err = fs.Save(f)
+			if err != nil {
+				return writeJSON(w, saveStatus(err), Payload{Message: err.Error()})
+			}
+			audit(r, "page.created", domain, f.ID, f.Slug)
+			return tr.writeDocument(w, http.StatusCreated, domain, f.ID)
+		}
+		return writeJSON(w, http.StatusMethodNotAllowed, Payload{Message: "use GET or POST"})
+	}
+
+	files, err := fs.GetCtx(tr.readContext(r, domain, domainKey), id, domain)
+	if err != nil || len(files) == 0 {
+		return writeJSON(w, http.StatusNotFound, Payload{Message: "document does not exist"})
+	}
+	if len(files) > 1 {
+		return writeJSON(w, http.StatusConflict, Payload{Message: "more than one document has that slug"})
+	}
+	f := files[0]
+	f.Domain = domain
+
+	switch r.Method {
+	case "GET":
+		return writeJSON(w, http.StatusOK, documentPage(domain, f))
+	case "PUT":
+		var p db.Page
+		err = json.NewDecoder(r.Body).Decode(&p)
+		if err != nil {
+			return writeJSON(w, http.StatusBadRequest, Payload{Message: err.Error()})
+		}
+		if p.ID != "" && p.ID != f.ID {
+			return writeJSON(w, http.StatusBadRequest, Payload{Message: "id does not match the url"})
+		}
+		f.Data = p.Data
+		if p.Slug != "" {
+			f.Slug = p.Slug
+		}
+		f.Modified = time.Now()
+		f.Author = tr.author(domain, domainKey)
+		err = fs.Save(f)
+		if err != nil {
+			return writeJSON(w, saveStatus(err), Payload{Message: err.Error()})
+		}
+		audit(r, "page.updated", domain, f.ID, f.Slug)
+		return tr.writeDocument(w, http.StatusOK, domain, f.ID)
+	case "DELETE":
+		err = fs.Delete(f.ID, domain)
+		if err != nil {
+			return writeJSON(w, saveStatus(err), Payload{Message: err.Error()})
+		}
+		audit(r, "page.deleted", domain, f.ID, "")
+		return writeJSON(w, http.StatusOK, Payload{ID: f.ID, Success: true})
+	}
+	return writeJSON(w, http.StatusMethodNotAllowed, Payload{Message: "use GET, PUT or DELETE"})
+}
+
+// writeDocument responds with a document as it was saved
+func (tr *TemplateRender) writeDocument(w http.ResponseWriter, status int, domain, id string) (err error) {
+	files, err := fs.GetCtx(db.AsMember(context.Background()), id, domain)
+	if err != nil {
+		return writeJSON(w, http.StatusInternalServerError, Payload{Message: err.Error()})
+	}
+	return writeJSON(w, status, documentPage(domain, files[0]))
+}
+
+// saveStatus returns the status of a document that could not be saved,
+// which is a conflict when its slug is reserved, and forbidden when its
+// domain is read-only
+func saveStatus(err error) int {
+	if _, ok := err.(db.ReservedSlugError); ok {
+		return http.StatusConflict
+	}
+	if _, ok := err.(db.ReadOnlyError); ok {
+		return http.StatusForbidden
+	}
+	return http.StatusBadRequest
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// domainFeedSize is how many of the latest pages are in the feeds of a
+// domain
+const domainFeedSize = 20
+
+// domainAtom is the Atom feed of a domain
+type domainAtom struct {
+	XMLName xml.Name          `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string            `xml:"title"`
+	ID      string            `xml:"id"`
+	Updated string            `xml:"updated"`
+	Author  string            `xml:"author>name"`
+	Links   []domainAtomLink  `xml:"link"`
+	Entries []domainAtomEntry `xml:"entry"`
+}
+
+// domainAtomLink is a link of an Atom feed or entry
+type domainAtomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+// domainAtomEntry is a page in the Atom feed of a domain
+type domainAtomEntry struct {
+	Title     string         `xml:"title"`
+	ID        string         `xml:"id"`
+	Link      domainAtomLink `xml:"link"`
+	Published string         `xml:"published"`
+	Updated   string         `xml:"updated"`
+	Content   struct {
+		Type string `xml:"type,attr"`
+		HTML string `xml:",chardata"`
+	} `xml:"content"`
+}
+
+// domainRSS is the RSS 2.0 feed of a domain
+type domainRSS struct {
+	XMLName       xml.Name        `xml:"rss"`
+	Version       string          `xml:"version,attr"`
+	AtomNamespace string          `xml:"xmlns:atom,attr"`
+	Title         string          `xml:"channel>title"`
+	Link          string          `xml:"channel>link"`
+	Description   string          `xml:"channel>description"`
+	Language      string          `xml:"channel>language,omitempty"`
+	LastBuildDate string          `xml:"channel>lastBuildDate"`
+	Self          domainAtomLink  `xml:"channel>atom:link"`
+	Items         []domainRSSItem `xml:"channel>item"`
+}
+
+// domainRSSItem is a page in the RSS feed of a domain
+type domainRSSItem struct {
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	GUID        string `xml:"guid"`
+	PubDate     string `xml:"pubDate"`
+	Description string `xml:"description"`
+}
+
+// domainFeedEntry is a page of a domain as it is in its feeds
+type domainFeedEntry struct {
+	f     db.File
+	title string
+	link  string
+	html  string
+}
+
+// handleDomainFeed writes the latest pages of a public domain as an Atom
+// feed (/DOMAIN/feed.xml) or an RSS feed (/DOMAIN/rss.xml), so that it can
+// be followed like a blog
+func (tr *TemplateRender) handleDomainFeed(w http.ResponseWriter, r *http.Request, rss bool) (err error) {
+	_, ispublic, err := fs.GetDomainFromName(tr.Domain)
+	if err != nil || !ispublic {
+		http.Error(w, "domain does not exist or is not public", http.StatusNotFound)
+		return nil
+	}
+	// feeds are for everyone, so they only have the pages that are listed
+	// and not sensitive
+	files, err := fs.GetTopXCtx(r.Context(), tr.Domain, domainFeedSize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil
+	}
+	files = fs.FilterSensitive(files)
+
+	base := siteURL(r)
+	domainURL := base + "/" + tr.Domain
+	var updated time.Time
+	entries := make([]domainFeedEntry, len(files))
+	for i, f := range files {
+		if f.Modified.After(updated) {
+			updated = f.Modified
+		}
+		page := f.Slug
+		if page == "" {
+			page = f.ID
+		}
+		_, body := utils.ParseFrontMatter(f.Data)
+		entries[i] = domainFeedEntry{
+			f:     f,
+			title: pageTitle(f, body),
+			link:  domainURL + "/" + page,
+			html:  absoluteLinks(string(utils.RenderMarkdownToHTML(linkTags(tr.Domain, body))), base),
+		}
+	}
+	if updated.IsZero() {
+		updated = time.Now()
+	}
+	updated = updated.UTC().Truncate(time.Second)
+
+	if since, errSince := http.ParseTime(r.Header.Get("If-Modified-Since")); errSince == nil && !updated.After(since) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+	w.Header().Set("Last-Modified", updated.Format(http.TimeFormat))
+
+	var feed interface{}
+	if rss {
+		w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+		language, _ := fs.GetDomainLanguage(tr.Domain)
+		channel := domainRSS{
+			Version:       "2.0",
+			AtomNamespace: "http://www.w3.org/2005/Atom",
+			Title:         tr.Domain,
+			Link:          domainURL,
+			Description:   "The latest pages of " + tr.Domain,
+			Language:      language,
+			LastBuildDate: updated.Format(time.RFC1123Z),
+			Self:          domainAtomLink{Href: domainURL + "/rss.xml", Rel: "self", Type: "application/rss+xml"},
+		}
+		for _, e := range entries {
+			channel.Items = append(channel.Items, domainRSSItem{
+				Title:       e.title,
+				Link:        e.link,
+				GUID:        e.link,
+				PubDate:     e.f.Created.UTC().Format(time.RFC1123Z),
+				Description: e.html,
+			})
+		}
+		feed = channel
+	} else {
+		w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+		atom := domainAtom{
+			Title:   tr.Domain,
+			ID:      domainURL + "/",
+			Updated: updated.Format(time.RFC3339),
+			Author:  tr.Domain,
+			Links: []domainAtomLink{
+				{Href: domainURL + "/feed.xml", Rel: "self", Type: "application/atom+xml"},
+				{Href: domainURL, Rel: "alternate", Type: "text/html"},
+			},
+		}
+		for _, e := range entries {
+			entry := domainAtomEntry{
+				Title:     e.title,
+				ID:        domainURL + "/" + e.f.ID,
+				Link:      domainAtomLink{Href: e.link, Rel: "alternate", Type: "text/html"},
+				Published: e.f.Created.UTC().Format(time.RFC3339),
+				Updated:   e.f.Modified.UTC().Format(time.RFC3339),
+			}
+			entry.Content.Type = "html"
+			entry.Content.HTML = e.html
+			atom.Entries = append(atom.Entries, entry)
+		}
+		feed = atom
+	}
+
+	w.Write([]byte(xml.Header))
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	return encoder.Encode(feed)
+}
+
+// siteURL returns the scheme and host that a request was made to
+func siteURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// rootLink is a link or image of rendered HTML that starts with "/", but
+// not with "//"
+var rootLink = regexp.MustCompile(`(href|src)="/([^/"])`)
+
+// absoluteLinks makes the links and images of rendered HTML that start
+// with "/" start with the site instead, since feeds are read elsewhere
+func absoluteLinks(html, base string) string {
+	return rootLink.ReplaceAllString(html, `$1="`+strings.Replace(base, "$", "$$", -1)+`/$2`)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// handleRenameDomain gives a domain a new name, and sends the links to its
+// old name to the new one
+func (tr *TemplateRender) handleRenameDomain(w http.ResponseWriter, r *http.Request) (err error) {
+	tr.DomainKey = strings.TrimSpace(strings.ToLower(r.FormValue("domain_key")))
+	tr.Domain = utils.NormalizeDomain(r.FormValue("domain"))
+	if tr.Domain == "public" || tr.Domain == "" {
+		tr.Domain = "public"
+		return tr.handleMain(w, r, "cannot modify public")
+	}
+
+	// check that the key is valid
+	domainFound, err := fs.CheckKey(tr.DomainKey)
+	if err != nil || tr.Domain != domainFound {
+		return tr.handleMain(w, r, "need to be logged in to change the domain")
+	}
+	if fs.KeyRole(tr.DomainKey) != db.RoleAdmin {
+		return tr.handleMain(w, r, "need to be an admin to change settings")
+	}
+
+	oldName := tr.Domain
+	newName := utils.NormalizeDomain(r.FormValue("new_domain"))
+	err = fs.RenameDomain(oldName, newName)
+	if err != nil {
+		return tr.handleMain(w, r, err.Error())
+	}
+	audit(r, "domain.renamed", newName, "", "renamed from "+oldName)
+	sendEvent("domain.renamed", oldName+" is now "+newName, map[string]string{
+		"domain":     newName,
+		"old_domain": oldName,
+	})
+	http.Redirect(w, r, "/"+newName, http.StatusFound)
+	return nil
+}
+
+// formatDeletePlan shows what deleting a domain would remove, and how to
+// confirm it
+func formatDeletePlan(plan db.DeletePlan) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Dry run of deleting %s.\n\n", plan.Domain)
+	fmt.Fprintf(&b, "%d pages and %d in the trash would be removed, with %d bytes of history.\n", plan.Pages, plan.Trash, plan.PageBytes)
+	fmt.Fprintf(&b, "%d uploads of %d bytes would no longer belong to it.\n", plan.Uploads, plan.UploadBytes)
+	fmt.Fprintf(&b, "%d keys would be logged out.\n", plan.Keys)
+	fmt.Fprintf(&b, "\nTo delete, confirm with the token %s\n", plan.Token)
+	return b.String()
+}
+
+// handleDeleteDomain removes a domain for good. Without a token it is a dry
+// run, which shows what would be removed and the token that confirms it.
+// Its pages are exported first, like the ones of domains that expire, so
+// that an admin of the instance can bring them back.
+func (tr *TemplateRender) handleDeleteDomain(w http.ResponseWriter, r *http.Request) (err error) {
+	tr.DomainKey = strings.TrimSpace(strings.ToLower(r.FormValue("domain_key")))
+	tr.Domain = utils.NormalizeDomain(r.FormValue("domain"))
+	if tr.Domain == "public" || tr.Domain == "" {
+		tr.Domain = "public"
+		return tr.handleMain(w, r, "cannot modify public")
+	}
+
+	// check that the key is valid
+	domainFound, err := fs.CheckKey(tr.DomainKey)
+	if err != nil || tr.Domain != domainFound {
+		return tr.handleMain(w, r, "need to be logged in to change the domain")
+	}
+	if fs.KeyRole(tr.DomainKey) != db.RoleAdmin {
+		return tr.handleMain(w, r, "need to be an admin to change settings")
+	}
+
+	plan, err := fs.PlanDeleteDomain(tr.Domain)
+	if err != nil {
+		return tr.handleMain(w, r, err.Error())
+	}
+	confirm := strings.TrimSpace(r.FormValue("confirm"))
+	if confirm == "" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, err = w.Write([]byte(formatDeletePlan(plan)))
+		return
+	}
+	if confirm != plan.Token {
+		return tr.handleMain(w, r, "the domain changed since the dry run, check it again")
+	}
+
+	file, err := exportDomain(tr.Domain)
+	if err != nil {
+		return tr.handleMain(w, r, "could not export the domain, so it is not deleted: "+err.Error())
+	}
+	err = fs.DeleteDomain(tr.Domain, confirm)
+	if err != nil {
+		return tr.handleMain(w, r, err.Error())
+	}
+	audit(r, "domain.deleted", tr.Domain, "", file)
+	sendEvent("domain.deleted", "deleted "+tr.Domain, map[string]string{
+		"domain": tr.Domain,
+		"file":   file,
+	})
+	http.Redirect(w, r, "/", http.StatusFound)
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	log "github.com/cihub/seelog"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// handleErase erases a text from a domain, or from every domain, with all
+// the versions of its pages, and dumps the database again so that the
+// dump does not keep it (POST). The old dump is overwritten before it is
+// removed when shred is on. The text is never logged or audited.
+func (tr *TemplateRender) handleErase(w http.ResponseWriter, r *http.Request) (err error) {
+	token, ok := checkAdminToken(w, r)
+	if !ok {
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "erase with a POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	domain := utils.NormalizeDomain(r.FormValue("domain"))
+	shred := r.FormValue("shred") == "on"
+	erased, err := fs.Erase(domain, r.FormValue("text"))
+	if err == nil && shred {
+		err = fs.ShredDump()
+	}
+	if err == nil && dumpDatabase {
+		err = fs.DumpSQL()
+		checkDatabase(err)
+	}
+	message := fmt.Sprintf("erased the text from %d pages", erased)
+	if err != nil {
+		log.Error(err)
+		message = "could not erase: " + err.Error()
+	} else {
+		audit(r, "content.erased", domain, "", fmt.Sprintf("%d pages", erased))
+	}
+
+	// the form of the report goes back to the report
+	if r.FormValue("token") != "" {
+		http.Redirect(w, r, "/admin/report?token="+url.QueryEscape(token)+"&m="+url.QueryEscape(message), 302)
+		return nil
+	}
+	if err != nil {
+		return writeJSON(w, http.StatusBadRequest, map[string]interface{}{"success": false, "message": message})
+	}
+	return writeJSON(w, http.StatusOK, map[string]interface{}{"success": true, "message": message, "erased": erased})
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// eventsPing is how often a stream of the changes of a page is sent a
+// comment, so that proxies do not close it
+const eventsPing = 30 * time.Second
+
+// handlePageEvents streams the versions of a page as server-sent events,
+// one "changed" event each time it is saved or put in the trash, so that
+// the people reading it know to reload it (/api/v1/{domain}/events/{id}).
+// A version given as ?version= that is not the current one is sent at
+// once, so that changes made while the page was loading are not missed.
+func (tr *TemplateRender) handlePageEvents(w http.ResponseWriter, r *http.Request) (err error) {
+	fields := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	domain := utils.NormalizeDomain(fields[2])
+	if !tr.canRead(domain, documentKey(r)) {
+		http.Error(w, "need to be logged in", http.StatusForbidden)
+		return nil
+	}
+	files, err := fs.GetCtx(tr.readContext(r, domain, documentKey(r)), fields[4], domain)
+	if err != nil {
+		http.Error(w, "page does not exist", http.StatusNotFound)
+		return nil
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return nil
+	}
+
+	changes, stop := fs.Watch(files[0].ID)
+	defer stop()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// nginx would keep the events until the stream ends
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: 10000\n\n")
+	current := db.VersionHash(files[0].Data)
+	if version := r.URL.Query().Get("version"); version != "" && version != current {
+		fmt.Fprintf(w, "event: changed\ndata: %s\n\n", current)
+	}
+	flusher.Flush()
+
+	ping := time.NewTicker(eventsPing)
+	defer ping.Stop()
+	for {
+		select {
+		case version := <-changes:
+			fmt.Fprintf(w, "event: changed\ndata: %s\n\n", version)
+		case <-ping.C:
+			fmt.Fprintf(w, ": ping\n\n")
+		case <-r.Context().Done():
+			return nil
+		}
+		flusher.Flush()
+	}
+}
+
+// handleDomainEvents streams the changes to the pages of a domain as
+// server-sent events, a "create", "update" or "delete" event with the
+// metadata of the page each time one changes (/api/v1/domains/{domain}/events).
+// The stream ends when it falls too far behind, and the client reads the
+// pages again when it reconnects.
+func (tr *TemplateRender) handleDomainEvents(w http.ResponseWriter, r *http.Request) (err error) {
+	domain := utils.NormalizeDomain(strings.Split(strings.Trim(r.URL.Path, "/"), "/")[3])
+	if _, _, errDomain := fs.GetDomainFromName(domain); errDomain != nil {
+		return writeJSON(w, http.StatusNotFound, Payload{Message: "domain does not exist"})
+	}
+	if !tr.canRead(domain, documentKey(r)) {
+		return writeJSON(w, http.StatusForbidden, Payload{Message: "need to be logged in"})
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return writeJSON(w, http.StatusInternalServerError, Payload{Message: "streaming is not supported"})
+	}
+
+	events, stop := fs.WatchDomain(domain)
+	defer stop()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// nginx would keep the events until the stream ends
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: 10000\n\n")
+	flusher.Flush()
+
+	ping := time.NewTicker(eventsPing)
+	defer ping.Stop()
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return nil
+			}
+			b, errJSON := json.Marshal(e)
+			if errJSON != nil {
+				return errJSON
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, b)
+		case <-ping.C:
+			fmt.Fprintf(w, ": ping\n\n")
+		case <-r.Context().Done():
+			return nil
+		}
+		flusher.Flush()
+	}
+}
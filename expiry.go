@@ -0,0 +1,119 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/cihub/seelog"
+)
+
+// domainExpiry is how long a domain that no account has a role in can go
+// without being used before it is flagged to be removed
+var domainExpiry time.Duration
+
+// expiryGrace is how long a flagged domain is kept, and told about on its
+// page, before it is exported and removed
+var expiryGrace = 7 * 24 * time.Hour
+
+// expiryDir is the directory that removed domains are exported to
+var expiryDir = "expired"
+
+// expireDomains flags the domains that no account has a role in and that
+// nobody has used for the domainExpiry, and exports and removes the ones
+// that were flagged for longer than the expiryGrace. The webhook is told
+// about both, since there is nobody else to tell.
+func expireDomains() {
+	if domainExpiry <= 0 {
+		return
+	}
+	domains, err := fs.GetAnonymousDomains()
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	idleSince := time.Now().Add(-domainExpiry)
+	for _, d := range domains {
+		if d.LastUsed.After(idleSince) || (!d.Expiring.IsZero() && d.LastUsed.After(d.Expiring)) {
+			if !d.Expiring.IsZero() {
+				log.Infof("%s is used again, so it is not removed", d.Name)
+				if err = fs.SetDomainExpiring(d.Name, time.Time{}); err != nil {
+					log.Error(err)
+				}
+			}
+			continue
+		}
+		if d.Expiring.IsZero() {
+			log.Infof("flagging %s to be removed", d.Name)
+			if err = fs.SetDomainExpiring(d.Name, time.Now()); err != nil {
+				log.Error(err)
+				continue
+			}
+			sendEvent("domain.expiring", d.Name+" will be removed unless it is used", map[string]string{
+				"domain":    d.Name,
+				"last_used": d.LastUsed.UTC().Format(time.RFC3339),
+				"removed":   time.Now().Add(expiryGrace).UTC().Format(time.RFC3339),
+			})
+			continue
+		}
+		if time.Since(d.Expiring) < expiryGrace {
+			continue
+		}
+		// the plan is made before the export, so that a domain that is
+		// used while it is exported is not removed
+		plan, errPlan := fs.PlanDeleteDomain(d.Name)
+		if errPlan != nil {
+			log.Error(errPlan)
+			continue
+		}
+		file, errExport := exportDomain(d.Name)
+		if errExport != nil {
+			log.Errorf("could not export %s, so it is not removed: %s", d.Name, errExport.Error())
+			continue
+		}
+		if err = fs.DeleteDomain(d.Name, plan.Token); err != nil {
+			log.Error(err)
+			continue
+		}
+		log.Infof("removed %s, which was exported to %s", d.Name, file)
+		audit(nil, "domain.expired", d.Name, "", file)
+		sendEvent("domain.expired", "removed "+d.Name, map[string]string{
+			"domain": d.Name,
+			"file":   file,
+		})
+	}
+}
+
+// exportDomain writes every page of a domain, with its history, as gzipped
+// JSON to the expiryDir and returns the name of the file
+func exportDomain(domain string) (file string, err error) {
+	pages, err := domainPages(domain)
+	if err != nil {
+		return
+	}
+
+	err = os.MkdirAll(expiryDir, 0755)
+	if err != nil {
+		return
+	}
+	file = filepath.Join(expiryDir, domain+"-"+time.Now().Format("20060102150405")+".json.gz")
+	fi, err := os.Create(file)
+	if err != nil {
+		return
+	}
+	defer fi.Close()
+	gz := gzip.NewWriter(fi)
+	err = json.NewEncoder(gz).Encode(struct {
+		Domain   string        `json:"domain"`
+		Exported time.Time     `json:"exported"`
+		Pages    []takeoutPage `json:"pages"`
+	}{domain, time.Now().UTC(), pages})
+	if err != nil {
+		gz.Close()
+		return
+	}
+	err = gz.Close()
+	return
+}
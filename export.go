@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+var uploadLink = regexp.MustCompile(`/uploads/(sha256-[0-9a-f]+)(\?filename=([^)\s"]*))?`)
+
+// exportSite writes a domain as the content directory of a Hugo or
+// Jekyll site
+func exportSite(args []string) (err error) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	domain := flags.String("domain", "", "domain to export")
+	format := flags.String("format", "hugo", "site format, either hugo or jekyll")
+	out := flags.String("out", "site", "directory to write the site to")
+	flags.Parse(args)
+	if *domain == "" {
+		return errors.New("usage: rwtxt export --domain x --format hugo|jekyll --out dir")
+	}
+	if *format != "hugo" && *format != "jekyll" {
+		return errors.New("format must be hugo or jekyll")
+	}
+
+	fs, err = db.New(dbName, dbOptions)
+	if err != nil {
+		return
+	}
+	defer fs.Close()
+
+	files, err := fs.GetAllCtx(db.AsMember(context.Background()), *domain)
+	if err != nil {
+		return
+	}
+
+	contentDir := filepath.Join(*out, "content")
+	uploadDir := filepath.Join(*out, "static", "uploads")
+	if *format == "jekyll" {
+		contentDir = filepath.Join(*out, "_posts")
+		uploadDir = filepath.Join(*out, "uploads")
+	}
+	err = os.MkdirAll(contentDir, 0755)
+	if err != nil {
+		return
+	}
+
+	// the name of each page in the site, by id and by slug
+	names := make(map[string]string)
+	for _, f := range files {
+		name := f.Slug
+		if name == "" {
+			name = f.ID
+		}
+		if *format == "jekyll" {
+			name = f.Created.Format("2006-01-02") + "-" + name
+		}
+		names[f.ID] = name
+		if _, ok := names[f.Slug]; !ok && f.Slug != "" {
+			names[f.Slug] = name
+		}
+	}
+
+	internalLink := regexp.MustCompile(`\]\(/` + regexp.QuoteMeta(*domain) + `/([^)\s/?#]+)\)`)
+	for _, f := range files {
+		meta, body := utils.ParseFrontMatter(f.Data)
+
+		// rewrite links to other pages in the domain
+		body = internalLink.ReplaceAllStringFunc(body, func(link string) string {
+			target := internalLink.FindStringSubmatch(link)[1]
+			name, ok := names[target]
+			if !ok {
+				return link
+			}
+			if *format == "jekyll" {
+				return "]({% post_url " + name + " %})"
+			}
+			return `]({{< ref "` + name + `.md" >}})`
+		})
+
+		// copy uploads and rewrite their links
+		for _, match := range uploadLink.FindAllStringSubmatch(body, -1) {
+			blobName, errBlob := exportBlob(match[1], uploadDir)
+			if errBlob != nil {
+				log.Warnf("could not export %s: %s", match[1], errBlob.Error())
+				continue
+			}
+			body = strings.Replace(body, match[0], "/uploads/"+match[1]+"/"+blobName, -1)
+		}
+
+		meta["title"] = pageTitle(f, body)
+		meta["date"] = f.Created.Format("2006-01-02T15:04:05Z07:00")
+		if *format == "hugo" {
+			meta["lastmod"] = f.Modified.Format("2006-01-02T15:04:05Z07:00")
+			meta["slug"] = names[f.ID]
+		} else {
+			meta["last_modified_at"] = f.Modified.Format("2006-01-02T15:04:05Z07:00")
+			if _, ok := meta["layout"]; !ok {
+				meta["layout"] = "post"
+			}
+		}
+
+		err = ioutil.WriteFile(filepath.Join(contentDir, names[f.ID]+".md"), []byte(frontMatter(meta)+body), 0644)
+		if err != nil {
+			return
+		}
+	}
+	log.Infof("exported %d pages from %s to %s", len(files), *domain, *out)
+	return
+}
+
+// exportBlob writes an upload to the upload directory and returns its file name
+func exportBlob(id, uploadDir string) (name string, err error) {
+	name, data, err := readBlob(id)
+	if err != nil {
+		return
+	}
+	name = path.Base(name)
+	err = os.MkdirAll(filepath.Join(uploadDir, id), 0755)
+	if err != nil {
+		return
+	}
+	err = ioutil.WriteFile(filepath.Join(uploadDir, id, name), data, 0644)
+	return
+}
+
+// gunzip decompresses uploads and assets, which are stored gzipped
+func gunzip(data []byte) ([]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	return ioutil.ReadAll(gz)
+}
+
+// pageTitle returns the first heading of a page, or its slug
+func pageTitle(f db.File, body string) string {
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "#") {
+			return strings.TrimSpace(strings.TrimLeft(line, "#"))
+		}
+	}
+	if f.Slug != "" {
+		return f.Slug
+	}
+	return f.ID
+}
+
+// frontMatter returns the keys as YAML front matter
+func frontMatter(meta map[string]string) string {
+	keys := make([]string, 0, len(meta))
+	for key := range meta {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteString("---\n")
+	for _, key := range keys {
+		b.WriteString(fmt.Sprintf("%s: %q\n", key, meta[key]))
+	}
+	b.WriteString("---\n")
+	return b.String()
+}
+
+// handleExportZip sends the pages and uploads of a domain as a zip of
+// markdown files, which only its admins can get, at /DOMAIN/export.zip
+func (tr *TemplateRender) handleExportZip(w http.ResponseWriter, r *http.Request) (err error) {
+	domainKey := r.FormValue("domain_key")
+	if domainKey == "" {
+		domainKey = tr.DomainKeys[tr.Domain]
+	}
+	if !tr.canWrite(tr.Domain, domainKey) {
+		return tr.handleMain(w, r, "need to be logged in to export the domain")
+	}
+	if fs.KeyRole(domainKey) != db.RoleAdmin {
+		return tr.handleMain(w, r, "need to be an admin to export the domain")
+	}
+	audit(r, "domain.exported", tr.Domain, "", "")
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+tr.Domain+`-`+time.Now().Format("20060102")+`.zip"`)
+	w.Header().Set("Cache-Control", "no-store")
+	err = fs.ExportDomain(tr.Domain, w)
+	if err != nil {
+		// the archive is already being sent, so it can only be cut short
+		log.Error(err)
+	}
+	return nil
+}
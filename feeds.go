@@ -0,0 +1,350 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// feedInterval is how often the feeds are polled for new entries
+var feedInterval = time.Hour
+
+// feedClient polls the feeds, and like clipClient only connects to public
+// addresses
+var feedClient = publicClient(20 * time.Second)
+
+// FeedRequest is the body of a request to subscribe a domain to a feed
+type FeedRequest struct {
+	Domain    string `json:"domain"`
+	DomainKey string `json:"domain_key,omitempty"`
+	URL       string `json:"url"`
+	Tag       string `json:"tag,omitempty"`
+}
+
+// feedEntry is an entry of an RSS or Atom feed
+type feedEntry struct {
+	GUID      string
+	Title     string
+	Link      string
+	Content   string
+	Published time.Time
+}
+
+// rssFeed is an RSS 2.0 feed
+type rssFeed struct {
+	Items []struct {
+		GUID        string `xml:"guid"`
+		Title       string `xml:"title"`
+		Link        string `xml:"link"`
+		Description string `xml:"description"`
+		Content     string `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+		PubDate     string `xml:"pubDate"`
+	} `xml:"channel>item"`
+}
+
+// atomFeed is an Atom feed
+type atomFeed struct {
+	Entries []struct {
+		ID    string `xml:"id"`
+		Title string `xml:"title"`
+		Links []struct {
+			Href string `xml:"href,attr"`
+			Rel  string `xml:"rel,attr"`
+		} `xml:"link"`
+		Summary   string `xml:"summary"`
+		Content   string `xml:"content"`
+		Published string `xml:"published"`
+		Updated   string `xml:"updated"`
+	} `xml:"entry"`
+}
+
+// parseFeed reads the entries of an RSS or Atom feed, oldest first
+func parseFeed(r io.Reader) (entries []feedEntry, err error) {
+	var root struct {
+		XMLName xml.Name
+		rssFeed
+		atomFeed
+	}
+	decoder := xml.NewDecoder(r)
+	decoder.Strict = false
+	decoder.Entity = xml.HTMLEntity
+	err = decoder.Decode(&root)
+	if err != nil {
+		return
+	}
+
+	switch root.XMLName.Local {
+	case "rss":
+		for _, item := range root.Items {
+			e := feedEntry{
+				GUID:    strings.TrimSpace(item.GUID),
+				Title:   strings.TrimSpace(item.Title),
+				Link:    strings.TrimSpace(item.Link),
+				Content: item.Content,
+			}
+			if e.Content == "" {
+				e.Content = item.Description
+			}
+			e.Published, _ = parseFeedTime(item.PubDate)
+			entries = append(entries, e)
+		}
+	case "feed":
+		for _, entry := range root.Entries {
+			e := feedEntry{
+				GUID:    strings.TrimSpace(entry.ID),
+				Title:   strings.TrimSpace(entry.Title),
+				Content: entry.Content,
+			}
+			for _, link := range entry.Links {
+				if link.Rel == "" || link.Rel == "alternate" {
+					e.Link = strings.TrimSpace(link.Href)
+					break
+				}
+			}
+			if e.Content == "" {
+				e.Content = entry.Summary
+			}
+			published := entry.Published
+			if published == "" {
+				published = entry.Updated
+			}
+			e.Published, _ = parseFeedTime(published)
+			entries = append(entries, e)
+		}
+	default:
+		err = fmt.Errorf("%s is not an RSS or Atom feed", root.XMLName.Local)
+		return
+	}
+
+	for i := range entries {
+		if entries[i].GUID == "" {
+			entries[i].GUID = entries[i].Link
+		}
+		if entries[i].GUID == "" {
+			entries[i].GUID = entries[i].Title
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Published.Before(entries[j].Published)
+	})
+	return
+}
+
+// parseFeedTime reads the dates of RSS (RFC 822) and Atom (RFC 3339)
+func parseFeedTime(s string) (t time.Time, err error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range []string{time.RFC3339, time.RFC1123Z, time.RFC1123, "Mon, 2 Jan 2006 15:04:05 -0700", "Mon, 2 Jan 2006 15:04:05 MST", time.RFC822Z, time.RFC822} {
+		t, err = time.Parse(layout, s)
+		if err == nil {
+			return
+		}
+	}
+	return
+}
+
+// feedTag is the tag of the pages of a feed that was not given one, which
+// is the host of the feed
+func feedTag(feedURL string) string {
+	u, err := url.Parse(feedURL)
+	if err != nil {
+		return "feed"
+	}
+	return strings.TrimPrefix(u.Hostname(), "www.")
+}
+
+// feedPage makes a page from an entry of a feed, with the source in its
+// front matter
+func feedPage(feed db.Feed, e feedEntry) (f db.File, err error) {
+	content, err := utils.HTMLToMarkdown(e.Content)
+	if err != nil {
+		return
+	}
+	title := e.Title
+	if title == "" {
+		title = feedTag(feed.URL)
+	}
+	published := e.Published
+	if published.IsZero() {
+		published = time.Now()
+	}
+
+	var b strings.Builder
+	b.WriteString("---\n")
+	if feed.Tag != "" {
+		b.WriteString("tags: " + feed.Tag + "\n")
+	}
+	if e.Link != "" {
+		b.WriteString("source: " + e.Link + "\n")
+	}
+	b.WriteString("feed: " + feed.URL + "\n")
+	b.WriteString("date: " + published.UTC().Format(time.RFC3339) + "\n")
+	b.WriteString("---\n\n# " + title + "\n\n")
+	if content = strings.TrimSpace(content); content != "" {
+		b.WriteString(content + "\n\n")
+	}
+	if e.Link != "" {
+		b.WriteString("*From <" + e.Link + ">*")
+	}
+
+	f = db.File{
+		ID:       utils.UUID(),
+		Slug:     utils.Slugify(title),
+		Data:     strings.TrimSpace(b.String()),
+		Domain:   feed.Domain,
+		Source:   feed.URL,
+		Created:  published,
+		Modified: time.Now(),
+	}
+	f.Slug = pageSlug(f, title)
+	return
+}
+
+// pollFeed saves the entries of a feed that were not saved before
+func pollFeed(feed db.Feed) (saved int, err error) {
+	if feed.Domain == "public" {
+		err = errors.New("feeds are not saved to public")
+		return
+	}
+	u, err := url.Parse(feed.URL)
+	if err != nil {
+		return
+	}
+	err = checkPublicURL(u)
+	if err != nil {
+		return
+	}
+	resp, err := feedClient.Get(u.String())
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("got %s", resp.Status)
+		return
+	}
+	entries, err := parseFeed(io.LimitReader(resp.Body, maxClipSize))
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if e.GUID == "" || fs.HasFeedItem(feed.ID, e.GUID) {
+			continue
+		}
+		var f db.File
+		f, err = feedPage(feed, e)
+		if err != nil {
+			return
+		}
+		err = fs.Save(f)
+		if err != nil {
+			return
+		}
+		audit(nil, "page.saved", f.Domain, f.ID, f.Slug)
+		err = fs.AddFeedItem(feed.ID, e.GUID, f.ID)
+		if err != nil {
+			return
+		}
+		saved++
+	}
+	return
+}
+
+// pollFeeds polls every feed and records how it went
+func pollFeeds() {
+	feeds, err := fs.GetFeeds("")
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	for _, feed := range feeds {
+		recordPoll(feed)
+	}
+}
+
+// recordPoll polls a feed and records when it was polled, and why it
+// could not be
+func recordPoll(feed db.Feed) {
+	saved, err := pollFeed(feed)
+	pollError := ""
+	if err != nil {
+		pollError = err.Error()
+		log.Debugf("could not poll %s: %s", feed.URL, pollError)
+	} else if saved > 0 {
+		log.Infof("saved %d entries of %s to %s", saved, feed.URL, feed.Domain)
+	}
+	if err = fs.SetFeedPolled(feed.ID, time.Now(), pollError); err != nil {
+		log.Error(err)
+	}
+}
+
+// handleFeeds lists (GET), adds (POST) and removes (DELETE) the feeds of
+// a domain. Only those who can edit a domain can change its feeds.
+func (tr *TemplateRender) handleFeeds(w http.ResponseWriter, r *http.Request) (err error) {
+	var req FeedRequest
+	if r.Method == "POST" {
+		err = json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			return writeJSON(w, http.StatusBadRequest, Payload{Message: err.Error()})
+		}
+	} else {
+		req.Domain = r.FormValue("domain")
+		req.DomainKey = r.FormValue("domain_key")
+	}
+	req.Domain = utils.NormalizeDomain(req.Domain)
+	if req.Domain == "" || req.Domain == "public" {
+		return writeJSON(w, http.StatusForbidden, Payload{Message: "need to log in to a domain to subscribe to feeds"})
+	}
+	if !tr.canWrite(req.Domain, req.DomainKey) {
+		return writeJSON(w, http.StatusForbidden, Payload{Message: "need to be logged in"})
+	}
+
+	switch r.Method {
+	case "POST":
+		u, errURL := url.Parse(strings.TrimSpace(req.URL))
+		if errURL == nil {
+			errURL = checkPublicURL(u)
+		}
+		if errURL != nil {
+			return writeJSON(w, http.StatusBadRequest, Payload{Message: errURL.Error()})
+		}
+		if strings.TrimSpace(req.Tag) == "" {
+			req.Tag = feedTag(req.URL)
+		}
+		feed, errAdd := fs.AddFeed(req.Domain, req.URL, req.Tag)
+		if errAdd != nil {
+			return writeJSON(w, http.StatusBadRequest, Payload{Message: errAdd.Error()})
+		}
+		audit(r, "feed.added", feed.Domain, "", feed.URL)
+		go recordPoll(feed)
+		return writeJSON(w, http.StatusOK, feed)
+	case "DELETE":
+		id, errID := strconv.Atoi(r.FormValue("id"))
+		if errID != nil {
+			return writeJSON(w, http.StatusBadRequest, Payload{Message: errID.Error()})
+		}
+		err = fs.DeleteFeed(req.Domain, id)
+		if err != nil {
+			return writeJSON(w, http.StatusNotFound, Payload{Message: err.Error()})
+		}
+		audit(r, "feed.removed", req.Domain, "", strconv.Itoa(id))
+		return writeJSON(w, http.StatusOK, Payload{Success: true})
+	default:
+		feeds, errGet := fs.GetFeeds(req.Domain)
+		if errGet != nil {
+			return writeJSON(w, http.StatusInternalServerError, Payload{Message: errGet.Error()})
+		}
+		return writeJSON(w, http.StatusOK, feeds)
+	}
+}
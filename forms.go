@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"html/template"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// forms need to take at least this long to fill in, to stop bots
+const formMinTime = 3 * time.Second
+
+var formBlock = regexp.MustCompile("(?s)```form\\s*\\n(.*?)```")
+var renderedFormBlock = regexp.MustCompile(`(?s)<pre><code class="language-form">.*?</code></pre>`)
+
+type formField struct {
+	Name    string
+	Label   string
+	Type    string
+	Options []string
+}
+
+type formRender struct {
+	Domain    string
+	FileID    string
+	Fields    []formField
+	Time      int64
+	Nonce     string
+	Signature string
+	Submitted bool
+	SignedIn  bool
+}
+
+var formTemplate = template.Must(template.New("form").Parse(`<form class="page-form" action="/form" method="post">
+{{ if .Submitted }}<p><em>Thanks, your response was saved.</em></p>{{ end }}
+<input type="hidden" name="domain" value="{{.Domain}}">
+<input type="hidden" name="page" value="{{.FileID}}">
+<input type="hidden" name="t" value="{{.Time}}">
+<input type="hidden" name="n" value="{{.Nonce}}">
+<input type="hidden" name="s" value="{{.Signature}}">
+<input type="text" name="website" value="" class="page-form-website" tabindex="-1" autocomplete="off">
+{{ range .Fields }}<label>{{.Label}}<br>
+{{ if eq .Type "textarea" }}<textarea name="{{.Name}}" rows="4"></textarea>
+{{ else if .Options }}<select name="{{.Name}}">{{ range .Options }}<option>{{.}}</option>{{ end }}</select>
+{{ else }}<input type="{{.Type}}" name="{{.Name}}">
+{{ end }}</label><br>
+{{ end }}<input class="button1" type="submit" value="Submit">
+{{ if .SignedIn }}<a href="/form/export?domain={{.Domain}}&amp;page={{.FileID}}">Download responses</a>{{ end }}
+</form>`))
+
+// parseForm returns the fields of the form defined in a page as
+//
+//	```form
+//	Name: text
+//	Attending: yes, no, maybe
+//	```
+//
+// where the type is text, email, number, date, textarea or a list of options
+func parseForm(markdown string) (fields []formField) {
+	match := formBlock.FindStringSubmatch(markdown)
+	if match == nil {
+		return
+	}
+	for _, line := range strings.Split(match[1], "\n") {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			continue
+		}
+		field := formField{
+			Label: strings.TrimSpace(parts[0]),
+			Name:  utils.Slugify(parts[0]),
+			Type:  strings.ToLower(strings.TrimSpace(parts[1])),
+		}
+		switch field.Type {
+		case "text", "email", "number", "date", "textarea":
+		case "":
+			field.Type = "text"
+		default:
+			for _, option := range strings.Split(parts[1], ",") {
+				field.Options = append(field.Options, strings.TrimSpace(option))
+			}
+			field.Type = "select"
+		}
+		fields = append(fields, field)
+	}
+	return
+}
+
+// formSignature signs the time a form was shown and the nonce that makes
+// each form that is shown single use
+func formSignature(fileID string, t int64, nonce string) string {
+	secret, err := fs.GetSecret("forms")
+	if err != nil {
+		log.Error(err)
+	}
+	return utils.Hash(secret, fmt.Sprintf("%s %d %s", fileID, t, nonce))[:16]
+}
+
+// addForm replaces the form block in a rendered page with the form
+func (tr *TemplateRender) addForm(rendered template.HTML, markdown string, submitted bool) template.HTML {
+	fields := parseForm(markdown)
+	if len(fields) == 0 {
+		return rendered
+	}
+	now := time.Now().Unix()
+	nonce := utils.UUID()
+	var buf bytes.Buffer
+	err := formTemplate.Execute(&buf, formRender{
+		Domain:    tr.Domain,
+		FileID:    tr.File.ID,
+		Fields:    fields,
+		Time:      now,
+		Nonce:     nonce,
+		Signature: formSignature(tr.File.ID, now, nonce),
+		Submitted: submitted,
+		SignedIn:  tr.SignedIn && tr.Domain != "public",
+	})
+	if err != nil {
+		log.Error(err)
+		return rendered
+	}
+	first := true
+	return template.HTML(renderedFormBlock.ReplaceAllStringFunc(string(rendered), func(s string) string {
+		if !first {
+			return s
+		}
+		first = false
+		return buf.String()
+	}))
+}
+
+// handleForm saves a submission of the form on a page
+func (tr *TemplateRender) handleForm(w http.ResponseWriter, r *http.Request) (err error) {
+	tr.Domain = utils.NormalizeDomain(r.FormValue("domain"))
+	page := strings.TrimSpace(r.FormValue("page"))
+	if !tr.canRead(tr.Domain, "") {
+		return tr.handleMain(w, r, "need to be logged in to submit")
+	}
+
+	// bots fill in hidden fields, submit too quickly and replay forms
+	t, _ := strconv.ParseInt(r.FormValue("t"), 10, 64)
+	nonce := r.FormValue("n")
+	age := time.Since(time.Unix(t, 0))
+	if r.FormValue("website") != "" || nonce == "" || r.FormValue("s") != formSignature(page, t, nonce) || age < formMinTime || age > 24*time.Hour {
+		http.Error(w, "could not save response, please try again", http.StatusBadRequest)
+		return
+	}
+
+	files, err := fs.GetCtx(tr.readContext(r, tr.Domain, ""), page, tr.Domain)
+	if err != nil {
+		return tr.handleMain(w, r, err.Error())
+	}
+	f := files[0]
+	data := make(map[string]string)
+	for _, field := range parseForm(f.Data) {
+		data[field.Name] = strings.TrimSpace(r.FormValue(field.Name))
+	}
+	if len(data) == 0 {
+		return tr.handleMain(w, r, "page has no form")
+	}
+	err = fs.AddResponse(f.ID, tr.Domain, data, remoteIP(r), nonce)
+	if err != nil {
+		return tr.handleMain(w, r, err.Error())
+	}
+	http.Redirect(w, r, "/"+tr.Domain+"/"+f.ID+"?submitted=1", 302)
+	return nil
+}
+
+// handleFormExport downloads the submissions of the form on a page as csv
+func (tr *TemplateRender) handleFormExport(w http.ResponseWriter, r *http.Request) (err error) {
+	tr.Domain = utils.NormalizeDomain(r.FormValue("domain"))
+	page := strings.TrimSpace(r.FormValue("page"))
+	if tr.Domain == "public" || !tr.canWrite(tr.Domain, r.FormValue("domain_key")) {
+		return tr.handleMain(w, r, "need to be logged in to download responses")
+	}
+	files, err := fs.GetCtx(db.AsMember(r.Context()), page, tr.Domain)
+	if err != nil {
+		return tr.handleMain(w, r, err.Error())
+	}
+	f := files[0]
+	responses, err := fs.GetResponses(f.ID, tr.Domain)
+	if err != nil {
+		return tr.handleMain(w, r, err.Error())
+	}
+
+	// the columns are the current fields, then any from older versions
+	columns := []string{}
+	haveColumn := make(map[string]bool)
+	for _, field := range parseForm(f.Data) {
+		columns = append(columns, field.Name)
+		haveColumn[field.Name] = true
+	}
+	for _, response := range responses {
+		for name := range response.Data {
+			if !haveColumn[name] {
+				columns = append(columns, name)
+				haveColumn[name] = true
+			}
+		}
+	}
+
+	name := f.Slug
+	if name == "" {
+		name = f.ID
+	}
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.csv"`)
+	cw := csv.NewWriter(w)
+	cw.Write(append([]string{"submitted"}, columns...))
+	for _, response := range responses {
+		row := []string{response.Created.Format(time.RFC3339)}
+		for _, column := range columns {
+			row = append(row, response.Data[column])
+		}
+		cw.Write(row)
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// sheet evaluates formulas in the cells of a table. Cells are addressed
+// like a spreadsheet, so A1 is the first column of the header row and
+// A2 is the first column of the first row of data.
+type sheet struct {
+	records [][]string
+	values  map[[2]int]float64
+	visited map[[2]int]bool
+}
+
+func newSheet(records [][]string) *sheet {
+	return &sheet{
+		records: records,
+		values:  make(map[[2]int]float64),
+		visited: make(map[[2]int]bool),
+	}
+}
+
+func isFormula(cell string) bool {
+	return strings.HasPrefix(strings.TrimSpace(cell), "=")
+}
+
+// display returns what to show for a cell
+func (s *sheet) display(row, column int) string {
+	cell := s.records[row][column]
+	if !isFormula(cell) {
+		return cell
+	}
+	v, err := s.value(row, column)
+	if err != nil {
+		return "#" + err.Error()
+	}
+	return formatNumber(v)
+}
+
+// value returns the number in a cell, evaluating it if it is a formula
+func (s *sheet) value(row, column int) (v float64, err error) {
+	if row < 0 || row >= len(s.records) || column < 0 || column >= len(s.records[row]) {
+		return 0, nil
+	}
+	key := [2]int{row, column}
+	if v, ok := s.values[key]; ok {
+		return v, nil
+	}
+	cell := strings.TrimSpace(s.records[row][column])
+	if !isFormula(cell) {
+		v, _ = parseNumber(cell)
+		return v, nil
+	}
+	if s.visited[key] {
+		return 0, errors.New("CYCLE")
+	}
+	s.visited[key] = true
+	p := &formulaParser{s: s, row: row, column: column, text: cell[1:]}
+	v, err = p.parse()
+	if err != nil {
+		// forget the visit, so the cell reports its own error next time
+		delete(s.visited, key)
+		return
+	}
+	s.values[key] = v
+	return
+}
+
+// numbers returns the numeric cells in a rectangle, skipping text and
+// blanks. The rectangle is cut to the table, so a range that is larger
+// than it does not take longer.
+func (s *sheet) numbers(r1, c1, r2, c2 int) (nums []float64, err error) {
+	if r1 < 0 {
+		r1 = 0
+	}
+	if c1 < 0 {
+		c1 = 0
+	}
+	if r2 > len(s.records)-1 {
+		r2 = len(s.records) - 1
+	}
+	for r := r1; r <= r2; r++ {
+		last := c2
+		if last > len(s.records[r])-1 {
+			last = len(s.records[r]) - 1
+		}
+		for c := c1; c <= last; c++ {
+			if isFormula(s.records[r][c]) {
+				v, errV := s.value(r, c)
+				if errV != nil {
+					return nil, errV
+				}
+				nums = append(nums, v)
+			} else if v, ok := parseNumber(s.records[r][c]); ok {
+				nums = append(nums, v)
+			}
+		}
+	}
+	return
+}
+
+// parseNumber reads a number, allowing currency signs and thousands separators
+func parseNumber(s string) (float64, bool) {
+	s = strings.TrimSpace(s)
+	s = strings.TrimLeft(s, "$€£¥")
+	s = strings.Replace(s, ",", "", -1)
+	if s == "" {
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	return v, err == nil
+}
+
+func formatNumber(v float64) string {
+	return strconv.FormatFloat(math.Round(v*1e9)/1e9, 'f', -1, 64)
+}
+
+// formulaParser is a recursive descent parser for formulas like
+// SUM(above), B2*C2 or AVG(B2:B10)/2
+type formulaParser struct {
+	s      *sheet
+	row    int
+	column int
+	text   string
+	pos    int
+}
+
+func (p *formulaParser) parse() (v float64, err error) {
+	v, err = p.expr()
+	if err != nil {
+		return
+	}
+	p.skipSpace()
+	if p.pos < len(p.text) {
+		err = errors.New("ERR")
+	}
+	return
+}
+
+func (p *formulaParser) skipSpace() {
+	for p.pos < len(p.text) && p.text[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+func (p *formulaParser) peek() byte {
+	p.skipSpace()
+	if p.pos < len(p.text) {
+		return p.text[p.pos]
+	}
+	return 0
+}
+
+func (p *formulaParser) expr() (v float64, err error) {
+	v, err = p.term()
+	for err == nil {
+		op := p.peek()
+		if op != '+' && op != '-' {
+			break
+		}
+		p.pos++
+		var w float64
+		w, err = p.term()
+		if op == '+' {
+			v += w
+		} else {
+			v -= w
+		}
+	}
+	return
+}
+
+func (p *formulaParser) term() (v float64, err error) {
+	v, err = p.factor()
+	for err == nil {
+		op := p.peek()
+		if op != '*' && op != '/' {
+			break
+		}
+		p.pos++
+		var w float64
+		w, err = p.factor()
+		if op == '*' {
+			v *= w
+		} else if w == 0 {
+			err = errors.New("DIV/0")
+		} else {
+			v /= w
+		}
+	}
+	return
+}
+
+func (p *formulaParser) factor() (v float64, err error) {
+	c := p.peek()
+	switch {
+	case c == '-':
+		p.pos++
+		v, err = p.factor()
+		return -v, err
+	case c == '(':
+		p.pos++
+		v, err = p.expr()
+		if err == nil && p.peek() != ')' {
+			err = errors.New("ERR")
+		}
+		p.pos++
+		return
+	case c == '.' || (c >= '0' && c <= '9'):
+		start := p.pos
+		for p.pos < len(p.text) && (p.text[p.pos] == '.' || (p.text[p.pos] >= '0' && p.text[p.pos] <= '9')) {
+			p.pos++
+		}
+		v, err = strconv.ParseFloat(p.text[start:p.pos], 64)
+		if err != nil {
+			err = errors.New("ERR")
+		}
+		return
+	case unicode.IsLetter(rune(c)):
+		word := p.word()
+		if p.peek() == '(' {
+			p.pos++
+			return p.function(word)
+		}
+		row, column, ok := parseRef(word)
+		if !ok {
+			return 0, errors.New("ERR")
+		}
+		return p.s.value(row, column)
+	}
+	return 0, errors.New("ERR")
+}
+
+func (p *formulaParser) word() string {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.text) && (unicode.IsLetter(rune(p.text[p.pos])) || unicode.IsDigit(rune(p.text[p.pos]))) {
+		p.pos++
+	}
+	return strings.ToUpper(p.text[start:p.pos])
+}
+
+// function reads the arguments of a function and applies it
+func (p *formulaParser) function(name string) (v float64, err error) {
+	var nums []float64
+	for {
+		var arg []float64
+		arg, err = p.argument()
+		if err != nil {
+			return
+		}
+		nums = append(nums, arg...)
+		c := p.peek()
+		p.pos++
+		if c == ')' {
+			break
+		} else if c != ',' {
+			return 0, errors.New("ERR")
+		}
+	}
+
+	switch name {
+	case "SUM":
+		for _, n := range nums {
+			v += n
+		}
+	case "AVG", "AVERAGE":
+		if len(nums) == 0 {
+			return 0, errors.New("DIV/0")
+		}
+		for _, n := range nums {
+			v += n
+		}
+		v = v / float64(len(nums))
+	case "MIN", "MAX":
+		for i, n := range nums {
+			if i == 0 || (name == "MIN" && n < v) || (name == "MAX" && n > v) {
+				v = n
+			}
+		}
+	case "COUNT":
+		v = float64(len(nums))
+	default:
+		err = errors.New("NAME")
+	}
+	return
+}
+
+// argument reads a function argument, which is a direction (above, below,
+// left, right), a range like B2:B10, or an expression
+func (p *formulaParser) argument() (nums []float64, err error) {
+	start := p.pos
+	word := p.word()
+	last := len(p.s.records) - 1
+	switch word {
+	case "ABOVE":
+		// the header row is never counted
+		return p.s.numbers(1, p.column, p.row-1, p.column)
+	case "BELOW":
+		return p.s.numbers(p.row+1, p.column, last, p.column)
+	case "LEFT":
+		return p.s.numbers(p.row, 0, p.row, p.column-1)
+	case "RIGHT":
+		return p.s.numbers(p.row, p.column+1, p.row, len(p.s.records[p.row])-1)
+	}
+	if r1, c1, ok := parseRef(word); ok && p.peek() == ':' {
+		p.pos++
+		r2, c2, ok := parseRef(p.word())
+		if !ok {
+			return nil, errors.New("ERR")
+		}
+		if r1 > r2 {
+			r1, r2 = r2, r1
+		}
+		if c1 > c2 {
+			c1, c2 = c2, c1
+		}
+		return p.s.numbers(r1, c1, r2, c2)
+	}
+	p.pos = start
+	v, err := p.expr()
+	return []float64{v}, err
+}
+
+// maxRefColumns and maxRefRows are how many letters and digits a cell
+// reference can have, so that its column and row can not overflow
+const (
+	maxRefColumns = 3
+	maxRefRows    = 7
+)
+
+// parseRef reads a cell reference like B2 into a zero-based row and column
+func parseRef(ref string) (row, column int, ok bool) {
+	i := 0
+	column = 0
+	for i < len(ref) && ref[i] >= 'A' && ref[i] <= 'Z' {
+		if i == maxRefColumns {
+			return
+		}
+		column = column*26 + int(ref[i]-'A') + 1
+		i++
+	}
+	if i == 0 || i == len(ref) || len(ref)-i > maxRefRows {
+		return
+	}
+	row, err := strconv.Atoi(ref[i:])
+	if err != nil || row < 1 {
+		return
+	}
+	return row - 1, column - 1, true
+}
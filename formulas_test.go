@@ -0,0 +1,85 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRef(t *testing.T) {
+	for _, tc := range []struct {
+		ref         string
+		row, column int
+		ok          bool
+	}{
+		{"A1", 0, 0, true},
+		{"B3", 2, 1, true},
+		{"Z10", 9, 25, true},
+		{"AA1", 0, 26, true},
+		{"XFD1048576", 1048575, 16383, true},
+		{"ZZZZ1", 0, 0, false},
+		{"A99999999", 0, 0, false},
+		{"A0", 0, 0, false},
+		{"A", 0, 0, false},
+		{"1", 0, 0, false},
+		{"", 0, 0, false},
+		{"A1B", 0, 0, false},
+	} {
+		row, column, ok := parseRef(tc.ref)
+		assert.Equal(t, tc.ok, ok, tc.ref)
+		if tc.ok {
+			assert.Equal(t, tc.row, row, tc.ref)
+			assert.Equal(t, tc.column, column, tc.ref)
+		}
+	}
+}
+
+func TestFormulas(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		records [][]string
+		row     int
+		column  int
+		want    string
+	}{
+		{"arithmetic", [][]string{{"a"}, {"=1+2*3-4/2"}}, 1, 0, "5"},
+		{"parentheses", [][]string{{"a"}, {"=(1+2)*3"}}, 1, 0, "9"},
+		{"negative", [][]string{{"a"}, {"=-(2+3)*-2"}}, 1, 0, "10"},
+		{"cell", [][]string{{"a", "b"}, {"2", "=A2*10"}}, 1, 1, "20"},
+		{"above", [][]string{{"a"}, {"1"}, {"2"}, {"x"}, {"=SUM(above)"}}, 4, 0, "3"},
+		{"left", [][]string{{"a", "b", "c"}, {"$1,000", "2", "=SUM(left)"}}, 1, 2, "1002"},
+		{"range", [][]string{{"a", "b"}, {"1", "2"}, {"3", "4"}, {"=SUM(A2:B3)", ""}}, 3, 0, "10"},
+		{"reversed range", [][]string{{"a", "b"}, {"1", "2"}, {"3", "4"}, {"=SUM(B3:A2)", ""}}, 3, 0, "10"},
+		{"range larger than the table", [][]string{{"=SUM(A2:XFD9999999)", "b"}, {"1", "2"}}, 0, 0, "3"},
+		{"nested", [][]string{{"a"}, {"4"}, {"8"}, {"=MAX(AVG(A2:A3), MIN(A2, 10)) + COUNT(above)"}}, 3, 0, "8"},
+		{"formulas in a range", [][]string{{"a"}, {"=2*2"}, {"=A2+1"}, {"=SUM(above)"}}, 3, 0, "9"},
+		{"division by zero", [][]string{{"a"}, {"=1/0"}}, 1, 0, "#DIV/0"},
+		{"average of nothing", [][]string{{"a"}, {"=AVG(above)"}}, 1, 0, "#DIV/0"},
+		{"cycle", [][]string{{"a", "b"}, {"=B2", "=A2"}}, 1, 0, "#CYCLE"},
+		{"itself", [][]string{{"a"}, {"=A2+1"}}, 1, 0, "#CYCLE"},
+		{"unknown function", [][]string{{"a"}, {"=FOO(1)"}}, 1, 0, "#NAME"},
+		{"unclosed", [][]string{{"a"}, {"=SUM(1,2"}}, 1, 0, "#ERR"},
+		{"trailing", [][]string{{"a"}, {"=1 2"}}, 1, 0, "#ERR"},
+		{"bad reference", [][]string{{"a"}, {"=ZZZZ1"}}, 1, 0, "#ERR"},
+		{"bad range", [][]string{{"a"}, {"=SUM(A1:)"}}, 1, 0, "#ERR"},
+		{"empty", [][]string{{"a"}, {"="}}, 1, 0, "#ERR"},
+		{"outside the table", [][]string{{"a"}, {"=C9+1"}}, 1, 0, "1"},
+		{"text", [][]string{{"a"}, {"hello"}}, 1, 0, "hello"},
+	} {
+		assert.Equal(t, tc.want, newSheet(tc.records).display(tc.row, tc.column), tc.name)
+	}
+}
+
+func TestFormulaLargeRange(t *testing.T) {
+	done := make(chan string)
+	go func() {
+		done <- newSheet([][]string{{"a", "b"}, {"1", "=SUM(B3:XFD9999999)"}}).display(1, 1)
+	}()
+	select {
+	case v := <-done:
+		assert.Equal(t, "0", v)
+	case <-time.After(time.Second):
+		t.Fatal("a large range took too long")
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// blobGrace is how long uploads are kept before they can be collected,
+// so that an upload is not collected before the page that uses it is
+// saved
+var blobGrace = 24 * time.Hour
+
+// formatCollection describes what collecting the unused uploads did
+func formatCollection(collected db.BlobCollection) string {
+	return fmt.Sprintf("took %d uploads from their domain and removed %d, reclaiming %d bytes",
+		len(collected.Released), len(collected.Removed), collected.Reclaimed)
+}
+
+// collectBlobs collects the uploads of a domain, or of every domain, that
+// no page links to
+func collectBlobs(args []string) (err error) {
+	flags := flag.NewFlagSet("gc", flag.ExitOnError)
+	domain := flags.String("domain", "", "domain to collect the uploads of (every domain when empty)")
+	grace := flags.Duration("grace", blobGrace, "how long uploads are kept before they can be collected")
+	flags.Parse(args)
+
+	fs, err = db.New(dbName, dbOptions)
+	if err != nil {
+		return
+	}
+	defer fs.Close()
+
+	collected, err := fs.GarbageCollectBlobs(utils.NormalizeDomain(*domain), *grace)
+	if err != nil {
+		return
+	}
+	for _, b := range collected.Removed {
+		fmt.Printf("removed %s (%s, %d bytes)\n", b.ID, b.Name, b.Size)
+	}
+	audit(nil, "blobs.collected", *domain, "", formatCollection(collected))
+	fmt.Println(formatCollection(collected))
+	return
+}
+
+// handleGarbageCollect collects the uploads of a domain, or of every
+// domain when no domain is given, that no page links to (POST). The
+// grace is how long uploads are kept, like 24h.
+func (tr *TemplateRender) handleGarbageCollect(w http.ResponseWriter, r *http.Request) (err error) {
+	token, ok := checkAdminToken(w, r)
+	if !ok {
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "collect with a POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	domain := utils.NormalizeDomain(r.FormValue("domain"))
+	grace := blobGrace
+	if r.FormValue("grace") != "" {
+		grace, err = time.ParseDuration(r.FormValue("grace"))
+		if err != nil {
+			return writeJSON(w, http.StatusBadRequest, map[string]interface{}{"success": false, "message": "could not parse the grace: " + err.Error()})
+		}
+	}
+	collected, err := fs.GarbageCollectBlobs(domain, grace)
+	message := formatCollection(collected)
+	if err != nil {
+		log.Error(err)
+		message = "could not collect the uploads: " + err.Error()
+	} else {
+		audit(r, "blobs.collected", domain, "", message)
+	}
+
+	// the form of the report goes back to the report
+	if r.FormValue("token") != "" {
+		http.Redirect(w, r, "/admin/report?token="+url.QueryEscape(token)+"&m="+url.QueryEscape(message), 302)
+		return nil
+	}
+	if err != nil {
+		return writeJSON(w, http.StatusBadRequest, map[string]interface{}{"success": false, "message": message})
+	}
+	return writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":   true,
+		"message":   message,
+		"released":  collected.Released,
+		"removed":   collected.Removed,
+		"reclaimed": collected.Reclaimed,
+	})
+}
@@ -2,6 +2,7 @@ module github.com/schollz/rwtxt
 
 require (
 	github.com/cihub/seelog v0.0.0-20170130134532-f561c5e57575
+	github.com/crewjam/saml v0.3.0
 	github.com/dustin/go-humanize v0.0.0-20180713052910-9f541cc9db5d // indirect
 	github.com/fsnotify/fsnotify v1.4.7 // indirect
 	github.com/gorilla/websocket v1.4.0
@@ -13,14 +14,16 @@ require (
 	github.com/schollz/documentsimilarity v0.0.0-20180911144411-e949781d9c5a
 	github.com/schollz/sqlite3dump v1.2.1
 	github.com/schollz/versionedtext v1.0.0
-	github.com/sergi/go-diff v1.0.0 // indirect
+	github.com/sergi/go-diff v1.0.0
 	github.com/shurcooL/sanitized_anchor_name v0.0.0-20170918181015-86672fcb3f95 // indirect
 	github.com/spf13/pflag v1.0.2 // indirect
-	github.com/stretchr/testify v1.2.2
+	github.com/stretchr/testify v1.4.0
 	github.com/tdewolff/minify v2.3.5+incompatible // indirect
 	github.com/tdewolff/parse v2.3.3+incompatible // indirect
-	golang.org/x/crypto v0.0.0-20180910181607-0e37d006457b
-	golang.org/x/net v0.0.0-20180911220305-26e67e76b6c3 // indirect
-	golang.org/x/sys v0.0.0-20180909124046-d0be0721c37e // indirect
+	golang.org/x/crypto v0.0.0-20190923035154-9ee001bba392
+	golang.org/x/net v0.0.0-20190404232315-eb5bcb51f2a3
+	golang.org/x/sys v0.0.0-20190922100055-0a153f010e69 // indirect
+	gopkg.in/asn1-ber.v1 v1.0.0-20181015200546-f715ec2f112d // indirect
+	gopkg.in/ldap.v2 v2.5.1
 	gopkg.in/russross/blackfriday.v2 v2.0.0
 )
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// historyVersion is a version of a page, as it is listed in its history
+type historyVersion struct {
+	Timestamp int64     `json:"timestamp"`
+	Time      time.Time `json:"time"`
+	Hash      string    `json:"hash"`
+	Diff      string    `json:"diff"`
+	Data      string    `json:"data,omitempty"`
+	// Author is the user who saved the version, when they were signed
+	// in as one
+	Author string `json:"author,omitempty"`
+}
+
+// RevertRequest is the body of a request to revert a page to a version
+type RevertRequest struct {
+	Domain    string `json:"domain"`
+	DomainKey string `json:"domain_key,omitempty"`
+	ID        string `json:"id"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// historyVersions returns the versions of a page, the newest first, with
+// their data when asked for
+func historyVersions(ctx context.Context, id, domain string, withData bool) (hvs []historyVersion, err error) {
+	files, err := fs.GetCtx(ctx, id, domain)
+	if err != nil {
+		return
+	}
+	vs, err := fs.GetVersions(id, domain)
+	if err != nil {
+		return
+	}
+	authors, err := fs.GetVersionAuthors(files[0].ID)
+	if err != nil {
+		return
+	}
+	hvs = make([]historyVersion, len(vs))
+	for i, v := range vs {
+		hvs[i] = historyVersion{
+			Timestamp: v.Timestamp,
+			Time:      time.Unix(0, v.Timestamp),
+			Hash:      v.Hash,
+			Diff:      v.Diff,
+			Author:    authors[v.Hash],
+		}
+		if withData {
+			hvs[i].Data = v.Data
+		}
+	}
+	return
+}
+
+// handleHistory lists the versions of a page at /DOMAIN/history/SLUG,
+// with the lines that changed in each (GET), and reverts the page to one
+// of them (POST)
+func (tr *TemplateRender) handleHistory(w http.ResponseWriter, r *http.Request) (err error) {
+	_, ispublic, _ := fs.GetDomainFromName(tr.Domain)
+	if !tr.SignedIn && !tr.Reader && !ispublic {
+		return tr.handleMain(w, r, "need to log in to see the history")
+	}
+	fields := strings.Split(r.URL.Path, "/")
+	if len(fields) < 4 || fields[3] == "" {
+		return tr.handleMain(w, r, "need a page to see the history of")
+	}
+	files, err := fs.GetCtx(tr.readContext(r, tr.Domain, ""), fields[3], tr.Domain)
+	if err != nil || len(files) == 0 {
+		return tr.handleMain(w, r, "page does not exist")
+	}
+	tr.File = files[0]
+
+	if r.Method == "POST" {
+		if !tr.canWrite(tr.Domain, r.FormValue("domain_key")) {
+			return tr.handleMain(w, r, "need to be logged in to revert")
+		}
+		timestamp, _ := strconv.ParseInt(r.FormValue("timestamp"), 10, 64)
+		_, err = fs.RevertTo(tr.File.ID, tr.Domain, timestamp)
+		if err != nil {
+			return tr.handleMain(w, r, err.Error())
+		}
+		audit(r, "page.reverted", tr.Domain, tr.File.ID, r.FormValue("timestamp"))
+		http.Redirect(w, r, "/"+tr.Domain+"/"+tr.File.ID, 302)
+		return nil
+	}
+
+	tr.Versions, err = historyVersions(tr.readContext(r, tr.Domain, ""), tr.File.ID, tr.Domain, false)
+	if err != nil {
+		return tr.handleMain(w, r, err.Error())
+	}
+	tr.Title = "History of " + tr.File.Slug
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Set("Content-Type", "text/html")
+	gz := gzip.NewWriter(w)
+	defer gz.Close()
+	return historyTemplate.Execute(gz, tr)
+}
+
+// handleHistoryAPI lists the versions of a page (GET), with their data
+// when asked for with ?include=data, and reverts a page to one of them
+// (POST)
+func (tr *TemplateRender) handleHistoryAPI(w http.ResponseWriter, r *http.Request) (err error) {
+	var req RevertRequest
+	if r.Method == "POST" {
+		err = json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			return writeJSON(w, http.StatusBadRequest, Payload{Message: err.Error()})
+		}
+	} else {
+		req.Domain = r.FormValue("domain")
+		req.DomainKey = r.FormValue("domain_key")
+		req.ID = r.FormValue("id")
+	}
+	req.Domain = utils.NormalizeDomain(req.Domain)
+	if req.Domain == "" {
+		req.Domain = "public"
+	}
+
+	if r.Method == "POST" {
+		if !tr.canWrite(req.Domain, req.DomainKey) {
+			return writeJSON(w, http.StatusForbidden, Payload{Message: "need to be logged in"})
+		}
+		f, errRevert := fs.RevertTo(req.ID, req.Domain, req.Timestamp)
+		if errRevert != nil {
+			return writeJSON(w, http.StatusNotFound, Payload{Message: errRevert.Error()})
+		}
+		audit(r, "page.reverted", req.Domain, f.ID, strconv.FormatInt(req.Timestamp, 10))
+		return writeJSON(w, http.StatusOK, Payload{ID: f.ID, Slug: f.Slug, Data: f.Data, Success: true})
+	}
+
+	if !tr.canRead(req.Domain, req.DomainKey) {
+		return writeJSON(w, http.StatusForbidden, Payload{Message: "need to be logged in"})
+	}
+	versions, err := historyVersions(tr.readContext(r, req.Domain, req.DomainKey), req.ID, req.Domain, r.FormValue("include") == "data")
+	if err != nil {
+		return writeJSON(w, http.StatusNotFound, Payload{Message: err.Error()})
+	}
+	return writeJSON(w, http.StatusOK, versions)
+}
+
+// handleHistoryPolicy changes how many versions of its pages a domain
+// keeps, which only its admins can do
+func (tr *TemplateRender) handleHistoryPolicy(w http.ResponseWriter, r *http.Request) (err error) {
+	tr.DomainKey = strings.TrimSpace(strings.ToLower(r.FormValue("domain_key")))
+	tr.Domain = utils.NormalizeDomain(r.FormValue("domain"))
+	if tr.Domain == "public" || tr.Domain == "" {
+		tr.Domain = "public"
+		return tr.handleMain(w, r, "cannot modify public")
+	}
+
+	// check that the key is valid
+	domainFound, err := fs.CheckKey(tr.DomainKey)
+	if err != nil || tr.Domain != domainFound {
+		return tr.handleMain(w, r, "need to be logged in to change the history")
+	}
+	if fs.KeyRole(tr.DomainKey) != db.RoleAdmin {
+		return tr.handleMain(w, r, "need to be an admin to change settings")
+	}
+
+	var policy db.HistoryPolicy
+	policy.Versions, _ = strconv.Atoi(r.FormValue("versions"))
+	policy.Days, _ = strconv.Atoi(r.FormValue("days"))
+	err = fs.SetHistoryPolicy(tr.Domain, policy)
+	if err != nil {
+		log.Debug(err)
+		return tr.handleMain(w, r, err.Error())
+	}
+	audit(r, "domain.updated", tr.Domain, "", "history policy updated")
+	return tr.handleMain(w, r, "history policy updated")
+}
+
+// compactHistories removes the versions of pages that the policies of
+// their domains do not keep
+func compactHistories() {
+	removed, err := fs.ApplyHistoryPolicies()
+	if err != nil {
+		log.Error(err)
+	} else if removed > 0 {
+		log.Infof("removed %d versions of pages from their history", removed)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// uploadLinkLifetime is how long the signed links to uploads in pages
+// work. They are made for the hour, so that a page links to the same
+// upload the same way for an hour.
+const uploadLinkLifetime = 24 * time.Hour
+
+var renderedUploadLink = regexp.MustCompile(`="(/uploads/sha256-[0-9a-f]+)(\?[^"]*)?"`)
+
+// uploadSignature signs a link to an upload until it expires, so that the
+// links in pages can not be used after
+func uploadSignature(id string, expires int64) string {
+	secret, err := fs.GetSecret("uploads")
+	if err != nil {
+		log.Error(err)
+	}
+	return utils.Hash(secret, id+" "+strconv.FormatInt(expires, 10))[:16]
+}
+
+// signUploadLinks signs the links to uploads in the pages of domains that
+// only serve their uploads from signed links
+func signUploadLinks(rendered template.HTML, domain string) template.HTML {
+	policy, err := fs.GetHotlinkPolicy(domain)
+	if err != nil || !policy.Signed {
+		return rendered
+	}
+	expires := time.Now().Truncate(time.Hour).Add(uploadLinkLifetime).Unix()
+	return template.HTML(renderedUploadLink.ReplaceAllStringFunc(string(rendered), func(s string) string {
+		m := renderedUploadLink.FindStringSubmatch(s)
+		id := strings.TrimPrefix(m[1], "/uploads/")
+		query := "?"
+		if m[2] != "" {
+			query = m[2] + "&amp;"
+		}
+		return `="` + m[1] + query + "expires=" + strconv.FormatInt(expires, 10) + "&amp;sig=" + uploadSignature(id, expires) + `"`
+	}))
+}
+
+// hotlinkRefused answers a request for an upload of a domain that keeps
+// other sites from embedding its uploads, when it is asked for from
+// another site or without a signed link that has not expired. It returns
+// whether it answered.
+func hotlinkRefused(w http.ResponseWriter, r *http.Request, id, domain string) bool {
+	policy, err := fs.GetHotlinkPolicy(domain)
+	if err != nil {
+		log.Debug(err)
+		return false
+	}
+	if !policy.AllowsReferer(r.Referer(), r.Host) {
+		http.Error(w, "uploads of this domain can not be embedded on other sites", http.StatusForbidden)
+		return true
+	}
+	if policy.Signed {
+		expires, _ := strconv.ParseInt(r.URL.Query().Get("expires"), 10, 64)
+		if time.Now().Unix() > expires || r.URL.Query().Get("sig") != uploadSignature(id, expires) {
+			http.Error(w, "the link to this upload expired", http.StatusForbidden)
+			return true
+		}
+	}
+	return false
+}
+
+// handleHotlinks changes how a domain keeps other sites from embedding its
+// uploads
+func (tr *TemplateRender) handleHotlinks(w http.ResponseWriter, r *http.Request) (err error) {
+	tr.DomainKey = strings.TrimSpace(strings.ToLower(r.FormValue("domain_key")))
+	tr.Domain = utils.NormalizeDomain(r.FormValue("domain"))
+	if tr.Domain == "public" || tr.Domain == "" {
+		tr.Domain = "public"
+		return tr.handleMain(w, r, "cannot modify public")
+	}
+
+	// check that the key is valid
+	domainFound, err := fs.CheckKey(tr.DomainKey)
+	if err != nil || tr.Domain != domainFound {
+		return tr.handleMain(w, r, "need to be logged in to change hotlinking")
+	}
+	if fs.KeyRole(tr.DomainKey) != db.RoleAdmin {
+		return tr.handleMain(w, r, "need to be an admin to change settings")
+	}
+
+	err = fs.SetHotlinkPolicy(tr.Domain, db.HotlinkPolicy{
+		Referer: r.FormValue("referer") == "on",
+		Allow:   strings.Split(r.FormValue("allow"), ","),
+		Signed:  r.FormValue("signed") == "on",
+	})
+	if err != nil {
+		log.Debug(err)
+		return tr.handleMain(w, r, err.Error())
+	}
+	audit(r, "domain.updated", tr.Domain, "", "hotlinking updated")
+	return tr.handleMain(w, r, "hotlinking updated")
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// importDomain makes pages in a domain from a directory or a zip archive
+// of markdown files
+func importDomain(args []string) (err error) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	domain := flags.String("domain", "", "domain to import the files to")
+	flags.Parse(args)
+	if *domain == "" || flags.NArg() != 1 {
+		return errors.New("usage: rwtxt import --domain x ./notes/ (or notes.zip)")
+	}
+	source := flags.Arg(0)
+	info, err := os.Stat(source)
+	if err != nil {
+		return
+	}
+
+	var r io.Reader
+	if info.IsDir() {
+		pr, pw := io.Pipe()
+		go func() {
+			pw.CloseWithError(zipDirectory(source, pw))
+		}()
+		defer pr.Close()
+		r = pr
+	} else {
+		f, errOpen := os.Open(source)
+		if errOpen != nil {
+			return errOpen
+		}
+		defer f.Close()
+		r = f
+	}
+
+	fs, err = db.New(dbName, dbOptions)
+	if err != nil {
+		return
+	}
+	defer fs.Close()
+
+	imported, err := fs.ImportDomain(utils.NormalizeDomain(*domain), r)
+	if err != nil {
+		return
+	}
+	for _, slug := range imported.Created {
+		fmt.Printf("created /%s/%s\n", *domain, slug)
+	}
+	for _, slug := range imported.Updated {
+		fmt.Printf("updated /%s/%s\n", *domain, slug)
+	}
+	summary := fmt.Sprintf("created %d pages, updated %d, left %d unchanged and uploaded %d files",
+		len(imported.Created), len(imported.Updated), len(imported.Unchanged), imported.Uploads)
+	audit(nil, "domain.imported", *domain, "", summary)
+	fmt.Println(summary)
+	return
+}
+
+// zipDirectory writes the files in a directory to a zip archive, with the
+// times they were modified
+func zipDirectory(dir string, w io.Writer) (err error) {
+	z := zip.NewWriter(w)
+	err = filepath.Walk(dir, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, name)
+		if err != nil {
+			return err
+		}
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		header.Method = zip.Deflate
+		f, err := z.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		file, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(f, file)
+		return err
+	})
+	if err != nil {
+		return
+	}
+	return z.Close()
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"strings"
+
+	log "github.com/cihub/seelog"
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// handleDomainLanguage changes the language of the pages of a domain that
+// do not have a "lang" of their own, which only its admins can do
+func (tr *TemplateRender) handleDomainLanguage(w http.ResponseWriter, r *http.Request) (err error) {
+	tr.DomainKey = strings.TrimSpace(strings.ToLower(r.FormValue("domain_key")))
+	tr.Domain = utils.NormalizeDomain(r.FormValue("domain"))
+	if tr.Domain == "public" || tr.Domain == "" {
+		tr.Domain = "public"
+		return tr.handleMain(w, r, "cannot modify public")
+	}
+
+	// check that the key is valid
+	domainFound, err := fs.CheckKey(tr.DomainKey)
+	if err != nil || tr.Domain != domainFound {
+		return tr.handleMain(w, r, "need to be logged in to change the language")
+	}
+	if fs.KeyRole(tr.DomainKey) != db.RoleAdmin {
+		return tr.handleMain(w, r, "need to be an admin to change settings")
+	}
+
+	err = fs.SetDomainLanguage(tr.Domain, r.FormValue("lang"))
+	if err != nil {
+		log.Debug(err)
+		return tr.handleMain(w, r, err.Error())
+	}
+	audit(r, "domain.updated", tr.Domain, "", "language updated")
+	return tr.handleMain(w, r, "language updated")
+}
+
+// handleLanguageList lists the pages of a domain in a language, at
+// /DOMAIN/list?lang=LANG
+func (tr *TemplateRender) handleLanguageList(w http.ResponseWriter, r *http.Request, lang string) (err error) {
+	files, err := fs.GetByLanguageCtx(tr.readContext(r, tr.Domain, ""), tr.Domain, lang)
+	if err != nil {
+		return tr.handleMain(w, r, err.Error())
+	}
+	for i := range files {
+		files[i].Data = ""
+		files[i].DataHTML = template.HTML("")
+	}
+	return tr.handleList(w, r, "All in "+lang, files, 0, len(files))
+}
+
+// setLanguage shows a page in its language, or else in the one of its
+// domain
+func (tr *TemplateRender) setLanguage(f db.File) {
+	tr.DomainLanguage, _ = fs.GetDomainLanguage(tr.Domain)
+	tr.Language = f.Language
+	if tr.Language == "" {
+		tr.Language = tr.DomainLanguage
+	}
+}
@@ -1,16 +1,21 @@
 package main
 
 import (
-	"bytes"
 	"compress/gzip"
-	"crypto/sha256"
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"html/template"
 	"io"
+	"io/ioutil"
+	"mime"
+	"net"
 	"net/http"
 	"net/url"
+	"path"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -23,40 +28,136 @@ import (
 
 const (
 	introText = "This note is empty. Click to edit it."
+	// listPageSize is how many pages are listed at a time
+	listPageSize = 50
+	// searchWriteTimeout is how long a result that is streamed over a
+	// websocket can take to send, so that slow clients don't hold up saves
+	searchWriteTimeout = 10 * time.Second
 )
 
 var viewEditTemplate *template.Template
 var mainTemplate *template.Template
 var loginTemplate *template.Template
 var listTemplate *template.Template
+var cardsTemplate *template.Template
+var reportTemplate *template.Template
+var historyTemplate *template.Template
+var uploadsTemplate *template.Template
 var fs *db.FileSystem
 
 type TemplateRender struct {
-	Title             string
-	Page              string
-	Rendered          template.HTML
-	File              db.File
-	IntroText         template.JS
-	Rows              int
-	RandomUUID        string
-	Domain            string
-	DomainID          int
-	DomainKey         string
-	DomainIsPrivate   bool
-	DomainValue       template.HTMLAttr
-	DomainList        []string
-	DomainKeys        map[string]string
-	DefaultDomain     string
-	SignedIn          bool
-	Message           string
-	NumResults        int
-	Files             []db.File
-	MostActiveList    []db.File
-	SimilarFiles      []db.File
-	Search            string
-	DomainExists      bool
+	Title           string
+	Page            string
+	Rendered        template.HTML
+	File            db.File
+	IntroText       template.JS
+	Rows            int
+	RandomUUID      string
+	Domain          string
+	DomainID        int
+	DomainKey       string
+	DomainIsPrivate bool
+	DomainValue     template.HTMLAttr
+	DomainList      []string
+	DomainKeys      map[string]string
+	DefaultDomain   string
+	SignedIn        bool
+	Message         string
+	NumResults      int
+	PrevPage        string
+	NextPage        string
+	IndexPending    int
+	Files           []db.File
+	MostActiveList  []db.File
+	SimilarFiles    []db.File
+	Search          string
+	DomainExists    bool
+	// DomainExpires is when the domain is removed because nobody uses it,
+	// which is zero unless it is flagged to be
+	DomainExpires     time.Time
 	ShowCookieMessage bool
 	EditOnly          bool
+	Canonical         string
+	Clicks            []db.Click
+	Version           string
+	ReadOnly          bool
+	Syndication       []string
+	Map               template.HTML
+	TimeReports       []timeReport
+	Tags              []db.Tag
+	Trash             bool
+	Versions          []historyVersion
+	Blobs             []db.Blob
+	Report            *db.Report
+	ReportDays        int
+	AdminToken        string
+	Cleanup           string
+	StopWords         string
+	Synonyms          string
+	HistoryPolicy     db.HistoryPolicy
+	Card              *db.Card
+	CardFront         template.HTML
+	CardBack          template.HTML
+	Pandoc            bool
+	Accounts          bool
+	Remember          bool
+	SAML              bool
+	// Language is the language of what is shown, which is the one of the
+	// page or else DomainLanguage, the one of the pages of the domain
+	Language       string
+	DomainLanguage string
+	Languages      []db.Language
+	// Translations are the translations of the page, and TranslationOf is
+	// the page that it is a translation of
+	Translations  []pageTranslation
+	TranslationOf *pageTranslation
+	Translator    bool
+	// SlugOptions are how the domain makes the slugs of its pages
+	SlugOptions db.SlugOptions
+	// Redirects are the old URLs that go to the pages of the domain
+	Redirects []db.Redirect
+	// NotFound are the paths of the domain that were asked for most and
+	// that are not pages, and Missing is the slug of a page that was not
+	// found
+	NotFound []db.NotFound
+	Missing  string
+	// DomainFeed is whether the domain has feeds of its latest pages,
+	// which public domains have
+	DomainFeed bool
+	// Announcements are shown at the top of every page, and
+	// AdminAnnouncements are the ones that have not ended, for the report
+	Announcements      []db.Announcement
+	AdminAnnouncements []db.Announcement
+	// ContentReports are the reports of pages that are not resolved, and
+	// AdminDomains are every domain, for the report
+	ContentReports []db.ContentReport
+	AdminDomains   []db.AdminDomain
+	// NoIndex is whether search engines are asked not to index the domain
+	NoIndex bool
+	// DomainReadOnly is whether the pages and uploads of the domain can
+	// not be changed
+	DomainReadOnly bool
+	// Sensitive shows the notice that the page is sensitive instead of the
+	// page, and DomainSensitive is whether every page of the domain is
+	Sensitive       bool
+	DomainSensitive bool
+	// Backlinks are the pages of the domain that link to the page
+	Backlinks []db.File
+	// Reader is whether the domain is read with the key of a reader, who
+	// is not signed in to write it, and Members are the users of the
+	// domain, for its admins
+	Reader  bool
+	Members []db.Member
+	// Tokens are the API tokens of the domain, for its admins
+	Tokens []db.Token
+	// BandwidthCap is how many megabytes of uploads the domain serves to
+	// the public each month, and Egress is how much it served
+	BandwidthCap         int64
+	BandwidthPlaceholder string
+	Egress               []db.Egress
+	// HotlinkPolicy is how the domain keeps other sites from embedding its
+	// uploads
+	HotlinkPolicy db.HotlinkPolicy
 }
 
 func init() {
@@ -109,9 +210,77 @@ func init() {
 		panic(err)
 	}
 	listTemplate = template.Must(listTemplate.Parse(string(b)))
+
+	b, err = Asset("assets/cards.html")
+	if err != nil {
+		panic(err)
+	}
+	cardsTemplate = template.Must(template.New("cards").Parse(string(b)))
+	b, err = Asset("assets/header.html")
+	if err != nil {
+		panic(err)
+	}
+	cardsTemplate = template.Must(cardsTemplate.Parse(string(b)))
+	b, err = Asset("assets/footer.html")
+	if err != nil {
+		panic(err)
+	}
+	cardsTemplate = template.Must(cardsTemplate.Parse(string(b)))
+
+	b, err = Asset("assets/report.html")
+	if err != nil {
+		panic(err)
+	}
+	reportTemplate = template.Must(template.New("report").Parse(string(b)))
+	b, err = Asset("assets/header.html")
+	if err != nil {
+		panic(err)
+	}
+	reportTemplate = template.Must(reportTemplate.Parse(string(b)))
+	b, err = Asset("assets/footer.html")
+	if err != nil {
+		panic(err)
+	}
+	reportTemplate = template.Must(reportTemplate.Parse(string(b)))
+
+	b, err = Asset("assets/history.html")
+	if err != nil {
+		panic(err)
+	}
+	historyTemplate = template.Must(template.New("history").Parse(string(b)))
+	b, err = Asset("assets/header.html")
+	if err != nil {
+		panic(err)
+	}
+	historyTemplate = template.Must(historyTemplate.Parse(string(b)))
+	b, err = Asset("assets/footer.html")
+	if err != nil {
+		panic(err)
+	}
+	historyTemplate = template.Must(historyTemplate.Parse(string(b)))
+
+	b, err = Asset("assets/uploads.html")
+	if err != nil {
+		panic(err)
+	}
+	uploadsTemplate = template.Must(template.New("uploads").Parse(string(b)))
+	b, err = Asset("assets/header.html")
+	if err != nil {
+		panic(err)
+	}
+	uploadsTemplate = template.Must(uploadsTemplate.Parse(string(b)))
+	b, err = Asset("assets/footer.html")
+	if err != nil {
+		panic(err)
+	}
+	uploadsTemplate = template.Must(uploadsTemplate.Parse(string(b)))
 }
 
 var dbName string
+
+// requestTimeout is how long requests can use the database for
+var requestTimeout time.Duration
+var dbOptions db.Options
 var Version string
 
 func main() {
@@ -119,6 +288,42 @@ func main() {
 	var debug = flag.Bool("debug", false, "debug mode")
 	var showVersion = flag.Bool("v", false, "show version")
 	var database = flag.String("db", "rwtxt.db", "name of the database")
+	var blobs = flag.String("blobs", "", "where uploads are kept: in the database (the default), in a directory like dir:/srv/uploads, or in an S3 bucket like s3://bucket/prefix?endpoint=https://minio.example.com with the keys in AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	var sqlite = flag.String("sqlite", "", "SQLite profile (durable, fast or memory) and pragmas, like \"fast,cache_size=-128000,busy_timeout=30s\"")
+	flag.BoolVar(&showPreviews, "previews", false, "show preview cards for links, which fetches the links in pages")
+	flag.BoolVar(&trackLinks, "track-links", false, "count clicks on links out of public domains")
+	flag.DurationVar(&requestTimeout, "timeout", 0, "how long a request can use the database for, like 30s (0 has no limit)")
+	flag.StringVar(&metricsToken, "metrics-token", "", "bearer token for Prometheus to scrape /metrics with")
+	flag.DurationVar(&backupStale, "backup-stale", backupStale, "how old the newest dump can get before the webhook is told (0 never tells it)")
+	flag.DurationVar(&feedInterval, "feeds", feedInterval, "how often to poll the feeds that domains subscribe to (0 never polls them)")
+	flag.DurationVar(&trashLifetime, "trash", trashLifetime, "how long deleted pages stay in the trash before they are removed for good (0 keeps them)")
+	flag.DurationVar(&domainExpiry, "expire", 0, "how long domains that no account has a role in can go unused before they are flagged to be removed (0 keeps them)")
+	flag.DurationVar(&expiryGrace, "expire-grace", expiryGrace, "how long flagged domains are kept before they are exported and removed")
+	flag.StringVar(&expiryDir, "expire-dir", expiryDir, "directory to export removed domains to")
+	flag.DurationVar(&mirrorInterval, "mirror", mirrorInterval, "how often to push the changes of domains to their mirrors (0 never pushes them)")
+	flag.BoolVar(&dumpDatabase, "dump", true, "dump the database to a gzipped SQL file next to it every few minutes")
+	flag.StringVar(&webhook, "webhook", "", "url to post events to, like failed dumps and integrity problems")
+	flag.Int64Var(&storageLimit, "storage-limit", 0, "size of the database in megabytes to warn about with the webhook")
+	flag.StringVar(&translator, "translate", "", "command that translates markdown from stdin, given the languages to translate from and to, or the url of a LibreTranslate server")
+	flag.StringVar(&translatorKey, "translate-key", "", "api key for the LibreTranslate server")
+	flag.StringVar(&pandoc, "pandoc", "", "path to pandoc, or the url of a pandoc server, for exporting pages (found automatically if installed)")
+	flag.StringVar(&auditLog, "audit", "", "file to append the audit log to as JSON lines, or \"syslog\"")
+	var ldapURL = flag.String("ldap", "", "url of an LDAP directory to log in with, like ldaps://ldap.example.com")
+	var ldapUser = flag.String("ldap-user", "uid=%s,ou=people,dc=example,dc=com", "distinguished name of LDAP users, with %s for the username")
+	var samlIDP = flag.String("saml-idp", "", "url or file of the metadata of a SAML identity provider to log in with")
+	var samlURL = flag.String("saml-url", "", "url of rwtxt for SAML, like https://notes.example.com/")
+	var samlCert = flag.String("saml-cert", "saml.crt", "certificate of rwtxt for SAML")
+	var samlKey = flag.String("saml-key", "saml.key", "key of rwtxt for SAML")
+	var samlGroups = flag.String("saml-groups", "groups", "SAML attribute with the groups of an account")
+	flag.StringVar(&authDomains, "auth-domains", "", "domains that LDAP and SAML groups can use, like \"engineering=eng;*=everyone\"")
+	flag.StringVar(&adminToken, "admin-token", "", "token for the report of empty, orphaned and unused content at /admin/report")
+	flag.StringVar(&provisionToken, "provision-token", "", "bearer token for the API that provisions users from an identity system")
+	flag.StringVar(&cookieSameSite, "cookie-samesite", cookieSameSite, "SameSite of cookies: lax, strict or none")
+	flag.BoolVar(&cookieSecure, "cookie-secure", false, "only send cookies over https (they always are when rwtxt is reached over https)")
+	flag.DurationVar(&sessionLifetime, "session", sessionLifetime, "how long logins last without being used, unless they are remembered")
+	flag.DurationVar(&rememberLifetime, "remember", rememberLifetime, "how long remembered logins last without being used")
+	flag.DurationVar(&rotateAfter, "rotate", rotateAfter, "how often the keys of remembered logins are replaced (0 never replaces them)")
+	var headers = flag.String("headers", "", "JSON file with the security headers for each path prefix, like {\"/eng/\": {\"Content-Security-Policy\": \"frame-ancestors *\"}}")
 	flag.Parse()
 
 	if *showVersion {
@@ -137,7 +342,103 @@ func main() {
 	}
 	dbName = *database
 	defer log.Flush()
+	dbOptions, err = db.ParseOptions(*sqlite)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	dbOptions.Blobs, err = db.ParseBlobStore(*blobs)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	if flag.Arg(0) == "publish" {
+		err = publish(flag.Args()[1:])
+		if err != nil {
+			log.Error(err)
+		}
+		return
+	} else if flag.Arg(0) == "export" {
+		err = exportSite(flag.Args()[1:])
+		if err != nil {
+			log.Error(err)
+		}
+		return
+	} else if flag.Arg(0) == "review" {
+		err = review(flag.Args()[1:])
+		if err != nil {
+			log.Error(err)
+		}
+		return
+	} else if flag.Arg(0) == "revert" {
+		err = revert(flag.Args()[1:])
+		if err != nil {
+			log.Error(err)
+		}
+		return
+	} else if flag.Arg(0) == "gc" {
+		err = collectBlobs(flag.Args()[1:])
+		if err != nil {
+			log.Error(err)
+		}
+		return
+	} else if flag.Arg(0) == "move-blobs" {
+		err = moveBlobs()
+		if err != nil {
+			log.Error(err)
+		}
+		return
+	} else if flag.Arg(0) == "import" {
+		err = importDomain(flag.Args()[1:])
+		if err != nil {
+			log.Error(err)
+		}
+		return
+	} else if flag.Arg(0) == "rename-reserved" {
+		err = renameReserved(flag.Args()[1:])
+		if err != nil {
+			log.Error(err)
+		}
+		return
+	} else if flag.Arg(0) == "redirects" {
+		err = importRedirects(flag.Args()[1:])
+		if err != nil {
+			log.Error(err)
+		}
+		return
+	} else if flag.Arg(0) == "stress" {
+		err = stress(flag.Args()[1:])
+		if err != nil {
+			log.Error(err)
+		}
+		return
+	} else if flag.Arg(0) == "backup" {
+		err = backupTo(flag.Args()[1:])
+		if err != nil {
+			log.Error(err)
+		}
+		return
+	}
 
+	findPandoc()
+	err = openAudit()
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	err = setupAuth(*ldapURL, *ldapUser, *samlIDP, *samlURL, *samlCert, *samlKey, *samlGroups)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	if *headers != "" {
+		err = loadSecurityHeaders(*headers)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+	}
 	err = serve()
 	if err != nil {
 		log.Error(err)
@@ -150,8 +451,11 @@ type Payload struct {
 	Domain    string `json:"domain,omitempty"`
 	Data      string `json:"data,omitempty"`
 	Slug      string `json:"slug,omitempty"`
-	Message   string `json:"message,omitempty"`
-	Success   bool   `json:"success"`
+	// Base is the data that an editor started from, for its changes to be
+	// merged with the changes of the other editors of the page
+	Base    string `json:"base,omitempty"`
+	Message string `json:"message,omitempty"`
+	Success bool   `json:"success"`
 }
 
 var wsupgrader = websocket.Upgrader{
@@ -163,12 +467,16 @@ var wsupgrader = websocket.Upgrader{
 }
 
 func serve() (err error) {
-	fs, err = db.New(dbName)
+	fs, err = db.New(dbName, dbOptions)
 	if err != nil {
 		log.Error(err)
 		return
 	}
 
+	loadBackups()
+	if dumpDatabase {
+		go validateDump()
+	}
 	go func() {
 		lastDumped := time.Now()
 		for {
@@ -179,18 +487,44 @@ func serve() (err error) {
 			}
 			if time.Since(lastModified).Seconds() > 3 && time.Since(lastDumped).Seconds() > 10 {
 				log.Debug("dumping")
-				errDelete := fs.DeleteOldKeys()
+				errDelete := fs.DeleteOldKeys(sessionLifetime, rememberLifetime)
 				if errDelete != nil {
 					log.Error(errDelete)
 				}
-				errDump := fs.DumpSQL()
+				var errDump error
+				if dumpDatabase {
+					errDump = fs.DumpSQL()
+				} else {
+					errDump = fs.Purge()
+				}
 				if errDump != nil {
 					log.Error(errDump)
 				}
+				checkDatabase(errDump)
+				purgeTrash()
+				expireDomains()
+				compactHistories()
 				lastDumped = time.Now()
 			}
+			checkBackups()
 		}
 	}()
+	if feedInterval > 0 {
+		go func() {
+			for {
+				pollFeeds()
+				time.Sleep(feedInterval)
+			}
+		}()
+	}
+	if mirrorInterval > 0 {
+		go func() {
+			for {
+				time.Sleep(mirrorInterval)
+				pushMirrors()
+			}
+		}()
+	}
 	log.Info("running on port 8152")
 	http.HandleFunc("/", handler)
 	return http.ListenAndServe(":8152", nil)
@@ -198,6 +532,13 @@ func serve() (err error) {
 
 func handler(w http.ResponseWriter, r *http.Request) {
 	t := time.Now()
+	// queries stop when the client goes away or the request takes too long
+	if requestTimeout > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+	}
+	setSecurityHeaders(w, r)
 	err := handle(w, r)
 	if err != nil {
 		log.Error(err)
@@ -207,26 +548,68 @@ func handler(w http.ResponseWriter, r *http.Request) {
 
 func (tr *TemplateRender) handleSearch(w http.ResponseWriter, r *http.Request, domain, query string) (err error) {
 	_, ispublic, _ := fs.GetDomainFromName(domain)
-	if !tr.SignedIn && !ispublic {
+	if !tr.SignedIn && !tr.Reader && !ispublic {
 		return tr.handleMain(w, r, "need to log in to search")
 	}
-	files, errGet := fs.Find(query, tr.Domain)
+	offset := listOffset(r)
+	files, total, errGet := fs.FindRankedOffsetCtx(tr.readContext(r, tr.Domain, ""), query, tr.Domain, listPageSize, offset)
 	if errGet != nil {
 		return errGet
 	}
-	return tr.handleList(w, r, query, files)
+	tr.IndexPending = fs.IndexPending(tr.Domain)
+	return tr.handleList(w, r, query, files, offset, total)
+}
+
+// listOffset is how many results are skipped to show the page of results
+// that is asked for
+func listOffset(r *http.Request) int {
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		return 0
+	}
+	return (page - 1) * listPageSize
 }
 
-func (tr *TemplateRender) handleList(w http.ResponseWriter, r *http.Request, query string, files []db.File) (err error) {
+// listPageLink links to another page of the results that are shown
+func listPageLink(r *http.Request, offset int) string {
+	u := *r.URL
+	q := u.Query()
+	if offset > 0 {
+		q.Set("page", strconv.Itoa(offset/listPageSize+1))
+	} else {
+		q.Del("page")
+	}
+	u.RawQuery = q.Encode()
+	return u.RequestURI()
+}
+
+// handleList shows files, which are the ones from offset of the total
+// that were found, with links to the pages before and after them
+func (tr *TemplateRender) handleList(w http.ResponseWriter, r *http.Request, query string, files []db.File, offset int, total int) (err error) {
 	_, ispublic, _ := fs.GetDomainFromName(tr.Domain)
-	if !tr.SignedIn && !ispublic {
+	if !tr.SignedIn && !tr.Reader && !ispublic {
 		return tr.handleMain(w, r, "need to log in to list")
 	}
 
+	// the lists that are not paged by the database can have pages that are
+	// not listed
+	if listed := fs.FilterListed(tr.readContext(r, tr.Domain, ""), files); len(listed) < len(files) {
+		total -= len(files) - len(listed)
+		files = listed
+	}
+
 	// show the list page
+	tr.DomainLanguage, _ = fs.GetDomainLanguage(tr.Domain)
+	tr.Language = tr.DomainLanguage
 	tr.Title = query + " pages"
 	tr.Files = files
-	tr.NumResults = len(files)
+	tr.NumResults = total
+	if offset > 0 {
+		tr.PrevPage = listPageLink(r, offset-listPageSize)
+	}
+	if offset+len(files) < total {
+		tr.NextPage = listPageLink(r, offset+listPageSize)
+	}
 	tr.Search = query
 	tr.RandomUUID = utils.UUID()
 
@@ -237,8 +620,8 @@ func (tr *TemplateRender) handleList(w http.ResponseWriter, r *http.Request, que
 	return listTemplate.Execute(gz, tr)
 }
 
-func isSignedIn(w http.ResponseWriter, r *http.Request, domain string) (signedin bool, domainkey string, defaultDomain string, domainList []string, domainKeys map[string]string) {
-	domainKeys, defaultDomain = getDomainListCookie(w, r)
+func isSignedIn(w http.ResponseWriter, r *http.Request, domain string) (signedin bool, domainkey string, defaultDomain string, domainList []string, domainKeys map[string]string, remember bool) {
+	domainKeys, defaultDomain, remember = getDomainListCookie(w, r)
 	domainList = make([]string, len(domainKeys))
 	i := 0
 	for domainName := range domainKeys {
@@ -270,14 +653,10 @@ func (tr TemplateRender) updateDomainCookie(w http.ResponseWriter, r *http.Reque
 
 	log.Debugf("setting new list: %+v", domainKeyList)
 	// return the new cookie
-	return http.Cookie{
-		Name:    "rwtxt-domains",
-		Value:   strings.Join(domainKeyList, ","),
-		Expires: time.Now().Add(365 * 24 * time.Hour),
-	}
+	return *newCookie(r, "rwtxt-domains", strings.Join(domainKeyList, ","), tr.Remember)
 }
 
-func getDomainListCookie(w http.ResponseWriter, r *http.Request) (domainKeys map[string]string, defaultDomain string) {
+func getDomainListCookie(w http.ResponseWriter, r *http.Request) (domainKeys map[string]string, defaultDomain string, remember bool) {
 	startTime := time.Now()
 	domainKeys = make(map[string]string)
 	cookie, cookieErr := r.Cookie("rwtxt-domains")
@@ -286,7 +665,7 @@ func getDomainListCookie(w http.ResponseWriter, r *http.Request) (domainKeys map
 		log.Debugf("got cookie: %s", cookie.Value)
 		for _, key := range strings.Split(cookie.Value, ",") {
 			startTime2 := time.Now()
-			domainName, domainErr := fs.CheckKey(key)
+			domainName, domainErr := fs.CheckReadKey(key)
 			log.Debugf("checked key: %s [%s]", key, time.Since(startTime2))
 			if domainErr == nil && domainName != "" {
 				if defaultDomain == "" {
@@ -297,6 +676,26 @@ func getDomainListCookie(w http.ResponseWriter, r *http.Request) (domainKeys map
 			}
 		}
 	}
+
+	// replace the remembered keys that are getting old
+	rotated, remember, err := fs.RotateKeys(keysToUpdate, rotateAfter)
+	if err != nil {
+		log.Error(err)
+	} else if len(rotated) > 0 {
+		for i, key := range keysToUpdate {
+			if rotated[key] == "" {
+				continue
+			}
+			keysToUpdate[i] = rotated[key]
+			for domainName := range domainKeys {
+				if domainKeys[domainName] == key {
+					domainKeys[domainName] = rotated[key]
+				}
+			}
+		}
+		http.SetCookie(w, newCookie(r, "rwtxt-domains", strings.Join(keysToUpdate, ","), true))
+	}
+
 	domainKeys["public"] = ""
 	if defaultDomain == "" {
 		defaultDomain = "public"
@@ -346,12 +745,43 @@ func (tr *TemplateRender) handleMain(w http.ResponseWriter, r *http.Request, mes
 	tr.SignedIn = signedin
 	tr.DomainIsPrivate = !ispublic && tr.Domain != "public"
 	tr.DomainExists = domainErr == nil
-	tr.Files, err = fs.GetTopX(tr.Domain, 10)
+	tr.DomainFeed = domainErr == nil && ispublic
+	tr.NoIndex, _ = fs.GetDomainNoIndex(tr.Domain)
+	tr.DomainSensitive, _ = fs.GetDomainSensitive(tr.Domain)
+	if tr.DomainExists && domainExpiry > 0 {
+		expiring, _ := fs.GetDomainExpiring(tr.Domain)
+		if !expiring.IsZero() {
+			tr.DomainExpires = expiring.Add(expiryGrace)
+		}
+	}
+	tr.Files, err = fs.GetTopXCtx(tr.readContext(r, tr.Domain, tr.DomainKey), tr.Domain, 10)
 	if err != nil {
 		log.Debug(err)
 	}
 
-	tr.MostActiveList, _ = fs.GetTopXMostViews(tr.Domain, 10)
+	tr.MostActiveList, _ = fs.GetTopXMostViewsCtx(tr.readContext(r, tr.Domain, tr.DomainKey), tr.Domain, 10)
+	if tr.SignedIn && trackLinks {
+		tr.Clicks, _ = fs.GetClicks(tr.Domain, 10)
+	}
+	if tr.SignedIn {
+		sw, _ := fs.GetSearchWords(tr.Domain)
+		tr.StopWords, tr.Synonyms = joinSearchWords(sw)
+		tr.HistoryPolicy, _ = fs.GetHistoryPolicy(tr.Domain)
+		tr.SlugOptions, _ = fs.GetSlugOptions(tr.Domain)
+		if quota, errQuota := fs.GetBandwidthQuota(tr.Domain); errQuota == nil {
+			tr.BandwidthCap, tr.BandwidthPlaceholder = quota.Cap>>20, quota.Placeholder
+		}
+		tr.Egress, _ = fs.GetEgress(tr.Domain, 3)
+		tr.HotlinkPolicy, _ = fs.GetHotlinkPolicy(tr.Domain)
+		tr.Redirects, _ = fs.ListRedirects(tr.Domain)
+		tr.NotFound, _ = fs.GetDomainNotFound(tr.Domain, 10)
+		if fs.KeyRole(tr.DomainKey) == db.RoleAdmin {
+			tr.Members, _ = fs.GetMembers(tr.Domain)
+			tr.Tokens, _ = fs.ListTokens(tr.Domain)
+		}
+	}
+	tr.DomainLanguage, _ = fs.GetDomainLanguage(tr.Domain)
+	tr.Language = tr.DomainLanguage
 	tr.Title = "rwtxt"
 	tr.Message = message
 	tr.DomainValue = template.HTMLAttr(`value="` + tr.Domain + `"`)
@@ -364,27 +794,38 @@ func (tr *TemplateRender) handleMain(w http.ResponseWriter, r *http.Request, mes
 }
 
 func (tr *TemplateRender) handleLogout(w http.ResponseWriter, r *http.Request) (err error) {
-	tr.Domain = strings.ToLower(strings.TrimSpace(r.URL.Query().Get("d")))
+	tr.Domain = utils.NormalizeDomain(r.URL.Query().Get("d"))
 
-	// delete all cookies
+	// delete all cookies, and their keys so that copies of them stop working
 	_, err = r.Cookie("rwtxt-domains")
 	if err == nil {
-		c := &http.Cookie{
-			Name:     "rwtxt-domains",
-			Value:    "",
-			Path:     "/",
-			Expires:  time.Unix(0, 0),
-			HttpOnly: true,
-		}
+		c := newCookie(r, "rwtxt-domains", "", false)
+		c.Expires = time.Unix(0, 0)
 		http.SetCookie(w, c)
 	}
+	for _, key := range tr.DomainKeys {
+		if key != "" {
+			fs.DeleteKey(key)
+		}
+	}
+	audit(r, "logout", tr.Domain, "", "")
 
 	return tr.handleMain(w, r, "You are not logged in.")
 }
 
 func (tr *TemplateRender) handleLogin(w http.ResponseWriter, r *http.Request) (err error) {
-	tr.Domain = strings.TrimSpace(strings.ToLower(r.FormValue("domain")))
+	tr.Domain = utils.NormalizeDomain(r.FormValue("domain"))
 	password := strings.TrimSpace(r.FormValue("password"))
+	if r.FormValue("username") != "" {
+		// users provisioned with a password log in with it, and everyone
+		// else with the directory
+		if ldapAuth != nil {
+			if _, errUser := fs.CheckUser(r.FormValue("username"), r.FormValue("password")); errUser != nil {
+				return tr.handleAccountLogin(w, r, ldapAuth)
+			}
+		}
+		return tr.handleAccountLogin(w, r, userProvider{})
+	}
 	if tr.Domain == "public" || tr.Domain == "" {
 		tr.Domain = "public"
 		return tr.handleMain(w, r, "")
@@ -395,6 +836,10 @@ func (tr *TemplateRender) handleLogin(w http.ResponseWriter, r *http.Request) (e
 	}
 	var key string
 
+	// the old names of domains that were renamed log in to them
+	if renamed, _ := fs.RenamedDomain(tr.Domain); renamed != "" {
+		tr.Domain = renamed
+	}
 	// check if exists
 	_, _, err = fs.GetDomainFromName(tr.Domain)
 	if err != nil {
@@ -406,12 +851,16 @@ func (tr *TemplateRender) handleLogin(w http.ResponseWriter, r *http.Request) (e
 			tr.Domain = "public"
 			return tr.handleMain(w, r, err.Error())
 		}
+		audit(r, "domain.created", tr.Domain, "", "")
 	}
 	tr.DomainKey, err = fs.SetKey(tr.Domain, password)
 	if err != nil {
+		audit(r, "login.failed", tr.Domain, "", err.Error())
 		tr.Domain = "public"
 		return tr.handleMain(w, r, err.Error())
 	}
+	audit(r, "login.succeeded", tr.Domain, "", "")
+	tr.rememberKeys(r.FormValue("remember") == "on")
 
 	log.Debugf("new key: %s", key)
 	// set domain password
@@ -423,7 +872,7 @@ func (tr *TemplateRender) handleLogin(w http.ResponseWriter, r *http.Request) (e
 
 func (tr *TemplateRender) handleLoginUpdate(w http.ResponseWriter, r *http.Request) (err error) {
 	tr.DomainKey = strings.TrimSpace(strings.ToLower(r.FormValue("domain_key")))
-	tr.Domain = strings.TrimSpace(strings.ToLower(r.FormValue("domain")))
+	tr.Domain = utils.NormalizeDomain(r.FormValue("domain"))
 	password := strings.TrimSpace(r.FormValue("password"))
 	isPublic := strings.TrimSpace(r.FormValue("ispublic")) == "on"
 	if tr.Domain == "public" || tr.Domain == "" {
@@ -439,34 +888,144 @@ func (tr *TemplateRender) handleLoginUpdate(w http.ResponseWriter, r *http.Reque
 		}
 		return tr.handleMain(w, r, err.Error())
 	}
+	if fs.KeyRole(tr.DomainKey) != db.RoleAdmin {
+		return tr.handleMain(w, r, "need to be an admin to change settings")
+	}
 
 	err = fs.UpdateDomain(tr.Domain, password, isPublic)
+	if err == nil {
+		err = fs.SetDomainNoIndex(tr.Domain, r.FormValue("noindex") == "on")
+	}
+	if err == nil {
+		err = fs.SetDomainReadOnly(tr.Domain, r.FormValue("readonly") == "on")
+	}
+	if err == nil {
+		err = fs.SetDomainSensitive(tr.Domain, r.FormValue("sensitive") == "on")
+	}
 	message := "settings updated"
 	if password != "" {
 		message = "password updated"
 	}
 	if err != nil {
 		message = err.Error()
+	} else {
+		audit(r, "domain.updated", tr.Domain, "", message)
 	}
 	return tr.handleMain(w, r, message)
 }
 
+func (tr *TemplateRender) handleClone(w http.ResponseWriter, r *http.Request) (err error) {
+	tr.DomainKey = strings.TrimSpace(strings.ToLower(r.FormValue("domain_key")))
+	tr.Domain = utils.NormalizeDomain(r.FormValue("domain"))
+	newDomain := utils.NormalizeDomain(r.FormValue("new_domain"))
+	password := strings.TrimSpace(r.FormValue("password"))
+	keepHistory := strings.TrimSpace(r.FormValue("history")) == "on"
+	if newDomain == "" || newDomain == "public" {
+		return tr.handleMain(w, r, "need a new domain name")
+	}
+
+	// check that the key is valid
+	domainFound, err := fs.CheckKey(tr.DomainKey)
+	if err != nil || tr.Domain != domainFound {
+		return tr.handleMain(w, r, "need to be logged in to clone")
+	}
+	if fs.KeyRole(tr.DomainKey) != db.RoleAdmin {
+		return tr.handleMain(w, r, "need to be an admin to clone")
+	}
+
+	err = fs.CloneDomain(tr.Domain, newDomain, password, keepHistory)
+	if err != nil {
+		return tr.handleMain(w, r, err.Error())
+	}
+	audit(r, "domain.cloned", tr.Domain, "", newDomain)
+
+	// sign in to the new domain
+	tr.Domain = newDomain
+	tr.DomainKey, err = fs.SetKey(tr.Domain, password)
+	if err != nil {
+		return tr.handleMain(w, r, err.Error())
+	}
+	tr.rememberKeys(tr.Remember)
+	cookie := tr.updateDomainCookie(w, r)
+	http.SetCookie(w, &cookie)
+	http.Redirect(w, r, "/"+tr.Domain, 302)
+	return nil
+}
+
+func (tr *TemplateRender) handleArchive(w http.ResponseWriter, r *http.Request) (err error) {
+	r.ParseForm()
+	tr.DomainKey = strings.TrimSpace(strings.ToLower(r.FormValue("domain_key")))
+	tr.Domain = utils.NormalizeDomain(r.FormValue("domain"))
+	archived := strings.TrimSpace(r.FormValue("archived")) != "off"
+
+	// check that the key is valid
+	domainFound, err := fs.CheckKey(tr.DomainKey)
+	if err != nil || tr.Domain != domainFound {
+		return tr.handleMain(w, r, "need to be logged in to archive")
+	}
+
+	changed, err := fs.SetArchived(tr.Domain, r.Form["id"], archived)
+	if _, ok := err.(db.ReadOnlyError); ok {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return nil
+	} else if err != nil {
+		return tr.handleMain(w, r, err.Error())
+	}
+	for _, id := range r.Form["id"] {
+		if archived {
+			audit(r, "page.archived", tr.Domain, id, "")
+		} else {
+			audit(r, "page.unarchived", tr.Domain, id, "")
+		}
+	}
+	if archived {
+		return tr.handleMain(w, r, fmt.Sprintf("archived %d pages", changed))
+	}
+	return tr.handleMain(w, r, fmt.Sprintf("unarchived %d pages", changed))
+}
+
+func (tr *TemplateRender) handleDuplicate(w http.ResponseWriter, r *http.Request) (err error) {
+	tr.Domain = utils.NormalizeDomain(r.FormValue("domain"))
+	id := strings.TrimSpace(r.FormValue("id"))
+	slug := utils.NormalizeSlug(r.FormValue("slug"))
+	if tr.Domain == "" {
+		tr.Domain = "public"
+	}
+	if tr.Domain != "public" {
+		tr.DomainKey = strings.TrimSpace(strings.ToLower(r.FormValue("domain_key")))
+		domainFound, errKey := fs.CheckKey(tr.DomainKey)
+		if errKey != nil || tr.Domain != domainFound {
+			return tr.handleMain(w, r, "need to be logged in to duplicate")
+		}
+	}
+
+	f, err := fs.Duplicate(id, tr.Domain, slug)
+	if err != nil {
+		return tr.handleMain(w, r, err.Error())
+	}
+	auditSave(r, f)
+	http.Redirect(w, r, "/"+tr.Domain+"/"+f.ID, 302)
+	return nil
+}
+
 func (tr *TemplateRender) handleWebsocket(w http.ResponseWriter, r *http.Request) (err error) {
 	// handle websockets on this page
-	c, errUpgrade := wsupgrader.Upgrade(w, r, nil)
+	conn, errUpgrade := wsupgrader.Upgrade(w, r, nil)
 	if errUpgrade != nil {
 		return errUpgrade
 	}
-	defer c.Close()
-	domainChecked := false
-	domainValidated := false
+	defer conn.Close()
+	c := &wsConn{Conn: conn}
 	var editFile db.File
-	var p Payload
 	for {
+		// each message starts empty, so that the id of a page is not
+		// kept from the message before
+		var p Payload
 		err := c.ReadJSON(&p)
 		if err != nil {
 			log.Debug("read:", err)
 			if editFile.ID != "" {
+				leavePage(editFile.Domain, editFile.ID, c)
 				log.Debugf("saving editing of /%s/%s", editFile.Domain, editFile.ID)
 				if editFile.Domain != "public" {
 					err = addSimilar(editFile.Domain, editFile.ID)
@@ -479,43 +1038,102 @@ func (tr *TemplateRender) handleWebsocket(w http.ResponseWriter, r *http.Request
 		}
 		// log.Debugf("recv: %v", p)
 
-		if !domainChecked {
-			domainChecked = true
-			if p.Domain == "public" {
-				domainValidated = true
+		if p.Message == "search" {
+			err = tr.streamSearch(r, c, p)
+			if err != nil {
+				log.Debug("write:", err)
+				break
+			}
+			continue
+		}
+		if p.Domain == "" {
+			p.Domain = "public"
+		}
+
+		// find the page when only given a slug, in a domain that this
+		// message can write to
+		if p.ID == "" && p.Slug != "" && tr.canWrite(p.Domain, p.DomainKey) {
+			files, _ := fs.GetCtx(db.AsMember(r.Context()), p.Slug, p.Domain)
+			if len(files) > 0 {
+				p.ID = files[0].ID
 			} else {
-				_, keyErr := fs.CheckKey(p.DomainKey)
-				if keyErr == nil {
-					domainValidated = true
-				}
+				p.ID = utils.UUID()
 			}
 		}
 
-		// save it
-		if p.ID != "" && domainValidated {
+		if p.Message == "join" {
 			if p.Domain == "" {
 				p.Domain = "public"
 			}
+			if p.ID != "" && tr.canRead(p.Domain, p.DomainKey) {
+				if editFile.ID != "" {
+					leavePage(editFile.Domain, editFile.ID, c)
+					editFile = db.File{}
+				}
+				var joined bool
+				joined, err = joinEdit(tr.readContext(r, p.Domain, p.DomainKey), c, p.ID, p.Domain)
+				if joined {
+					editFile = db.File{ID: p.ID, Domain: p.Domain}
+				}
+				if err != nil {
+					log.Debug("write:", err)
+					break
+				}
+			}
+			continue
+		}
+
+		// save it, checking every time since each message names its domain
+		if p.ID != "" && tr.canWrite(p.Domain, p.DomainKey) {
 			data := strings.TrimSpace(p.Data)
 			if data == introText {
 				data = ""
 			}
+			if editFile.ID != "" && (editFile.ID != p.ID || editFile.Domain != p.Domain) {
+				leavePage(editFile.Domain, editFile.ID, c)
+			}
 			editFile = db.File{
 				ID:      p.ID,
 				Slug:    p.Slug,
 				Data:    data,
 				Created: time.Now(),
 				Domain:  p.Domain,
+				Source:  remoteIP(r),
+				Author:  tr.author(p.Domain, p.DomainKey),
+			}
+			editFile.Slug = pageSlug(editFile, editFile.Data)
+			if p.Message == "edit" {
+				// editors that send what they started from edit
+				// together with the other editors of the page
+				base := strings.TrimSpace(p.Base)
+				if base == introText {
+					base = ""
+				}
+				editFile, err = handleEdit(r, c, editFile, base)
+				if err != nil {
+					log.Debug("write:", err)
+					break
+				}
+				continue
 			}
 			err = fs.Save(editFile)
-			if err != nil {
+			if _, ok := err.(db.ReadOnlyError); ok {
+				err = c.WriteJSON(Payload{Message: "not saving"})
+				if err != nil {
+					log.Debug("write:", err)
+					break
+				}
+				continue
+			} else if err != nil {
 				log.Error(err)
+			} else {
+				auditSave(r, editFile)
 			}
-			fs, _ := fs.Get(p.Slug, p.Domain)
+			fs, _ := fs.GetCtx(db.AsMember(r.Context()), editFile.Slug, p.Domain)
 
 			err = c.WriteJSON(Payload{
 				ID:      p.ID,
-				Slug:    p.Slug,
+				Slug:    editFile.Slug,
 				Message: "unique_slug",
 				Success: len(fs) < 2,
 			})
@@ -537,6 +1155,39 @@ func (tr *TemplateRender) handleWebsocket(w http.ResponseWriter, r *http.Request
 	return
 }
 
+// streamSearch sends the pages that match a search over a websocket as
+// soon as each one is found, followed by a message saying how many were
+// found
+func (tr *TemplateRender) streamSearch(r *http.Request, c *wsConn, p Payload) (err error) {
+	p.Domain = utils.NormalizeDomain(p.Domain)
+	if p.Domain == "" || p.Domain == "public" {
+		return c.WriteJSON(Payload{Message: "can't search public"})
+	}
+	if !tr.canRead(p.Domain, p.DomainKey) {
+		return c.WriteJSON(Payload{Domain: p.Domain, Message: "need to log in to search"})
+	}
+
+	found := 0
+	errFind := fs.FindEach(tr.readContext(r, p.Domain, p.DomainKey), p.Data, p.Domain, func(f db.File) error {
+		found++
+		c.SetWriteDeadline(time.Now().Add(searchWriteTimeout))
+		return c.WriteJSON(Payload{
+			ID:      f.ID,
+			Slug:    f.Slug,
+			Domain:  p.Domain,
+			Data:    string(f.Snippet),
+			Message: "search_result",
+			Success: true,
+		})
+	})
+	c.SetWriteDeadline(time.Time{})
+	if errFind != nil {
+		log.Debugf("could not search %s: %s", p.Domain, errFind.Error())
+		return c.WriteJSON(Payload{Domain: p.Domain, Message: "search_done", Data: strconv.Itoa(found)})
+	}
+	return c.WriteJSON(Payload{Domain: p.Domain, Message: "search_done", Data: strconv.Itoa(found), Success: true})
+}
+
 func handleStatic(w http.ResponseWriter, r *http.Request) (err error) {
 	page := r.URL.Path
 	w.Header().Set("Vary", "Accept-Encoding")
@@ -564,28 +1215,50 @@ func (tr *TemplateRender) handleViewEdit(w http.ResponseWriter, r *http.Request)
 	// handle new page
 	// get edit url parameter
 	log.Debugf("loading %s", tr.Page)
+	if strings.Contains(tr.Page, "@") {
+		return tr.handleVersion(w, r)
+	}
 	havePage, err := fs.Exists(tr.Page, tr.Domain)
 	if err != nil {
 		return
 	}
+	// pages like /slug.fr are the translations of pages
+	if !havePage {
+		if source, lang, ok := translationPath(tr.readContext(r, tr.Domain, ""), tr.Domain, tr.Page); ok {
+			return tr.handleTranslation(w, r, source, lang)
+		}
+		if tr.redirectMissing(w, r, true) {
+			return
+		}
+	}
 	initialMarkdown := ""
 	var f db.File
 
 	// check if domain is public and exists
 	_, ispublic, errGet := fs.GetDomainFromName(tr.Domain)
-	if errGet == nil && !tr.SignedIn && !ispublic {
+	if errGet == nil && !tr.SignedIn && !tr.Reader && !ispublic {
 		return tr.handleMain(w, r, "domain is not public, sign in first")
 	}
+	// people who can not write in the domain are told that the page is not
+	// there, instead of it being made
+	if !havePage && errGet == nil && !tr.SignedIn && tr.Domain != "public" {
+		return tr.handleNotFound(w, r)
+	}
+	tr.DomainFeed = errGet == nil && ispublic
 
 	if havePage {
 		var files []db.File
-		files, err = fs.Get(tr.Page, tr.Domain)
-		if err != nil {
+		ctx := tr.readContext(r, tr.Domain, "")
+		files, err = fs.GetCtx(ctx, tr.Page, tr.Domain)
+		if err != nil && ctx == r.Context() {
+			// private pages are not there for who is not a member
+			return tr.handleNotFound(w, r)
+		} else if err != nil {
 			log.Error(err)
 			return tr.handleMain(w, r, err.Error())
 		}
 		if len(files) > 1 {
-			return tr.handleList(w, r, tr.Page, files)
+			return tr.handleList(w, r, tr.Page, files, 0, len(files))
 		} else {
 			f = files[0]
 		}
@@ -593,6 +1266,12 @@ func (tr *TemplateRender) handleViewEdit(w http.ResponseWriter, r *http.Request)
 		if err != nil {
 			log.Error(err)
 		}
+		tr.SimilarFiles = fs.FilterListed(ctx, tr.SimilarFiles)
+		tr.Backlinks, err = fs.GetBacklinks(f.ID, tr.Domain)
+		if err != nil {
+			log.Error(err)
+		}
+		tr.Backlinks = fs.FilterListed(ctx, tr.Backlinks)
 	} else {
 		uuid := utils.UUID()
 		f = db.File{
@@ -603,14 +1282,19 @@ func (tr *TemplateRender) handleViewEdit(w http.ResponseWriter, r *http.Request)
 		}
 		f.Slug = tr.Page
 		f.Data = ""
-		err = fs.Save(f)
-		if err != nil {
+		err = fs.SaveCtx(r.Context(), f)
+		if _, ok := err.(db.ReadOnlyError); ok {
+			return tr.handleMain(w, r, "page does not exist, and "+err.Error())
+		} else if err != nil {
 			return tr.handleMain(w, r, "domain does not exist")
 		}
 		log.Debugf("saved: %+v", f)
 		http.Redirect(w, r, "/"+tr.Domain+"/"+tr.Page, 302)
 		return
 	}
+	if tr.sensitiveGate(w, r, f) {
+		return tr.showSensitiveNotice(w, r, f)
+	}
 	initialMarkdown += "\n\n" + f.Data
 	// if f.Data == "" {
 	// 	f.Data = introText
@@ -623,12 +1307,38 @@ func (tr *TemplateRender) handleViewEdit(w http.ResponseWriter, r *http.Request)
 		}
 	}()
 
+	// front matter is not rendered
+	meta, body := utils.ParseFrontMatter(f.Data)
+	if r.URL.Query().Get("export") == "html" {
+		return tr.handleStandalone(w, r, f, meta, body)
+	}
+	initialMarkdown = "\n\n" + linkTags(tr.Domain, linkWikiLinks(tr.Domain, body))
+	tr.Canonical = meta["canonical"]
+	for _, link := range strings.Split(meta["syndication"], ",") {
+		link = strings.TrimSpace(link)
+		if link != "" {
+			tr.Syndication = append(tr.Syndication, link)
+		}
+	}
+
 	tr.Title = f.Slug
-	tr.Rendered = utils.RenderMarkdownToHTML(initialMarkdown)
+	tr.Version = db.VersionHash(f.Data)
+	tr.setLanguage(f)
+	tr.setTranslations(tr.readContext(r, tr.Domain, ""), f)
+	tr.Translator = translator != ""
+	tr.Rendered = addPreviews(utils.RenderMarkdownToHTML(initialMarkdown))
+	tr.Rendered = addOutboundTracking(tr.Rendered, tr.Domain, r.Host)
+	tr.Rendered = signUploadLinks(tr.Rendered, tr.Domain)
+	tr.File = f
+	tr.Rendered = tr.addForm(tr.Rendered, body, r.URL.Query().Get("submitted") != "")
+	tr.Rendered = tr.addTables(tr.Rendered, body)
+	tr.Rendered = addCharts(tr.Rendered, body)
+	tr.Rendered = addMaps(tr.Rendered, meta)
 	tr.File = f
 	tr.IntroText = template.JS(introText)
 	tr.Rows = len(strings.Split(string(utils.RenderMarkdownToHTML(initialMarkdown)), "\n")) + 1
-	tr.EditOnly = strings.TrimSpace(f.Data) == ""
+	tr.EditOnly = strings.TrimSpace(f.Data) == "" && !tr.DomainReadOnly
+	tr.Pandoc = pandoc != ""
 
 	w.Header().Set("Content-Encoding", "gzip")
 	w.Header().Set("Content-Type", "text/html")
@@ -640,31 +1350,151 @@ func (tr *TemplateRender) handleViewEdit(w http.ResponseWriter, r *http.Request)
 
 }
 
+// handleVersion shows a page as it was at one version, given as
+// /domain/slug@hash
+func (tr *TemplateRender) handleVersion(w http.ResponseWriter, r *http.Request) (err error) {
+	_, ispublic, errGet := fs.GetDomainFromName(tr.Domain)
+	if errGet == nil && !tr.SignedIn && !tr.Reader && !ispublic {
+		return tr.handleMain(w, r, "domain is not public, sign in first")
+	}
+
+	parts := strings.SplitN(tr.Page, "@", 2)
+	current, errVisible := fs.GetCtx(tr.readContext(r, tr.Domain, ""), parts[0], tr.Domain)
+	if errVisible != nil {
+		return tr.handleNotFound(w, r)
+	}
+	if tr.sensitiveGate(w, r, current[0]) {
+		return tr.showSensitiveNotice(w, r, current[0])
+	}
+	f, err := fs.GetVersionByHash(parts[0], tr.Domain, parts[1])
+	if err != nil {
+		return tr.handleMain(w, r, err.Error())
+	}
+
+	_, body := utils.ParseFrontMatter(f.Data)
+	tr.Title = f.Slug
+	tr.setLanguage(f)
+	tr.Rendered = utils.RenderMarkdownToHTML("\n\n" + body)
+	tr.File = f
+	tr.Version = parts[1]
+	tr.ReadOnly = true
+
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Set("Content-Type", "text/html")
+	// versions never change, but the signed links to their uploads expire,
+	// and sensitive pages are not kept where others could be shown them
+	if current[0].Sensitive {
+		tr.Rendered = signUploadLinks(tr.Rendered, tr.Domain)
+		w.Header().Set("Cache-Control", "private, max-age=3600")
+	} else if signed := signUploadLinks(tr.Rendered, tr.Domain); signed != tr.Rendered {
+		tr.Rendered = signed
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=7776000")
+	}
+	gz := gzip.NewWriter(w)
+	defer gz.Close()
+	return viewEditTemplate.Execute(gz, tr)
+}
+
 func (tr *TemplateRender) handleUploads(w http.ResponseWriter, r *http.Request, id string) (err error) {
 	log.Debug("getting ", id)
-	name, data, _, err := fs.GetBlob(id)
+	// the requests for the rest of an upload that is being played are not
+	// more views of it
+	rangeHeader := r.Header.Get("Range")
+	b, err := fs.ViewBlob(id, tr.DomainList, rangeHeader == "" || strings.HasPrefix(rangeHeader, "bytes=0-"))
+	var rs db.ReadSeekCloser
+	if err == nil {
+		rs, err = fs.OpenBlob(id)
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+		// uploads that can not be read are not found, so that nobody can
+		// tell which uploads a private domain has
+		log.Debug(err)
+		http.Error(w, "upload not found", http.StatusNotFound)
+		return nil
 	}
+	defer rs.Close()
 
-	w.Header().Set("Vary", "Accept-Encoding")
-	w.Header().Set("Cache-Control", "public, max-age=7776000")
-	w.Header().Set("Content-Encoding", "gzip")
-	w.Header().Set("Content-Type", "text/plain")
-	w.Header().Set("Content-Disposition",
-		`attachment; filename="`+name+`"`,
-	)
-	w.Write(data)
+	// uploads served to the public count against the monthly cap of their
+	// domain, so that a hot-linked upload can not use all the bandwidth
+	domain, public, errEgress := fs.BlobEgressDomain(id, tr.DomainList)
+	if errEgress != nil {
+		log.Debug(errEgress)
+	}
+	if public && (hotlinkRefused(w, r, id, domain) || overBandwidth(w, r, domain)) {
+		return nil
+	}
+	if domain != "" {
+		ew := &egressWriter{ResponseWriter: w}
+		w = ew
+		defer func() {
+			if errEgress := fs.AddEgress(domain, ew.n, false); errEgress != nil {
+				log.Debug(errEgress)
+			}
+		}()
+	}
+
+	// uploads of private domains are only for who is logged in to them,
+	// and the data of an upload never changes since its id is its hash
+	w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+	w.Header().Set("ETag", `"`+b.ID+`"`)
+	// images, audio and video are shown as they are, and anything else,
+	// which could be a page with scripts, is downloaded
+	disposition := "attachment"
+	contentType := "application/octet-stream"
+	if inlineContentType(b.ContentType) {
+		disposition = "inline"
+		contentType = b.ContentType
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Disposition", disposition+`; filename="`+b.Name+`"`)
+	// ranges of the upload can be asked for, so that audio and video can
+	// be played before they are downloaded
+	http.ServeContent(w, r, b.Name, b.Created, rs)
 	return
 }
 
+// inlineContentType returns whether uploads of a type can be shown by a
+// browser without running what is in them
+func inlineContentType(contentType string) bool {
+	contentType = strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0])
+	if contentType == "image/svg+xml" {
+		return false
+	}
+	return strings.HasPrefix(contentType, "image/") ||
+		strings.HasPrefix(contentType, "audio/") ||
+		strings.HasPrefix(contentType, "video/")
+}
+
+// readBlob returns the name and data of an upload
+func readBlob(id string) (name string, data []byte, err error) {
+	b, err := fs.BlobInfo(id)
+	if err != nil {
+		return
+	}
+	rs, err := fs.OpenBlob(id)
+	if err != nil {
+		return
+	}
+	defer rs.Close()
+	data, err = ioutil.ReadAll(rs)
+	return b.Name, data, err
+}
+
 func (tr *TemplateRender) handleUpload(w http.ResponseWriter, r *http.Request) (err error) {
 	domain := r.URL.Query().Get("domain")
-	if !tr.SignedIn || domain == "public" {
+	// the domain of the path is "upload", so it is the domain that was
+	// asked for that has to be logged in to
+	if domain == "public" || !tr.canWrite(domain, "") {
 		http.Error(w, "need to be logged in", http.StatusForbidden)
 		return
 	}
+	if readonly, _ := fs.GetDomainReadOnly(domain); readonly {
+		http.Error(w, db.ReadOnlyError{Domain: domain}.Error(), http.StatusForbidden)
+		return
+	}
 
 	file, info, err := r.FormFile("file")
 	if err != nil {
@@ -673,46 +1503,73 @@ func (tr *TemplateRender) handleUpload(w http.ResponseWriter, r *http.Request) (
 	}
 	defer file.Close()
 
-	h := sha256.New()
-	if _, err = io.Copy(h, file); err != nil {
+	id, err := storeBlob(domain, info.Filename, file)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	id := fmt.Sprintf("sha256-%x", h.Sum(nil))
 
-	// copy file to buffer
-	file.Seek(0, io.SeekStart)
-	var fileData bytes.Buffer
-	gzipWriter := gzip.NewWriter(&fileData)
-	_, err = io.Copy(gzipWriter, file)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	w.Header().Set("Location", "/uploads/"+id+"?filename="+url.QueryEscape(info.Filename))
+	_, err = w.Write([]byte("ok"))
+	return
+}
+
+// storeBlob saves an upload to a domain, returning its id, which is the
+// hash of its data. It is read a chunk at a time, and uploads that are
+// saved already are not saved again.
+func storeBlob(domain, name string, file io.ReadSeeker) (id string, err error) {
+	// the type is found from the name, or else from the start of the
+	// upload
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		head := make([]byte, 512)
+		n, _ := io.ReadFull(file, head)
+		contentType = http.DetectContentType(head[:n])
+		_, err = file.Seek(0, io.SeekStart)
+		if err != nil {
+			return
+		}
 	}
-	gzipWriter.Close()
 
-	// save file
-	err = fs.SaveBlob(id, info.Filename, fileData.Bytes())
+	id, size, err := fs.StoreBlob(name, file)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-
-	w.Header().Set("Location", "/uploads/"+id+"?filename="+url.QueryEscape(info.Filename))
-	_, err = w.Write([]byte("ok"))
+	err = fs.AddBlob(domain, db.Blob{
+		ID:          id,
+		Name:        name,
+		ContentType: contentType,
+		Size:        size,
+	}, nil)
 	return
 }
 
+// writeJSON writes the response of an api call
+func writeJSON(w http.ResponseWriter, status int, v interface{}) (err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(v)
+}
+
+// checkDomainKey returns whether the key can write to the domain
+func checkDomainKey(domain, domainKey string) bool {
+	if domain == "public" {
+		return true
+	}
+	domainFound, err := fs.CheckKey(domainKey)
+	return err == nil && domain == domainFound
+}
+
 func handle(w http.ResponseWriter, r *http.Request) (err error) {
 	// very special paths
 	if r.URL.Path == "/robots.txt" {
 		// special path
-		w.Write([]byte(`User-agent: * 
-Disallow: /`))
+		return handleRobots(w, r)
 	} else if r.URL.Path == "/favicon.ico" {
 		// TODO
 	} else if r.URL.Path == "/sitemap.xml" {
-		// TODO
+		// special path /sitemap.xml
+		return handleSitemapIndex(w, r)
 	} else if strings.HasPrefix(r.URL.Path, "/static") {
 		// special path /static
 		return handleStatic(w, r)
@@ -723,13 +1580,27 @@ Disallow: /`))
 	tr := new(TemplateRender)
 	tr.Domain = "public"
 	if len(fields) > 2 {
-		tr.Page = strings.TrimSpace(strings.ToLower(fields[2]))
+		tr.Page = utils.NormalizeSlug(fields[2])
 	}
 	if len(fields) > 1 {
-		tr.Domain = strings.TrimSpace(strings.ToLower(fields[1]))
+		tr.Domain = utils.NormalizeDomain(fields[1])
 	}
 
-	tr.SignedIn, tr.DomainKey, tr.DefaultDomain, tr.DomainList, tr.DomainKeys = isSignedIn(w, r, tr.Domain)
+	tr.SignedIn, tr.DomainKey, tr.DefaultDomain, tr.DomainList, tr.DomainKeys, tr.Remember = isSignedIn(w, r, tr.Domain)
+	if tr.SignedIn && fs.KeyRole(tr.DomainKey) == db.RoleReader {
+		// readers can read the domain but not write it
+		tr.SignedIn = false
+		tr.Reader = true
+	}
+	tr.Accounts = ldapAuth != nil || provisionToken != "" || fs.HasUsers()
+	tr.SAML = samlAuth != nil
+	tr.Announcements = activeAnnouncements()
+	if noindex, _ := fs.GetDomainNoIndex(tr.Domain); noindex {
+		// unlisted domains are not indexed by search engines
+		w.Header().Set("X-Robots-Tag", "noindex")
+		tr.NoIndex = true
+	}
+	tr.DomainReadOnly, _ = fs.GetDomainReadOnly(tr.Domain)
 
 	if r.URL.Path == "/" {
 		// special path /
@@ -737,12 +1608,177 @@ Disallow: /`))
 	} else if r.URL.Path == "/login" {
 		// special path /login
 		return tr.handleLogin(w, r)
+	} else if strings.HasPrefix(r.URL.Path, "/saml/") {
+		// special path /saml/login, /saml/acs and /saml/metadata
+		return tr.handleSAML(w, r)
 	} else if r.URL.Path == "/ws" {
 		// special path /ws
 		return tr.handleWebsocket(w, r)
 	} else if r.URL.Path == "/update" {
 		// special path /login
 		return tr.handleLoginUpdate(w, r)
+	} else if r.URL.Path == "/clone" {
+		// special path /clone
+		return tr.handleClone(w, r)
+	} else if r.URL.Path == "/api/v1/annotations" {
+		// special path /api/v1/annotations
+		return tr.handleAnnotations(w, r)
+	} else if strings.HasPrefix(r.URL.Path, "/api/v1/pages/") {
+		// special path /api/v1/pages/{id}
+		return tr.handlePages(w, r)
+	} else if r.URL.Path == "/admin" || r.URL.Path == "/admin/report" {
+		// special path /admin and /admin/report
+		return tr.handleReport(w, r)
+	} else if r.URL.Path == "/admin/domains" {
+		// special path /admin/domains
+		return tr.handleAdminDomains(w, r)
+	} else if r.URL.Path == "/admin/moderation" {
+		// special path /admin/moderation
+		return tr.handleModeration(w, r)
+	} else if r.URL.Path == "/admin/erase" {
+		// special path /admin/erase
+		return tr.handleErase(w, r)
+	} else if r.URL.Path == "/admin/announcements" {
+		// special path /admin/announcements
+		return tr.handleAnnouncements(w, r)
+	} else if r.URL.Path == "/admin/gc" {
+		// special path /admin/gc
+		return tr.handleGarbageCollect(w, r)
+	} else if r.URL.Path == "/metrics" {
+		// special path
+		return tr.handleMetrics(w, r)
+	} else if r.URL.Path == "/api/v1/users" || strings.HasPrefix(r.URL.Path, "/api/v1/users/") {
+		// special path /api/v1/users and /api/v1/users/{name}
+		return tr.handleUsers(w, r)
+	} else if r.URL.Path == "/api/v1/time" {
+		// special path /api/v1/time
+		return tr.handleTimeReport(w, r)
+	} else if r.URL.Path == "/api/v1/table" {
+		// special path /api/v1/table
+		return tr.handleTable(w, r)
+	} else if r.URL.Path == "/api/v1/readability" {
+		// special path /api/v1/readability
+		return tr.handleReadability(w, r)
+	} else if r.URL.Path == "/api/v1/history" {
+		// special path /api/v1/history
+		return tr.handleHistoryAPI(w, r)
+	} else if r.URL.Path == "/api/v1/tags" {
+		// special path /api/v1/tags
+		return tr.handleTagsAPI(w, r)
+	} else if r.URL.Path == "/api/v1/feeds" {
+		// special path /api/v1/feeds
+		return tr.handleFeeds(w, r)
+	} else if r.URL.Path == "/api/v1/sync" {
+		// special path /api/v1/sync
+		return tr.handleSync(w, r)
+	} else if r.URL.Path == "/api/v1/mirrors" {
+		// special path /api/v1/mirrors
+		return tr.handleMirrors(w, r)
+	} else if r.URL.Path == "/api/v1/mirror" {
+		// special path /api/v1/mirror
+		return handleMirror(w, r)
+	} else if r.URL.Path == "/api/v1/clip" {
+		// special path /api/v1/clip
+		return handleClip(w, r)
+	} else if fields := strings.Split(strings.Trim(r.URL.Path, "/"), "/"); len(fields) == 4 && fields[0] == "api" && fields[1] == "v1" && fields[3] == "backup" {
+		// special path /api/v1/{domain}/backup
+		return tr.handleDomainBackup(w, r)
+	} else if fields := strings.Split(strings.Trim(r.URL.Path, "/"), "/"); len(fields) >= 4 && len(fields) <= 5 && fields[0] == "api" && fields[1] == "v1" && fields[3] == "members" {
+		// special path /api/v1/{domain}/members and /api/v1/{domain}/members/{name}
+		return tr.handleDomainMembers(w, r)
+	} else if fields := strings.Split(strings.Trim(r.URL.Path, "/"), "/"); len(fields) >= 4 && len(fields) <= 5 && fields[0] == "api" && fields[1] == "v1" && fields[3] == "tokens" {
+		// special path /api/v1/{domain}/tokens and /api/v1/{domain}/tokens/{id}
+		return tr.handleDomainTokens(w, r)
+	} else if fields := strings.Split(strings.Trim(r.URL.Path, "/"), "/"); len(fields) == 5 && fields[0] == "api" && fields[1] == "v1" && fields[2] == "domains" && fields[4] == "events" {
+		// special path /api/v1/domains/{domain}/events
+		return tr.handleDomainEvents(w, r)
+	} else if fields := strings.Split(strings.Trim(r.URL.Path, "/"), "/"); len(fields) == 5 && fields[0] == "api" && fields[1] == "v1" && fields[3] == "events" {
+		// special path /api/v1/{domain}/events/{id}
+		return tr.handlePageEvents(w, r)
+	} else if fields := strings.Split(strings.Trim(r.URL.Path, "/"), "/"); len(fields) >= 4 && len(fields) <= 5 && fields[0] == "api" && fields[1] == "v1" && fields[3] == "documents" {
+		// special path /api/v1/{domain}/documents and /api/v1/{domain}/documents/{id}
+		return tr.handleDocuments(w, r)
+	} else if r.URL.Path == "/out" {
+		// special path /out
+		return handleOutbound(w, r)
+	} else if r.URL.Path == "/clipping" {
+		// special path /clipping
+		return tr.handleClipping(w, r)
+	} else if r.URL.Path == "/form" {
+		// special path /form
+		return tr.handleForm(w, r)
+	} else if r.URL.Path == "/form/export" {
+		// special path /form/export
+		return tr.handleFormExport(w, r)
+	} else if r.URL.Path == "/report" {
+		// special path /report
+		return tr.handleReportPage(w, r)
+	} else if r.URL.Path == "/rename-domain" {
+		// special path /rename-domain
+		return tr.handleRenameDomain(w, r)
+	} else if r.URL.Path == "/delete-domain" {
+		// special path /delete-domain
+		return tr.handleDeleteDomain(w, r)
+	} else if r.URL.Path == "/sensitive" {
+		// special path /sensitive
+		return tr.handleSensitive(w, r)
+	} else if r.URL.Path == "/visibility" {
+		// special path /visibility
+		return tr.handleVisibility(w, r)
+	} else if r.URL.Path == "/archive" {
+		// special path /archive
+		return tr.handleArchive(w, r)
+	} else if r.URL.Path == "/search-words" {
+		// special path /search-words
+		return tr.handleSearchWords(w, r)
+	} else if r.URL.Path == "/slug-options" {
+		// special path /slug-options
+		return tr.handleSlugOptions(w, r)
+	} else if r.URL.Path == "/redirects" {
+		// special path /redirects
+		return tr.handleImportRedirects(w, r)
+	} else if r.URL.Path == "/members" {
+		// special path /members
+		return tr.handleMembers(w, r)
+	} else if r.URL.Path == "/hotlinks" {
+		// special path /hotlinks
+		return tr.handleHotlinks(w, r)
+	} else if r.URL.Path == "/bandwidth" {
+		// special path /bandwidth
+		return tr.handleBandwidth(w, r)
+	} else if r.URL.Path == "/tokens" {
+		// special path /tokens
+		return tr.handleTokens(w, r)
+	} else if r.URL.Path == "/history-policy" {
+		// special path /history-policy
+		return tr.handleHistoryPolicy(w, r)
+	} else if r.URL.Path == "/translate" {
+		// special path /translate
+		return tr.handleTranslate(w, r)
+	} else if r.URL.Path == "/language" {
+		// special path /language
+		return tr.handleDomainLanguage(w, r)
+	} else if r.URL.Path == "/delete" {
+		// special path /delete
+		return tr.handleDelete(w, r)
+	} else if r.URL.Path == "/cards/grade" {
+		// special path /cards/grade
+		return tr.handleGradeCard(w, r)
+	} else if r.URL.Path == "/cards/export" {
+		// special path /cards/export
+		return tr.handleExportCards(w, r)
+	} else if r.URL.Path == "/export" {
+		// special path /export
+		return tr.handlePandoc(w, r)
+	} else if r.URL.Path == "/review" {
+		// special path /review
+		return tr.handleReview(w, r)
+	} else if r.URL.Path == "/duplicate" {
+		// special path /duplicate
+		return tr.handleDuplicate(w, r)
+	} else if r.URL.Path == "/revert" {
+		// special path /revert
+		return tr.handleRevert(w, r)
 	} else if r.URL.Path == "/logout" {
 		// special path /logout
 		return tr.handleLogout(w, r)
@@ -763,6 +1799,9 @@ Disallow: /`))
 			}
 			return tr.handleSearch(w, r, tr.Domain, r.URL.Query().Get("q"))
 		}
+		if tr.redirectMissing(w, r, false) {
+			return
+		}
 		// domain exists, handle normally
 		return tr.handleMain(w, r, "")
 	} else if tr.Domain != "" && tr.Page != "" {
@@ -771,12 +1810,72 @@ Disallow: /`))
 				return tr.handleMain(w, r, "can't list public")
 			}
 
-			files, _ := fs.GetAll(tr.Domain)
+			if lang := r.URL.Query().Get("lang"); lang != "" {
+				return tr.handleLanguageList(w, r, lang)
+			}
+			offset := listOffset(r)
+			files, total, _ := fs.GetTopXOffsetCtx(tr.readContext(r, tr.Domain, ""), tr.Domain, listPageSize, offset)
+			for i := range files {
+				files[i].Data = ""
+				files[i].DataHTML = template.HTML("")
+			}
+			tr.Languages, _ = fs.ListLanguages(tr.Domain)
+			return tr.handleList(w, r, "All", files, offset, total)
+		} else if tr.Page == "archived" {
+			if tr.Domain == "public" {
+				return tr.handleMain(w, r, "can't list public")
+			}
+
+			files, _ := fs.GetArchived(tr.Domain)
 			for i := range files {
 				files[i].Data = ""
 				files[i].DataHTML = template.HTML("")
 			}
-			return tr.handleList(w, r, "All", files)
+			return tr.handleList(w, r, "Archived", files, 0, len(files))
+		} else if tr.Page == "map" {
+			if tr.Domain == "public" {
+				return tr.handleMain(w, r, "can't list public")
+			}
+
+			files, _ := fs.GetAllCtx(tr.readContext(r, tr.Domain, ""), tr.Domain)
+			tr.Map, files = domainMap(tr.Domain, files)
+			for i := range files {
+				files[i].Data = ""
+				files[i].DataHTML = template.HTML("")
+			}
+			return tr.handleList(w, r, "Map", files, 0, len(files))
+		} else if tr.Page == "time" {
+			if tr.Domain == "public" {
+				return tr.handleMain(w, r, "can't list public")
+			}
+			return tr.handleTimes(w, r)
+		} else if tr.Page == "trash" {
+			if tr.Domain == "public" {
+				return tr.handleMain(w, r, "can't list public")
+			}
+			return tr.handleTrash(w, r)
+		} else if tr.Page == "history" {
+			return tr.handleHistory(w, r)
+		} else if tr.Page == "takeout" {
+			return tr.handleTakeout(w, r)
+		} else if tr.Page == "sitemap.xml" {
+			return tr.handleSitemap(w, r)
+		} else if tr.Page == "feed.xml" || tr.Page == "rss.xml" {
+			return tr.handleDomainFeed(w, r, tr.Page == "rss.xml")
+		} else if tr.Page == "export.zip" {
+			return tr.handleExportZip(w, r)
+		} else if tr.Page == "uploads" {
+			return tr.handleUploadList(w, r)
+		} else if tr.Page == "tags" {
+			if tr.Domain == "public" {
+				return tr.handleMain(w, r, "can't list public")
+			}
+			return tr.handleTags(w, r)
+		} else if tr.Page == "review" {
+			if tr.Domain == "public" {
+				return tr.handleMain(w, r, "can't review public")
+			}
+			return tr.handleCards(w, r)
 		}
 		return tr.handleViewEdit(w, r)
 	}
@@ -821,6 +1920,15 @@ func setLogLevel(level string) (err error) {
 	return
 }
 
+// remoteIP returns the address of the client without the port
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
 // createPage throws error if domain does not exist
 func createPage(domain string) (f db.File) {
 	f = db.File{
@@ -837,7 +1945,7 @@ func createPage(domain string) (f db.File) {
 }
 
 func addSimilar(domain string, fileid string) (err error) {
-	files, err := fs.GetAll(domain)
+	files, err := fs.GetAllCtx(db.AsMember(context.Background()), domain)
 	documents := []string{}
 	ids := []string{}
 	maindocument := ""
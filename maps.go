@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// a map is drawn in a box with this size, and scaled to fit the page
+const (
+	mapWidth   = 600
+	mapHeight  = 300
+	mapTile    = 256
+	mapMaxZoom = 15
+)
+
+var mapShortcode = regexp.MustCompile(`<p>\{\{\s*map(?:\s+(-?[\d.]+)\s*,\s*(-?[\d.]+))?\s*\}\}</p>`)
+
+type mapMarker struct {
+	Lat   float64
+	Lon   float64
+	Title string
+	Link  string
+}
+
+type mapTileImage struct {
+	URL    string
+	Left   float64
+	Top    float64
+	Width  float64
+	Height float64
+}
+
+type mapRender struct {
+	Tiles   []mapTileImage
+	Markers []mapMarker
+	Left    []float64
+	Top     []float64
+	Link    string
+}
+
+var mapTemplate = template.Must(template.New("map").Parse(`<div class="geo-map">
+{{ range .Tiles }}<img src="{{.URL}}" alt="" style="left:{{.Left}}%;top:{{.Top}}%;width:{{.Width}}%;height:{{.Height}}%">{{ end }}
+{{ range $i, $m := .Markers }}<a class="geo-marker" {{ if .Link }}href="{{.Link}}" {{ end }}title="{{.Title}}" style="left:{{index $.Left $i}}%;top:{{index $.Top $i}}%"></a>{{ end }}
+<span class="geo-attribution"><a href="{{.Link}}">View larger map</a> &copy; <a href="https://www.openstreetmap.org/copyright">OpenStreetMap</a> contributors</span>
+</div>`))
+
+// pageGeo returns the location in the front matter of a page, which is
+// either "geo: 48.85, 2.35" or separate "lat" and "lon" fields
+func pageGeo(meta map[string]string) (lat, lon float64, ok bool) {
+	latText, lonText := meta["lat"], meta["lon"]
+	if latText == "" {
+		latText = meta["latitude"]
+	}
+	if lonText == "" {
+		lonText = meta["lng"]
+	}
+	if lonText == "" {
+		lonText = meta["longitude"]
+	}
+	if geo := strings.SplitN(meta["geo"], ",", 2); len(geo) == 2 {
+		latText, lonText = geo[0], geo[1]
+	}
+	return parseLatLon(latText, lonText)
+}
+
+func parseLatLon(latText, lonText string) (lat, lon float64, ok bool) {
+	lat, errLat := strconv.ParseFloat(strings.TrimSpace(latText), 64)
+	lon, errLon := strconv.ParseFloat(strings.TrimSpace(lonText), 64)
+	ok = errLat == nil && errLon == nil && math.Abs(lat) <= 85 && math.Abs(lon) <= 180
+	return
+}
+
+// addMaps replaces {{map}} on its own line with a map of the location
+// of the page, or of the location given like {{map 48.85, 2.35}}
+func addMaps(rendered template.HTML, meta map[string]string) template.HTML {
+	return template.HTML(mapShortcode.ReplaceAllStringFunc(string(rendered), func(s string) string {
+		match := mapShortcode.FindStringSubmatch(s)
+		lat, lon, ok := pageGeo(meta)
+		title := meta["place"]
+		if match[1] != "" {
+			lat, lon, ok = parseLatLon(match[1], match[2])
+			title = ""
+		}
+		if !ok {
+			return s
+		}
+		return string(renderMap([]mapMarker{{Lat: lat, Lon: lon, Title: title}}))
+	}))
+}
+
+// project converts a location into pixels on the world map at a zoom level
+func project(lat, lon float64, zoom int) (x, y float64) {
+	size := mapTile * math.Pow(2, float64(zoom))
+	x = (lon + 180) / 360 * size
+	rad := lat * math.Pi / 180
+	y = (1 - math.Log(math.Tan(rad)+1/math.Cos(rad))/math.Pi) / 2 * size
+	return
+}
+
+// renderMap draws OpenStreetMap tiles with markers, zoomed in as far as
+// possible while still showing every marker
+func renderMap(markers []mapMarker) template.HTML {
+	if len(markers) == 0 {
+		return ""
+	}
+	zoom := mapMaxZoom
+	var minX, minY, maxX, maxY float64
+	for ; zoom >= 0; zoom-- {
+		minX, minY = math.Inf(1), math.Inf(1)
+		maxX, maxY = math.Inf(-1), math.Inf(-1)
+		for _, m := range markers {
+			x, y := project(m.Lat, m.Lon, zoom)
+			minX, maxX = math.Min(minX, x), math.Max(maxX, x)
+			minY, maxY = math.Min(minY, y), math.Max(maxY, y)
+		}
+		// leave room around the markers
+		if maxX-minX <= mapWidth-60 && maxY-minY <= mapHeight-60 {
+			break
+		}
+	}
+	if zoom < 0 {
+		zoom = 0
+	}
+	if len(markers) == 1 && zoom > 13 {
+		zoom = 13
+		minX, minY = project(markers[0].Lat, markers[0].Lon, zoom)
+		maxX, maxY = minX, minY
+	}
+	left := (minX+maxX)/2 - mapWidth/2
+	top := (minY+maxY)/2 - mapHeight/2
+
+	mr := mapRender{Markers: markers}
+	tiles := int(math.Pow(2, float64(zoom)))
+	for ty := int(math.Floor(top / mapTile)); float64(ty*mapTile) < top+mapHeight; ty++ {
+		if ty < 0 || ty >= tiles {
+			continue
+		}
+		for tx := int(math.Floor(left / mapTile)); float64(tx*mapTile) < left+mapWidth; tx++ {
+			mr.Tiles = append(mr.Tiles, mapTileImage{
+				URL:    fmt.Sprintf("https://tile.openstreetmap.org/%d/%d/%d.png", zoom, ((tx%tiles)+tiles)%tiles, ty),
+				Left:   percent(float64(tx*mapTile)-left, mapWidth),
+				Top:    percent(float64(ty*mapTile)-top, mapHeight),
+				Width:  percent(mapTile, mapWidth),
+				Height: percent(mapTile, mapHeight),
+			})
+		}
+	}
+	for _, m := range markers {
+		x, y := project(m.Lat, m.Lon, zoom)
+		mr.Left = append(mr.Left, percent(x-left, mapWidth))
+		mr.Top = append(mr.Top, percent(y-top, mapHeight))
+	}
+	centerLat, centerLon := markers[0].Lat, markers[0].Lon
+	mr.Link = fmt.Sprintf("https://www.openstreetmap.org/?mlat=%f&mlon=%f#map=%d/%f/%f", centerLat, centerLon, zoom, centerLat, centerLon)
+
+	var buf bytes.Buffer
+	mapTemplate.Execute(&buf, mr)
+	return template.HTML(buf.String())
+}
+
+func percent(v, total float64) float64 {
+	return math.Round(v/total*10000) / 100
+}
+
+// domainMap draws a map with every page in a domain that has a location
+func domainMap(domain string, files []db.File) (m template.HTML, geotagged []db.File) {
+	var markers []mapMarker
+	for _, f := range files {
+		meta, _ := utils.ParseFrontMatter(f.Data)
+		lat, lon, ok := pageGeo(meta)
+		if !ok {
+			continue
+		}
+		title := meta["place"]
+		if title == "" {
+			title = f.Slug
+		}
+		markers = append(markers, mapMarker{Lat: lat, Lon: lon, Title: title, Link: "/" + domain + "/" + f.ID})
+		geotagged = append(geotagged, f)
+	}
+	return renderMap(markers), geotagged
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// MemberRequest gives a user a role in a domain
+type MemberRequest struct {
+	Name string `json:"name"`
+	Role string `json:"role"`
+}
+
+// handleMembers gives a user a role in a domain, or takes it away with
+// action=remove, from the settings of the domain
+func (tr *TemplateRender) handleMembers(w http.ResponseWriter, r *http.Request) (err error) {
+	tr.DomainKey = strings.TrimSpace(strings.ToLower(r.FormValue("domain_key")))
+	tr.Domain = utils.NormalizeDomain(r.FormValue("domain"))
+	if tr.Domain == "public" || tr.Domain == "" {
+		tr.Domain = "public"
+		return tr.handleMain(w, r, "cannot modify public")
+	}
+
+	// check that the key is valid
+	domainFound, err := fs.CheckKey(tr.DomainKey)
+	if err != nil || tr.Domain != domainFound {
+		return tr.handleMain(w, r, "need to be logged in to change members")
+	}
+	if fs.KeyRole(tr.DomainKey) != db.RoleAdmin {
+		return tr.handleMain(w, r, "need to be an admin to change settings")
+	}
+
+	name := strings.ToLower(strings.TrimSpace(r.FormValue("name")))
+	if r.FormValue("action") == "remove" {
+		err = fs.RemoveMember(tr.Domain, name)
+		if err != nil {
+			return tr.handleMain(w, r, err.Error())
+		}
+		audit(r, "member.removed", tr.Domain, "", name)
+		return tr.handleMain(w, r, "removed "+name)
+	}
+	err = fs.SetMember(tr.Domain, name, r.FormValue("role"))
+	if err != nil {
+		return tr.handleMain(w, r, err.Error())
+	}
+	audit(r, "member.updated", tr.Domain, "", name+" is "+r.FormValue("role"))
+	return tr.handleMain(w, r, name+" is a member")
+}
+
+// handleDomainMembers lists (GET) and sets (POST) the members of a domain at
+// /api/v1/{domain}/members, and takes the role of a member away (DELETE)
+// at /api/v1/{domain}/members/{name}, which logs them out of the domain.
+// Only admins of the domain can use it.
+func (tr *TemplateRender) handleDomainMembers(w http.ResponseWriter, r *http.Request) (err error) {
+	fields := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	domain := utils.NormalizeDomain(fields[2])
+	domainKey := documentKey(r)
+	if !tr.canWrite(domain, domainKey) {
+		return writeJSON(w, http.StatusForbidden, Payload{Message: "need to be logged in"})
+	}
+	if domainKey == "" {
+		domainKey = tr.DomainKeys[domain]
+	}
+	if domain == "public" || fs.KeyRole(domainKey) != db.RoleAdmin {
+		return writeJSON(w, http.StatusForbidden, Payload{Message: "need to be an admin to change members"})
+	}
+
+	if len(fields) == 5 {
+		if r.Method != "DELETE" {
+			return writeJSON(w, http.StatusMethodNotAllowed, Payload{Message: "use DELETE"})
+		}
+		name := strings.ToLower(fields[4])
+		err = fs.RemoveMember(domain, name)
+		if err != nil {
+			return writeJSON(w, http.StatusNotFound, Payload{Message: err.Error()})
+		}
+		audit(r, "member.removed", domain, "", name)
+		return writeJSON(w, http.StatusOK, Payload{Success: true, Message: "removed " + name})
+	}
+
+	switch r.Method {
+	case "GET":
+	case "POST":
+		var req MemberRequest
+		err = json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			return writeJSON(w, http.StatusBadRequest, Payload{Message: err.Error()})
+		}
+		req.Name = strings.ToLower(strings.TrimSpace(req.Name))
+		err = fs.SetMember(domain, req.Name, req.Role)
+		if err != nil {
+			return writeJSON(w, http.StatusBadRequest, Payload{Message: err.Error()})
+		}
+		audit(r, "member.updated", domain, "", req.Name+" is "+req.Role)
+	default:
+		return writeJSON(w, http.StatusMethodNotAllowed, Payload{Message: "use GET or POST"})
+	}
+	members, err := fs.GetMembers(domain)
+	if err != nil {
+		return writeJSON(w, http.StatusInternalServerError, Payload{Message: err.Error()})
+	}
+	return writeJSON(w, http.StatusOK, members)
+}
+
+// author returns the name of the user who is saving with a key, or with
+// the key in the cookie, which is empty for domain passwords
+func (tr *TemplateRender) author(domain, domainKey string) string {
+	if domainKey == "" {
+		domainKey = tr.DomainKeys[domain]
+	}
+	if domainKey == "" {
+		return ""
+	}
+	return fs.KeyUser(domainKey)
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// mirrorInterval is how often the changes of domains are pushed to their
+// mirrors
+var mirrorInterval = 30 * time.Second
+
+// mirrorOverlap is how far back each push starts before the last one, so
+// that saves that were still being written during a push are not missed
+const mirrorOverlap = 5 * time.Second
+
+// mirrorMaxAge is how old a signed push can be before it is refused, so
+// that pushes can not be replayed later
+const mirrorMaxAge = 5 * time.Minute
+
+// maxMirrorSize is the largest page, with its history, that is accepted
+const maxMirrorSize = 50 * 1024 * 1024
+
+// mirrorClient only connects to public addresses, so that mirrors can not
+// reach the services on the network of the server
+var mirrorClient = publicClient(30 * time.Second)
+
+// MirrorRequest is the body of a request to add a mirror to a domain
+type MirrorRequest struct {
+	db.Mirror
+	DomainKey string `json:"domain_key,omitempty"`
+}
+
+// mirrorSignature signs a page that is pushed to a domain of a mirror
+func mirrorSignature(secret, timestamp, domain string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "\n" + domain + "\n"))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// pushMirror pushes the pages of a domain that changed since the last
+// push, with their history, to a mirror
+func pushMirror(m db.Mirror) (pushed int, err error) {
+	start := time.Now()
+	since := m.Pushed
+	if !since.IsZero() {
+		since = since.Add(-mirrorOverlap)
+	}
+	ids, err := fs.ChangedSince(m.Domain, since)
+	if err != nil {
+		return
+	}
+	for _, id := range ids {
+		var p db.Page
+		p, err = fs.GetPageCtx(db.AsMember(context.Background()), id, m.Domain, true)
+		if err != nil {
+			return
+		}
+		p.Domain = m.RemoteDomain
+		err = pushPage(m, p)
+		if err != nil {
+			err = fmt.Errorf("could not push %s: %s", id, err.Error())
+			return
+		}
+		pushed++
+	}
+	err = fs.SetMirrorPushed(m.ID, start, "")
+	return
+}
+
+// pushPage sends a page to a mirror, signed with its secret
+func pushPage(m db.Mirror, p db.Page) (err error) {
+	body, err := json.Marshal(p)
+	if err != nil {
+		return
+	}
+	req, err := http.NewRequest("POST", m.URL+"/api/v1/mirror?domain="+url.QueryEscape(m.RemoteDomain), bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Rwtxt-Timestamp", timestamp)
+	req.Header.Set("X-Rwtxt-Signature", mirrorSignature(m.Secret, timestamp, m.RemoteDomain, body))
+	resp, err := mirrorClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		// what the mirror answered is not kept, since it can be read back
+		err = fmt.Errorf("mirror answered with status %d", resp.StatusCode)
+	}
+	return
+}
+
+// pushMirrors pushes the changes of every domain to its mirrors and
+// records how it went
+func pushMirrors() {
+	mirrors, err := fs.GetMirrors("")
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	for _, m := range mirrors {
+		if m.URL == "" {
+			continue
+		}
+		pushed, errPush := pushMirror(m)
+		if errPush != nil {
+			log.Debugf("could not push %s to %s: %s", m.Domain, m.URL, errPush.Error())
+			if err = fs.SetMirrorPushed(m.ID, time.Time{}, errPush.Error()); err != nil {
+				log.Error(err)
+			}
+		} else if pushed > 0 {
+			log.Infof("pushed %d pages of %s to %s", pushed, m.Domain, m.URL)
+		}
+	}
+}
+
+// handleMirror saves a page that another rwtxt pushed to a domain, when it
+// is signed with the secret of a mirror that the domain accepts
+func handleMirror(w http.ResponseWriter, r *http.Request) (err error) {
+	if r.Method != "POST" {
+		return writeJSON(w, http.StatusMethodNotAllowed, Payload{Message: "must POST"})
+	}
+	domain := utils.NormalizeDomain(r.URL.Query().Get("domain"))
+	timestamp := r.Header.Get("X-Rwtxt-Timestamp")
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || time.Since(time.Unix(seconds, 0)) > mirrorMaxAge || time.Until(time.Unix(seconds, 0)) > mirrorMaxAge {
+		return writeJSON(w, http.StatusForbidden, Payload{Message: "push is too old"})
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxMirrorSize))
+	if err != nil {
+		return writeJSON(w, http.StatusBadRequest, Payload{Message: err.Error()})
+	}
+
+	mirrors, err := fs.GetMirrors(domain)
+	if err != nil {
+		return writeJSON(w, http.StatusInternalServerError, Payload{Message: err.Error()})
+	}
+	signature := []byte(r.Header.Get("X-Rwtxt-Signature"))
+	signed := false
+	for _, m := range mirrors {
+		if m.URL == "" && hmac.Equal(signature, []byte(mirrorSignature(m.Secret, timestamp, domain, body))) {
+			signed = true
+			break
+		}
+	}
+	if !signed {
+		return writeJSON(w, http.StatusForbidden, Payload{Message: "push is not signed by a mirror of " + domain})
+	}
+
+	var p db.Page
+	err = json.Unmarshal(body, &p)
+	if err != nil {
+		return writeJSON(w, http.StatusBadRequest, Payload{Message: err.Error()})
+	}
+	err = fs.PutPage(domain, p)
+	if err != nil {
+		return writeJSON(w, http.StatusBadRequest, Payload{Message: err.Error()})
+	}
+	audit(r, "page.mirrored", domain, p.ID, p.Slug)
+	return writeJSON(w, http.StatusOK, Payload{ID: p.ID, Slug: p.Slug, Success: true})
+}
+
+// handleMirrors lists (GET), adds (POST) and removes (DELETE) the mirrors
+// of a domain. Only admins of the domain can use it, and the secret of a
+// mirror that accepts pushes is only shown when it is added.
+func (tr *TemplateRender) handleMirrors(w http.ResponseWriter, r *http.Request) (err error) {
+	var req MirrorRequest
+	if r.Method == "POST" {
+		err = json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			return writeJSON(w, http.StatusBadRequest, Payload{Message: err.Error()})
+		}
+	} else {
+		req.Domain = r.FormValue("domain")
+		req.DomainKey = r.FormValue("domain_key")
+	}
+	req.Domain = utils.NormalizeDomain(req.Domain)
+	if req.Domain == "" {
+		req.Domain = "public"
+	}
+	if !tr.canWrite(req.Domain, req.DomainKey) {
+		return writeJSON(w, http.StatusForbidden, Payload{Message: "need to be logged in"})
+	}
+	if req.DomainKey == "" {
+		req.DomainKey = tr.DomainKeys[req.Domain]
+	}
+	if req.Domain == "public" || fs.KeyRole(req.DomainKey) != db.RoleAdmin {
+		return writeJSON(w, http.StatusForbidden, Payload{Message: "need to be an admin to change mirrors"})
+	}
+
+	switch r.Method {
+	case "POST":
+		if req.URL != "" {
+			u, errURL := url.Parse(req.URL)
+			if errURL != nil {
+				return writeJSON(w, http.StatusBadRequest, Payload{Message: errURL.Error()})
+			}
+			if errURL = checkPublicURL(u); errURL != nil {
+				return writeJSON(w, http.StatusBadRequest, Payload{Message: errURL.Error()})
+			}
+		}
+		m, errAdd := fs.AddMirror(req.Mirror)
+		if errAdd != nil {
+			return writeJSON(w, http.StatusBadRequest, Payload{Message: errAdd.Error()})
+		}
+		audit(r, "mirror.added", m.Domain, "", m.URL)
+		if m.URL != "" {
+			m.Secret = ""
+		}
+		return writeJSON(w, http.StatusOK, m)
+	case "DELETE":
+		id, errID := strconv.Atoi(r.FormValue("id"))
+		if errID != nil {
+			return writeJSON(w, http.StatusBadRequest, Payload{Message: errID.Error()})
+		}
+		err = fs.DeleteMirror(req.Domain, id)
+		if err != nil {
+			return writeJSON(w, http.StatusNotFound, Payload{Message: err.Error()})
+		}
+		audit(r, "mirror.removed", req.Domain, "", strconv.Itoa(id))
+		return writeJSON(w, http.StatusOK, Payload{Success: true})
+	default:
+		mirrors, errGet := fs.GetMirrors(req.Domain)
+		if errGet != nil {
+			return writeJSON(w, http.StatusInternalServerError, Payload{Message: errGet.Error()})
+		}
+		for i := range mirrors {
+			mirrors[i].Secret = ""
+		}
+		return writeJSON(w, http.StatusOK, mirrors)
+	}
+}
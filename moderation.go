@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+
+	log "github.com/cihub/seelog"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// reporterHash returns who reports a page, as a hash of where they report
+// it from, so that the reports do not keep their address
+func reporterHash(r *http.Request) string {
+	secret, err := fs.GetSecret("reports")
+	if err != nil {
+		log.Error(err)
+	}
+	return utils.Hash(secret, remoteIP(r))[:16]
+}
+
+// handleReportPage files a report that a page should not be on the
+// instance, from the form under the page, and tells the admin about it
+func (tr *TemplateRender) handleReportPage(w http.ResponseWriter, r *http.Request) (err error) {
+	if r.Method != "POST" {
+		http.Error(w, "report a page with a POST", http.StatusMethodNotAllowed)
+		return nil
+	}
+	tr.Domain = utils.NormalizeDomain(r.FormValue("domain"))
+	if tr.Domain == "" {
+		tr.Domain = "public"
+	}
+	id := r.FormValue("id")
+	if !tr.canRead(tr.Domain, "") {
+		return tr.handleMain(w, r, "page does not exist")
+	}
+	if _, errGet := fs.GetCtx(tr.readContext(r, tr.Domain, ""), id, tr.Domain); errGet != nil {
+		return tr.handleMain(w, r, "page does not exist")
+	}
+	report, err := fs.AddContentReport(tr.Domain, id, r.FormValue("reason"), r.FormValue("details"), reporterHash(r))
+	if err != nil {
+		return tr.handleMain(w, r, err.Error())
+	}
+	audit(r, "page.reported", report.Domain, report.Page, strconv.Itoa(report.ID)+" "+report.Reason)
+	sendEvent("page.reported", "/"+report.Domain+"/"+report.Slug+" was reported as "+report.Reason, map[string]string{
+		"id":      strconv.Itoa(report.ID),
+		"domain":  report.Domain,
+		"page":    report.Page,
+		"slug":    report.Slug,
+		"reason":  report.Reason,
+		"details": report.Details,
+	})
+	return tr.handleMain(w, r, "thank you, the page was reported to the admin")
+}
+
+// handleModeration lists the reports that are not resolved (GET), and acts
+// on one with its id and an action of hide, delete or dismiss (POST). The
+// admin token is given as ?token= or as a bearer token.
+func (tr *TemplateRender) handleModeration(w http.ResponseWriter, r *http.Request) (err error) {
+	token, ok := checkAdminToken(w, r)
+	if !ok {
+		return
+	}
+	if r.Method == "GET" {
+		reports, errList := fs.GetContentReports(r.FormValue("status"))
+		if errList != nil {
+			return writeJSON(w, http.StatusInternalServerError, map[string]interface{}{"success": false, "message": errList.Error()})
+		}
+		return writeJSON(w, http.StatusOK, map[string]interface{}{"success": true, "reports": reports})
+	}
+	if r.Method != "POST" {
+		http.Error(w, "list reports with a GET, or act on one with a POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var message string
+	action := r.FormValue("action")
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err == nil {
+		report, errResolve := fs.ResolveContentReport(id, action)
+		err = errResolve
+		if err == nil {
+			message = "the report of /" + report.Domain + "/" + report.Slug + " is " + report.Status
+			audit(r, "report."+report.Status, report.Domain, report.Page, strconv.Itoa(id))
+		}
+	}
+	if err != nil {
+		message = err.Error()
+	}
+
+	// the form of the report goes back to the report
+	if r.FormValue("token") != "" {
+		http.Redirect(w, r, "/admin/report?token="+url.QueryEscape(token)+"&m="+url.QueryEscape(message), 302)
+		return nil
+	}
+	if err != nil {
+		return writeJSON(w, http.StatusBadRequest, map[string]interface{}{"success": false, "message": message})
+	}
+	return writeJSON(w, http.StatusOK, map[string]interface{}{"success": true, "message": message})
+}
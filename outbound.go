@@ -0,0 +1,67 @@
+package main
+
+import (
+	"html"
+	"html/template"
+	"net/http"
+	"net/url"
+	"regexp"
+
+	log "github.com/cihub/seelog"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// trackLinks is set when clicks on links out of public domains are counted
+var trackLinks bool
+
+var externalLink = regexp.MustCompile(`<a href="(https?://[^"]+)" rel="nofollow"`)
+
+// outboundSignature signs a link so that /out can not be used to
+// redirect to anywhere
+func outboundSignature(domain, link string) string {
+	secret, err := fs.GetSecret("outbound")
+	if err != nil {
+		log.Error(err)
+	}
+	return utils.Hash(secret, domain+" "+link)[:16]
+}
+
+// addOutboundTracking sends external links in public domains through /out
+func addOutboundTracking(rendered template.HTML, domain, host string) template.HTML {
+	if !trackLinks {
+		return rendered
+	}
+	_, ispublic, err := fs.GetDomainFromName(domain)
+	if err != nil || !ispublic {
+		return rendered
+	}
+	return template.HTML(externalLink.ReplaceAllStringFunc(string(rendered), func(s string) string {
+		link := html.UnescapeString(externalLink.FindStringSubmatch(s)[1])
+		u, err := url.Parse(link)
+		if err != nil || u.Host == host {
+			return s
+		}
+		out := "/out?" + url.Values{
+			"d": {domain},
+			"u": {link},
+			"s": {outboundSignature(domain, link)},
+		}.Encode()
+		return `<a href="` + template.HTMLEscapeString(out) + `" rel="nofollow"`
+	}))
+}
+
+// handleOutbound counts a click on an external link and redirects to it
+func handleOutbound(w http.ResponseWriter, r *http.Request) (err error) {
+	domain := r.URL.Query().Get("d")
+	link := r.URL.Query().Get("u")
+	if r.URL.Query().Get("s") != outboundSignature(domain, link) {
+		http.Error(w, "bad link", http.StatusBadRequest)
+		return
+	}
+	err = fs.AddClick(domain, link)
+	if err != nil {
+		log.Debug(err)
+	}
+	http.Redirect(w, r, link, http.StatusFound)
+	return nil
+}
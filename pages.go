@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// handlePages returns (GET) or saves (PUT) a page as JSON, described by
+// docs/page.schema.json, with its full history when asked for with
+// ?include=history, or puts it in the trash (DELETE)
+func (tr *TemplateRender) handlePages(w http.ResponseWriter, r *http.Request) (err error) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/v1/pages/")
+	query := r.URL.Query()
+	domain := utils.NormalizeDomain(query.Get("domain"))
+	if domain == "" {
+		domain = "public"
+	}
+	domainKey := query.Get("domain_key")
+
+	switch r.Method {
+	case "GET":
+		if !tr.canRead(domain, domainKey) {
+			return writeJSON(w, http.StatusForbidden, Payload{Message: "need to be logged in"})
+		}
+		p, errGet := fs.GetPageCtx(tr.readContext(r, domain, domainKey), id, domain, query.Get("include") == "history")
+		if errGet != nil {
+			return writeJSON(w, http.StatusNotFound, Payload{Message: errGet.Error()})
+		}
+		return writeJSON(w, http.StatusOK, p)
+	case "PUT":
+		if !tr.canWrite(domain, domainKey) {
+			return writeJSON(w, http.StatusForbidden, Payload{Message: "need to be logged in"})
+		}
+		var p db.Page
+		err = json.NewDecoder(r.Body).Decode(&p)
+		if err != nil {
+			return writeJSON(w, http.StatusBadRequest, Payload{Message: err.Error()})
+		}
+		if p.ID == "" {
+			p.ID = id
+		} else if p.ID != id {
+			return writeJSON(w, http.StatusBadRequest, Payload{Message: "id does not match the url"})
+		}
+		// a page with a history replaces the history of the page, which
+		// only the admins of a domain can do
+		if len(p.History) > 0 {
+			if domainKey == "" {
+				domainKey = tr.DomainKeys[domain]
+			}
+			if domain == "public" || fs.KeyRole(domainKey) != db.RoleAdmin {
+				return writeJSON(w, http.StatusForbidden, Payload{Message: "need to be an admin to replace the history of a page"})
+			}
+		}
+		err = fs.PutPage(domain, p)
+		if err != nil {
+			return writeJSON(w, http.StatusBadRequest, Payload{Message: err.Error()})
+		}
+		audit(r, "page.put", domain, p.ID, p.Slug)
+		return writeJSON(w, http.StatusOK, Payload{ID: p.ID, Slug: p.Slug, Success: true})
+	case "DELETE":
+		if !tr.canWrite(domain, domainKey) {
+			return writeJSON(w, http.StatusForbidden, Payload{Message: "need to be logged in"})
+		}
+		err = fs.Delete(id, domain)
+		if err != nil {
+			return writeJSON(w, http.StatusNotFound, Payload{Message: err.Error()})
+		}
+		audit(r, "page.deleted", domain, id, "")
+		return writeJSON(w, http.StatusOK, Payload{ID: id, Success: true})
+	}
+	return writeJSON(w, http.StatusMethodNotAllowed, Payload{Message: "use GET, PUT or DELETE"})
+}
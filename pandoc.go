@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// pandoc is the path to pandoc, or the url of a pandoc server, that is
+// used to export pages to other formats
+var pandoc string
+
+// pandocFormats are the formats pages can be exported to, with their
+// file extension and content type
+var pandocFormats = map[string][2]string{
+	"docx":  {".docx", "application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+	"odt":   {".odt", "application/vnd.oasis.opendocument.text"},
+	"latex": {".tex", "application/x-latex"},
+}
+
+// findPandoc uses pandoc if it is installed and no other was given
+func findPandoc() {
+	if pandoc != "" {
+		return
+	}
+	if p, err := exec.LookPath("pandoc"); err == nil {
+		pandoc = p
+		log.Infof("exporting with %s", pandoc)
+	}
+}
+
+// pandocTemplate returns the styling for a format from the domain. A page
+// called pandoc-latex has the LaTeX template, and pages called pandoc-docx
+// and pandoc-odt have a link to the upload of the reference document.
+func pandocTemplate(domain, format string) (template []byte, err error) {
+	files, err := fs.GetCtx(db.AsMember(context.Background()), "pandoc-"+format, domain)
+	if err != nil {
+		return nil, nil
+	}
+	_, body := utils.ParseFrontMatter(files[0].Data)
+	if format == "latex" {
+		return []byte(body), nil
+	}
+	match := uploadLink.FindStringSubmatch(body)
+	if match == nil {
+		return nil, nil
+	}
+	_, template, err = readBlob(match[1])
+	return
+}
+
+// convertWithPandoc converts markdown to another format
+func convertWithPandoc(domain, markdown, format string) (out []byte, err error) {
+	template, err := pandocTemplate(domain, format)
+	if err != nil {
+		return
+	}
+	if strings.HasPrefix(pandoc, "http://") || strings.HasPrefix(pandoc, "https://") {
+		return convertWithPandocServer(markdown, format, template)
+	}
+
+	dir, err := ioutil.TempDir("", "rwtxt-pandoc")
+	if err != nil {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	// pandoc reads the uploads from the directory
+	for _, match := range uploadLink.FindAllStringSubmatch(markdown, -1) {
+		name, errBlob := exportBlob(match[1], dir)
+		if errBlob != nil {
+			log.Warnf("could not export %s: %s", match[1], errBlob.Error())
+			continue
+		}
+		markdown = strings.Replace(markdown, match[0], match[1]+"/"+name, -1)
+	}
+
+	args := []string{"--from", "markdown", "--to", format, "--standalone", "--output", "out"}
+	if template != nil {
+		option, name := "--reference-doc", "reference"+pandocFormats[format][0]
+		if format == "latex" {
+			option, name = "--template", "template.tex"
+		}
+		err = ioutil.WriteFile(filepath.Join(dir, name), template, 0644)
+		if err != nil {
+			return
+		}
+		args = append(args, option, name)
+	}
+	cmd := exec.Command(pandoc, args...)
+	cmd.Dir = dir
+	cmd.Stdin = strings.NewReader(markdown)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if err != nil {
+		return nil, fmt.Errorf("pandoc: %s %s", err.Error(), stderr.String())
+	}
+	return ioutil.ReadFile(filepath.Join(dir, "out"))
+}
+
+// convertWithPandocServer converts markdown with pandoc running as a
+// server. The server can not read uploads or reference documents, so only
+// LaTeX templates are used.
+func convertWithPandocServer(markdown, format string, template []byte) (out []byte, err error) {
+	options := map[string]interface{}{
+		"text":       markdown,
+		"from":       "markdown",
+		"to":         format,
+		"standalone": true,
+	}
+	if format == "latex" && template != nil {
+		options["template"] = string(template)
+	}
+	body, err := json.Marshal(options)
+	if err != nil {
+		return
+	}
+	req, err := http.NewRequest("POST", pandoc, bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/octet-stream")
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	out, err = ioutil.ReadAll(resp.Body)
+	if err == nil && resp.StatusCode != http.StatusOK {
+		err = errors.New("pandoc: " + strings.TrimSpace(string(out)))
+	}
+	return
+}
+
+// handlePandoc downloads a page converted to docx, odt or LaTeX
+func (tr *TemplateRender) handlePandoc(w http.ResponseWriter, r *http.Request) (err error) {
+	domain := utils.NormalizeDomain(r.FormValue("domain"))
+	if domain == "" {
+		domain = "public"
+	}
+	format := r.FormValue("format")
+	if pandoc == "" {
+		return tr.handleMain(w, r, "exporting needs pandoc")
+	}
+	if _, ok := pandocFormats[format]; !ok {
+		return tr.handleMain(w, r, "can only export to docx, odt or latex")
+	}
+	if !tr.canRead(domain, r.FormValue("domain_key")) {
+		return tr.handleMain(w, r, "need to be logged in to export")
+	}
+	files, err := fs.GetCtx(tr.readContext(r, domain, r.FormValue("domain_key")), r.FormValue("page"), domain)
+	if err != nil {
+		return tr.handleMain(w, r, err.Error())
+	}
+	f := files[0]
+
+	meta, body := utils.ParseFrontMatter(f.Data)
+	if meta["title"] == "" {
+		meta["title"] = pageTitle(f, body)
+	}
+	out, err := convertWithPandoc(domain, frontMatter(meta)+body, format)
+	if err != nil {
+		log.Error(err)
+		return tr.handleMain(w, r, "could not export: "+err.Error())
+	}
+	w.Header().Set("Content-Type", pandocFormats[format][1])
+	w.Header().Set("Content-Disposition", `attachment; filename="`+f.Slug+pandocFormats[format][0]+`"`)
+	_, err = w.Write(out)
+	return
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"html"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// showPreviews is set when pages should show preview cards for links,
+// which means the server fetches the links that are in pages
+var showPreviews bool
+
+const previewMaxAge = 7 * 24 * time.Hour
+
+// previewClient fetches the links of pages, and like clipClient only
+// connects to public addresses
+var previewClient = publicClient(10 * time.Second)
+
+// bareLink matches a rendered paragraph that only has a link to itself
+var bareLink = regexp.MustCompile(`<p><a href="(https?://[^"]+)" rel="nofollow">([^<]+)</a></p>`)
+
+var previewsFetching = struct {
+	sync.Mutex
+	urls map[string]bool
+}{urls: make(map[string]bool)}
+
+// addPreviews replaces links that are on their own line with preview
+// cards. Links without a cached preview are fetched in the background
+// and get a card the next time the page is rendered.
+func addPreviews(rendered template.HTML) template.HTML {
+	if !showPreviews {
+		return rendered
+	}
+	return template.HTML(bareLink.ReplaceAllStringFunc(string(rendered), func(s string) string {
+		match := bareLink.FindStringSubmatch(s)
+		if match[1] != match[2] {
+			return s
+		}
+		// the link is already escaped for html
+		link := match[1]
+		u, err := url.Parse(html.UnescapeString(link))
+		if err != nil {
+			return s
+		}
+		p, err := fs.GetPreview(u.String())
+		if err != nil || time.Since(p.Fetched) > previewMaxAge {
+			go fetchPreview(u.String())
+		}
+		if err != nil || p.Title == "" {
+			return s
+		}
+		card := `<div class="preview-card"><a href="` + link + `" rel="nofollow">`
+		if p.Image != "" {
+			card += `<img src="` + template.HTMLEscapeString(p.Image) + `" alt="">`
+		}
+		card += `<strong>` + template.HTMLEscapeString(p.Title) + `</strong>`
+		if p.Description != "" {
+			card += `<span>` + template.HTMLEscapeString(p.Description) + `</span>`
+		}
+		card += `<small>` + template.HTMLEscapeString(u.Host) + `</small></a></div>`
+		return card
+	}))
+}
+
+// fetchPreview caches the preview of a link
+func fetchPreview(link string) {
+	previewsFetching.Lock()
+	if previewsFetching.urls[link] {
+		previewsFetching.Unlock()
+		return
+	}
+	previewsFetching.urls[link] = true
+	previewsFetching.Unlock()
+	defer func() {
+		previewsFetching.Lock()
+		delete(previewsFetching.urls, link)
+		previewsFetching.Unlock()
+	}()
+
+	p := db.Preview{URL: link}
+	u, err := url.Parse(link)
+	if err == nil {
+		err = checkPublicURL(u)
+	}
+	var resp *http.Response
+	if err == nil {
+		resp, err = previewClient.Get(u.String())
+	}
+	if err == nil {
+		defer resp.Body.Close()
+		p.Title, p.Description, p.Image, err = utils.ExtractPreview(io.LimitReader(resp.Body, 1024*1024))
+		if image, errParse := resp.Request.URL.Parse(p.Image); errParse == nil && p.Image != "" {
+			p.Image = image.String()
+		}
+	}
+	if err != nil {
+		log.Debugf("could not preview %s: %s", link, err.Error())
+	}
+	// cache failures too so that they are not fetched on every view
+	err = fs.SavePreview(p)
+	if err != nil {
+		log.Error(err)
+	}
+}
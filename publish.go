@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/gorilla/websocket"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// publish pushes a local markdown file to a page on a running rwtxt
+// server, and keeps republishing it when it changes if watch is set.
+func publish(args []string) (err error) {
+	flags := flag.NewFlagSet("publish", flag.ExitOnError)
+	server := flags.String("server", "http://localhost:8152", "address of the rwtxt server")
+	domain := flags.String("domain", "public", "domain to publish to")
+	password := flags.String("password", "", "password of the domain")
+	slug := flags.String("slug", "", "slug of the page (default is the file name)")
+	watch := flags.Bool("watch", false, "republish whenever the file changes")
+
+	// allow the file name before or after the flags
+	fileName := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		fileName = args[0]
+		args = args[1:]
+	}
+	flags.Parse(args)
+	if fileName == "" {
+		fileName = flags.Arg(0)
+	}
+	if fileName == "" {
+		return errors.New("usage: rwtxt publish file.md --domain x --slug y [--watch]")
+	}
+	if *slug == "" {
+		*slug = strings.TrimSuffix(filepath.Base(fileName), filepath.Ext(fileName))
+	}
+	*domain = utils.NormalizeDomain(*domain)
+	*slug = utils.NormalizeSlug(*slug)
+	*server = strings.TrimSuffix(*server, "/")
+
+	domainKey := ""
+	if *domain != "public" {
+		domainKey, err = loginToServer(*server, *domain, *password)
+		if err != nil {
+			return
+		}
+	}
+
+	wsURL := "ws" + strings.TrimPrefix(*server, "http") + "/ws"
+	c, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		return
+	}
+	defer c.Close()
+
+	id := ""
+	var lastModified time.Time
+	for {
+		info, errStat := os.Stat(fileName)
+		if errStat != nil {
+			return errStat
+		}
+		if info.ModTime() != lastModified {
+			lastModified = info.ModTime()
+			data, errRead := ioutil.ReadFile(fileName)
+			if errRead != nil {
+				return errRead
+			}
+			err = c.WriteJSON(Payload{
+				ID:        id,
+				DomainKey: domainKey,
+				Domain:    *domain,
+				Data:      string(data),
+				Slug:      *slug,
+			})
+			if err != nil {
+				return
+			}
+			var p Payload
+			err = c.ReadJSON(&p)
+			if err != nil {
+				return
+			}
+			if p.ID == "" {
+				return errors.New("could not publish: " + p.Message)
+			}
+			id = p.ID
+			log.Infof("published %s to %s/%s/%s", fileName, *server, *domain, *slug)
+		}
+		if !*watch {
+			return
+		}
+		time.Sleep(1 * time.Second)
+	}
+}
+
+// loginToServer logs in to a domain and returns the domain key
+func loginToServer(server, domain, password string) (domainKey string, err error) {
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.PostForm(server+"/login", url.Values{
+		"domain":   {domain},
+		"password": {password},
+	})
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == "rwtxt-domains" {
+			domainKey = strings.Split(cookie.Value, ",")[0]
+		}
+	}
+	if domainKey == "" {
+		err = fmt.Errorf("could not log in to domain '%s'", domain)
+	}
+	return
+}
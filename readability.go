@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// handleReadability returns how easy the text of a page is to read, as it
+// was when the page was last saved, for ?domain= and ?id=, which is the
+// id or slug of the page
+func (tr *TemplateRender) handleReadability(w http.ResponseWriter, r *http.Request) (err error) {
+	domain := utils.NormalizeDomain(r.FormValue("domain"))
+	if domain == "" {
+		domain = "public"
+	}
+	domainKey := r.FormValue("domain_key")
+	if domainKey == "" {
+		domainKey = documentKey(r)
+	}
+	if !tr.canRead(domain, domainKey) {
+		return writeJSON(w, http.StatusForbidden, Payload{Message: "need to be logged in"})
+	}
+
+	files, err := fs.GetCtx(tr.readContext(r, domain, domainKey), r.FormValue("id"), domain)
+	if err != nil || len(files) == 0 {
+		return writeJSON(w, http.StatusNotFound, Payload{Message: "page does not exist"})
+	}
+	if len(files) > 1 {
+		return writeJSON(w, http.StatusConflict, Payload{Message: "more than one page has that slug"})
+	}
+	readability, err := fs.GetReadability(files[0].ID)
+	if err != nil {
+		return writeJSON(w, http.StatusNotFound, Payload{Message: "page was not measured yet"})
+	}
+	return writeJSON(w, http.StatusOK, readability)
+}
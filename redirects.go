@@ -0,0 +1,204 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	log "github.com/cihub/seelog"
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// redirectMissing sends people from an old URL, which is not a page or a
+// domain, to where it was imported to go or to the new slug of a page that
+// was renamed, and counts the missing pages that do not have a redirect.
+// People who are signed in are sent too, so that they do not make an empty
+// page at the old slug of a page that was renamed.
+func (tr *TemplateRender) redirectMissing(w http.ResponseWriter, r *http.Request, page bool) bool {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return false
+	}
+	if tr.redirectRenamed(w, r) {
+		return true
+	}
+	if !page {
+		if _, _, err := fs.GetDomainFromName(tr.Domain); err == nil {
+			return false
+		}
+	}
+	location, err := fs.FindRedirect(r.URL.Path)
+	if err != nil {
+		log.Debug(err)
+		return false
+	}
+	if location != "" {
+		http.Redirect(w, r, location, http.StatusMovedPermanently)
+		return true
+	}
+	if page && !tr.SignedIn {
+		// only the paths of pages are counted, so that the names of the
+		// domains that people make are not
+		err = fs.RecordNotFound(r.URL.Path, r.Referer())
+		if err != nil {
+			log.Debug(err)
+		}
+	}
+	return false
+}
+
+// redirectRenamed sends the links to a domain that was renamed, which no
+// other domain has the name of, to its new name
+func (tr *TemplateRender) redirectRenamed(w http.ResponseWriter, r *http.Request) bool {
+	if _, _, err := fs.GetDomainFromName(tr.Domain); err == nil {
+		return false
+	}
+	renamed, err := fs.RenamedDomain(tr.Domain)
+	if err != nil || renamed == "" {
+		return false
+	}
+	location := "/" + renamed
+	if parts := strings.SplitN(r.URL.Path, "/", 3); len(parts) == 3 {
+		location += "/" + parts[2]
+	}
+	if r.URL.RawQuery != "" {
+		location += "?" + r.URL.RawQuery
+	}
+	http.Redirect(w, r, location, http.StatusMovedPermanently)
+	return true
+}
+
+// handleNotFound tells people that a page is not in a domain, with the
+// pages whose slugs are like the one they asked for
+func (tr *TemplateRender) handleNotFound(w http.ResponseWriter, r *http.Request) (err error) {
+	tr.Files, err = fs.SuggestSlugs(tr.Domain, tr.Page, 5)
+	if err != nil {
+		log.Debug(err)
+	}
+	tr.Files = fs.FilterListed(tr.readContext(r, tr.Domain, ""), tr.Files)
+	tr.DomainLanguage, _ = fs.GetDomainLanguage(tr.Domain)
+	tr.Language = tr.DomainLanguage
+	tr.Title = "Not found"
+	tr.Missing = tr.Page
+	tr.NumResults = len(tr.Files)
+
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(http.StatusNotFound)
+	gz := gzip.NewWriter(w)
+	defer gz.Close()
+	return listTemplate.Execute(gz, tr)
+}
+
+// handleImportRedirects adds the redirects of a CSV file of old URLs and
+// slugs to a domain, or removes one with action=remove and its old URL
+func (tr *TemplateRender) handleImportRedirects(w http.ResponseWriter, r *http.Request) (err error) {
+	tr.DomainKey = strings.TrimSpace(strings.ToLower(r.FormValue("domain_key")))
+	tr.Domain = utils.NormalizeDomain(r.FormValue("domain"))
+	if tr.Domain == "public" || tr.Domain == "" {
+		tr.Domain = "public"
+		return tr.handleMain(w, r, "cannot modify public")
+	}
+
+	// check that the key is valid
+	domainFound, err := fs.CheckKey(tr.DomainKey)
+	if err != nil || tr.Domain != domainFound {
+		return tr.handleMain(w, r, "need to be logged in to import redirects")
+	}
+	if fs.KeyRole(tr.DomainKey) != db.RoleAdmin {
+		return tr.handleMain(w, r, "need to be an admin to change settings")
+	}
+
+	if r.FormValue("action") == "remove" {
+		err = fs.DeleteRedirect(tr.Domain, r.FormValue("pattern"))
+		if err != nil {
+			return tr.handleMain(w, r, err.Error())
+		}
+		audit(r, "domain.updated", tr.Domain, "", "removed the redirect of "+r.FormValue("pattern"))
+		return tr.handleMain(w, r, "removed the redirect")
+	}
+	if r.FormValue("replace") == "on" {
+		err = fs.DeleteRedirects(tr.Domain)
+		if err != nil {
+			log.Debug(err)
+			return tr.handleMain(w, r, err.Error())
+		}
+	}
+	imported := 0
+	file, _, errFile := r.FormFile("redirects")
+	if errFile == nil {
+		defer file.Close()
+		imported, err = fs.ImportRedirects(tr.Domain, file)
+		if err != nil {
+			log.Debug(err)
+			return tr.handleMain(w, r, err.Error())
+		}
+	}
+	message := "imported " + strconv.Itoa(imported) + " redirects"
+	audit(r, "domain.updated", tr.Domain, "", message)
+	return tr.handleMain(w, r, message)
+}
+
+// importRedirects adds the redirects of a CSV file of old URLs and slugs to
+// a domain, or lists the paths that were not found as the lines of one
+func importRedirects(args []string) (err error) {
+	flags := flag.NewFlagSet("redirects", flag.ExitOnError)
+	domain := flags.String("domain", "", "domain to import the redirects to")
+	replace := flags.Bool("replace", false, "remove the redirects of the domain first")
+	notFound := flags.Bool("not-found", false, "list the paths that were not found")
+	flags.Parse(args)
+	if !*notFound && (*domain == "" || (flags.NArg() != 1 && !*replace)) {
+		return errors.New("usage: rwtxt redirects --domain x redirects.csv (or rwtxt redirects --not-found)")
+	}
+
+	fs, err = db.New(dbName, dbOptions)
+	if err != nil {
+		return
+	}
+	defer fs.Close()
+
+	if *notFound {
+		var paths []db.NotFound
+		paths, err = fs.GetNotFound(1000)
+		if err != nil {
+			return
+		}
+		out := csv.NewWriter(os.Stdout)
+		out.Write([]string{"old_url", "slug", "hits"})
+		for _, p := range paths {
+			out.Write([]string{p.Path, "", strconv.Itoa(p.Hits)})
+		}
+		out.Flush()
+		return out.Error()
+	}
+
+	*domain = utils.NormalizeDomain(*domain)
+	if *replace {
+		err = fs.DeleteRedirects(*domain)
+		if err != nil {
+			return
+		}
+	}
+	imported := 0
+	if flags.NArg() == 1 {
+		f, errOpen := os.Open(flags.Arg(0))
+		if errOpen != nil {
+			return errOpen
+		}
+		defer f.Close()
+		imported, err = fs.ImportRedirects(*domain, f)
+		if err != nil {
+			return
+		}
+	}
+	summary := fmt.Sprintf("imported %d redirects", imported)
+	audit(nil, "domain.updated", *domain, "", summary)
+	fmt.Println(summary)
+	return
+}
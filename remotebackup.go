@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// remoteBackupReport is what a push to a backup did
+type remoteBackupReport struct {
+	Pushed    int
+	Deleted   int
+	Unchanged int
+}
+
+// backupTo pushes the pages of a domain that changed since the last push to
+// a domain of another rwtxt, through its api, so that it has an off-site
+// copy. Pages are pushed when their text is not the version that was
+// pushed last, and pages that were put in the trash are put in the trash
+// there too.
+func backupTo(args []string) (err error) {
+	flags := flag.NewFlagSet("backup", flag.ExitOnError)
+	to := flags.String("to", "", "domain of the other rwtxt to back up to, like https://notes.example.com/backup")
+	token := flags.String("token", "", "key of the domain of the other rwtxt")
+	domain := flags.String("domain", "", "domain to back up (default the domain in --to)")
+	full := flags.Bool("full", false, "push every page again, and not only the ones that changed")
+	flags.Parse(args)
+	if *to == "" || *token == "" {
+		return errors.New("usage: rwtxt backup --to https://notes.example.com/domain --token KEY [--domain x]")
+	}
+	base, remoteDomain, err := splitBackupURL(*to)
+	if err != nil {
+		return
+	}
+	if *domain == "" {
+		*domain = remoteDomain
+	}
+	*domain = utils.NormalizeDomain(*domain)
+
+	fs, err = db.New(dbName, dbOptions)
+	if err != nil {
+		return
+	}
+	defer fs.Close()
+
+	target := base + "/" + remoteDomain
+	report, err := pushRemoteBackup(*domain, base, remoteDomain, *token, *full)
+	fmt.Printf("pushed %d pages of %s to %s, put %d in the trash and %d had not changed\n",
+		report.Pushed, *domain, target, report.Deleted, report.Unchanged)
+	return
+}
+
+// splitBackupURL splits the url of a domain of another rwtxt into the url of
+// that rwtxt and the domain
+func splitBackupURL(to string) (base, domain string, err error) {
+	u, err := url.Parse(strings.TrimRight(strings.TrimSpace(to), "/"))
+	if err != nil {
+		return
+	}
+	i := strings.LastIndex(u.Path, "/")
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || i < 0 || u.Path[i+1:] == "" {
+		err = errors.New("--to needs to be the url of a domain, like https://notes.example.com/domain")
+		return
+	}
+	domain = utils.NormalizeDomain(u.Path[i+1:])
+	u.Path = u.Path[:i]
+	u.RawQuery, u.Fragment = "", ""
+	base = u.String()
+	return
+}
+
+// pushRemoteBackup pushes the pages of a domain that changed since the last
+// push to a domain of another rwtxt. What was pushed is recorded page by
+// page, so that a push that fails carries on where it stopped.
+func pushRemoteBackup(domain, base, remoteDomain, token string, full bool) (report remoteBackupReport, err error) {
+	target := base + "/" + remoteDomain
+	backup, err := fs.GetRemoteBackup(domain, target)
+	if err != nil {
+		return
+	}
+	start := time.Now()
+	since := backup.Pushed
+	if full {
+		since = time.Time{}
+	} else if !since.IsZero() {
+		since = since.Add(-mirrorOverlap)
+	}
+	ids, err := fs.ChangedSince(domain, since)
+	if err != nil {
+		return
+	}
+
+	documents := base + "/api/v1/" + url.PathEscape(remoteDomain) + "/documents"
+	for _, id := range ids {
+		var p db.Page
+		p, err = fs.GetPageCtx(db.AsMember(context.Background()), id, domain, false)
+		if err != nil {
+			return
+		}
+		version := db.VersionHash(p.Data)
+		pushed, wasPushed := backup.Versions[id]
+		if !full && pushed == version {
+			report.Unchanged++
+			continue
+		}
+		if p.Data == "" {
+			// pages that are empty or in the trash are only put in the
+			// trash there when they were pushed before
+			if !wasPushed || pushed == version {
+				continue
+			}
+			err = backupRequest("DELETE", documents+"/"+url.PathEscape(id), token, nil, http.StatusOK, http.StatusNotFound)
+			if err != nil {
+				err = fmt.Errorf("could not put %s in the trash: %s", id, err.Error())
+				return
+			}
+			report.Deleted++
+		} else {
+			body := db.Page{ID: id, Slug: p.Slug, Data: p.Data}
+			err = backupRequest("PUT", documents+"/"+url.PathEscape(id), token, body, http.StatusOK)
+			if err == errBackupNotFound {
+				err = backupRequest("POST", documents, token, body, http.StatusCreated)
+			}
+			if err != nil {
+				err = fmt.Errorf("could not push %s: %s", id, err.Error())
+				return
+			}
+			report.Pushed++
+		}
+		err = fs.SetRemoteBackupPage(domain, target, id, version)
+		if err != nil {
+			return
+		}
+	}
+	err = fs.SetRemoteBackupPushed(domain, target, start)
+	return
+}
+
+// errBackupNotFound is the answer of the other rwtxt for a page that it
+// does not have
+var errBackupNotFound = errors.New("not found")
+
+// backupRequest sends a request to the api of another rwtxt with the key of
+// its domain, and fails unless it answers with one of the statuses
+func backupRequest(method, u, token string, body interface{}, statuses ...int) (err error) {
+	var reader io.Reader
+	if body != nil {
+		b, errJSON := json.Marshal(body)
+		if errJSON != nil {
+			return errJSON
+		}
+		reader = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, u, reader)
+	if err != nil {
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := mirrorClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	for _, status := range statuses {
+		if resp.StatusCode == status {
+			return nil
+		}
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return errBackupNotFound
+	}
+	var payload Payload
+	json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&payload)
+	return fmt.Errorf("got %s %s", resp.Status, payload.Message)
+}
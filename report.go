@@ -0,0 +1,146 @@
+package main
+
+import (
+	"compress/gzip"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+)
+
+// adminToken is the token for the report of what can be cleaned up, at
+// /admin/report
+var adminToken string
+
+// idleDays is how many days a domain has to go without a page being
+// written for the report to show it, unless ?days= says otherwise
+const idleDays = 90
+
+// cleanups are the cleanups that were asked for from the report. They
+// run one at a time in the background, since they read every page.
+var cleanups struct {
+	sync.Mutex
+	running string
+	last    string
+}
+
+// cleanupActions are the cleanups that the report can start, with what
+// each does
+var cleanupActions = map[string]func() (int, error){
+	"empty": func() (int, error) {
+		return fs.RemoveEmptyPages()
+	},
+	"blobs": func() (int, error) {
+		return fs.RemoveUnusedBlobs()
+	},
+	"trash": func() (int, error) {
+		return fs.PurgeTrash("", time.Now())
+	},
+}
+
+// startCleanup runs a cleanup in the background, unless one is running
+// already
+func startCleanup(action string) (err error) {
+	run, ok := cleanupActions[action]
+	if !ok {
+		return fmt.Errorf("no cleanup called %s", action)
+	}
+	cleanups.Lock()
+	defer cleanups.Unlock()
+	if cleanups.running != "" {
+		return fmt.Errorf("the %s cleanup is still running", cleanups.running)
+	}
+	cleanups.running = action
+	go func() {
+		removed, errRun := run()
+		result := fmt.Sprintf("the %s cleanup removed %d at %s", action, removed, time.Now().Format("Mon Jan 2 3:04pm 2006"))
+		if errRun != nil {
+			log.Error(errRun)
+			result = fmt.Sprintf("the %s cleanup failed: %s", action, errRun.Error())
+		}
+		log.Info(result)
+		cleanups.Lock()
+		cleanups.running = ""
+		cleanups.last = result
+		cleanups.Unlock()
+	}()
+	return
+}
+
+// checkAdminToken checks the admin token, given as ?token= or as a bearer
+// token, and writes the error when it is not right
+func checkAdminToken(w http.ResponseWriter, r *http.Request) (token string, ok bool) {
+	if adminToken == "" {
+		http.Error(w, "the report is not enabled", http.StatusNotFound)
+		return
+	}
+	token = r.FormValue("token")
+	if token == "" {
+		token = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+		http.Error(w, "need the admin token", http.StatusUnauthorized)
+		return
+	}
+	return token, true
+}
+
+// handleReport shows the report of what can be cleaned up across every
+// domain (GET), and starts one of its cleanups (POST). The admin token is
+// given as ?token= or as a bearer token.
+func (tr *TemplateRender) handleReport(w http.ResponseWriter, r *http.Request) (err error) {
+	token, ok := checkAdminToken(w, r)
+	if !ok {
+		return
+	}
+
+	if r.Method == "POST" {
+		action := r.FormValue("action")
+		message := "started the " + action + " cleanup"
+		err = startCleanup(action)
+		if err != nil {
+			message = err.Error()
+		} else {
+			audit(r, "report.cleanup", "", "", action)
+		}
+		http.Redirect(w, r, "/admin/report?token="+url.QueryEscape(token)+"&m="+url.QueryEscape(message), 302)
+		return nil
+	}
+
+	days, errDays := strconv.Atoi(r.FormValue("days"))
+	if errDays != nil || days <= 0 {
+		days = idleDays
+	}
+	report, err := fs.GetReport(time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil
+	}
+	tr.Title = "Report"
+	tr.Report = &report
+	tr.ReportDays = days
+	tr.AdminToken = token
+	tr.Message = r.FormValue("m")
+	tr.AdminAnnouncements, _ = fs.GetAnnouncements()
+	tr.ContentReports, _ = fs.GetContentReports("")
+	tr.AdminDomains, _ = fs.ListDomains()
+	cleanups.Lock()
+	tr.Cleanup = cleanups.last
+	if cleanups.running != "" {
+		tr.Cleanup = "the " + cleanups.running + " cleanup is running"
+	}
+	cleanups.Unlock()
+
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Set("Content-Type", "text/html")
+	w.Header().Set("Cache-Control", "no-store")
+	gz := gzip.NewWriter(w)
+	defer gz.Close()
+	return reportTemplate.Execute(gz, tr)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/schollz/rwtxt/src/db"
+)
+
+// renameReserved renames the pages whose slugs are reserved for the routes
+// of rwtxt, which can not be seen at their slugs
+func renameReserved(args []string) (err error) {
+	flags := flag.NewFlagSet("rename-reserved", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "only list the pages that would be renamed")
+	flags.Parse(args)
+
+	fs, err = db.New(dbName, dbOptions)
+	if err != nil {
+		return
+	}
+	defer fs.Close()
+
+	renamed, err := fs.RenameReservedSlugs(*dryRun)
+	if err != nil {
+		return
+	}
+	for _, r := range renamed {
+		fmt.Printf("/%s/%s (%s) to /%s/%s\n", r.Domain, r.From, r.ID, r.Domain, r.To)
+		if !*dryRun {
+			audit(nil, "page.renamed", r.Domain, r.ID, r.From+" to "+r.To)
+		}
+	}
+	if *dryRun {
+		fmt.Printf("%d pages would be renamed\n", len(renamed))
+	} else {
+		fmt.Printf("renamed %d pages\n", len(renamed))
+	}
+	return
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// revertWindow returns the time of the edits to revert, which is either
+// the last "since" or between "from" and "to", or else the last day
+func revertWindow(since, fromTime, toTime string) (from, to time.Time, err error) {
+	to = time.Now()
+	from = to.Add(-24 * time.Hour)
+	if since != "" {
+		var duration time.Duration
+		duration, err = time.ParseDuration(since)
+		if err != nil {
+			return
+		}
+		from = to.Add(-duration)
+		return
+	}
+	if fromTime != "" {
+		from, err = time.Parse(time.RFC3339, fromTime)
+		if err != nil {
+			return
+		}
+	}
+	if toTime != "" {
+		to, err = time.Parse(time.RFC3339, toTime)
+	}
+	return
+}
+
+// formatRevertPlan describes what a revert would change, with a sample of
+// the changes and the token that confirms it
+func formatRevertPlan(plan db.RevertPlan, samples int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Dry run of reverting the edits from %s in %s\n", plan.Source, plan.Domain)
+	fmt.Fprintf(&b, "between %s and %s.\n\n", plan.From.Format(time.RFC3339), plan.To.Format(time.RFC3339))
+	fmt.Fprintf(&b, "%d pages would change, and %d edited by others would be skipped.\n", len(plan.Pages), plan.Skipped)
+	if freed := plan.BytesFreed(); freed >= 0 {
+		fmt.Fprintf(&b, "%d bytes would be freed.\n", freed)
+	} else {
+		fmt.Fprintf(&b, "%d bytes would be added back.\n", -freed)
+	}
+	for i, p := range plan.Pages {
+		if i == samples {
+			fmt.Fprintf(&b, "\nand %d more pages.\n", len(plan.Pages)-samples)
+			break
+		}
+		fmt.Fprintf(&b, "\n/%s/%s (%s): %d to %d bytes\n", plan.Domain, p.Slug, p.ID, p.Before, p.After)
+		for _, line := range strings.Split(p.Diff, "\n") {
+			fmt.Fprintf(&b, "    %s\n", line)
+		}
+	}
+	if len(plan.Pages) > 0 {
+		fmt.Fprintf(&b, "\nTo revert, confirm with the token %s\n", plan.Token)
+	}
+	return b.String()
+}
+
+// revert shows what reverting the edits from a source would change, and
+// reverts them when confirmed with the token of the dry run
+func revert(args []string) (err error) {
+	flags := flag.NewFlagSet("revert", flag.ExitOnError)
+	domain := flags.String("domain", "", "domain to revert")
+	source := flags.String("source", "", "address the edits came from")
+	since := flags.String("since", "", "revert the edits of the last duration, like 24h")
+	from := flags.String("from", "", "revert the edits after this time (RFC3339)")
+	to := flags.String("to", "", "revert the edits before this time (RFC3339)")
+	confirm := flags.String("confirm", "", "token from the dry run that confirms the revert")
+	flags.Parse(args)
+	if *domain == "" || *source == "" {
+		return errors.New("usage: rwtxt revert --domain x --source ip [--since 24h | --from time --to time] [--confirm token]")
+	}
+	fromTime, toTime, err := revertWindow(*since, *from, *to)
+	if err != nil {
+		return
+	}
+
+	fs, err = db.New(dbName, dbOptions)
+	if err != nil {
+		return
+	}
+	defer fs.Close()
+
+	if *confirm == "" {
+		plan, errPlan := fs.PlanRevert(*domain, *source, fromTime, toTime)
+		if errPlan != nil {
+			return errPlan
+		}
+		fmt.Print(formatRevertPlan(plan, 10))
+		if len(plan.Pages) > 0 {
+			fmt.Printf("rwtxt revert --domain %s --source %s --from %s --to %s --confirm %s\n",
+				*domain, *source, fromTime.Format(time.RFC3339), toTime.Format(time.RFC3339), plan.Token)
+		}
+		return
+	}
+	reverted, skipped, err := fs.RevertEdits(*domain, *source, fromTime, toTime, *confirm)
+	if err != nil {
+		return
+	}
+	audit(nil, "domain.reverted", *domain, "", fmt.Sprintf("reverted %d pages edited from %s", reverted, *source))
+	fmt.Printf("reverted %d pages, skipped %d edited by others\n", reverted, skipped)
+	return
+}
+
+// handleRevert shows a dry run of reverting the edits from a source, as
+// text, and reverts them when it is posted again with the token it gave
+func (tr *TemplateRender) handleRevert(w http.ResponseWriter, r *http.Request) (err error) {
+	tr.DomainKey = strings.TrimSpace(strings.ToLower(r.FormValue("domain_key")))
+	tr.Domain = utils.NormalizeDomain(r.FormValue("domain"))
+	source := strings.TrimSpace(r.FormValue("source"))
+	if source == "" {
+		return tr.handleMain(w, r, "need a source to revert")
+	}
+
+	// check that the key is valid
+	domainFound, err := fs.CheckKey(tr.DomainKey)
+	if err != nil || tr.Domain != domainFound {
+		return tr.handleMain(w, r, "need to be logged in to revert")
+	}
+	if fs.KeyRole(tr.DomainKey) != db.RoleAdmin {
+		return tr.handleMain(w, r, "need to be an admin to revert")
+	}
+
+	from, to, err := revertWindow(r.FormValue("since"), r.FormValue("from"), r.FormValue("to"))
+	if err != nil {
+		return tr.handleMain(w, r, err.Error())
+	}
+
+	confirm := strings.TrimSpace(r.FormValue("confirm"))
+	if confirm == "" {
+		plan, errPlan := fs.PlanRevert(tr.Domain, source, from, to)
+		if errPlan != nil {
+			return tr.handleMain(w, r, errPlan.Error())
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, err = w.Write([]byte(formatRevertPlan(plan, 10)))
+		return
+	}
+
+	reverted, skipped, err := fs.RevertEdits(tr.Domain, source, from, to, confirm)
+	if err != nil {
+		return tr.handleMain(w, r, err.Error())
+	}
+	audit(r, "domain.reverted", tr.Domain, "", fmt.Sprintf("reverted %d pages edited from %s", reverted, source))
+	return tr.handleMain(w, r, fmt.Sprintf("reverted %d pages, skipped %d edited by others", reverted, skipped))
+}
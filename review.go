@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// reviewTemplateSlug is the slug of a page in a domain that, if it exists,
+// is used instead of the default template for reviews
+const reviewTemplateSlug = "review-template"
+
+const defaultReviewTemplate = `# Review {{.To.Format "2006-01-02"}}
+
+From {{.From.Format "Mon Jan 2"}} to {{.To.Format "Mon Jan 2 2006"}}: {{len .Created}} new pages, {{len .Edited}} edited pages, {{.WordsAdded}} words written and {{len .Completed}} tasks completed ({{.OpenTasks}} still open).
+
+## New pages
+{{range .Created}}
+- [{{.Slug}}](/{{$.Domain}}/{{.ID}}) ({{.Words}} words){{else}}
+None.{{end}}
+
+## Edited pages
+{{range .Edited}}
+- [{{.Slug}}](/{{$.Domain}}/{{.ID}}) ({{if ge .WordsAdded 0}}+{{end}}{{.WordsAdded}} words){{else}}
+None.{{end}}
+
+## Completed tasks
+{{range .Completed}}
+- [x] {{.Task}} ([{{.Slug}}](/{{$.Domain}}/{{.ID}})){{else}}
+None.{{end}}
+`
+
+// generateReview writes the review of the last days of a domain as
+// markdown, using the review template of the domain if it has one
+func generateReview(domain string, days int, templateText string) (markdown string, err error) {
+	to := time.Now()
+	review, err := fs.Review(domain, to.AddDate(0, 0, -days), to)
+	if err != nil {
+		return
+	}
+
+	if templateText == "" {
+		templateText = defaultReviewTemplate
+		files, errGet := fs.GetCtx(db.AsMember(context.Background()), reviewTemplateSlug, domain)
+		if errGet == nil && strings.TrimSpace(files[0].Data) != "" {
+			_, templateText = utils.ParseFrontMatter(files[0].Data)
+		}
+	}
+	t, err := template.New("review").Parse(templateText)
+	if err != nil {
+		return
+	}
+	var buf bytes.Buffer
+	err = t.Execute(&buf, review)
+	markdown = buf.String()
+	return
+}
+
+// saveReview saves a review as a new page in a domain
+func saveReview(domain, markdown string) (f db.File, err error) {
+	f = fs.NewFile(utils.Slugify(markdown), markdown)
+	f.Domain = domain
+	err = fs.Save(f)
+	return
+}
+
+// review prints or saves a review of a domain
+func review(args []string) (err error) {
+	flags := flag.NewFlagSet("review", flag.ExitOnError)
+	domain := flags.String("domain", "", "domain to review")
+	days := flags.Int("days", 7, "number of days to review")
+	templateFile := flags.String("template", "", "file with a template for the review")
+	save := flags.Bool("save", false, "save the review as a page in the domain")
+	flags.Parse(args)
+	if *domain == "" {
+		return errors.New("usage: rwtxt review --domain x [--days 7] [--template file] [--save]")
+	}
+
+	fs, err = db.New(dbName, dbOptions)
+	if err != nil {
+		return
+	}
+	defer fs.Close()
+
+	var templateText []byte
+	if *templateFile != "" {
+		templateText, err = ioutil.ReadFile(*templateFile)
+		if err != nil {
+			return
+		}
+	}
+	markdown, err := generateReview(*domain, *days, string(templateText))
+	if err != nil {
+		return
+	}
+	if !*save {
+		fmt.Print(markdown)
+		return
+	}
+	f, err := saveReview(*domain, markdown)
+	if err != nil {
+		return
+	}
+	fmt.Printf("saved review to /%s/%s\n", *domain, f.ID)
+	return
+}
+
+func (tr *TemplateRender) handleReview(w http.ResponseWriter, r *http.Request) (err error) {
+	tr.DomainKey = strings.TrimSpace(strings.ToLower(r.FormValue("domain_key")))
+	tr.Domain = utils.NormalizeDomain(r.FormValue("domain"))
+	days, errDays := strconv.Atoi(r.FormValue("days"))
+	if errDays != nil || days < 1 {
+		days = 7
+	}
+
+	// check that the key is valid
+	domainFound, err := fs.CheckKey(tr.DomainKey)
+	if err != nil || tr.Domain != domainFound {
+		return tr.handleMain(w, r, "need to be logged in to review")
+	}
+
+	markdown, err := generateReview(tr.Domain, days, "")
+	if err != nil {
+		return tr.handleMain(w, r, err.Error())
+	}
+	f, err := saveReview(tr.Domain, markdown)
+	if err != nil {
+		return tr.handleMain(w, r, err.Error())
+	}
+	auditSave(r, f)
+	http.Redirect(w, r, "/"+tr.Domain+"/"+f.ID, 302)
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	log "github.com/cihub/seelog"
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// splitSearchWords reads stop words separated by spaces or commas, and
+// groups of synonyms with a group on each line and its words separated
+// by commas
+func splitSearchWords(stopWords, synonyms string) (sw db.SearchWords) {
+	sw.StopWords = strings.FieldsFunc(stopWords, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\n' || r == '\r' || r == '\t'
+	})
+	for _, line := range strings.Split(synonyms, "\n") {
+		if group := strings.Split(line, ","); len(group) > 1 {
+			sw.Synonyms = append(sw.Synonyms, group)
+		}
+	}
+	return
+}
+
+// joinSearchWords writes stop words and synonyms the way that
+// splitSearchWords reads them
+func joinSearchWords(sw db.SearchWords) (stopWords, synonyms string) {
+	groups := make([]string, len(sw.Synonyms))
+	for i, group := range sw.Synonyms {
+		groups[i] = strings.Join(group, ", ")
+	}
+	return strings.Join(sw.StopWords, " "), strings.Join(groups, "\n")
+}
+
+// handleSearchWords changes the stop words and synonyms of a domain,
+// which only its admins can do
+func (tr *TemplateRender) handleSearchWords(w http.ResponseWriter, r *http.Request) (err error) {
+	tr.DomainKey = strings.TrimSpace(strings.ToLower(r.FormValue("domain_key")))
+	tr.Domain = utils.NormalizeDomain(r.FormValue("domain"))
+	if tr.Domain == "public" || tr.Domain == "" {
+		tr.Domain = "public"
+		return tr.handleMain(w, r, "cannot modify public")
+	}
+
+	// check that the key is valid
+	domainFound, err := fs.CheckKey(tr.DomainKey)
+	if err != nil || tr.Domain != domainFound {
+		return tr.handleMain(w, r, "need to be logged in to change search")
+	}
+	if fs.KeyRole(tr.DomainKey) != db.RoleAdmin {
+		return tr.handleMain(w, r, "need to be an admin to change settings")
+	}
+
+	err = fs.SetSearchWords(tr.Domain, splitSearchWords(r.FormValue("stop_words"), r.FormValue("synonyms")))
+	if err != nil {
+		log.Debug(err)
+		return tr.handleMain(w, r, err.Error())
+	}
+	audit(r, "domain.updated", tr.Domain, "", "search words updated")
+	return tr.handleMain(w, r, "search words updated")
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// securityHeaders are the headers sent with every response, by the path
+// prefix they are for. Headers for a longer prefix override the ones for a
+// shorter one, so that a route or a domain (like "/eng/") can relax them.
+// An empty value removes a header. The Content-Security-Policy is
+// overridden by directive, so that "frame-ancestors *" only changes who
+// can embed the pages.
+var securityHeaders = map[string]map[string]string{
+	"/": {
+		"Content-Security-Policy":   "default-src 'self'; script-src 'self' 'unsafe-inline'; style-src 'self' 'unsafe-inline'; img-src * data:; connect-src 'self' ws: wss:; object-src 'none'; base-uri 'self'; frame-ancestors 'self'",
+		"X-Frame-Options":           "SAMEORIGIN",
+		"X-Content-Type-Options":    "nosniff",
+		"Referrer-Policy":           "strict-origin-when-cross-origin",
+		"Strict-Transport-Security": "max-age=31536000",
+	},
+}
+
+// loadSecurityHeaders reads the headers for each path prefix from a JSON
+// file, on top of the defaults
+func loadSecurityHeaders(filename string) (err error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return
+	}
+	var headers map[string]map[string]string
+	err = json.Unmarshal(b, &headers)
+	if err != nil {
+		return
+	}
+	for prefix, h := range headers {
+		if securityHeaders[prefix] == nil {
+			securityHeaders[prefix] = make(map[string]string)
+		}
+		for name, value := range h {
+			securityHeaders[prefix][http.CanonicalHeaderKey(name)] = value
+		}
+	}
+	return
+}
+
+// setSecurityHeaders sets the headers for the path of a request
+func setSecurityHeaders(w http.ResponseWriter, r *http.Request) {
+	prefixes := make([]string, 0, len(securityHeaders))
+	for prefix := range securityHeaders {
+		if strings.HasPrefix(r.URL.Path, prefix) {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	sort.Slice(prefixes, func(i, j int) bool {
+		return len(prefixes[i]) < len(prefixes[j])
+	})
+
+	headers := make(map[string]string)
+	for _, prefix := range prefixes {
+		for name, value := range securityHeaders[prefix] {
+			if name == "Content-Security-Policy" && value != "" && headers[name] != "" {
+				value = mergeCSP(headers[name], value)
+			}
+			headers[name] = value
+		}
+	}
+
+	// browsers only keep HSTS from https, and it would break http
+	// deployments that are reached some other way
+	if r.TLS == nil && r.Header.Get("X-Forwarded-Proto") != "https" {
+		delete(headers, "Strict-Transport-Security")
+	}
+	for name, value := range headers {
+		if value != "" {
+			w.Header().Set(name, value)
+		}
+	}
+}
+
+// mergeCSP overrides the directives of a policy with the ones in another
+func mergeCSP(policy, override string) string {
+	var names []string
+	directives := make(map[string]string)
+	for _, p := range []string{policy, override} {
+		for _, directive := range strings.Split(p, ";") {
+			directive = strings.TrimSpace(directive)
+			if directive == "" {
+				continue
+			}
+			name := strings.ToLower(strings.Fields(directive)[0])
+			if _, ok := directives[name]; !ok {
+				names = append(names, name)
+			}
+			directives[name] = directive
+		}
+	}
+	merged := make([]string, len(names))
+	for i, name := range names {
+		merged[i] = directives[name]
+	}
+	return strings.Join(merged, "; ")
+}
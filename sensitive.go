@@ -0,0 +1,76 @@
+package main
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strings"
+
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// sensitiveCookie is set once a reader agreed to see sensitive pages, for
+// the rest of their session
+const sensitiveCookie = "rwtxt-sensitive"
+
+// sensitiveGate returns whether a page is shown behind a notice that it is
+// sensitive, which is for who is not a member of its domain and did not
+// agree to see sensitive pages yet. They agree with ?sensitive=ok.
+func (tr *TemplateRender) sensitiveGate(w http.ResponseWriter, r *http.Request, f db.File) bool {
+	if !f.Sensitive || tr.SignedIn || tr.Reader {
+		return false
+	}
+	if c, err := r.Cookie(sensitiveCookie); err == nil && c.Value == "ok" {
+		return false
+	}
+	if r.URL.Query().Get("sensitive") == "ok" {
+		http.SetCookie(w, newCookie(r, sensitiveCookie, "ok", false))
+		return false
+	}
+	return true
+}
+
+// showSensitiveNotice shows the notice that a page is sensitive instead of
+// the page, which links to the page once they agree to see it
+func (tr *TemplateRender) showSensitiveNotice(w http.ResponseWriter, r *http.Request, f db.File) (err error) {
+	tr.Title = f.Slug
+	tr.File = db.File{ID: f.ID, Slug: f.Slug}
+	tr.Sensitive = true
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Set("Content-Type", "text/html")
+	w.Header().Set("Cache-Control", "no-store")
+	gz := gzip.NewWriter(w)
+	defer gz.Close()
+	return viewEditTemplate.Execute(gz, tr)
+}
+
+// handleSensitive marks whether a page of a domain is sensitive
+func (tr *TemplateRender) handleSensitive(w http.ResponseWriter, r *http.Request) (err error) {
+	r.ParseForm()
+	tr.DomainKey = strings.TrimSpace(strings.ToLower(r.FormValue("domain_key")))
+	tr.Domain = utils.NormalizeDomain(r.FormValue("domain"))
+	sensitive := r.FormValue("sensitive") == "on"
+	if tr.Domain == "public" || tr.Domain == "" {
+		tr.Domain = "public"
+		return tr.handleMain(w, r, "cannot modify public")
+	}
+
+	// check that the key is valid
+	domainFound, err := fs.CheckKey(tr.DomainKey)
+	if err != nil || tr.Domain != domainFound {
+		return tr.handleMain(w, r, "need to be logged in to mark pages as sensitive")
+	}
+
+	id := r.FormValue("id")
+	err = fs.SetSensitive(tr.Domain, id, sensitive)
+	if err != nil {
+		return tr.handleMain(w, r, err.Error())
+	}
+	message := "marked as sensitive"
+	if !sensitive {
+		message = "marked as not sensitive"
+	}
+	audit(r, "page.updated", tr.Domain, id, message)
+	http.Redirect(w, r, "/"+tr.Domain+"/"+id, http.StatusFound)
+	return nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/schollz/rwtxt/src/db"
+)
+
+// sitemapNamespace is the namespace of sitemaps and sitemap indexes
+const sitemapNamespace = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+// sitemapURL is a page in a sitemap
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+// sitemap is the sitemap of a domain
+type sitemap struct {
+	XMLName xml.Name     `xml:"urlset"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+// sitemapIndex is the sitemap of the instance, which has the sitemaps of
+// the domains
+type sitemapIndex struct {
+	XMLName  xml.Name     `xml:"sitemapindex"`
+	Xmlns    string       `xml:"xmlns,attr"`
+	Sitemaps []sitemapURL `xml:"sitemap"`
+}
+
+// handleRobots lets search engines index the public domains that are not
+// unlisted, with their sitemaps, and nothing else
+func handleRobots(w http.ResponseWriter, r *http.Request) (err error) {
+	domains, err := fs.IndexedDomains()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil
+	}
+	var b strings.Builder
+	b.WriteString("User-agent: *\n")
+	for _, domain := range domains {
+		b.WriteString("Allow: /" + domain + "/\n")
+		b.WriteString("Allow: /" + domain + "$\n")
+	}
+	b.WriteString("Disallow: /\n")
+	if len(domains) > 0 {
+		b.WriteString("\nSitemap: " + siteURL(r) + "/sitemap.xml\n")
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, err = w.Write([]byte(b.String()))
+	return
+}
+
+// handleSitemapIndex writes the index of the sitemaps of the domains that
+// search engines can index (/sitemap.xml)
+func handleSitemapIndex(w http.ResponseWriter, r *http.Request) (err error) {
+	domains, err := fs.IndexedDomains()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil
+	}
+	index := sitemapIndex{Xmlns: sitemapNamespace}
+	for _, domain := range domains {
+		index.Sitemaps = append(index.Sitemaps, sitemapURL{Loc: siteURL(r) + "/" + domain + "/sitemap.xml"})
+	}
+	return writeXML(w, index)
+}
+
+// handleSitemap writes the sitemap of a public domain, with the slug of
+// each page and when it was modified (/DOMAIN/sitemap.xml). Domains that
+// are unlisted have none.
+func (tr *TemplateRender) handleSitemap(w http.ResponseWriter, r *http.Request) (err error) {
+	_, ispublic, err := fs.GetDomainFromName(tr.Domain)
+	noindex, _ := fs.GetDomainNoIndex(tr.Domain)
+	if err != nil || !ispublic || noindex || tr.Domain == "public" {
+		http.Error(w, "domain does not exist or is not indexed", http.StatusNotFound)
+		return nil
+	}
+	files, err := fs.SitemapPages(tr.Domain)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil
+	}
+
+	domainURL := siteURL(r) + "/" + tr.Domain
+	s := sitemap{Xmlns: sitemapNamespace}
+	home := sitemapURL{Loc: domainURL}
+	if len(files) > 0 {
+		home.LastMod = files[0].Modified.UTC().Format(time.RFC3339)
+	}
+	s.URLs = append(s.URLs, home)
+	for _, f := range files {
+		page := f.Slug
+		if page == "" {
+			page = f.ID
+		}
+		s.URLs = append(s.URLs, sitemapURL{
+			Loc:     domainURL + "/" + url.PathEscape(page),
+			LastMod: f.Modified.UTC().Format(time.RFC3339),
+		})
+	}
+	if len(s.URLs) > db.MaxSitemapPages {
+		s.URLs = s.URLs[:db.MaxSitemapPages]
+	}
+	return writeXML(w, s)
+}
+
+// writeXML writes a value as an XML document
+func writeXML(w http.ResponseWriter, v interface{}) (err error) {
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	return encoder.Encode(v)
+}
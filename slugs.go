@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+
+	log "github.com/cihub/seelog"
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// pageSlug returns the slug of a page made from a text, like its title,
+// the way the domain of the page makes slugs. Domains that make slugs the
+// way the editor does keep the slug the page was given, or make one when
+// it has none. Pages that exist already keep the day they were created,
+// and slugs that rwtxt reserves get -page at their end.
+func pageSlug(f db.File, text string) string {
+	options, err := fs.GetSlugOptions(f.Domain)
+	if err != nil || options == (db.SlugOptions{}) {
+		if f.Slug == "" {
+			return db.UnreservedSlug(utils.Slugify(text))
+		}
+		return db.UnreservedSlug(utils.NormalizeSlug(f.Slug))
+	}
+	created := f.Created
+	if options.DatePrefix {
+		files, _ := fs.GetCtx(db.AsMember(context.Background()), f.ID, f.Domain)
+		for _, existing := range files {
+			if existing.ID == f.ID {
+				created = existing.Created
+			}
+		}
+	}
+	return db.UnreservedSlug(options.Slug(text, f.ID, created))
+}
+
+// handleSlugOptions changes how a domain makes the slugs of its pages
+func (tr *TemplateRender) handleSlugOptions(w http.ResponseWriter, r *http.Request) (err error) {
+	tr.DomainKey = strings.TrimSpace(strings.ToLower(r.FormValue("domain_key")))
+	tr.Domain = utils.NormalizeDomain(r.FormValue("domain"))
+	if tr.Domain == "public" || tr.Domain == "" {
+		tr.Domain = "public"
+		return tr.handleMain(w, r, "cannot modify public")
+	}
+
+	// check that the key is valid
+	domainFound, err := fs.CheckKey(tr.DomainKey)
+	if err != nil || tr.Domain != domainFound {
+		return tr.handleMain(w, r, "need to be logged in to change slugs")
+	}
+	if fs.KeyRole(tr.DomainKey) != db.RoleAdmin {
+		return tr.handleMain(w, r, "need to be an admin to change settings")
+	}
+
+	var options db.SlugOptions
+	options.Transliterate = r.FormValue("transliterate") == "on"
+	options.MaxLength, _ = strconv.Atoi(r.FormValue("max_length"))
+	options.Suffix = r.FormValue("suffix") == "on"
+	options.DatePrefix = r.FormValue("date_prefix") == "on"
+	err = fs.SetSlugOptions(tr.Domain, options)
+	if err != nil {
+		log.Debug(err)
+		return tr.handleMain(w, r, err.Error())
+	}
+	audit(r, "domain.updated", tr.Domain, "", "slugs updated")
+	return tr.handleMain(w, r, "slugs updated")
+}
@@ -0,0 +1,142 @@
+package db
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// AdminDomain is a domain as the admin of the instance sees it, with how
+// many pages it has, how much it stores, and when it was last used
+type AdminDomain struct {
+	Name   string `json:"name"`
+	Public bool   `json:"public"`
+	Pages  int    `json:"pages"`
+	// PageBytes is the size of the pages of the domain with their history,
+	// and UploadBytes is the size of the uploads that belong to it, which
+	// other domains can have too
+	PageBytes   int64 `json:"page_bytes"`
+	UploadBytes int64 `json:"upload_bytes"`
+	// Modified is when one of its pages was last changed, and LastLogin is
+	// when a key of the domain was last used
+	Modified  time.Time `json:"modified"`
+	LastLogin time.Time `json:"last_login"`
+	Keys      int       `json:"keys"`
+}
+
+// ListDomains returns every domain besides the public one, for the admin
+// of the instance
+func (fs *FileSystem) ListDomains() (domains []AdminDomain, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+
+	rows, err := fs.db.Query(`
+	SELECT
+		domains.name,
+		domains.ispublic,
+		(SELECT COUNT(*) FROM fs WHERE fs.domainid = domains.id AND fs.deleted = 0),
+		(SELECT COALESCE(SUM(LENGTH(fs.history)), 0) FROM fs WHERE fs.domainid = domains.id),
+		(SELECT COALESCE(SUM(blobs.size), 0) FROM blobs
+			INNER JOIN blob_domains ON blobs.id=blob_domains.blobid
+			WHERE blob_domains.domainid = domains.id),
+		(SELECT COUNT(*) FROM keys WHERE keys.domainid = domains.id)
+	FROM domains WHERE name != 'public' ORDER BY name`)
+	if err != nil {
+		return nil, errors.Wrap(err, "ListDomains")
+	}
+	defer rows.Close()
+	domains = []AdminDomain{}
+	byName := make(map[string]int)
+	for rows.Next() {
+		var d AdminDomain
+		err = rows.Scan(&d.Name, &d.Public, &d.Pages, &d.PageBytes, &d.UploadBytes, &d.Keys)
+		if err != nil {
+			return nil, errors.Wrap(err, "get rows of ListDomains")
+		}
+		byName[d.Name] = len(domains)
+		domains = append(domains, d)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.Wrap(err, "ListDomains")
+	}
+
+	// the times are found apart, since they are only read as times from
+	// their columns
+	err = fs.scanDomainTimes(`SELECT domains.name, fs.modified FROM fs
+	INNER JOIN domains ON fs.domainid=domains.id WHERE fs.deleted = 0`, func(name string, t time.Time) {
+		if i, ok := byName[name]; ok && t.After(domains[i].Modified) {
+			domains[i].Modified = t
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = fs.scanDomainTimes(`SELECT domains.name, keys.lastused FROM keys
+	INNER JOIN domains ON keys.domainid=domains.id`, func(name string, t time.Time) {
+		if i, ok := byName[name]; ok && t.After(domains[i].LastLogin) {
+			domains[i].LastLogin = t
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// scanDomainTimes calls fn with the name of a domain and a time from each
+// row of a query, which can be NULL
+func (fs *FileSystem) scanDomainTimes(query string, fn func(name string, t time.Time)) (err error) {
+	rows, err := fs.db.Query(query)
+	if err != nil {
+		return errors.Wrap(err, "ListDomains")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		var t *time.Time
+		err = rows.Scan(&name, &t)
+		if err != nil {
+			return errors.Wrap(err, "get times of ListDomains")
+		}
+		if t != nil {
+			fn(name, *t)
+		}
+	}
+	return rows.Err()
+}
+
+// ResetDomainKeys logs everybody out of a domain, by deleting every key of
+// it, and gives it a new password when there is one
+func (fs *FileSystem) ResetDomainKeys(domain, password string) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	domain = utils.NormalizeDomain(domain)
+	domainid, _, _, _ := fs.getDomainFromName(domain)
+	if domainid == 0 || domain == "public" {
+		return errors.New("domain does not exist")
+	}
+	tx, err := fs.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin ResetDomainKeys")
+	}
+	_, err = tx.Exec(`DELETE FROM keys WHERE domainid = ?`, domainid)
+	if err == nil && password != "" {
+		var hashedPassword string
+		hashedPassword, err = utils.HashPassword(password)
+		if err == nil {
+			_, err = tx.Exec(`UPDATE domains SET hashed_pass = ? WHERE id = ?`, hashedPassword, domainid)
+		}
+	}
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "exec ResetDomainKeys")
+	}
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrap(err, "commit ResetDomainKeys")
+	}
+	return
+}
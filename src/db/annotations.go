@@ -0,0 +1,106 @@
+package db
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Annotation is a highlight of some text in a file with an optional
+// comment. The text is found again by its quote and the text around it.
+type Annotation struct {
+	ID      int       `json:"id"`
+	FileID  string    `json:"file_id"`
+	Quote   string    `json:"quote"`
+	Prefix  string    `json:"prefix,omitempty"`
+	Suffix  string    `json:"suffix,omitempty"`
+	Comment string    `json:"comment,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+// AddAnnotation adds an annotation to the file with the given id or slug
+func (fs *FileSystem) AddAnnotation(domain string, a Annotation) (annotation Annotation, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	if a.Quote == "" {
+		err = errors.New("annotation needs a quote")
+		return
+	}
+	files, err := fs.getAny(a.FileID, domain)
+	if err != nil {
+		return
+	}
+	domainid, _, _, _ := fs.getDomainFromName(domain)
+
+	a.FileID = files[0].ID
+	a.Created = time.Now().UTC()
+	tx, err := fs.db.Begin()
+	if err != nil {
+		return a, errors.Wrap(err, "begin AddAnnotation")
+	}
+	stmt, err := tx.Prepare(`
+	INSERT INTO annotations 
+		(fsid, domainid, quote, prefix, suffix, comment, created) 
+	VALUES (?,?,?,?,?,?,?)`)
+	if err != nil {
+		return a, errors.Wrap(err, "stmt AddAnnotation")
+	}
+	defer stmt.Close()
+	res, err := stmt.Exec(a.FileID, domainid, a.Quote, a.Prefix, a.Suffix, a.Comment, a.Created)
+	if err != nil {
+		return a, errors.Wrap(err, "exec AddAnnotation")
+	}
+	err = tx.Commit()
+	if err != nil {
+		return a, errors.Wrap(err, "commit AddAnnotation")
+	}
+	id, _ := res.LastInsertId()
+	a.ID = int(id)
+	return a, nil
+}
+
+// GetAnnotations returns the annotations of the file with the given id or slug
+func (fs *FileSystem) GetAnnotations(id, domain string) (annotations []Annotation, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+
+	files, err := fs.getAny(id, domain)
+	if err != nil {
+		return
+	}
+	stmt, err := fs.db.Prepare(`
+	SELECT id, fsid, quote, prefix, suffix, comment, created FROM annotations 
+	WHERE fsid = ? ORDER BY created`)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query(files[0].ID)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	annotations = []Annotation{}
+	for rows.Next() {
+		var a Annotation
+		err = rows.Scan(&a.ID, &a.FileID, &a.Quote, &a.Prefix, &a.Suffix, &a.Comment, &a.Created)
+		if err != nil {
+			err = errors.Wrap(err, "get rows of annotations")
+			return
+		}
+		annotations = append(annotations, a)
+	}
+	err = rows.Err()
+	return
+}
+
+// DeleteAnnotation removes an annotation from a domain
+func (fs *FileSystem) DeleteAnnotation(domain string, id int) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	domainid, _, _, _ := fs.getDomainFromName(domain)
+	_, err = fs.db.Exec(`DELETE FROM annotations WHERE id = ? AND domainid = ?`, id, domainid)
+	return
+}
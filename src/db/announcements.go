@@ -0,0 +1,110 @@
+package db
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// AnnouncementSeverities are the severities of announcements, from the
+// least to the most severe
+var AnnouncementSeverities = []string{"info", "warning", "critical"}
+
+// Announcement is a message, like a notice of maintenance, that is shown
+// across every page of the instance between its start and its end. A zero
+// start or end has no limit.
+type Announcement struct {
+	ID       int       `json:"id"`
+	Message  string    `json:"message"`
+	Severity string    `json:"severity"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Created  time.Time `json:"created"`
+}
+
+// Active returns whether an announcement is shown at a time
+func (a Announcement) Active(t time.Time) bool {
+	return (a.Start.IsZero() || !t.Before(a.Start)) && (a.End.IsZero() || t.Before(a.End))
+}
+
+// AddAnnouncement adds an announcement, and returns its id
+func (fs *FileSystem) AddAnnouncement(a Announcement) (id int, err error) {
+	a.Message = strings.TrimSpace(a.Message)
+	if a.Message == "" {
+		return 0, errors.New("announcement has no message")
+	}
+	if a.Severity == "" {
+		a.Severity = "info"
+	}
+	valid := false
+	for _, severity := range AnnouncementSeverities {
+		if a.Severity == severity {
+			valid = true
+		}
+	}
+	if !valid {
+		return 0, errors.Errorf("severity should be one of %s", strings.Join(AnnouncementSeverities, ", "))
+	}
+	if !a.Start.IsZero() && !a.End.IsZero() && !a.End.After(a.Start) {
+		return 0, errors.New("announcement ends before it starts")
+	}
+
+	fs.Lock()
+	defer fs.Unlock()
+	res, err := fs.db.Exec(`INSERT INTO announcements (message, severity, starts, ends, created) VALUES (?, ?, ?, ?, ?)`,
+		a.Message, a.Severity, a.Start.UTC(), a.End.UTC(), time.Now().UTC())
+	if err != nil {
+		return 0, errors.Wrap(err, "exec AddAnnouncement")
+	}
+	lastID, err := res.LastInsertId()
+	if err != nil {
+		return 0, errors.Wrap(err, "AddAnnouncement")
+	}
+	return int(lastID), nil
+}
+
+// DeleteAnnouncement removes an announcement
+func (fs *FileSystem) DeleteAnnouncement(id int) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+	res, err := fs.db.Exec(`DELETE FROM announcements WHERE id = ?`, id)
+	if err != nil {
+		return errors.Wrap(err, "exec DeleteAnnouncement")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New("announcement does not exist")
+	}
+	return
+}
+
+// GetAnnouncements returns the announcements that have not ended, the ones
+// that start first first
+func (fs *FileSystem) GetAnnouncements() (announcements []Announcement, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+
+	rows, err := fs.db.Query(`SELECT id, message, severity, starts, ends, created FROM announcements ORDER BY starts, id`)
+	if err != nil {
+		return nil, errors.Wrap(err, "GetAnnouncements")
+	}
+	defer rows.Close()
+	now := time.Now()
+	announcements = []Announcement{}
+	for rows.Next() {
+		var a Announcement
+		err = rows.Scan(&a.ID, &a.Message, &a.Severity, &a.Start, &a.End, &a.Created)
+		if err != nil {
+			return nil, errors.Wrap(err, "get rows of GetAnnouncements")
+		}
+		if !a.End.IsZero() && !now.Before(a.End) {
+			continue
+		}
+		announcements = append(announcements, a)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.Wrap(err, "GetAnnouncements")
+	}
+	return
+}
@@ -0,0 +1,80 @@
+package db
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// AuditEvent is a security event, like a save, a delete or a login
+type AuditEvent struct {
+	ID      int       `json:"-"`
+	Created time.Time `json:"time"`
+	Event   string    `json:"event"`
+	Domain  string    `json:"domain"`
+	Page    string    `json:"page,omitempty"`
+	Source  string    `json:"source,omitempty"`
+	Detail  string    `json:"detail,omitempty"`
+}
+
+// AddAudit adds an event to the audit log
+func (fs *FileSystem) AddAudit(e AuditEvent) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	if e.Created.IsZero() {
+		e.Created = time.Now().UTC()
+	}
+	tx, err := fs.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin AddAudit")
+	}
+	stmt, err := tx.Prepare(`INSERT INTO audit (created, event, domain, page, source, detail) VALUES (?,?,?,?,?,?)`)
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "stmt AddAudit")
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(e.Created, e.Event, e.Domain, e.Page, e.Source, e.Detail)
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "exec AddAudit")
+	}
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrap(err, "commit AddAudit")
+	}
+	return
+}
+
+// GetAudit returns the latest events in the audit log of a domain
+func (fs *FileSystem) GetAudit(domain string, num int) (events []AuditEvent, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+
+	stmt, err := fs.db.Prepare(`
+	SELECT id, created, event, domain, page, source, detail FROM audit
+	WHERE domain = ?
+	ORDER BY created DESC, id DESC LIMIT ?`)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query(domain, num)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	events = []AuditEvent{}
+	for rows.Next() {
+		var e AuditEvent
+		err = rows.Scan(&e.ID, &e.Created, &e.Event, &e.Domain, &e.Page, &e.Source, &e.Detail)
+		if err != nil {
+			err = errors.Wrap(err, "get rows of audit")
+			return
+		}
+		events = append(events, e)
+	}
+	err = rows.Err()
+	return
+}
@@ -0,0 +1,176 @@
+package db
+
+import (
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// BandwidthQuota is how many bytes of uploads a domain serves to the public
+// each month, which is not capped when it is zero. Uploads asked for after
+// that are redirected to the placeholder, or refused when it has none.
+// Members of the domain are never capped.
+type BandwidthQuota struct {
+	Cap         int64  `json:"cap"`
+	Placeholder string `json:"placeholder,omitempty"`
+}
+
+// Egress is how much of the uploads of a domain was served in a month,
+// like "2024-05", and how many requests were refused for the cap
+type Egress struct {
+	Month    string `json:"month"`
+	Bytes    int64  `json:"bytes"`
+	Requests int    `json:"requests"`
+	Denied   int    `json:"denied"`
+}
+
+// egressMonth returns the month that egress at a time is counted in
+func egressMonth(t time.Time) string {
+	return t.UTC().Format("2006-01")
+}
+
+// SetBandwidthQuota changes how much of its uploads a domain serves to the
+// public each month
+func (fs *FileSystem) SetBandwidthQuota(domain string, quota BandwidthQuota) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+	if quota.Cap < 0 {
+		return errors.New("cap can not be less than nothing")
+	}
+	quota.Placeholder = strings.TrimSpace(quota.Placeholder)
+	if quota.Placeholder != "" {
+		u, errURL := url.Parse(quota.Placeholder)
+		if errURL != nil || strings.HasPrefix(quota.Placeholder, "//") ||
+			!((u.Scheme == "http" || u.Scheme == "https") && u.Host != "" || u.Scheme == "" && strings.HasPrefix(u.Path, "/")) {
+			return errors.New("placeholder must be a path like /static/img/logo.png or a url")
+		}
+	}
+	res, err := fs.db.Exec(`UPDATE domains SET bandwidth_cap = ?, bandwidth_placeholder = ? WHERE name = ?`,
+		quota.Cap, quota.Placeholder, utils.NormalizeDomain(domain))
+	if err != nil {
+		return errors.Wrap(err, "SetBandwidthQuota")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New("domain does not exist")
+	}
+	return
+}
+
+// GetBandwidthQuota returns how much of its uploads a domain serves to the
+// public each month
+func (fs *FileSystem) GetBandwidthQuota(domain string) (quota BandwidthQuota, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+	err = fs.db.QueryRow(`SELECT bandwidth_cap, bandwidth_placeholder FROM domains WHERE name = ?`, utils.NormalizeDomain(domain)).Scan(
+		&quota.Cap, &quota.Placeholder)
+	if err != nil {
+		err = errors.Wrap(err, "GetBandwidthQuota")
+	}
+	return
+}
+
+// BlobEgressDomain returns the domain that serving a blob counts against,
+// which is one of the readable domains that it belongs to, or else one of
+// its public domains, when it is served to the public. Blobs that belong to
+// no domain count against none.
+func (fs *FileSystem) BlobEgressDomain(id string, readable []string) (domain string, public bool, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+	rows, err := fs.db.Query(`
+	SELECT domains.name, COALESCE(domains.ispublic, 0) FROM blob_domains
+	INNER JOIN domains ON blob_domains.domainid=domains.id
+	WHERE blob_domains.blobid = ? ORDER BY domains.name`, id)
+	if err != nil {
+		return "", false, errors.Wrap(err, "BlobEgressDomain")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		var ispublic bool
+		err = rows.Scan(&name, &ispublic)
+		if err != nil {
+			return "", false, errors.Wrap(err, "get rows of BlobEgressDomain")
+		}
+		for _, r := range readable {
+			if r == name && name != "public" {
+				return name, false, nil
+			}
+		}
+		if (ispublic || name == "public") && domain == "" {
+			domain, public = name, true
+		}
+	}
+	err = rows.Err()
+	return
+}
+
+// OverBandwidth returns the quota of a domain and whether it served all
+// that its cap allows this month
+func (fs *FileSystem) OverBandwidth(domain string) (quota BandwidthQuota, over bool, err error) {
+	quota, err = fs.GetBandwidthQuota(domain)
+	if err != nil || quota.Cap == 0 {
+		return
+	}
+	fs.RLock()
+	defer fs.RUnlock()
+	var served int64
+	err = fs.db.QueryRow(`SELECT COALESCE(SUM(bytes), 0) FROM blob_egress
+	WHERE domainid = (SELECT id FROM domains WHERE name = ?) AND month = ?`,
+		utils.NormalizeDomain(domain), egressMonth(time.Now())).Scan(&served)
+	if err != nil {
+		return quota, false, errors.Wrap(err, "OverBandwidth")
+	}
+	over = served >= quota.Cap
+	return
+}
+
+// AddEgress counts the bytes of uploads that a domain served this month,
+// or a request that was refused for its cap
+func (fs *FileSystem) AddEgress(domain string, bytes int64, denied bool) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+	domainid, _, _, _ := fs.getDomainFromName(domain)
+	if domainid == 0 {
+		return errors.New("domain " + domain + " does not exist")
+	}
+	requests, refused := 1, 0
+	if denied {
+		requests, refused = 0, 1
+	}
+	_, err = fs.db.Exec(`INSERT INTO blob_egress (domainid, month, bytes, requests, denied) VALUES (?,?,?,?,?)
+	ON CONFLICT (domainid, month) DO UPDATE SET bytes = bytes + excluded.bytes,
+	requests = requests + excluded.requests, denied = denied + excluded.denied`,
+		domainid, egressMonth(time.Now()), bytes, requests, refused)
+	if err != nil {
+		err = errors.Wrap(err, "AddEgress")
+	}
+	return
+}
+
+// GetEgress returns how much of the uploads of a domain was served in each
+// of the last months, the newest first
+func (fs *FileSystem) GetEgress(domain string, months int) (egress []Egress, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+	rows, err := fs.db.Query(`SELECT month, bytes, requests, denied FROM blob_egress
+	WHERE domainid = (SELECT id FROM domains WHERE name = ?)
+	ORDER BY month DESC LIMIT ?`, utils.NormalizeDomain(domain), months)
+	if err != nil {
+		return nil, errors.Wrap(err, "GetEgress")
+	}
+	defer rows.Close()
+	egress = []Egress{}
+	for rows.Next() {
+		var e Egress
+		err = rows.Scan(&e.Month, &e.Bytes, &e.Requests, &e.Denied)
+		if err != nil {
+			return nil, errors.Wrap(err, "get rows of GetEgress")
+		}
+		egress = append(egress, e)
+	}
+	err = rows.Err()
+	return
+}
@@ -0,0 +1,550 @@
+package db
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"database/sql"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"regexp"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+)
+
+// blobChunkSize is how much of a blob is kept in each of its chunks. All
+// the chunks but the last are this long, so it can not change without
+// the blobs that were saved with it.
+const blobChunkSize = 1 << 20
+
+// Blob is what is known about an upload besides its data, with the
+// domains that it was uploaded to. Its size is of the upload before it
+// was compressed. Its id is the hash of its data, so an upload that is
+// uploaded again is kept once, with each name that it was uploaded as,
+// and the pages that link to it.
+type Blob struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	Created     time.Time `json:"created"`
+	Views       int       `json:"views"`
+	Domains     []string  `json:"domains,omitempty"`
+	Names       []string  `json:"names,omitempty"`
+	Pages       []string  `json:"pages,omitempty"`
+}
+
+// uploadRef finds the links to uploads in a text, with the name of the
+// upload that the link has
+var uploadRef = regexp.MustCompile(`/uploads/(sha256-[0-9a-f]+)(\?filename=([^)\s"&]*))?`)
+
+// AddBlob saves an upload to a domain. An upload that is already saved,
+// which has the same id since its id is the hash of its data, is added
+// to the domain as it is. The data is nil for uploads that were saved
+// with SaveBlobReader.
+func (fs *FileSystem) AddBlob(domain string, b Blob, data []byte) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	domainid, _, _, _ := fs.getDomainFromName(domain)
+	if domainid == 0 {
+		return errors.New("domain does not exist")
+	}
+	if err = fs.checkWritable(domain); err != nil {
+		return
+	}
+	now := time.Now().UTC()
+	tx, err := fs.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin AddBlob")
+	}
+	if data != nil {
+		_, err = tx.Exec(`INSERT OR IGNORE INTO blobs (id, name, data, content_type, size, created) VALUES (?,?,?,?,?,?)`,
+			b.ID, b.Name, data, b.ContentType, b.Size, now)
+	}
+	if err == nil {
+		// blobs that were saved before they had metadata get it now
+		_, err = tx.Exec(`UPDATE blobs SET content_type = ?, size = ?, created = ? WHERE id = ? AND created IS NULL`,
+			b.ContentType, b.Size, now, b.ID)
+	}
+	if err == nil {
+		_, err = tx.Exec(`INSERT OR IGNORE INTO blob_domains (blobid, domainid, created) VALUES (?,?,?)`, b.ID, domainid, now)
+	}
+	if err == nil {
+		_, err = tx.Exec(`INSERT OR IGNORE INTO blob_refs (blobid, domainid, fsid, name, created) VALUES (?,?,'',?,?)`, b.ID, domainid, b.Name, now)
+	}
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "exec AddBlob")
+	}
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrap(err, "commit AddBlob")
+	}
+	return
+}
+
+// BlobInfo returns what is known about a blob, without its data
+func (fs *FileSystem) BlobInfo(id string) (b Blob, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+	return fs.blobInfo(id)
+}
+
+func (fs *FileSystem) blobInfo(id string) (b Blob, err error) {
+	var created *time.Time
+	err = fs.db.QueryRow(`SELECT id, name, content_type, size, created, views FROM blobs WHERE id = ?`, id).Scan(
+		&b.ID, &b.Name, &b.ContentType, &b.Size, &created, &b.Views)
+	if err != nil {
+		return b, errors.Wrap(err, "BlobInfo")
+	}
+	if created != nil {
+		b.Created = *created
+	}
+	b.Domains, err = fs.getAllFromPreparedQuerySingleString(`
+	SELECT domains.name FROM blob_domains
+	INNER JOIN domains ON blob_domains.domainid=domains.id
+	WHERE blob_domains.blobid = ?
+	ORDER BY domains.name`, id)
+	if err != nil {
+		return
+	}
+	b.Names, err = fs.getAllFromPreparedQuerySingleString(`
+	SELECT DISTINCT name FROM blob_refs WHERE blobid = ? AND fsid = '' AND name != '' ORDER BY name`, id)
+	if err != nil {
+		return
+	}
+	b.Pages, err = fs.getAllFromPreparedQuerySingleString(`
+	SELECT DISTINCT fsid FROM blob_refs WHERE blobid = ? AND fsid != '' ORDER BY fsid`, id)
+	return
+}
+
+// ListBlobs returns the blobs that were uploaded to a domain, the newest
+// first
+func (fs *FileSystem) ListBlobs(domain string) (blobs []Blob, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+
+	rows, err := fs.db.Query(`
+	SELECT blobs.id, blobs.name, blobs.content_type, blobs.size, blob_domains.created, blobs.views FROM blobs
+	INNER JOIN blob_domains ON blobs.id=blob_domains.blobid
+	INNER JOIN domains ON blob_domains.domainid=domains.id
+	WHERE domains.name = ?
+	ORDER BY blob_domains.created DESC`, domain)
+	if err != nil {
+		return nil, errors.Wrap(err, "ListBlobs")
+	}
+	defer rows.Close()
+	blobs = []Blob{}
+	for rows.Next() {
+		var b Blob
+		err = rows.Scan(&b.ID, &b.Name, &b.ContentType, &b.Size, &b.Created, &b.Views)
+		if err != nil {
+			return nil, errors.Wrap(err, "get rows of ListBlobs")
+		}
+		b.Domains = []string{domain}
+		blobs = append(blobs, b)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.Wrap(err, "ListBlobs")
+	}
+	rows.Close()
+
+	// the names of each upload in the domain, and the pages of the domain
+	// that link to it
+	for i := range blobs {
+		blobs[i].Names, err = fs.getAllFromPreparedQuerySingleString(`
+		SELECT DISTINCT blob_refs.name FROM blob_refs
+		INNER JOIN domains ON blob_refs.domainid=domains.id
+		WHERE blob_refs.blobid = ? AND domains.name = ? AND blob_refs.fsid = '' AND blob_refs.name != ''
+		ORDER BY blob_refs.name`, blobs[i].ID, domain)
+		if err != nil {
+			return
+		}
+		blobs[i].Pages, err = fs.getAllFromPreparedQuerySingleString(`
+		SELECT DISTINCT blob_refs.fsid FROM blob_refs
+		INNER JOIN domains ON blob_refs.domainid=domains.id
+		WHERE blob_refs.blobid = ? AND domains.name = ? AND blob_refs.fsid != ''
+		ORDER BY blob_refs.fsid`, blobs[i].ID, domain)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// canReadBlob returns whether a blob belongs to no domain, to a public
+// one or to one of the readable domains
+func (fs *FileSystem) canReadBlob(id string, readable []string) (ok bool, err error) {
+	rows, err := fs.db.Query(`
+	SELECT domains.name, COALESCE(domains.ispublic, 0) FROM blob_domains
+	INNER JOIN domains ON blob_domains.domainid=domains.id
+	WHERE blob_domains.blobid = ?`, id)
+	if err != nil {
+		return false, errors.Wrap(err, "canReadBlob")
+	}
+	defer rows.Close()
+	ok = true
+	for rows.Next() {
+		var name string
+		var ispublic bool
+		err = rows.Scan(&name, &ispublic)
+		if err != nil {
+			return false, errors.Wrap(err, "get rows of canReadBlob")
+		}
+		if ispublic || name == "public" {
+			return true, nil
+		}
+		ok = false
+		for _, domain := range readable {
+			if domain == name {
+				return true, nil
+			}
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		err = errors.Wrap(err, "canReadBlob")
+	}
+	return
+}
+
+// ViewBlob returns what is known about a blob, if it can be read by
+// somebody who is signed in to the readable domains like with GetBlob,
+// and counts it as a view when asked to
+func (fs *FileSystem) ViewBlob(id string, readable []string, count bool) (b Blob, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	ok, err := fs.canReadBlob(id, readable)
+	if err != nil {
+		return
+	}
+	if !ok {
+		err = errors.New("not allowed to read the upload")
+		return
+	}
+	b, err = fs.blobInfo(id)
+	if err != nil || !count {
+		return
+	}
+	_, err = fs.db.Exec(`UPDATE blobs SET views = views + 1 WHERE id = ?`, id)
+	if err != nil {
+		return b, errors.Wrap(err, "exec ViewBlob")
+	}
+	b.Views++
+	return
+}
+
+// SaveBlobReader saves a blob as it is read, to the store that new blobs
+// are kept in, so that big uploads are never all in memory. It replaces
+// the blob that has the same id.
+func (fs *FileSystem) SaveBlobReader(id, name string, r io.Reader) (err error) {
+	spooled, size, err := spoolBlob(r, nil)
+	if err != nil {
+		return
+	}
+	defer spooled.Close()
+	return fs.putBlob(id, name, spooled, size)
+}
+
+// StoreBlob saves a blob as it is read like SaveBlobReader, with the hash
+// of its data as its id, and returns its id and size. A blob that is
+// saved already is not saved again.
+func (fs *FileSystem) StoreBlob(name string, r io.Reader) (id string, size int64, err error) {
+	// the blob is read before it is kept, since its id is its hash
+	h := sha256.New()
+	spooled, size, err := spoolBlob(r, h)
+	if err != nil {
+		return
+	}
+	defer spooled.Close()
+	id = fmt.Sprintf("sha256-%x", h.Sum(nil))
+
+	var exists bool
+	fs.RLock()
+	err = fs.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM blobs WHERE id = ?)`, id).Scan(&exists)
+	fs.RUnlock()
+	if err != nil {
+		return "", 0, errors.Wrap(err, "exec StoreBlob")
+	}
+	if exists {
+		return
+	}
+	err = fs.putBlob(id, name, spooled, size)
+	if err != nil {
+		return "", 0, err
+	}
+	return
+}
+
+// putBlob keeps the data of a blob in the store that new blobs are kept
+// in, and then saves the blob. The database is not locked while the data
+// is kept, since a store outside of it can take a while.
+func (fs *FileSystem) putBlob(id, name string, r io.Reader, size int64) (err error) {
+	store := fs.putBlobStore()
+	err = store.Put(id, r, size)
+	if err != nil {
+		return
+	}
+
+	fs.Lock()
+	defer fs.Unlock()
+	tx, err := fs.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin putBlob")
+	}
+	err = insertChunkedBlob(tx, id, name, size, store.Name())
+	if err != nil {
+		tx.Rollback()
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrap(err, "commit putBlob")
+	}
+	return
+}
+
+// writeChunks saves what is read as the chunks of a blob, replacing the
+// chunks it had, and returns how much was read
+func writeChunks(tx *sql.Tx, id string, r io.Reader) (size int64, err error) {
+	_, err = tx.Exec(`DELETE FROM blob_chunks WHERE blobid = ?`, id)
+	if err != nil {
+		return 0, errors.Wrap(err, "exec writeChunks")
+	}
+	stmt, err := tx.Prepare(`INSERT INTO blob_chunks (blobid, n, data) VALUES (?,?,?)`)
+	if err != nil {
+		return 0, errors.Wrap(err, "stmt writeChunks")
+	}
+	defer stmt.Close()
+
+	chunk := make([]byte, blobChunkSize)
+	for n := 0; ; n++ {
+		read, errRead := io.ReadFull(r, chunk)
+		if read > 0 {
+			_, err = stmt.Exec(id, n, chunk[:read])
+			if err != nil {
+				return 0, errors.Wrap(err, "exec writeChunks")
+			}
+			size += int64(read)
+		}
+		if errRead == io.EOF || errRead == io.ErrUnexpectedEOF {
+			break
+		} else if errRead != nil {
+			return 0, errors.Wrap(errRead, "read writeChunks")
+		}
+	}
+	return
+}
+
+// insertChunkedBlob saves a blob whose data is in chunks, or in a store
+// outside of the database
+func insertChunkedBlob(tx *sql.Tx, id, name string, size int64, store string) (err error) {
+	_, err = tx.Exec(`INSERT INTO blobs (id, name, data, size, chunked, store) VALUES (?,?,NULL,?,1,?)
+	ON CONFLICT(id) DO UPDATE SET
+		name = excluded.name,
+		data = NULL,
+		size = excluded.size,
+		chunked = 1,
+		store = excluded.store`, id, name, size, store)
+	if err != nil {
+		return errors.Wrap(err, "exec insertChunkedBlob")
+	}
+	return
+}
+
+// setBlobRefs replaces which uploads a page links to, with the name of the
+// upload in each link
+func (fs *FileSystem) setBlobRefs(tx *sql.Tx, fileid string, domainid int, data string) (err error) {
+	_, err = tx.Exec(`DELETE FROM blob_refs WHERE fsid = ?`, fileid)
+	if err != nil {
+		return errors.Wrap(err, "exec delete blob_refs")
+	}
+	matches := uploadRef.FindAllStringSubmatch(data, -1)
+	if len(matches) == 0 {
+		return
+	}
+	stmt, err := tx.Prepare(`INSERT OR IGNORE INTO blob_refs (blobid, domainid, fsid, name, created) VALUES (?,?,?,?,?)`)
+	if err != nil {
+		return errors.Wrap(err, "stmt setBlobRefs")
+	}
+	defer stmt.Close()
+	now := time.Now().UTC()
+	for _, match := range matches {
+		name, errName := url.QueryUnescape(match[3])
+		if errName != nil {
+			name = match[3]
+		}
+		_, err = stmt.Exec(match[1], domainid, fileid, name, now)
+		if err != nil {
+			return errors.Wrap(err, "exec setBlobRefs")
+		}
+	}
+	return
+}
+
+// indexBlobRefs finds the uploads that pages link to, and the names of
+// the uploads to each domain, when they were saved before they were kept
+func (fs *FileSystem) indexBlobRefs() (err error) {
+	rows, err := fs.db.Query(`SELECT fs.id,fs.domainid,fts.data FROM fs INNER JOIN fts ON fs.id=fts.id WHERE INSTR(fts.data, '/uploads/') > 0`)
+	if err != nil {
+		return errors.Wrap(err, "indexBlobRefs")
+	}
+	type page struct {
+		id       string
+		domainid int
+		data     string
+	}
+	var pages []page
+	for rows.Next() {
+		var p page
+		err = rows.Scan(&p.id, &p.domainid, &p.data)
+		if err != nil {
+			rows.Close()
+			return errors.Wrap(err, "get rows of indexBlobRefs")
+		}
+		pages = append(pages, p)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return errors.Wrap(err, "indexBlobRefs")
+	}
+
+	tx, err := fs.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin indexBlobRefs")
+	}
+	for _, p := range pages {
+		err = fs.setBlobRefs(tx, p.id, p.domainid, p.data)
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+	}
+	_, err = tx.Exec(`INSERT OR IGNORE INTO blob_refs (blobid, domainid, fsid, name, created)
+	SELECT blob_domains.blobid, blob_domains.domainid, '', blobs.name, blob_domains.created FROM blob_domains
+	INNER JOIN blobs ON blobs.id=blob_domains.blobid`)
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "exec indexBlobRefs")
+	}
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrap(err, "commit indexBlobRefs")
+	}
+	if len(pages) > 0 {
+		log.Infof("found the uploads of %d pages", len(pages))
+	}
+	return
+}
+
+// OpenBlob opens a blob to be read and seeked in, from the store that
+// keeps it, which is closed after it is read. Blobs that are kept in the
+// database are read a chunk at a time. Blobs that were saved before blobs
+// were kept in chunks are gzipped as a whole, so they are read into
+// memory.
+func (fs *FileSystem) OpenBlob(id string) (rs ReadSeekCloser, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+
+	var data []byte
+	var size int64
+	var chunked bool
+	var storeName string
+	err = fs.db.QueryRow(`SELECT data, size, chunked, IFNULL(store, '') FROM blobs WHERE id = ?`, id).Scan(&data, &size, &chunked, &storeName)
+	if err != nil {
+		return nil, errors.Wrap(err, "OpenBlob")
+	}
+	if chunked {
+		store, errStore := fs.blobStore(storeName)
+		if errStore != nil {
+			return nil, errStore
+		}
+		return store.Open(id, size)
+	}
+	z, errGzip := gzip.NewReader(bytes.NewReader(data))
+	if errGzip != nil {
+		// blobs that were saved by other programs might not be gzipped
+		return memoryBlob{bytes.NewReader(data)}, nil
+	}
+	defer z.Close()
+	data, err = ioutil.ReadAll(z)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not gunzip blob")
+	}
+	return memoryBlob{bytes.NewReader(data)}, nil
+}
+
+// memoryBlob is the data of a blob that was read into memory
+type memoryBlob struct {
+	*bytes.Reader
+}
+
+func (memoryBlob) Close() error {
+	return nil
+}
+
+// blobReader reads a blob that is kept in chunks, getting the chunk that
+// has what is read next when it is not the one it got last
+type blobReader struct {
+	fs     *FileSystem
+	id     string
+	size   int64
+	offset int64
+	// n is the number of the chunk that was got last, which is -1 before
+	// one is got
+	n     int64
+	chunk []byte
+}
+
+func (br *blobReader) Read(p []byte) (read int, err error) {
+	if br.offset >= br.size {
+		return 0, io.EOF
+	}
+	n := br.offset / blobChunkSize
+	if n != br.n {
+		br.fs.RLock()
+		err = br.fs.db.QueryRow(`SELECT data FROM blob_chunks WHERE blobid = ? AND n = ?`, br.id, n).Scan(&br.chunk)
+		br.fs.RUnlock()
+		if err != nil {
+			br.n = -1
+			return 0, errors.Wrap(err, "read chunk of blob")
+		}
+		br.n = n
+	}
+	start := br.offset - n*blobChunkSize
+	if start >= int64(len(br.chunk)) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	read = copy(p, br.chunk[start:])
+	br.offset += int64(read)
+	return
+}
+
+func (br *blobReader) Close() error {
+	return nil
+}
+
+func (br *blobReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += br.offset
+	case io.SeekEnd:
+		offset += br.size
+	default:
+		return 0, errors.New("seek with an unknown whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("seek to before the start of the blob")
+	}
+	br.offset = offset
+	return offset, nil
+}
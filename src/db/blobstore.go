@@ -0,0 +1,346 @@
+package db
+
+import (
+	"database/sql"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+)
+
+// BlobStore keeps the data of uploads by their id. The database keeps it
+// by default, and stores that keep it outside of the database keep large
+// uploads out of its dumps. Each blob is marked with the name of the store
+// that has its data, so the blobs that were saved before the store was
+// changed can still be read.
+type BlobStore interface {
+	// Name is what the blobs that the store keeps are marked with
+	Name() string
+	// Put saves the data of a blob as it is read, replacing the data it
+	// had. The size is how much there is to read.
+	Put(id string, r io.Reader, size int64) error
+	// Open opens the data of a blob, which has a size, to be read and
+	// seeked in
+	Open(id string, size int64) (ReadSeekCloser, error)
+	// Delete removes the data of a blob, if it has any
+	Delete(id string) error
+}
+
+// ReadSeekCloser is the data of a blob, which is closed after it is read
+type ReadSeekCloser interface {
+	io.Reader
+	io.Seeker
+	io.Closer
+}
+
+// sqliteBlobs is the name of the store that keeps blobs in chunks in the
+// database
+const sqliteBlobs = "sqlite"
+
+// ParseBlobStore returns the store that is described by a path to a
+// directory, like "dir:/srv/rwtxt/uploads", or by the url of an S3 bucket
+// like "s3://bucket/prefix?endpoint=https://minio.example.com&region=us-east-1".
+// The keys of S3 come from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY.
+// An empty description, or "sqlite", is the database.
+func ParseBlobStore(description string) (store BlobStore, err error) {
+	switch {
+	case description == "" || description == sqliteBlobs:
+		return nil, nil
+	case strings.HasPrefix(description, "dir:"):
+		return NewDirStore(strings.TrimPrefix(description, "dir:"))
+	case strings.HasPrefix(description, "s3://"):
+		return ParseS3Store(description)
+	}
+	return nil, errors.Errorf("no blob store like %s, use dir:/path or s3://bucket", description)
+}
+
+// sqliteStore keeps the data of blobs in the database, in chunks, so that
+// big uploads are never all in memory
+type sqliteStore struct {
+	fs *FileSystem
+}
+
+func (s sqliteStore) Name() string {
+	return sqliteBlobs
+}
+
+func (s sqliteStore) Put(id string, r io.Reader, size int64) (err error) {
+	s.fs.Lock()
+	defer s.fs.Unlock()
+
+	tx, err := s.fs.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin Put")
+	}
+	_, err = writeChunks(tx, id, r)
+	if err != nil {
+		tx.Rollback()
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrap(err, "commit Put")
+	}
+	return
+}
+
+func (s sqliteStore) Open(id string, size int64) (ReadSeekCloser, error) {
+	return &blobReader{fs: s.fs, id: id, size: size, n: -1}, nil
+}
+
+// Delete does nothing, since the chunks of a blob are removed together
+// with the blob
+func (s sqliteStore) Delete(id string) error {
+	return nil
+}
+
+// DirStore keeps the data of blobs as files in a directory
+type DirStore struct {
+	dir string
+}
+
+// NewDirStore returns a store that keeps blobs in a directory, which is
+// made if it does not exist
+func NewDirStore(dir string) (s *DirStore, err error) {
+	if dir == "" {
+		return nil, errors.New("need a directory to keep blobs in")
+	}
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, errors.Wrap(err, "NewDirStore")
+	}
+	return &DirStore{dir: dir}, nil
+}
+
+// Name is "dir"
+func (s *DirStore) Name() string {
+	return "dir"
+}
+
+// path returns the file of a blob, for ids that can be the name of a file
+func (s *DirStore) path(id string) (string, error) {
+	if id == "" || id != filepath.Base(id) || strings.HasPrefix(id, ".") {
+		return "", errors.Errorf("%s can not be kept in a directory", id)
+	}
+	return filepath.Join(s.dir, id), nil
+}
+
+// Put writes the data to a file next to the one of the blob and renames
+// it, so that a blob is never read while it is half written
+func (s *DirStore) Put(id string, r io.Reader, size int64) (err error) {
+	path, err := s.path(id)
+	if err != nil {
+		return
+	}
+	f, err := ioutil.TempFile(s.dir, ".pending-")
+	if err != nil {
+		return errors.Wrap(err, "Put")
+	}
+	defer os.Remove(f.Name())
+	_, err = io.Copy(f, r)
+	if err == nil {
+		err = f.Sync()
+	}
+	if errClose := f.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		return errors.Wrap(err, "Put")
+	}
+	err = os.Rename(f.Name(), path)
+	if err != nil {
+		return errors.Wrap(err, "Put")
+	}
+	return
+}
+
+// Open opens the file of a blob
+func (s *DirStore) Open(id string, size int64) (ReadSeekCloser, error) {
+	path, err := s.path(id)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "Open")
+	}
+	return f, nil
+}
+
+// Delete removes the file of a blob
+func (s *DirStore) Delete(id string) (err error) {
+	path, err := s.path(id)
+	if err != nil {
+		return
+	}
+	err = os.Remove(path)
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	return
+}
+
+// blobStore returns the store that keeps the blobs that are marked with a
+// name
+func (fs *FileSystem) blobStore(name string) (store BlobStore, err error) {
+	if name == "" || name == sqliteBlobs {
+		return sqliteStore{fs: fs}, nil
+	}
+	if fs.blobs != nil && fs.blobs.Name() == name {
+		return fs.blobs, nil
+	}
+	return nil, errors.Errorf("blob is kept in the %s store, which is not used", name)
+}
+
+// putBlobStore returns the store that new blobs are kept in
+func (fs *FileSystem) putBlobStore() BlobStore {
+	if fs.blobs != nil {
+		return fs.blobs
+	}
+	return sqliteStore{fs: fs}
+}
+
+// spoolBlob reads a blob into a temporary file, so that it can be hashed
+// before it is kept and its size is known. The file is removed when it
+// is closed.
+func spoolBlob(r io.Reader, w io.Writer) (f *spooledBlob, size int64, err error) {
+	tmp, err := ioutil.TempFile("", "rwtxt-blob-")
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "spoolBlob")
+	}
+	f = &spooledBlob{tmp}
+	if w != nil {
+		r = io.TeeReader(r, w)
+	}
+	size, err = io.Copy(tmp, r)
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return nil, 0, errors.Wrap(err, "spoolBlob")
+	}
+	return
+}
+
+// spooledBlob is a temporary file with a blob in it
+type spooledBlob struct {
+	*os.File
+}
+
+func (f *spooledBlob) Close() error {
+	f.File.Close()
+	return os.Remove(f.Name())
+}
+
+// removeBlob removes an upload with its data and everything that refers
+// to it, returning the store that keeps its data, which it is removed
+// from with deleteStoredBlobs after the transaction is committed
+func removeBlob(tx *sql.Tx, id string) (store string, err error) {
+	err = tx.QueryRow(`SELECT IFNULL(store, '') FROM blobs WHERE id = ?`, id).Scan(&store)
+	if err != nil && err != sql.ErrNoRows {
+		return
+	}
+	for _, table := range []string{"blobs", "blob_domains", "blob_chunks", "blob_refs"} {
+		column := "blobid"
+		if table == "blobs" {
+			column = "id"
+		}
+		_, err = tx.Exec(`DELETE FROM `+table+` WHERE `+column+` = ?`, id)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// deleteStoredBlobs removes the data of removed blobs from the stores that
+// keep them outside of the database. The blobs are removed already, so
+// data that can not be removed is only logged.
+func (fs *FileSystem) deleteStoredBlobs(stores map[string]string) {
+	for id, name := range stores {
+		if name == "" || name == sqliteBlobs {
+			continue
+		}
+		store, err := fs.blobStore(name)
+		if err == nil {
+			err = store.Delete(id)
+		}
+		if err != nil {
+			log.Warnf("could not remove the data of %s: %s", id, err.Error())
+		}
+	}
+}
+
+// MoveBlobs moves the data of the blobs that are kept in the database to
+// the store that new blobs are kept in, returning how many were moved,
+// and vacuums the database so that it gets smaller
+func (fs *FileSystem) MoveBlobs() (moved int, err error) {
+	store := fs.putBlobStore()
+	if store.Name() == sqliteBlobs {
+		return 0, errors.New("blobs are kept in the database already")
+	}
+	ids, err := fs.getAllFromPreparedQuerySingleString(`SELECT id FROM blobs WHERE IFNULL(store, '') IN ('', ?) ORDER BY id`, sqliteBlobs)
+	if err != nil {
+		return
+	}
+	for _, id := range ids {
+		err = fs.moveBlob(store, id)
+		if err != nil {
+			return
+		}
+		moved++
+	}
+	if moved > 0 {
+		fs.Lock()
+		defer fs.Unlock()
+		_, err = fs.db.Exec(`VACUUM`)
+		if err != nil {
+			return moved, errors.Wrap(err, "vacuum MoveBlobs")
+		}
+	}
+	return
+}
+
+// moveBlob copies the data of a blob to a store, and then marks the blob
+// as kept there and removes its data from the database
+func (fs *FileSystem) moveBlob(store BlobStore, id string) (err error) {
+	rs, err := fs.OpenBlob(id)
+	if err != nil {
+		return
+	}
+	spooled, size, err := spoolBlob(rs, nil)
+	rs.Close()
+	if err != nil {
+		return
+	}
+	defer spooled.Close()
+	err = store.Put(id, spooled, size)
+	if err != nil {
+		return
+	}
+
+	fs.Lock()
+	defer fs.Unlock()
+	tx, err := fs.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin moveBlob")
+	}
+	_, err = tx.Exec(`UPDATE blobs SET data = NULL, size = ?, chunked = 1, store = ? WHERE id = ?`, size, store.Name(), id)
+	if err == nil {
+		_, err = tx.Exec(`DELETE FROM blob_chunks WHERE blobid = ?`, id)
+	}
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "exec moveBlob")
+	}
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrap(err, "commit moveBlob")
+	}
+	return
+}
@@ -0,0 +1,209 @@
+package db
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var clozeText = regexp.MustCompile(`\{\{c\d+::(.*?)(?:::(.*?))?\}\}`)
+
+// Card is a flashcard from a page, scheduled for review with SM-2
+type Card struct {
+	ID          string
+	FileID      string
+	Slug        string
+	Front       string
+	Back        string
+	Ease        float64
+	Interval    int
+	Repetitions int
+	Due         time.Time
+}
+
+// parseCards finds the flashcards in a text, which are either a question
+// and its answer
+//
+//	Q: What is the capital of France?
+//	A: Paris
+//
+// or a line with cloze deletions like "The capital of France is {{c1::Paris}}"
+func parseCards(data string) (cards []Card) {
+	lines := strings.Split(data, "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if strings.HasPrefix(line, "Q:") {
+			front := strings.TrimSpace(line[2:])
+			var back []string
+			j := i + 1
+			for ; j < len(lines); j++ {
+				next := strings.TrimSpace(lines[j])
+				if next == "" || strings.HasPrefix(next, "Q:") {
+					break
+				}
+				if len(back) == 0 {
+					if !strings.HasPrefix(next, "A:") {
+						break
+					}
+					next = strings.TrimSpace(next[2:])
+				}
+				back = append(back, next)
+			}
+			if front != "" && len(back) > 0 {
+				cards = append(cards, Card{Front: front, Back: strings.Join(back, "\n")})
+				i = j - 1
+			}
+		} else if clozeText.MatchString(line) {
+			front := clozeText.ReplaceAllStringFunc(line, func(s string) string {
+				hint := clozeText.FindStringSubmatch(s)[2]
+				if hint == "" {
+					hint = "..."
+				}
+				return "[" + hint + "]"
+			})
+			cards = append(cards, Card{Front: front, Back: clozeText.ReplaceAllString(line, "$1")})
+		}
+	}
+	return
+}
+
+// cardID identifies a card by its page and front, so that it keeps its
+// schedule when the rest of the page changes
+func cardID(fileid, front string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(fileid+"\n"+front)))[:16]
+}
+
+// setCards adds the new cards in the data of a file and removes the ones
+// that are gone, keeping the schedule of the others, as part of the
+// transaction that saves the file
+func (fs *FileSystem) setCards(tx *sql.Tx, fileid string, domainid int, data string) (err error) {
+	cards := parseCards(data)
+	ids := make([]interface{}, len(cards)+1)
+	ids[0] = fileid
+	for i := range cards {
+		ids[i+1] = cardID(fileid, cards[i].Front)
+	}
+
+	_, err = tx.Exec(`DELETE FROM cards WHERE fsid = ? AND id NOT IN (''`+strings.Repeat(",?", len(cards))+`)`, ids...)
+	if err != nil {
+		return errors.Wrap(err, "exec delete cards")
+	}
+	stmt, err := tx.Prepare(`INSERT OR IGNORE INTO cards (id, fsid, domainid, front, back, ease, interval, repetitions, due) VALUES (?,?,?,?,?,2.5,0,0,?)`)
+	if err != nil {
+		return errors.Wrap(err, "stmt setCards")
+	}
+	defer stmt.Close()
+	stmtBack, err := tx.Prepare(`UPDATE cards SET back = ? WHERE id = ?`)
+	if err != nil {
+		return errors.Wrap(err, "stmt setCards")
+	}
+	defer stmtBack.Close()
+	now := time.Now().UTC()
+	for i, c := range cards {
+		id := ids[i+1].(string)
+		_, err = stmt.Exec(id, fileid, domainid, c.Front, c.Back, now)
+		if err == nil {
+			_, err = stmtBack.Exec(c.Back, id)
+		}
+		if err != nil {
+			return errors.Wrap(err, "exec setCards")
+		}
+	}
+	return
+}
+
+// GetCards returns the cards in a domain, the ones due soonest first
+func (fs *FileSystem) GetCards(domain string) (cards []Card, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+	return fs.getCards(`domains.name = ?`, domain)
+}
+
+// GetDueCards returns the cards in a domain that are due for review
+func (fs *FileSystem) GetDueCards(domain string) (cards []Card, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+	return fs.getCards(`domains.name = ? AND cards.due <= ?`, domain, time.Now().UTC())
+}
+
+func (fs *FileSystem) getCards(where string, args ...interface{}) (cards []Card, err error) {
+	stmt, err := fs.db.Prepare(`
+	SELECT cards.id, cards.fsid, fs.slug, cards.front, cards.back, cards.ease, cards.interval, cards.repetitions, cards.due FROM cards
+	INNER JOIN fs ON cards.fsid=fs.id
+	INNER JOIN domains ON cards.domainid=domains.id
+	WHERE fs.deleted = 0 AND ` + where + `
+	ORDER BY cards.due, cards.id`)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query(args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	cards = []Card{}
+	for rows.Next() {
+		var c Card
+		err = rows.Scan(&c.ID, &c.FileID, &c.Slug, &c.Front, &c.Back, &c.Ease, &c.Interval, &c.Repetitions, &c.Due)
+		if err != nil {
+			err = errors.Wrap(err, "get rows of cards")
+			return
+		}
+		cards = append(cards, c)
+	}
+	err = rows.Err()
+	return
+}
+
+// GradeCard schedules the next review of a card with the SM-2 algorithm,
+// from how well it was remembered, from 0 (not at all) to 5 (perfectly)
+func (fs *FileSystem) GradeCard(domain, id string, grade int) (card Card, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	if grade < 0 || grade > 5 {
+		err = errors.New("grade must be from 0 to 5")
+		return
+	}
+	cards, err := fs.getCards(`domains.name = ? AND cards.id = ?`, domain, id)
+	if err != nil {
+		return
+	}
+	if len(cards) == 0 {
+		err = errors.New("no such card")
+		return
+	}
+	card = cards[0]
+
+	if grade < 3 {
+		card.Repetitions = 0
+		card.Interval = 1
+	} else {
+		card.Repetitions++
+		switch card.Repetitions {
+		case 1:
+			card.Interval = 1
+		case 2:
+			card.Interval = 6
+		default:
+			card.Interval = int(math.Round(float64(card.Interval) * card.Ease))
+		}
+	}
+	q := float64(5 - grade)
+	card.Ease = math.Max(1.3, card.Ease+0.1-q*(0.08+q*0.02))
+	card.Due = time.Now().UTC().AddDate(0, 0, card.Interval)
+
+	_, err = fs.db.Exec(`UPDATE cards SET ease = ?, interval = ?, repetitions = ?, due = ? WHERE id = ?`,
+		card.Ease, card.Interval, card.Repetitions, card.Due, card.ID)
+	if err != nil {
+		err = errors.Wrap(err, "exec GradeCard")
+	}
+	return
+}
@@ -0,0 +1,90 @@
+package db
+
+import (
+	"github.com/pkg/errors"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// Click is the number of times readers followed a link out of a domain
+type Click struct {
+	URL   string
+	Count int
+}
+
+// AddClick counts a click on an outbound link. Only the total is kept,
+// nothing about who clicked it.
+func (fs *FileSystem) AddClick(domain, url string) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	domainid, _, _, _ := fs.getDomainFromName(domain)
+	if domainid == 0 {
+		return errors.New("domain does not exist")
+	}
+	tx, err := fs.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin AddClick")
+	}
+	_, err = tx.Exec(`INSERT OR IGNORE INTO clicks (domainid, url, count) VALUES (?,?,0)`, domainid, url)
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "insert AddClick")
+	}
+	_, err = tx.Exec(`UPDATE clicks SET count = count + 1 WHERE domainid = ? AND url = ?`, domainid, url)
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "update AddClick")
+	}
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrap(err, "commit AddClick")
+	}
+	return
+}
+
+// GetClicks returns the most followed outbound links of a domain
+func (fs *FileSystem) GetClicks(domain string, num int) (clicks []Click, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+
+	stmt, err := fs.db.Prepare(`
+	SELECT clicks.url, clicks.count FROM clicks
+	INNER JOIN domains ON clicks.domainid=domains.id
+	WHERE domains.name = ?
+	ORDER BY clicks.count DESC LIMIT ?`)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query(domain, num)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	clicks = []Click{}
+	for rows.Next() {
+		var c Click
+		err = rows.Scan(&c.URL, &c.Count)
+		if err != nil {
+			err = errors.Wrap(err, "get rows of clicks")
+			return
+		}
+		clicks = append(clicks, c)
+	}
+	err = rows.Err()
+	return
+}
+
+// GetSecret returns a random secret for the instance with the given
+// name, making it the first time it is asked for
+func (fs *FileSystem) GetSecret(name string) (secret string, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	_, err = fs.db.Exec(`INSERT OR IGNORE INTO secrets (name, value) VALUES (?,?)`, name, utils.UUID()+utils.UUID()+utils.UUID())
+	if err != nil {
+		return
+	}
+	err = fs.db.QueryRow(`SELECT value FROM secrets WHERE name = ?`, name).Scan(&secret)
+	return
+}
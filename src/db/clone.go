@@ -0,0 +1,70 @@
+package db
+
+import (
+	"github.com/pkg/errors"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// CloneDomain copies every page and the settings of the src domain into a
+// new dst domain protected by password. Uploads are shared between domains
+// so links to them keep working. Version history is only copied if
+// keepHistory is set, otherwise each page starts fresh.
+func (fs *FileSystem) CloneDomain(src, dst, password string, keepHistory bool) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	srcid, _, ispublic, _ := fs.getDomainFromName(src)
+	if srcid == 0 {
+		return errors.New("domain " + src + " does not exist")
+	}
+	dstid, _, _, _ := fs.getDomainFromName(dst)
+	if dstid != 0 {
+		return errors.New("domain " + dst + " already exists")
+	}
+	if password == "" {
+		return errors.New("domain key cannot be empty")
+	}
+
+	err = fs.setDomain(dst, password)
+	if err != nil {
+		return
+	}
+	_, err = fs.db.Exec(`UPDATE domains SET ispublic = ? WHERE name = ?`, ispublic, dst)
+	if err != nil {
+		return errors.Wrap(err, "copying settings")
+	}
+
+	files, err := fs.getAllWithEmpty(src)
+	if err != nil {
+		return
+	}
+	for _, f := range files {
+		if f.Data == "" {
+			continue
+		}
+		err = f.LoadHistory()
+		if err != nil {
+			return
+		}
+		history := f.History
+		f.ID = utils.UUID()
+		f.Domain = dst
+		f.Views = 0
+		err = fs.save(f)
+		if err != nil {
+			return
+		}
+		if !keepHistory {
+			continue
+		}
+		historyBytes, errEncode := encodeHistory(history)
+		if errEncode != nil {
+			return errors.Wrap(errEncode, "copying history")
+		}
+		_, err = fs.db.Exec(`UPDATE fs SET history = ? WHERE id = ?`, historyBytes, f.ID)
+		if err != nil {
+			return errors.Wrap(err, "copying history")
+		}
+	}
+	return
+}
@@ -0,0 +1,173 @@
+package db
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/schollz/versionedtext"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// HistoryPolicy is how much of the history of its pages a domain keeps.
+// A version is kept if it is one of the newest Versions or is newer than
+// Days, and zero does not keep any by that measure. The newest version is
+// always kept, and a policy of zeros keeps every version.
+type HistoryPolicy struct {
+	Versions int `json:"versions"`
+	Days     int `json:"days"`
+}
+
+// compactHistory removes the versions of a history that are older than
+// both the newest keepN and since, which do not keep any when they are
+// zero. The oldest version that is left is stored whole, since the
+// versions that its diff was from are gone.
+func compactHistory(history versionedtext.VersionedText, keepN int, since time.Time) (compacted versionedtext.VersionedText, removed int) {
+	snapshots := history.GetSnapshots()
+	if len(snapshots) < 2 {
+		return history, 0
+	}
+	first := len(snapshots) - 1
+	if keepN > 0 && len(snapshots)-keepN < first {
+		first = len(snapshots) - keepN
+	}
+	if !since.IsZero() {
+		for i, timestamp := range snapshots[:first] {
+			if timestamp >= since.UnixNano() {
+				first = i
+				break
+			}
+		}
+	}
+	if first <= 0 {
+		return history, 0
+	}
+
+	vs := versions(history)
+	if len(vs) != len(snapshots) {
+		// a history that can not be rebuilt is left as it is
+		return history, 0
+	}
+	dmp := diffmatchpatch.New()
+	compacted = versionedtext.VersionedText{
+		CurrentText: history.CurrentText,
+		Diffs:       make(map[int64]string),
+	}
+	compacted.Diffs[snapshots[first]] = dmp.DiffToDelta(dmp.DiffMain("", vs[first].Data, false))
+	for _, timestamp := range snapshots[first+1:] {
+		compacted.Diffs[timestamp] = history.Diffs[timestamp]
+	}
+	return compacted, first
+}
+
+// CompactHistory removes the versions of a page besides the newest keepN,
+// returning how many were removed
+func (fs *FileSystem) CompactHistory(id string, keepN int) (removed int, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+	if keepN < 1 {
+		return 0, errors.New("need to keep at least one version")
+	}
+	return fs.compactPage(id, keepN, time.Time{})
+}
+
+func (fs *FileSystem) compactPage(id string, keepN int, since time.Time) (removed int, err error) {
+	var historyBytes []byte
+	err = fs.db.QueryRow(`SELECT history FROM fs WHERE id = ?`, id).Scan(&historyBytes)
+	if err != nil {
+		return 0, errors.Wrap(err, "get history of "+id)
+	}
+	if len(historyBytes) == 0 {
+		return
+	}
+	history, err := decodeHistory(historyBytes)
+	if err != nil {
+		return 0, errors.Wrap(err, "could not parse history of "+id)
+	}
+	history, removed = compactHistory(history, keepN, since)
+	if removed == 0 {
+		return
+	}
+	historyBytes, err = encodeHistory(history)
+	if err != nil {
+		return 0, err
+	}
+	_, err = fs.db.Exec(`UPDATE fs SET history = ? WHERE id = ?`, historyBytes, id)
+	if err != nil {
+		return 0, errors.Wrap(err, "compact history")
+	}
+	return
+}
+
+// SetHistoryPolicy changes how much of the history of its pages a domain
+// keeps
+func (fs *FileSystem) SetHistoryPolicy(domain string, policy HistoryPolicy) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+	if policy.Versions < 0 || policy.Days < 0 {
+		return errors.New("can not keep fewer than no versions")
+	}
+	res, err := fs.db.Exec(`UPDATE domains SET history_versions = ?, history_days = ? WHERE name = ?`, policy.Versions, policy.Days, domain)
+	if err != nil {
+		return errors.Wrap(err, "SetHistoryPolicy")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New("domain does not exist")
+	}
+	return
+}
+
+// GetHistoryPolicy returns how much of the history of its pages a domain
+// keeps
+func (fs *FileSystem) GetHistoryPolicy(domain string) (policy HistoryPolicy, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+	err = fs.db.QueryRow(`SELECT history_versions, history_days FROM domains WHERE name = ?`, domain).Scan(&policy.Versions, &policy.Days)
+	if err != nil {
+		err = errors.Wrap(err, "GetHistoryPolicy")
+	}
+	return
+}
+
+// ApplyHistoryPolicies removes the versions of the pages of every domain
+// that its policy does not keep, returning how many were removed
+func (fs *FileSystem) ApplyHistoryPolicies() (removed int, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	rows, err := fs.db.Query(`SELECT id, history_versions, history_days FROM domains WHERE history_versions > 0 OR history_days > 0`)
+	if err != nil {
+		return 0, errors.Wrap(err, "ApplyHistoryPolicies")
+	}
+	policies := make(map[int]HistoryPolicy)
+	for rows.Next() {
+		var domainid int
+		var policy HistoryPolicy
+		err = rows.Scan(&domainid, &policy.Versions, &policy.Days)
+		if err != nil {
+			rows.Close()
+			return 0, errors.Wrap(err, "get rows of ApplyHistoryPolicies")
+		}
+		policies[domainid] = policy
+	}
+	rows.Close()
+
+	for domainid, policy := range policies {
+		var since time.Time
+		if policy.Days > 0 {
+			since = time.Now().AddDate(0, 0, -policy.Days)
+		}
+		var ids []string
+		ids, err = fs.getAllFromPreparedQuerySingleString(`SELECT id FROM fs WHERE domainid = ?`, domainid)
+		if err != nil {
+			return
+		}
+		for _, id := range ids {
+			n, errCompact := fs.compactPage(id, policy.Versions, since)
+			if errCompact != nil {
+				return removed, errCompact
+			}
+			removed += n
+		}
+	}
+	return
+}
@@ -3,8 +3,9 @@ package db
 import (
 	"bufio"
 	"compress/gzip"
+	"context"
 	"database/sql"
-	"encoding/json"
+	"hash/fnv"
 	"html/template"
 	"os"
 	"strings"
@@ -12,17 +13,29 @@ import (
 	"time"
 
 	log "github.com/cihub/seelog"
-	_ "github.com/mattn/go-sqlite3"
 	"github.com/pkg/errors"
 	"github.com/schollz/rwtxt/src/utils"
 	"github.com/schollz/sqlite3dump"
 	"github.com/schollz/versionedtext"
 )
 
+// FileSystem is a database of domains and their files. Most things hold
+// its lock, but saves only hold the read lock and the lock of their file,
+// so that saves of different files do not wait for each other.
 type FileSystem struct {
 	name string
 	db   *sql.DB
+	// reads take the read lock, and so do saves, which only need to wait
+	// for the other saves of the same file. Changes to anything else take
+	// the write lock.
 	sync.RWMutex
+	files [64]sync.Mutex
+	index searchIndex
+	// blobs is the store that new blobs are kept in, which is the
+	// database when it is nil
+	blobs BlobStore
+	// watchers are told when the pages they watch change
+	watchers pageWatchers
 }
 
 // File is the basic unit that is saved
@@ -36,10 +49,38 @@ type File struct {
 	History  versionedtext.VersionedText
 	DataHTML template.HTML
 	Views    int
+	Archived bool
+	Source   string
+	// Author is the name of the user who saved the file, when they were
+	// signed in as one
+	Author string
+	// Matches is how many times a ranked search matched the file, and
+	// Snippet shows the best of them
+	Matches int
+	Snippet template.HTML
+	// Revision is the hash of the older version of the file that matched
+	// a search of its history, which is then in Data, and is empty when
+	// the file matched as it is now
+	Revision string
+	// Language is the language of the file, from the "lang" of its front
+	// matter, which is empty when it is in the language of its domain
+	Language string
+	// Visibility is whether the file is listed, unlisted or private, and
+	// Sensitive is whether it or its domain is marked sensitive, which are
+	// only read by Get
+	Visibility string
+	Sensitive  bool
+	// history is the History as it is stored, which is only read when it
+	// is loaded, since lists of files do not need it
+	history []byte
+	// version replaces the version vector of the file when it is saved,
+	// instead of counting the save as a save on the server
+	version VersionVector
 }
 
-// New will initialize a filesystem
-func New(name string) (fs *FileSystem, err error) {
+// New will initialize a filesystem, with the DefaultOptions unless other
+// options are given
+func New(name string, options ...Options) (fs *FileSystem, err error) {
 	fs = new(FileSystem)
 	if name == "" {
 		err = errors.New("database must have name")
@@ -47,7 +88,16 @@ func New(name string) (fs *FileSystem, err error) {
 	}
 	fs.name = name
 
-	fs.db, err = sql.Open("sqlite3", fs.name)
+	o := DefaultOptions
+	if len(options) > 0 {
+		o = options[0]
+	}
+	err = o.validate()
+	if err != nil {
+		return
+	}
+	fs.blobs = o.Blobs
+	fs.db, err = sql.Open(o.driverName(), o.dsn(fs.name))
 	if err != nil {
 		return
 	}
@@ -56,32 +106,38 @@ func New(name string) (fs *FileSystem, err error) {
 		err = errors.Wrap(err, "could not initialize")
 		return
 	}
+	err = fs.recoverIndex()
+	if err != nil {
+		err = errors.Wrap(err, "could not recover index")
+		return
+	}
 
 	return
 }
 
 func (fs *FileSystem) initializeDB() (err error) {
-	// if _, errHaveSQL := os.Stat(fs.name + ".sql.gz"); errHaveSQL == nil {
-	// 	fi, err := os.Open(fs.name + ".sql.gz")
-	// 	if err != nil {
-	// 		return err
-	// 	}
-	// 	defer fi.Close()
-
-	// 	fz, err := gzip.NewReader(fi)
-	// 	if err != nil {
-	// 		return err
-	// 	}
-	// 	defer fz.Close()
-
-	// 	s, err := ioutil.ReadAll(fz)
-	// 	if err != nil {
-	// 		return err
-	// 	}
-	// 	_, err = fs.db.Exec(string(s))
-	// 	return err
-	// }
-	sqlStmt := `CREATE TABLE IF NOT EXISTS 
+	// a new database starts from the dump of the one that it replaces,
+	// which is loaded once the tables are made
+	var tables int
+	err = fs.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table'`).Scan(&tables)
+	if err != nil {
+		err = errors.Wrap(err, "checking tables")
+		return
+	}
+
+	sqlStmt := `CREATE TABLE IF NOT EXISTS
+	schema_version (
+		version INTEGER NOT NULL PRIMARY KEY,
+		name TEXT,
+		applied TIMESTAMP
+	);`
+	_, err = fs.db.Exec(sqlStmt)
+	if err != nil {
+		err = errors.Wrap(err, "creating schema_version table")
+		return
+	}
+
+	sqlStmt = `CREATE TABLE IF NOT EXISTS 
 		fs (
 			id TEXT NOT NULL PRIMARY KEY,
 			domainid INTEGER,
@@ -89,7 +145,13 @@ func (fs *FileSystem) initializeDB() (err error) {
 			created TIMESTAMP,
 			modified TIMESTAMP,
 			history TEXT,
-			views INTEGER DEFAULT 0
+			views INTEGER DEFAULT 0,
+			archived INTEGER DEFAULT 0,
+			indexed INTEGER DEFAULT 1,
+			deleted INTEGER DEFAULT 0,
+			lang TEXT DEFAULT '',
+			visibility TEXT DEFAULT '',
+			sensitive INTEGER DEFAULT 0
 		);`
 	_, err = fs.db.Exec(sqlStmt)
 	if err != nil {
@@ -98,10 +160,11 @@ func (fs *FileSystem) initializeDB() (err error) {
 	}
 
 	sqlStmt = `CREATE VIRTUAL TABLE IF NOT EXISTS 
-		fts USING fts4 (id,data);`
+		fts USING fts5 (id UNINDEXED,data,slug,title,words);`
 	_, err = fs.db.Exec(sqlStmt)
 	if err != nil {
-		err = errors.Wrap(err, "creating virtual table")
+		err = errors.Wrap(err, "creating virtual table (rwtxt needs to be built with -tags fts5)")
+		return
 	}
 
 	sqlStmt = `CREATE TABLE IF NOT EXISTS 
@@ -109,7 +172,23 @@ func (fs *FileSystem) initializeDB() (err error) {
 		id INTEGER NOT NULL PRIMARY KEY,
 		name TEXT,
 		hashed_pass TEXT,
-		ispublic INTEGER DEFAULT 0
+		ispublic INTEGER DEFAULT 0,
+		expiring TIMESTAMP,
+		history_versions INTEGER DEFAULT 0,
+		history_days INTEGER DEFAULT 0,
+		lang TEXT DEFAULT '',
+		slug_transliterate INTEGER DEFAULT 0,
+		slug_length INTEGER DEFAULT 0,
+		slug_suffix INTEGER DEFAULT 0,
+		slug_date INTEGER DEFAULT 0,
+		noindex INTEGER DEFAULT 0,
+		readonly INTEGER DEFAULT 0,
+		sensitive INTEGER DEFAULT 0,
+		bandwidth_cap INTEGER DEFAULT 0,
+		bandwidth_placeholder TEXT DEFAULT '',
+		hotlink_referer INTEGER DEFAULT 0,
+		hotlink_allow TEXT DEFAULT '',
+		hotlink_signed INTEGER DEFAULT 0
 	);`
 	_, err = fs.db.Exec(sqlStmt)
 	if err != nil {
@@ -121,7 +200,11 @@ func (fs *FileSystem) initializeDB() (err error) {
 		id INTEGER NOT NULL PRIMARY KEY,
 		domainid INTEGER,
 		key TEXT,
-		lastused TIMESTAMP
+		lastused TIMESTAMP,
+		userid INTEGER DEFAULT 0,
+		role TEXT DEFAULT 'admin',
+		created TIMESTAMP,
+		remember INTEGER DEFAULT 1
 	);`
 	_, err = fs.db.Exec(sqlStmt)
 	if err != nil {
@@ -133,13 +216,130 @@ func (fs *FileSystem) initializeDB() (err error) {
 		id TEXT NOT NULL PRIMARY KEY,
 		name TEXT,
 		data BLOB,
-		views INTEGER DEFAULT 0
+		views INTEGER DEFAULT 0,
+		content_type TEXT DEFAULT '',
+		size INTEGER DEFAULT 0,
+		created TIMESTAMP,
+		store TEXT DEFAULT 'sqlite',
+		chunked INTEGER DEFAULT 0
 	);`
 	_, err = fs.db.Exec(sqlStmt)
 	if err != nil {
 		err = errors.Wrap(err, "creating domains table")
 	}
 
+	sqlStmt = `CREATE TABLE IF NOT EXISTS
+	blob_chunks (
+		blobid TEXT,
+		n INTEGER,
+		data BLOB,
+		PRIMARY KEY (blobid, n)
+	);`
+	_, err = fs.db.Exec(sqlStmt)
+	if err != nil {
+		err = errors.Wrap(err, "creating blob_chunks table")
+	}
+
+	sqlStmt = `CREATE TABLE IF NOT EXISTS
+	blob_domains (
+		blobid TEXT,
+		domainid INTEGER,
+		created TIMESTAMP,
+		PRIMARY KEY (blobid, domainid)
+	);`
+	_, err = fs.db.Exec(sqlStmt)
+	if err != nil {
+		err = errors.Wrap(err, "creating blob_domains table")
+	}
+
+	sqlStmt = `CREATE TABLE IF NOT EXISTS
+	blob_refs (
+		blobid TEXT,
+		domainid INTEGER,
+		fsid TEXT,
+		name TEXT,
+		created TIMESTAMP,
+		PRIMARY KEY (blobid, domainid, fsid, name)
+	);`
+	_, err = fs.db.Exec(sqlStmt)
+	if err != nil {
+		err = errors.Wrap(err, "creating blob_refs table")
+		return
+	}
+
+	sqlStmt = `CREATE TABLE IF NOT EXISTS
+	links (
+		from_id TEXT,
+		to_id TEXT,
+		to_slug TEXT,
+		domainid INTEGER,
+		PRIMARY KEY (from_id, to_id, to_slug)
+	);`
+	_, err = fs.db.Exec(sqlStmt)
+	if err != nil {
+		err = errors.Wrap(err, "creating links table")
+		return
+	}
+
+	sqlStmt = `CREATE TABLE IF NOT EXISTS
+	tokens (
+		id INTEGER NOT NULL PRIMARY KEY,
+		domainid INTEGER,
+		name TEXT,
+		hash TEXT,
+		scopes TEXT,
+		created TIMESTAMP,
+		expires TIMESTAMP,
+		lastused TIMESTAMP
+	);`
+	_, err = fs.db.Exec(sqlStmt)
+	if err != nil {
+		err = errors.Wrap(err, "creating tokens table")
+		return
+	}
+
+	sqlStmt = `CREATE TABLE IF NOT EXISTS
+	remote_backups (
+		domainid INTEGER,
+		target TEXT,
+		pushed TIMESTAMP,
+		PRIMARY KEY (domainid, target)
+	);`
+	_, err = fs.db.Exec(sqlStmt)
+	if err != nil {
+		err = errors.Wrap(err, "creating remote_backups table")
+		return
+	}
+
+	sqlStmt = `CREATE TABLE IF NOT EXISTS
+	remote_backup_pages (
+		domainid INTEGER,
+		target TEXT,
+		fsid TEXT,
+		version TEXT,
+		PRIMARY KEY (domainid, target, fsid)
+	);`
+	_, err = fs.db.Exec(sqlStmt)
+	if err != nil {
+		err = errors.Wrap(err, "creating remote_backup_pages table")
+		return
+	}
+
+	sqlStmt = `CREATE TABLE IF NOT EXISTS
+	blob_egress (
+		domainid INTEGER,
+		month TEXT,
+		bytes INTEGER DEFAULT 0,
+		requests INTEGER DEFAULT 0,
+		denied INTEGER DEFAULT 0,
+		PRIMARY KEY (domainid, month)
+	);`
+	_, err = fs.db.Exec(sqlStmt)
+	if err != nil {
+		err = errors.Wrap(err, "creating blob_egress table")
+		return
+	}
+
 	sqlStmt = `CREATE TABLE IF NOT EXISTS
 	similar (
 		id INTEGER NOT NULL PRIMARY KEY,
@@ -151,6 +351,390 @@ func (fs *FileSystem) initializeDB() (err error) {
 		err = errors.Wrap(err, "creating similarities table")
 	}
 
+	sqlStmt = `CREATE TABLE IF NOT EXISTS
+	previews (
+		url TEXT NOT NULL PRIMARY KEY,
+		title TEXT,
+		description TEXT,
+		image TEXT,
+		fetched TIMESTAMP
+	);`
+	_, err = fs.db.Exec(sqlStmt)
+	if err != nil {
+		err = errors.Wrap(err, "creating previews table")
+	}
+
+	sqlStmt = `CREATE TABLE IF NOT EXISTS
+	clicks (
+		domainid INTEGER,
+		url TEXT,
+		count INTEGER DEFAULT 0,
+		PRIMARY KEY (domainid, url)
+	);`
+	_, err = fs.db.Exec(sqlStmt)
+	if err != nil {
+		err = errors.Wrap(err, "creating clicks table")
+	}
+
+	sqlStmt = `CREATE TABLE IF NOT EXISTS
+	secrets (
+		name TEXT NOT NULL PRIMARY KEY,
+		value TEXT
+	);`
+	_, err = fs.db.Exec(sqlStmt)
+	if err != nil {
+		err = errors.Wrap(err, "creating secrets table")
+	}
+
+	sqlStmt = `CREATE TABLE IF NOT EXISTS
+	annotations (
+		id INTEGER NOT NULL PRIMARY KEY,
+		fsid TEXT,
+		domainid INTEGER,
+		quote TEXT,
+		prefix TEXT,
+		suffix TEXT,
+		comment TEXT,
+		created TIMESTAMP
+	);`
+	_, err = fs.db.Exec(sqlStmt)
+	if err != nil {
+		err = errors.Wrap(err, "creating annotations table")
+	}
+
+	sqlStmt = `CREATE TABLE IF NOT EXISTS
+	responses (
+		id INTEGER NOT NULL PRIMARY KEY,
+		fsid TEXT,
+		domainid INTEGER,
+		data TEXT,
+		source TEXT,
+		nonce TEXT DEFAULT '',
+		created TIMESTAMP
+	);`
+	_, err = fs.db.Exec(sqlStmt)
+	if err != nil {
+		err = errors.Wrap(err, "creating responses table")
+	}
+
+	sqlStmt = `CREATE TABLE IF NOT EXISTS
+	edits (
+		id INTEGER NOT NULL PRIMARY KEY,
+		fsid TEXT,
+		domainid INTEGER,
+		source TEXT,
+		author TEXT DEFAULT '',
+		version TEXT DEFAULT '',
+		created TIMESTAMP
+	);`
+	_, err = fs.db.Exec(sqlStmt)
+	if err != nil {
+		err = errors.Wrap(err, "creating edits table")
+	}
+
+	sqlStmt = `CREATE TABLE IF NOT EXISTS
+	times (
+		id INTEGER NOT NULL PRIMARY KEY,
+		fsid TEXT,
+		domainid INTEGER,
+		day TIMESTAMP,
+		seconds INTEGER,
+		tags TEXT,
+		note TEXT,
+		line TEXT
+	);`
+	_, err = fs.db.Exec(sqlStmt)
+	if err != nil {
+		err = errors.Wrap(err, "creating times table")
+	}
+
+	sqlStmt = `CREATE TABLE IF NOT EXISTS
+	cards (
+		id TEXT NOT NULL PRIMARY KEY,
+		fsid TEXT,
+		domainid INTEGER,
+		front TEXT,
+		back TEXT,
+		ease REAL,
+		interval INTEGER,
+		repetitions INTEGER,
+		due TIMESTAMP
+	);`
+	_, err = fs.db.Exec(sqlStmt)
+	if err != nil {
+		err = errors.Wrap(err, "creating cards table")
+	}
+
+	sqlStmt = `CREATE TABLE IF NOT EXISTS
+	stopwords (
+		domainid INTEGER,
+		word TEXT,
+		PRIMARY KEY(domainid, word)
+	);`
+	_, err = fs.db.Exec(sqlStmt)
+	if err != nil {
+		err = errors.Wrap(err, "creating stopwords table")
+		return
+	}
+
+	sqlStmt = `CREATE TABLE IF NOT EXISTS
+	synonyms (
+		domainid INTEGER,
+		grp INTEGER,
+		word TEXT
+	);`
+	_, err = fs.db.Exec(sqlStmt)
+	if err != nil {
+		err = errors.Wrap(err, "creating synonyms table")
+		return
+	}
+
+	sqlStmt = `CREATE TABLE IF NOT EXISTS
+	tags (
+		fsid TEXT,
+		domainid INTEGER,
+		tag TEXT,
+		manual INTEGER DEFAULT 0,
+		PRIMARY KEY(fsid, tag, manual)
+	);`
+	_, err = fs.db.Exec(sqlStmt)
+	if err != nil {
+		err = errors.Wrap(err, "creating tags table")
+		return
+	}
+
+	sqlStmt = `CREATE TABLE IF NOT EXISTS
+	users (
+		id INTEGER NOT NULL PRIMARY KEY,
+		name TEXT UNIQUE,
+		hashed_pass TEXT,
+		active INTEGER DEFAULT 1,
+		created TIMESTAMP
+	);`
+	_, err = fs.db.Exec(sqlStmt)
+	if err != nil {
+		err = errors.Wrap(err, "creating users table")
+	}
+
+	sqlStmt = `CREATE TABLE IF NOT EXISTS
+	roles (
+		userid INTEGER,
+		domainid INTEGER,
+		role TEXT,
+		PRIMARY KEY (userid, domainid)
+	);`
+	_, err = fs.db.Exec(sqlStmt)
+	if err != nil {
+		err = errors.Wrap(err, "creating roles table")
+	}
+
+	sqlStmt = `CREATE TABLE IF NOT EXISTS
+	feeds (
+		id INTEGER NOT NULL PRIMARY KEY,
+		domainid INTEGER,
+		url TEXT,
+		tag TEXT,
+		created TIMESTAMP,
+		polled TIMESTAMP,
+		error TEXT,
+		UNIQUE(domainid, url)
+	);`
+	_, err = fs.db.Exec(sqlStmt)
+	if err != nil {
+		err = errors.Wrap(err, "creating feeds table")
+		return
+	}
+
+	sqlStmt = `CREATE TABLE IF NOT EXISTS
+	feed_items (
+		feedid INTEGER,
+		guid TEXT,
+		fsid TEXT,
+		created TIMESTAMP,
+		PRIMARY KEY(feedid, guid)
+	);`
+	_, err = fs.db.Exec(sqlStmt)
+	if err != nil {
+		err = errors.Wrap(err, "creating feed_items table")
+		return
+	}
+
+	sqlStmt = `CREATE TABLE IF NOT EXISTS
+	clocks (
+		fsid TEXT,
+		device TEXT,
+		counter INTEGER,
+		PRIMARY KEY(fsid, device)
+	);`
+	_, err = fs.db.Exec(sqlStmt)
+	if err != nil {
+		err = errors.Wrap(err, "creating clocks table")
+		return
+	}
+
+	sqlStmt = `CREATE TABLE IF NOT EXISTS
+	mirrors (
+		id INTEGER NOT NULL PRIMARY KEY,
+		domainid INTEGER,
+		url TEXT,
+		remotedomain TEXT,
+		secret TEXT,
+		created TIMESTAMP,
+		pushed TIMESTAMP,
+		error TEXT
+	);`
+	_, err = fs.db.Exec(sqlStmt)
+	if err != nil {
+		err = errors.Wrap(err, "creating mirrors table")
+		return
+	}
+
+	sqlStmt = `CREATE TABLE IF NOT EXISTS
+	audit (
+		id INTEGER NOT NULL PRIMARY KEY,
+		created TIMESTAMP,
+		event TEXT,
+		domain TEXT,
+		page TEXT,
+		source TEXT,
+		detail TEXT
+	);`
+	_, err = fs.db.Exec(sqlStmt)
+	if err != nil {
+		err = errors.Wrap(err, "creating audit table")
+	}
+
+	sqlStmt = `CREATE TABLE IF NOT EXISTS
+	snapshots (
+		id INTEGER NOT NULL PRIMARY KEY,
+		domainid INTEGER,
+		created TIMESTAMP,
+		data TEXT
+	);`
+	_, err = fs.db.Exec(sqlStmt)
+	if err != nil {
+		err = errors.Wrap(err, "creating snapshots table")
+	}
+
+	sqlStmt = `CREATE TABLE IF NOT EXISTS
+	translations (
+		fsid TEXT,
+		lang TEXT,
+		domainid INTEGER,
+		translationid TEXT,
+		version TEXT,
+		translated TIMESTAMP,
+		PRIMARY KEY (fsid, lang)
+	);`
+	_, err = fs.db.Exec(sqlStmt)
+	if err != nil {
+		err = errors.Wrap(err, "creating translations table")
+	}
+
+	sqlStmt = `CREATE TABLE IF NOT EXISTS
+	readability (
+		fsid TEXT PRIMARY KEY,
+		domainid INTEGER,
+		words INTEGER,
+		sentences INTEGER,
+		syllables INTEGER,
+		longest INTEGER,
+		lengths TEXT
+	);`
+	_, err = fs.db.Exec(sqlStmt)
+	if err != nil {
+		err = errors.Wrap(err, "creating readability table")
+		return
+	}
+
+	sqlStmt = `CREATE TABLE IF NOT EXISTS
+	redirects (
+		pattern TEXT PRIMARY KEY,
+		domainid INTEGER,
+		slug TEXT,
+		hits INTEGER DEFAULT 0,
+		created TIMESTAMP
+	);`
+	_, err = fs.db.Exec(sqlStmt)
+	if err != nil {
+		err = errors.Wrap(err, "creating redirects table")
+		return
+	}
+
+	sqlStmt = `CREATE TABLE IF NOT EXISTS
+	not_found (
+		path TEXT PRIMARY KEY,
+		hits INTEGER DEFAULT 0,
+		first TIMESTAMP,
+		last TIMESTAMP,
+		domainid INTEGER DEFAULT 0,
+		referrer TEXT DEFAULT ''
+	);`
+	_, err = fs.db.Exec(sqlStmt)
+	if err != nil {
+		err = errors.Wrap(err, "creating not_found table")
+		return
+	}
+
+	sqlStmt = `CREATE TABLE IF NOT EXISTS
+	announcements (
+		id INTEGER NOT NULL PRIMARY KEY,
+		message TEXT,
+		severity TEXT,
+		starts TIMESTAMP,
+		ends TIMESTAMP,
+		created TIMESTAMP
+	);`
+	_, err = fs.db.Exec(sqlStmt)
+	if err != nil {
+		err = errors.Wrap(err, "creating announcements table")
+		return
+	}
+
+	sqlStmt = `CREATE TABLE IF NOT EXISTS
+	domain_renames (
+		name TEXT NOT NULL PRIMARY KEY,
+		domainid INTEGER,
+		created TIMESTAMP
+	);`
+	_, err = fs.db.Exec(sqlStmt)
+	if err != nil {
+		err = errors.Wrap(err, "creating domain_renames table")
+		return
+	}
+
+	sqlStmt = `CREATE TABLE IF NOT EXISTS
+	content_reports (
+		id INTEGER NOT NULL PRIMARY KEY,
+		domainid INTEGER,
+		fsid TEXT,
+		slug TEXT,
+		reason TEXT,
+		details TEXT,
+		reporter TEXT,
+		status TEXT,
+		visibility TEXT DEFAULT '',
+		created TIMESTAMP,
+		resolved TIMESTAMP
+	);`
+	_, err = fs.db.Exec(sqlStmt)
+	if err != nil {
+		err = errors.Wrap(err, "creating content_reports table")
+		return
+	}
+
+	if tables == 0 {
+		err = fs.loadDump()
+		if err != nil {
+			return
+		}
+	}
+	err = fs.migrate()
+	if err != nil {
+		err = errors.Wrap(err, "migrating")
+		return
+	}
+
 	domainid, _, _, _ := fs.getDomainFromName("public")
 	if domainid == 0 {
 		fs.setDomain("public", "")
@@ -167,10 +751,7 @@ func (fs *FileSystem) DumpSQL() (err error) {
 	defer fs.Unlock()
 
 	// first purge the database of old stuff
-	_, err = fs.db.Exec(`
-	DELETE FROM fs WHERE id IN (SELECT id FROM fts where data == '');
-	DELETE FROM fts WHERE data = '';
-	`)
+	err = fs.purge()
 	if err != nil {
 		return
 	}
@@ -188,6 +769,29 @@ func (fs *FileSystem) DumpSQL() (err error) {
 	return
 }
 
+// Purge deletes the pages that were deleted by emptying them, which
+// dumping the database also does
+func (fs *FileSystem) Purge() (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+	return fs.purge()
+}
+
+func (fs *FileSystem) purge() (err error) {
+	err = fs.flushIndex()
+	if err != nil {
+		return
+	}
+	_, err = fs.db.Exec(`
+	DELETE FROM fs WHERE deleted = 0 AND id IN (SELECT id FROM fts where data == '');
+	DELETE FROM fts WHERE data = '' AND id NOT IN (SELECT id FROM fs);
+	DELETE FROM tags WHERE fsid NOT IN (SELECT id FROM fs);
+	DELETE FROM readability WHERE fsid NOT IN (SELECT id FROM fs);
+	DELETE FROM links WHERE from_id NOT IN (SELECT id FROM fs);
+	`)
+	return
+}
+
 // NewFile returns a new file
 func (fs *FileSystem) NewFile(slug, data string) (f File) {
 	f = File{
@@ -202,10 +806,16 @@ func (fs *FileSystem) NewFile(slug, data string) (f File) {
 
 // SaveBlob will save a blob
 func (fs *FileSystem) SaveBlob(id string, name string, blob []byte) (err error) {
+	return fs.SaveBlobCtx(context.Background(), id, name, blob)
+}
+
+// SaveBlobCtx saves a blob, and rolls it back when the context is done
+// before it is saved
+func (fs *FileSystem) SaveBlobCtx(ctx context.Context, id string, name string, blob []byte) (err error) {
 	fs.Lock()
 	defer fs.Unlock()
 
-	tx, err := fs.db.Begin()
+	tx, err := fs.db.BeginTx(ctx, nil)
 	if err != nil {
 		return errors.Wrap(err, "begin SaveBlob")
 	}
@@ -226,7 +836,7 @@ func (fs *FileSystem) SaveBlob(id string, name string, blob []byte) (err error)
 	if err != nil {
 		return errors.Wrap(err, "stmt SaveBlob")
 	}
-	_, err = stmt.Exec(
+	_, err = stmt.ExecContext(ctx,
 		id, name, blob,
 	)
 	if err != nil {
@@ -240,19 +850,39 @@ func (fs *FileSystem) SaveBlob(id string, name string, blob []byte) (err error)
 	return
 }
 
-// GetBlob will save a blob
-func (fs *FileSystem) GetBlob(id string) (name string, data []byte, views int, err error) {
+// errChunkedBlob is returned for the blobs that are kept in chunks, which
+// are not gzipped as a whole and are read with OpenBlob
+var errChunkedBlob = errors.New("blob is kept in chunks, open it to read it")
+
+// GetBlob returns a blob and counts it as a view, if it can be read by
+// somebody who is signed in to the readable domains. Blobs of public
+// domains, and blobs that were uploaded before blobs had domains, can be
+// read by anybody.
+func (fs *FileSystem) GetBlob(id string, readable []string) (name string, data []byte, views int, err error) {
 	fs.Lock()
 	defer fs.Unlock()
 
-	stmt, err := fs.db.Prepare("SELECT name,data,views FROM blobs WHERE id = ?")
+	ok, err := fs.canReadBlob(id, readable)
+	if err != nil {
+		return
+	}
+	if !ok {
+		err = errors.New("not allowed to read the upload")
+		return
+	}
+
+	stmt, err := fs.db.Prepare("SELECT name,data,views,chunked FROM blobs WHERE id = ?")
 	if err != nil {
 		return
 	}
 	defer stmt.Close()
-	err = stmt.QueryRow(id).Scan(&name, &data, &views)
+	var chunked bool
+	err = stmt.QueryRow(id).Scan(&name, &data, &views, &chunked)
 	if err != nil {
 		return
+	} else if chunked {
+		err = errChunkedBlob
+		return
 	}
 
 	log.Debugf("id :%s, views: %d", id, views)
@@ -276,35 +906,195 @@ func (fs *FileSystem) GetBlob(id string) (name string, data []byte, views int, e
 	return
 }
 
-// Save a file to the file system. Will insert or ignore, and then update.
+// ReadBlob returns a blob without counting it as a view
+func (fs *FileSystem) ReadBlob(id string) (name string, data []byte, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+
+	stmt, err := fs.db.Prepare("SELECT name,data,chunked FROM blobs WHERE id = ?")
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	var chunked bool
+	err = stmt.QueryRow(id).Scan(&name, &data, &chunked)
+	if err == nil && chunked {
+		err = errChunkedBlob
+	}
+	return
+}
+
+// Save a file to the file system, inserting it or updating it in one
+// transaction.
 func (fs *FileSystem) Save(f File) (err error) {
-	fs.Lock()
-	defer fs.Unlock()
+	return fs.SaveCtx(context.Background(), f)
+}
+
+// SaveCtx saves a file unless the context is done before it starts. A
+// save that has started is finished, so that a page is never half saved.
+func (fs *FileSystem) SaveCtx(ctx context.Context, f File) (err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+	defer fs.lockFile(f.ID)()
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return fs.save(f)
+}
 
-	// get current history and then update the history
-	files, _ := fs.get(f.ID, f.Domain)
+// lockFile locks a stripe of the files that has a file, and returns what
+// unlocks it
+func (fs *FileSystem) lockFile(id string) (unlock func()) {
+	mu := &fs.files[fs.fileStripe(id)]
+	mu.Lock()
+	return mu.Unlock
+}
+
+// lockFiles locks the stripes that have some files, in order so that two
+// of them never wait for each other, and returns what unlocks them
+func (fs *FileSystem) lockFiles(ids []string) (unlock func()) {
+	stripes := make([]bool, len(fs.files))
+	for _, id := range ids {
+		stripes[fs.fileStripe(id)] = true
+	}
+	for i := range stripes {
+		if stripes[i] {
+			fs.files[i].Lock()
+		}
+	}
+	return func() {
+		for i := range stripes {
+			if stripes[i] {
+				fs.files[i].Unlock()
+			}
+		}
+	}
+}
+
+// fileStripe returns the stripe of the files that has a file
+func (fs *FileSystem) fileStripe(id string) int {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return int(h.Sum32() % uint32(len(fs.files)))
+}
+
+func (fs *FileSystem) save(f File) (err error) {
+	// make sure domain exists
+	if f.Domain == "" {
+		f.Domain = "public"
+	}
+	f.Domain = utils.NormalizeDomain(f.Domain)
+	f.Slug = utils.NormalizeSlug(f.Slug)
+	// get current history and then update the history. The page is
+	// found by its id alone, since a page whose slug is the id is another
+	// page, and with its history even when it is missing from the index.
+	// A page that can not be read is not saved over, which would lose its
+	// history.
+	files, err := fs.getFiles(context.Background(), `
+		SELECT fs.id,fs.slug,fs.created,fs.modified,COALESCE(fts.data,''),fs.history,fs.views,fs.archived FROM fs
+		LEFT JOIN fts ON fs.id=fts.id
+		INNER JOIN domains ON fs.domainid=domains.id
+		WHERE fs.id = ? AND domains.name = ?`, f.ID, f.Domain)
+	if err != nil {
+		return errors.Wrap(err, "get current version")
+	}
+	if len(files) == 0 {
+		// ids are unique across domains
+		var exists bool
+		err = fs.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM fs WHERE id = ?)`, f.ID).Scan(&exists)
+		if err != nil {
+			return errors.Wrap(err, "get current version")
+		}
+		if exists {
+			return errors.New("id is used in another domain")
+		}
+	}
+	err = loadHistories(files)
+	if err != nil {
+		return
+	}
+	// pages that had a reserved slug before it was reserved keep it until
+	// they are renamed with RenameReservedSlugs
+	if IsReservedSlug(f.Slug) && (len(files) != 1 || files[0].Slug != f.Slug) {
+		return ReservedSlugError{Slug: f.Slug}
+	}
 	if len(files) == 1 {
+		// saves that change nothing, like the periodic saves of the
+		// editor, do not make a version or change when it was modified
+		if files[0].ID == f.ID && files[0].Slug == f.Slug && files[0].Data == f.Data {
+			return
+		}
 		f.History = files[0].History
 		f.History.Update(f.Data)
 	} else {
 		f.History = versionedtext.NewVersionedText(f.Data)
 	}
-	// make sure domain exists
-	if f.Domain == "" {
-		f.Domain = "public"
-	}
+	return fs.write(f)
+}
+
+// write saves a file along with the history it has. The file, its index,
+// its time entries, its cards and its edit are saved in one transaction,
+// so a save that fails or crashes halfway leaves none of them changed.
+func (fs *FileSystem) write(f File) (err error) {
 	domainid, _, _, _ := fs.getDomainFromName(f.Domain)
 	if domainid == 0 {
 		return errors.New("domain does not exist")
 	}
-
-	tx, err := fs.db.Begin()
+	if err = fs.checkWritable(f.Domain); err != nil {
+		return
+	}
+	historyBytes, err := encodeHistory(f.History)
+	if err != nil {
+		return errors.Wrap(err, "encode history")
+	}
+
+	// flushes of the index lock the stripes of the pages they index, like
+	// saves do, so that the index never goes back to older data of a page
+	// while saves of other pages go on
+	tx, err := fs.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin Save")
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var ftsHasID bool
+	err = tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM fts WHERE id = ?)`, f.ID).Scan(&ftsHasID)
+	if err != nil {
+		return errors.Wrap(err, "doesExist")
+	}
+	var inTrash bool
+	err = tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM fs WHERE id = ? AND deleted = 1)`, f.ID).Scan(&inTrash)
 	if err != nil {
-		return errors.Wrap(err, "begin Save")
+		return errors.Wrap(err, "inTrash")
+	}
+	var oldSlug string
+	err = tx.QueryRow(`SELECT slug FROM fs WHERE id = ?`, f.ID).Scan(&oldSlug)
+	if err != nil && err != sql.ErrNoRows {
+		return errors.Wrap(err, "oldSlug")
+	}
+	// whether the page had text before is in the data that is waiting to
+	// be indexed, or else in the index
+	hadData := false
+	if data, ok := fs.pendingData(f.ID); ok {
+		hadData = data != ""
+	} else {
+		err = tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM fts WHERE id = ? AND LENGTH(data) > 0)`, f.ID).Scan(&hadData)
+		if err != nil {
+			return errors.Wrap(err, "hadData")
+		}
 	}
+	hadData = hadData && !inTrash
+	// new and deleted pages, and pages that are saved again after they
+	// were put in the trash, are indexed now, since the lists of pages
+	// come from the index, and the others are indexed later
+	indexNow := !ftsHasID || f.Data == "" || inTrash
 
-	stmt, err := tx.Prepare(`
-	INSERT OR IGNORE INTO
+	_, err = tx.Exec(`
+	INSERT INTO
 		fs
 	(
 		id,
@@ -312,7 +1102,8 @@ func (fs *FileSystem) Save(f File) (err error) {
 		slug,
 		created,
 		modified,
-		history
+		history,
+		indexed
 	) 
 		values 	
 	(
@@ -321,109 +1112,143 @@ func (fs *FileSystem) Save(f File) (err error) {
 		?,
 		?,
 		?,
+		?,
 		?
-	)`)
-	if err != nil {
-		return errors.Wrap(err, "stmt Save")
-	}
-
-	historyBytes, _ := json.Marshal(f.History)
-
-	_, err = stmt.Exec(
+	)
+	ON CONFLICT(id) DO UPDATE SET
+		slug = excluded.slug,
+		modified = excluded.modified,
+		history = excluded.history,
+		indexed = excluded.indexed,
+		deleted = 0`,
 		f.ID,
 		domainid,
 		f.Slug,
 		f.Created,
 		time.Now().UTC(),
-		string(historyBytes),
+		historyBytes,
+		indexNow,
 	)
 	if err != nil {
 		return errors.Wrap(err, "exec Save")
 	}
-	defer stmt.Close()
-	err = tx.Commit()
+	// links to the old slug of a page that is renamed go to its new one
+	err = fs.redirectSlug(tx, domainid, utils.NormalizeDomain(f.Domain), oldSlug, f.Slug)
 	if err != nil {
-		return errors.Wrap(err, "commit Save")
+		return
+	}
+
+	if indexNow {
+		sqlStmt := "INSERT INTO fts(data,slug,title,id) VALUES (?,?,?,?)"
+		if ftsHasID {
+			sqlStmt = "UPDATE fts SET data=?,slug=?,title=? WHERE id=?"
+		}
+		_, err = tx.Exec(sqlStmt, f.Data, f.Slug, pageTitle(f.Data), f.ID)
+		if err != nil {
+			return errors.Wrap(err, "exec virtual update")
+		}
+		err = fs.setLanguage(tx, f.ID, f.Data)
+		if err != nil {
+			return
+		}
 	}
 
-	// if it was ignored
-	tx2, err := fs.db.Begin()
+	err = fs.setTimeEntries(tx, f.ID, domainid, f.Data)
 	if err != nil {
-		return errors.Wrap(err, "begin Save")
+		return
 	}
-	stmt2, err := tx2.Prepare(`
-	UPDATE fs SET 
-		slug = ?,
-		modified = ?,
-		history = ?
-	WHERE
-		id = ?
-	`)
+	err = fs.setCards(tx, f.ID, domainid, f.Data)
 	if err != nil {
-		return errors.Wrap(err, "stmt update")
+		return
 	}
-	defer stmt2.Close()
-
-	_, err = stmt2.Exec(
-		f.Slug,
-		time.Now().UTC(),
-		string(historyBytes),
-		f.ID,
-	)
+	err = fs.setTags(tx, f.ID, domainid, f.Data)
 	if err != nil {
-		return errors.Wrap(err, "exec update")
+		return
 	}
-	err = tx2.Commit()
+	err = fs.setBlobRefs(tx, f.ID, domainid, f.Data)
 	if err != nil {
-		return errors.Wrap(err, "commit update")
+		return
 	}
-
-	// check if exists in fts
-	sqlStmt := "INSERT INTO fts(data,id) VALUES (?,?)"
-	var ftsHasID bool
-	ftsHasID, err = fs.idExists(f.ID)
+	err = fs.setLinks(tx, f.ID, domainid, utils.NormalizeDomain(f.Domain), f.Data)
 	if err != nil {
-		return errors.Wrap(err, "doesExist")
+		return
 	}
-	if ftsHasID {
-		sqlStmt = "UPDATE fts SET data=? WHERE id=?"
+	err = fs.setReadability(tx, f.ID, domainid, f.Data)
+	if err != nil {
+		return
 	}
 
-	// update the index
-	tx3, err := fs.db.Begin()
-	if err != nil {
-		return errors.Wrap(err, "begin virtual Save")
+	// record who made the edit
+	if f.Source != "" || f.Author != "" {
+		err = fs.addEdit(tx, f.ID, domainid, f.Source, f.Author, VersionHash(f.Data))
+		if err != nil {
+			return
+		}
 	}
-	stmt3, err := tx3.Prepare(sqlStmt)
+	err = fs.setVersion(tx, f.ID, f.version)
 	if err != nil {
-		return errors.Wrap(err, "stmt virtual update")
+		return
 	}
-	defer stmt3.Close()
 
-	_, err = stmt3.Exec(
-		f.Data,
-		f.ID,
-	)
+	fs.index.publishing.Lock()
+	defer fs.index.publishing.Unlock()
+	err = tx.Commit()
 	if err != nil {
-		return errors.Wrap(err, "exec virtual update")
+		return errors.Wrap(err, "commit Save")
 	}
-	err = tx3.Commit()
-	if err != nil {
-		return errors.Wrap(err, "commit virtual update")
+	if indexNow {
+		fs.unqueueIndex(f.ID)
+	} else {
+		fs.queueIndex(f.ID, domainid, f.Slug, f.Data)
+	}
+	fs.notifyWatchers(f.ID, VersionHash(f.Data))
+	if hadData && f.Data != "" {
+		fs.notifyDomainWatchers(EventUpdate, f, oldSlug)
+	} else if f.Data != "" {
+		fs.notifyDomainWatchers(EventCreate, f, oldSlug)
+	} else if hadData {
+		fs.notifyDomainWatchers(EventDelete, f, oldSlug)
 	}
 	return
+}
+
+// Duplicate copies the current contents of a file into a new file with
+// the given slug. Uploads are stored once and shared, so the links to
+// them in the copy keep working.
+func (fs *FileSystem) Duplicate(id, domain, newSlug string) (f File, err error) {
+	fs.Lock()
+	defer fs.Unlock()
 
+	files, err := fs.getAny(id, domain)
+	if err != nil {
+		return
+	}
+	f = File{
+		ID:       utils.UUID(),
+		Slug:     newSlug,
+		Created:  time.Now().UTC(),
+		Modified: time.Now().UTC(),
+		Data:     files[0].Data,
+		Domain:   domain,
+	}
+	err = fs.save(f)
+	return
 }
 
-// Close will make sure that the lock file is closed
+// Close will make sure that the lock file is closed, after indexing the
+// changes that are waiting
 func (fs *FileSystem) Close() (err error) {
+	err = fs.FlushIndex()
+	if err != nil {
+		return
+	}
 	return fs.db.Close()
 }
 
 // Len returns how many things
 func (fs *FileSystem) Len() (l int, err error) {
-	fs.Lock()
-	defer fs.Unlock()
+	fs.RLock()
+	defer fs.RUnlock()
 
 	// prepare statement
 	query := "SELECT COUNT(id) FROM FS"
@@ -469,37 +1294,118 @@ func (fs *FileSystem) SetKey(domain, password string) (key string, err error) {
 		err = errors.New("domain does not exist")
 		return
 	}
+	return fs.newKey(domainid, 0, RoleAdmin)
+}
+
+// NewKey makes a key for a domain without its password, for accounts that
+// were authenticated some other way, like with LDAP or SAML. The key is
+// for a user, if they were provisioned, and has their role in the domain.
+func (fs *FileSystem) NewKey(domain string, userid int, role string) (key string, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+	domainid, _, _, _ := fs.getDomainFromName(domain)
+	if domainid == 0 {
+		err = errors.New("domain does not exist")
+		return
+	}
+	return fs.newKey(domainid, userid, role)
+}
+
+func (fs *FileSystem) newKey(domainid, userid int, role string) (key string, err error) {
 	tx, err := fs.db.Begin()
 	if err != nil {
 		return
 	}
-	stmt, err := tx.Prepare("insert into keys(domainid,key,lastused) values(?, ?,?)")
+	stmt, err := tx.Prepare("insert into keys(domainid,key,lastused,userid,role,created,remember) values(?, ?,?,?,?,?,1)")
 	if err != nil {
+		tx.Rollback()
 		return
 	}
 	defer stmt.Close()
 	key = utils.UUID()
-	_, err = stmt.Exec(domainid, key, time.Now().UTC())
+	_, err = stmt.Exec(domainid, key, time.Now().UTC(), userid, role, time.Now().UTC())
 	if err != nil {
+		tx.Rollback()
 		return
 	}
 	err = tx.Commit()
 	return
 }
 
-// DeleteOldKeys deletes keys older than 5 days
-func (fs *FileSystem) DeleteOldKeys() (err error) {
+// DeleteOldKeys deletes keys that have not been used for the lifetime of
+// a session, or for longer if they are remembered
+func (fs *FileSystem) DeleteOldKeys(session, remember time.Duration) (err error) {
 	// first check if it is a domain
 	fs.Lock()
 	defer fs.Unlock()
 
 	// first purge the database of old stuff
-	stmt, err := fs.db.Prepare(`DELETE FROM keys WHERE lastused <= DATETIME('now','-5 days')`)
+	stmt, err := fs.db.Prepare(`DELETE FROM keys WHERE (remember = 0 AND lastused <= ?) OR (remember = 1 AND lastused <= ?) OR (remember = 2 AND lastused <= ?)`)
 	if err != nil {
 		return
 	}
 	defer stmt.Close()
-	_, err = stmt.Exec()
+	now := time.Now().UTC()
+	_, err = stmt.Exec(now.Add(-session), now.Add(-remember), now.Add(-time.Minute))
+	return
+}
+
+// RememberKeys sets whether keys are remembered, or only last a session
+func (fs *FileSystem) RememberKeys(keys []string, remember bool) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+	for _, key := range keys {
+		_, err = fs.db.Exec(`UPDATE keys SET remember = ? WHERE key = ? AND remember != 2`, remember, key)
+		if err != nil {
+			return errors.Wrap(err, "exec RememberKeys")
+		}
+	}
+	return
+}
+
+// RotateKeys replaces the remembered keys that were made before the given
+// age with new ones, so that a key that was copied stops working. The old
+// keys keep working for a minute, for requests that were already made with
+// them. It returns the new key for each one that was replaced, and whether
+// any of the keys are remembered.
+func (fs *FileSystem) RotateKeys(keys []string, age time.Duration) (rotated map[string]string, remember bool, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	rotated = make(map[string]string)
+	for _, key := range keys {
+		var domainid, userid, remembered int
+		var role string
+		var created *time.Time
+		err = fs.db.QueryRow(`SELECT domainid, userid, role, created, remember FROM keys WHERE key = ?`, key).Scan(&domainid, &userid, &role, &created, &remembered)
+		if err == sql.ErrNoRows {
+			err = nil
+			continue
+		} else if err != nil {
+			err = errors.Wrap(err, "get key")
+			return
+		}
+		// 0 is a session, 1 is remembered and 2 was already rotated
+		if remembered == 0 {
+			continue
+		}
+		remember = true
+		// keys from before keys had a creation time are rotated right away
+		if remembered == 2 || age <= 0 || (created != nil && time.Since(*created) < age) {
+			continue
+		}
+		var newKey string
+		newKey, err = fs.newKey(domainid, userid, role)
+		if err != nil {
+			return
+		}
+		_, err = fs.db.Exec(`UPDATE keys SET remember = 2, lastused = ? WHERE key = ?`, time.Now().UTC(), key)
+		if err != nil {
+			err = errors.Wrap(err, "exec RotateKeys")
+			return
+		}
+		rotated[key] = newKey
+	}
 	return
 }
 
@@ -543,8 +1449,8 @@ func (fs *FileSystem) UpdateViews(f File) (err error) {
 
 // CheckKeys checks that it is a valid key for a domain
 func (fs *FileSystem) CheckKeys(keys []string) (domains []string, validKeys []string, err error) {
-	fs.Lock()
-	defer fs.Unlock()
+	fs.RLock()
+	defer fs.RUnlock()
 
 	domains = make([]string, len(keys))
 	validKeys = make([]string, len(keys))
@@ -561,10 +1467,11 @@ func (fs *FileSystem) CheckKeys(keys []string) (domains []string, validKeys []st
 	return
 }
 
-// CheckKey checks that it is a valid key for a domain
+// CheckKey checks that it is a valid key for writing to a domain. The keys
+// of readers are not. API tokens that can write are keys too.
 func (fs *FileSystem) CheckKey(key string) (domain string, err error) {
-	fs.Lock()
-	defer fs.Unlock()
+	fs.RLock()
+	defer fs.RUnlock()
 	return fs.checkKey(key)
 }
 
@@ -578,12 +1485,18 @@ func (fs *FileSystem) checkKey(key string) (domain string, err error) {
 		ON keys.domainid=domains.id 
 
 	WHERE
-		keys.key=?`)
+		keys.key=? AND keys.role != ?`)
 	if err != nil {
 		return
 	}
 	defer stmt.Close()
-	err = stmt.QueryRow(key).Scan(&domain)
+	err = stmt.QueryRow(key, RoleReader).Scan(&domain)
+	if err == sql.ErrNoRows {
+		var role string
+		if domain, role = fs.tokenRole(key); role != "" && role != RoleReader {
+			err = nil
+		}
+	}
 	if err != nil {
 		return
 	}
@@ -604,7 +1517,7 @@ func (fs *FileSystem) UpdateKeys(keys []string) (err error) {
 		return
 	}
 	for _, key := range keys {
-		stmt, errUpdate := tx.Prepare("UPDATE keys SET lastused=? WHERE key=?")
+		stmt, errUpdate := tx.Prepare("UPDATE keys SET lastused=? WHERE key=? AND remember != 2")
 		if errUpdate != nil {
 			err = errUpdate
 			return
@@ -629,11 +1542,15 @@ func (fs *FileSystem) SetDomain(domain, password string) (err error) {
 		err = errors.New("domain already exists")
 		return
 	}
+	if renamed, _ := fs.renamedDomain(utils.NormalizeDomain(domain)); renamed != "" {
+		err = errors.New("domain was renamed to " + renamed)
+		return
+	}
 	return fs.setDomain(domain, password)
 }
 
 func (fs *FileSystem) setDomain(domain, password string) (err error) {
-	domain = strings.ToLower(domain)
+	domain = utils.NormalizeDomain(domain)
 	tx, err := fs.db.Begin()
 	if err != nil {
 		return errors.Wrap(err, "begin Save")
@@ -671,7 +1588,7 @@ func (fs *FileSystem) UpdateDomain(domain, password string, ispublic bool) (err
 		return
 	}
 
-	domain = strings.ToLower(domain)
+	domain = utils.NormalizeDomain(domain)
 	isPublicValue := 0
 	if ispublic {
 		isPublicValue = 1
@@ -723,14 +1640,14 @@ func (fs *FileSystem) UpdateDomain(domain, password string, ispublic bool) (err
 
 // ValidateDomain returns the domain id or an error if the password doesn't match or if the domain doesn't exist
 func (fs *FileSystem) ValidateDomain(domain, password string) (domainid int, err error) {
-	fs.Lock()
-	defer fs.Unlock()
+	fs.RLock()
+	defer fs.RUnlock()
 	return fs.validateDomain(domain, password)
 }
 
 // ValidateDomain returns the domain id or an error if the password doesn't match or if the domain doesn't exist
 func (fs *FileSystem) validateDomain(domain, password string) (domainid int, err error) {
-	domain = strings.ToLower(domain)
+	domain = utils.NormalizeDomain(domain)
 	domainid, hashedPassword, _, err := fs.getDomainFromName(domain)
 	if domainid == 0 {
 		err = errors.New("domain " + domain + " does not exist")
@@ -748,9 +1665,9 @@ func (fs *FileSystem) validateDomain(domain, password string) (domainid int, err
 
 // GetDomainFromName returns the domain id, throwing an error if it doesn't exist
 func (fs *FileSystem) GetDomainFromName(domain string) (domainid int, ispublic bool, err error) {
-	fs.Lock()
-	defer fs.Unlock()
-	domain = strings.ToLower(domain)
+	fs.RLock()
+	defer fs.RUnlock()
+	domain = utils.NormalizeDomain(domain)
 	var ispublicint int
 	domainid, _, ispublicint, err = fs.getDomainFromName(domain)
 	if domainid == 0 {
@@ -761,6 +1678,7 @@ func (fs *FileSystem) GetDomainFromName(domain string) (domainid int, ispublic b
 }
 
 func (fs *FileSystem) getDomainFromName(domain string) (domainid int, hashedPassword string, ispublic int, err error) {
+	domain = utils.NormalizeDomain(domain)
 	// prepare statement
 	query := "SELECT id,hashed_pass,ispublic FROM domains WHERE name = ?"
 	stmt, err := fs.db.Prepare(query)
@@ -845,24 +1763,32 @@ func (fs *FileSystem) SetSimilar(id string, similarids []string) (err error) {
 
 // GetAll returns all the files for a given domain
 func (fs *FileSystem) GetAll(domain string) (files []File, err error) {
-	fs.Lock()
-	defer fs.Unlock()
-	return fs.getAllFromPreparedQuery(`
-	SELECT fs.id,fs.slug,fs.created,fs.modified,fts.data,fs.history,fs.views FROM fs 
+	return fs.GetAllCtx(context.Background(), domain)
+}
+
+// GetAllCtx returns all the files for a given domain, and stops when the
+// context is done
+func (fs *FileSystem) GetAllCtx(ctx context.Context, domain string) (files []File, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+	return fs.getFiles(ctx, `
+	SELECT fs.id,fs.slug,fs.created,fs.modified,fts.data,fs.history,fs.views,fs.archived FROM fs 
 	INNER JOIN fts ON fs.id=fts.id 
 	INNER JOIN domains ON fs.domainid=domains.id
 	WHERE 
 		domains.name = ?
 		AND LENGTH(fts.data) > 0
-	ORDER BY fs.modified DESC`, domain)
+		AND fs.archived = 0
+		AND (? = 0 OR fs.visibility = '')
+	ORDER BY fs.modified DESC`, domain, publicReader(ctx))
 }
 
 // GetSimilar returns all the files for a given domain
 func (fs *FileSystem) GetSimilar(fileid string) (files []File, err error) {
-	fs.Lock()
-	defer fs.Unlock()
+	fs.RLock()
+	defer fs.RUnlock()
 	return fs.getAllFromPreparedQuery(`
-	SELECT fs.id,fs.slug,fs.created,fs.modified,fts.data,fs.history,fs.views FROM fs 
+	SELECT fs.id,fs.slug,fs.created,fs.modified,fts.data,fs.history,fs.views,fs.archived FROM fs 
 	INNER JOIN fts ON fs.id=fts.id 
 	WHERE 
 		LENGTH(fts.data) > 0
@@ -875,60 +1801,118 @@ func (fs *FileSystem) GetSimilar(fileid string) (files []File, err error) {
 
 // GetTopX returns the info from a file
 func (fs *FileSystem) GetTopX(domain string, num int) (files []File, err error) {
-	fs.Lock()
-	defer fs.Unlock()
-	return fs.getAllFromPreparedQuery(`
-	SELECT fs.id,fs.slug,fs.created,fs.modified,fts.data,fs.history,fs.views FROM fs 
+	return fs.GetTopXCtx(context.Background(), domain, num)
+}
+
+// GetTopXCtx returns the most recently modified files like GetTopX,
+// without the ones that are not listed for who reads with the context
+func (fs *FileSystem) GetTopXCtx(ctx context.Context, domain string, num int) (files []File, err error) {
+	files, _, err = fs.GetTopXOffsetCtx(ctx, domain, num, 0)
+	return
+}
+
+// GetTopXOffset returns num of the most recently modified files of a
+// domain after skipping offset of them, and how many files there are in
+// total so that they can be paged through
+func (fs *FileSystem) GetTopXOffset(domain string, num int, offset int) (files []File, total int, err error) {
+	return fs.GetTopXOffsetCtx(context.Background(), domain, num, offset)
+}
+
+// GetTopXOffsetCtx pages through the files like GetTopXOffset, without the
+// ones that are not listed for who reads with the context
+func (fs *FileSystem) GetTopXOffsetCtx(ctx context.Context, domain string, num int, offset int) (files []File, total int, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+	files, err = fs.getFiles(ctx, `
+	SELECT fs.id,fs.slug,fs.created,fs.modified,fts.data,fs.history,fs.views,fs.archived FROM fs 
+	INNER JOIN fts ON fs.id=fts.id 
+	INNER JOIN domains ON fs.domainid=domains.id
+	WHERE 
+		domains.name = ?
+		AND LENGTH(fts.data) > 0
+		AND fs.archived = 0
+		AND (? = 0 OR fs.visibility = '')
+	ORDER BY fs.modified DESC LIMIT ? OFFSET ?`, domain, publicReader(ctx), num, offset)
+	if err != nil {
+		return
+	}
+	total, err = fs.count(ctx, `
+	SELECT COUNT(*) FROM fs 
 	INNER JOIN fts ON fs.id=fts.id 
 	INNER JOIN domains ON fs.domainid=domains.id
 	WHERE 
 		domains.name = ?
 		AND LENGTH(fts.data) > 0
-	ORDER BY fs.modified DESC LIMIT ?`, domain, num)
+		AND fs.archived = 0
+		AND (? = 0 OR fs.visibility = '')`, domain, publicReader(ctx))
+	return
 }
 
 // GetTopX returns the info from a file
 func (fs *FileSystem) GetTopXMostViews(domain string, num int) (files []File, err error) {
-	fs.Lock()
-	defer fs.Unlock()
-	return fs.getAllFromPreparedQuery(`
-	SELECT fs.id,fs.slug,fs.created,fs.modified,fts.data,fs.history,fs.views FROM fs 
+	return fs.GetTopXMostViewsCtx(context.Background(), domain, num)
+}
+
+// GetTopXMostViewsCtx returns the most viewed files like GetTopXMostViews,
+// without the ones that are not listed for who reads with the context
+func (fs *FileSystem) GetTopXMostViewsCtx(ctx context.Context, domain string, num int) (files []File, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+	return fs.getFiles(ctx, `
+	SELECT fs.id,fs.slug,fs.created,fs.modified,fts.data,fs.history,fs.views,fs.archived FROM fs 
 	INNER JOIN fts ON fs.id=fts.id 
 	INNER JOIN domains ON fs.domainid=domains.id
 	WHERE 
 		domains.name = ?
 		AND LENGTH(fts.data) > 0
-	ORDER BY fs.views DESC LIMIT ?`, domain, num)
+		AND fs.archived = 0
+		AND (? = 0 OR fs.visibility = '')
+	ORDER BY fs.views DESC LIMIT ?`, domain, publicReader(ctx), num)
 }
 
 // Get returns the info from a file
 func (fs *FileSystem) Get(id string, domain string) (files []File, err error) {
-	fs.Lock()
-	defer fs.Unlock()
-	return fs.get(id, domain)
+	return fs.GetCtx(context.Background(), id, domain)
+}
+
+// GetCtx returns the info from a file, and stops when the context is done
+func (fs *FileSystem) GetCtx(ctx context.Context, id string, domain string) (files []File, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+	return fs.getCtx(ctx, id, domain)
 }
 
-func (fs *FileSystem) get(id string, domain string) (files []File, err error) {
+// getAny returns a file whatever its visibility, for the changes that the
+// caller was already allowed to make
+func (fs *FileSystem) getAny(id string, domain string) (files []File, err error) {
+	return fs.getCtx(allPages(), id, domain)
+}
 
-	files, err = fs.getAllFromPreparedQuery(`
-		SELECT fs.id,fs.slug,fs.created,fs.modified,fts.data,fs.history,fs.views FROM fs 
+func (fs *FileSystem) getCtx(ctx context.Context, id string, domain string) (files []File, err error) {
+	files, err = fs.getFiles(ctx, `
+		SELECT fs.id,fs.slug,fs.created,fs.modified,fts.data,fs.history,fs.views,fs.archived FROM fs 
 		INNER JOIN fts ON fs.id=fts.id 
 		INNER JOIN domains ON fs.domainid=domains.id
 		WHERE 
 			fs.id = ? 
 			AND
 			domains.name = ?
-		ORDER BY modified DESC`, id, domain)
+			AND (? = 0 OR fs.visibility != 'private')
+		ORDER BY modified DESC`, id, domain, publicReader(ctx))
 	if err != nil {
 		err = errors.Wrap(err, "get from id")
 		return
 	}
 	if len(files) > 0 {
+		err = fs.loadVisibilities(ctx, files)
+		if err == nil {
+			err = loadHistories(files)
+		}
 		return
 	}
 
-	files, err = fs.getAllFromPreparedQuery(`
-	SELECT fs.id,fs.slug,fs.created,fs.modified,fts.data,fs.history,fs.views
+	files, err = fs.getFiles(ctx, `
+	SELECT fs.id,fs.slug,fs.created,fs.modified,fts.data,fs.history,fs.views,fs.archived
 	FROM fs 
 	INNER JOIN fts ON fs.id=fts.id 
 	INNER JOIN domains ON fs.domainid=domains.id
@@ -936,12 +1920,17 @@ func (fs *FileSystem) get(id string, domain string) (files []File, err error) {
 		fs.id IN (SELECT id FROM fs WHERE slug=?) 
 		AND
 		domains.name = ?
-		ORDER BY modified DESC`, id, domain)
+		AND (? = 0 OR fs.visibility != 'private')
+		ORDER BY modified DESC`, utils.NormalizeSlug(id), domain, publicReader(ctx))
 	if err != nil {
 		err = errors.Wrap(err, "get from slug")
 		return
 	}
 	if len(files) > 0 {
+		err = fs.loadVisibilities(ctx, files)
+		if err == nil {
+			err = loadHistories(files)
+		}
 		return
 	}
 
@@ -982,18 +1971,149 @@ func (fs *FileSystem) LastModified() (lastModified time.Time, err error) {
 	return
 }
 
-// Find returns the info from a file
+// Find returns the info from a file. Archived pages are only
+// included if the text contains "include:archived". Older versions of
+// pages are also searched if the text contains "include:history", and a
+// page that only matched in its history has the newest version that
+// matched as its data, with the hash of that version as its Revision.
 func (fs *FileSystem) Find(text string, domain string) (files []File, err error) {
-	fs.Lock()
-	defer fs.Unlock()
+	return fs.FindCtx(context.Background(), text, domain)
+}
+
+// FindCtx finds files like Find, and stops when the context is done
+func (fs *FileSystem) FindCtx(ctx context.Context, text string, domain string) (files []File, err error) {
+	files, _, err = fs.FindOffsetCtx(ctx, text, domain, -1, 0)
+	return
+}
 
-	files, err = fs.getAllFromPreparedQuery(`
-		SELECT fs.id,fs.slug,fs.created,fs.modified,snippet(fts),fs.history,fs.views FROM fts 
+// FindOffset finds files like Find, but returns only num of them after
+// skipping offset of them, and how many were found in total. A negative
+// num returns all of them.
+func (fs *FileSystem) FindOffset(text string, domain string, num int, offset int) (files []File, total int, err error) {
+	return fs.FindOffsetCtx(context.Background(), text, domain, num, offset)
+}
+
+// FindOffsetCtx finds files like FindOffset, and stops when the context
+// is done
+func (fs *FileSystem) FindOffsetCtx(ctx context.Context, text string, domain string, num int, offset int) (files []File, total int, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+
+	includeArchived := 0
+	if strings.Contains(text, "include:archived") {
+		text = strings.TrimSpace(strings.Replace(text, "include:archived", "", -1))
+		includeArchived = 1
+	}
+	queryNum, queryOffset := num, offset
+	includeHistory := strings.Contains(text, "include:history")
+	if includeHistory {
+		text = strings.TrimSpace(strings.Replace(text, "include:history", "", -1))
+		queryNum, queryOffset = -1, 0
+	}
+
+	files, err = fs.queryFiles(ctx, `
+		SELECT fs.id,fs.slug,fs.created,fs.modified,snippet(fts,1,'<b>','</b>','<b>...</b>',15),fs.history,fs.views,fs.archived FROM fts 
 			INNER JOIN fs ON fs.id=fts.id 
 			INNER JOIN domains ON fs.domainid=domains.id
-			WHERE fts.data MATCH ?
+			WHERE fts MATCH ?
 			AND domains.name = ?
-			ORDER BY modified DESC`, text, domain)
+			AND fs.archived <= ?
+			AND (? = 0 OR fs.visibility = '')
+			ORDER BY modified DESC LIMIT ? OFFSET ?`, fs.searchQuery(text, domain), domain, includeArchived, publicReader(ctx), queryNum, queryOffset)
+	if err != nil {
+		return
+	}
+	if includeHistory {
+		var older []File
+		older, err = fs.findHistory(ctx, text, domain, includeArchived, files)
+		if err != nil {
+			return
+		}
+		files = append(files, older...)
+		total = len(files)
+		files = pageFiles(files, num, offset)
+		return
+	}
+	total, err = fs.countMatches(ctx, text, domain, includeArchived, num, offset, len(files))
+	return
+}
+
+// GetArchived returns all the archived files for a given domain
+func (fs *FileSystem) GetArchived(domain string) (files []File, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+	return fs.getAllFromPreparedQuery(`
+	SELECT fs.id,fs.slug,fs.created,fs.modified,fts.data,fs.history,fs.views,fs.archived FROM fs 
+	INNER JOIN fts ON fs.id=fts.id 
+	INNER JOIN domains ON fs.domainid=domains.id
+	WHERE 
+		domains.name = ?
+		AND LENGTH(fts.data) > 0
+		AND fs.archived = 1
+	ORDER BY fs.modified DESC`, domain)
+}
+
+// SetArchived archives or unarchives the files with the given ids or
+// slugs in a domain, returning how many were changed
+func (fs *FileSystem) SetArchived(domain string, ids []string, archived bool) (changed int, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	if err = fs.checkWritable(domain); err != nil {
+		return
+	}
+	domainid, _, _, _ := fs.getDomainFromName(domain)
+	if domainid == 0 {
+		err = errors.New("domain does not exist")
+		return
+	}
+	archivedValue := 0
+	if archived {
+		archivedValue = 1
+	}
+
+	tx, err := fs.db.Begin()
+	if err != nil {
+		return 0, errors.Wrap(err, "begin SetArchived")
+	}
+	stmt, err := tx.Prepare(`UPDATE fs SET archived = ? WHERE domainid = ? AND (id = ? OR slug = ?)`)
+	if err != nil {
+		return 0, errors.Wrap(err, "stmt SetArchived")
+	}
+	defer stmt.Close()
+	for _, id := range ids {
+		res, errExec := stmt.Exec(archivedValue, domainid, id, utils.NormalizeSlug(id))
+		if errExec != nil {
+			tx.Rollback()
+			return 0, errors.Wrap(errExec, "exec SetArchived")
+		}
+		n, _ := res.RowsAffected()
+		changed += int(n)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return 0, errors.Wrap(err, "commit SetArchived")
+	}
+	return
+}
+
+// ArchiveOlderThan archives every file in a domain that has not been
+// modified since the given time
+func (fs *FileSystem) ArchiveOlderThan(domain string, before time.Time) (changed int, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	domainid, _, _, _ := fs.getDomainFromName(domain)
+	if domainid == 0 {
+		err = errors.New("domain does not exist")
+		return
+	}
+	res, err := fs.db.Exec(`UPDATE fs SET archived = 1 WHERE domainid = ? AND archived = 0 AND modified < ?`, domainid, before.UTC())
+	if err != nil {
+		return 0, errors.Wrap(err, "exec ArchiveOlderThan")
+	}
+	n, _ := res.RowsAffected()
+	changed = int(n)
 	return
 }
 
@@ -1012,8 +2132,8 @@ func (fs *FileSystem) idExists(id string) (exists bool, err error) {
 
 // Exists returns whether specified id or slug exists
 func (fs *FileSystem) Exists(id string, domain string) (exists bool, err error) {
-	fs.Lock()
-	defer fs.Unlock()
+	fs.RLock()
+	defer fs.RUnlock()
 
 	files, err := fs.getAllFromPreparedQuerySingleString(`
 		SELECT fs.id FROM fs INNER JOIN domains ON fs.domainid=domains.id WHERE fs.id = ? AND domains.name = ?`, id, domain)
@@ -1029,7 +2149,7 @@ func (fs *FileSystem) Exists(id string, domain string) (exists bool, err error)
 	files, err = fs.getAllFromPreparedQuerySingleString(`
 	SELECT fs.id FROM fs 
 	INNER JOIN domains ON fs.domainid=domains.id
-	WHERE fs.slug = ? AND domains.name = ?`, id, domain)
+	WHERE fs.slug = ? AND domains.name = ?`, utils.NormalizeSlug(id), domain)
 	if err != nil {
 		err = errors.Wrap(err, "Exists")
 		return
@@ -1042,15 +2162,36 @@ func (fs *FileSystem) Exists(id string, domain string) (exists bool, err error)
 }
 
 func (fs *FileSystem) getAllFromPreparedQuery(query string, args ...interface{}) (files []File, err error) {
+	return fs.getFiles(context.Background(), query, args...)
+}
+
+// getFiles returns files with the changes that are not indexed yet, and
+// stops when the context is done
+func (fs *FileSystem) getFiles(ctx context.Context, query string, args ...interface{}) (files []File, err error) {
+	fs.index.publishing.RLock()
+	defer fs.index.publishing.RUnlock()
+	files, err = fs.queryFiles(ctx, query, args...)
+	for i := range files {
+		if data, ok := fs.pendingData(files[i].ID); ok {
+			files[i].Data = data
+			files[i].DataHTML = template.HTML(data)
+		}
+		files[i].Language = pageLanguage(files[i].Data)
+	}
+	return
+}
+
+// queryFiles returns files as they are in the index
+func (fs *FileSystem) queryFiles(ctx context.Context, query string, args ...interface{}) (files []File, err error) {
 	// prepare statement
-	stmt, err := fs.db.Prepare(query)
+	stmt, err := fs.db.PrepareContext(ctx, query)
 	if err != nil {
 		err = errors.Wrap(err, "preparing query: "+query)
 		return
 	}
 
 	defer stmt.Close()
-	rows, err := stmt.Query(args...)
+	rows, err := stmt.QueryContext(ctx, args...)
 	if err != nil {
 		err = errors.Wrap(err, query)
 		return
@@ -1061,7 +2202,7 @@ func (fs *FileSystem) getAllFromPreparedQuery(query string, args ...interface{})
 	files = []File{}
 	for rows.Next() {
 		var f File
-		var history sql.NullString
+		var history []byte
 		err = rows.Scan(
 			&f.ID,
 			&f.Slug,
@@ -1070,18 +2211,13 @@ func (fs *FileSystem) getAllFromPreparedQuery(query string, args ...interface{})
 			&f.Data,
 			&history,
 			&f.Views,
+			&f.Archived,
 		)
 		if err != nil {
 			err = errors.Wrap(err, "get rows of file")
 			return
 		}
-		if history.Valid {
-			err = json.Unmarshal([]byte(history.String), &f.History)
-			if err != nil {
-				err = errors.Wrap(err, "could not parse history")
-				return
-			}
-		}
+		f.history = history
 		f.DataHTML = template.HTML(f.Data)
 		files = append(files, f)
 	}
@@ -1092,6 +2228,15 @@ func (fs *FileSystem) getAllFromPreparedQuery(query string, args ...interface{})
 	return
 }
 
+// count returns the number from a query that counts rows
+func (fs *FileSystem) count(ctx context.Context, query string, args ...interface{}) (n int, err error) {
+	err = fs.db.QueryRowContext(ctx, query, args...).Scan(&n)
+	if err != nil {
+		err = errors.Wrap(err, "count")
+	}
+	return
+}
+
 func (fs *FileSystem) getAllFromPreparedQuerySingleString(query string, args ...interface{}) (s []string, err error) {
 	// prepare statement
 	stmt, err := fs.db.Prepare(query)
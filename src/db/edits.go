@@ -0,0 +1,227 @@
+package db
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// addEdit records the source and author of a save, and the version it
+// made, as part of the transaction that saves the file
+func (fs *FileSystem) addEdit(tx *sql.Tx, fileid string, domainid int, source, author, version string) (err error) {
+	_, err = tx.Exec(`INSERT INTO edits (fsid, domainid, source, author, version, created) VALUES (?,?,?,?,?,?)`,
+		fileid, domainid, source, author, version, time.Now().UTC())
+	if err != nil {
+		return errors.Wrap(err, "exec addEdit")
+	}
+	return
+}
+
+// RevertPlan is what reverting the edits of a source would change, for
+// checking before anything is changed. The token confirms the revert, and
+// only works while the same pages would change in the same way.
+type RevertPlan struct {
+	Domain  string
+	Source  string
+	From    time.Time
+	To      time.Time
+	Pages   []RevertChange
+	Skipped int
+	Token   string
+}
+
+// RevertChange is a page that a revert would change
+type RevertChange struct {
+	ID     string
+	Slug   string
+	Before int
+	After  int
+	Diff   string
+	data   string
+}
+
+// BytesFreed is how much smaller the pages would get
+func (plan RevertPlan) BytesFreed() (freed int) {
+	for _, p := range plan.Pages {
+		freed += p.Before - p.After
+	}
+	return
+}
+
+// PlanRevert returns what reverting the edits of a source would change,
+// without changing anything
+func (fs *FileSystem) PlanRevert(domain, source string, from, to time.Time) (plan RevertPlan, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+	return fs.planRevert(domain, source, from, to)
+}
+
+func (fs *FileSystem) planRevert(domain, source string, from, to time.Time) (plan RevertPlan, err error) {
+	plan = RevertPlan{Domain: domain, Source: source, From: from.UTC(), To: to.UTC(), Pages: []RevertChange{}}
+	fileids, err := fs.getAllFromPreparedQuerySingleString(`
+	SELECT DISTINCT edits.fsid FROM edits
+	INNER JOIN domains ON edits.domainid=domains.id
+	WHERE
+		domains.name = ?
+		AND edits.source = ?
+		AND edits.created >= ?
+		AND edits.created <= ?`, domain, source, from.UTC(), to.UTC())
+	if err != nil {
+		return
+	}
+
+	token := sha256.New()
+	fmt.Fprintf(token, "%s\n%s\n", domain, source)
+	for _, fileid := range fileids {
+		var others []string
+		others, err = fs.getAllFromPreparedQuerySingleString(`
+		SELECT id FROM edits WHERE fsid = ? AND source != ? AND created >= ?`, fileid, source, from.UTC())
+		if err != nil {
+			return
+		}
+		if len(others) > 0 {
+			log.Debugf("not reverting %s, edited by others", fileid)
+			plan.Skipped++
+			continue
+		}
+
+		var files []File
+		files, err = fs.getAny(fileid, domain)
+		if err != nil {
+			return
+		}
+		f := files[0]
+		data, errData := dataAtTime(f, from)
+		if errData != nil {
+			log.Debugf("could not rebuild %s: %s", f.ID, errData.Error())
+			plan.Skipped++
+			continue
+		}
+		if data == f.Data {
+			continue
+		}
+		plan.Pages = append(plan.Pages, RevertChange{
+			ID:     f.ID,
+			Slug:   f.Slug,
+			Before: len(f.Data),
+			After:  len(data),
+			Diff:   lineDiff(f.Data, data, 10),
+			data:   data,
+		})
+		fmt.Fprintf(token, "%s\n%s\n%s\n", f.ID, VersionHash(f.Data), VersionHash(data))
+	}
+	plan.Token = fmt.Sprintf("%x", token.Sum(nil))[:16]
+	return
+}
+
+// lineDiff shows the lines that change between two texts, up to a number
+// of lines
+func lineDiff(before, after string, max int) string {
+	// empty texts have no lines, rather than one empty line
+	if before != "" {
+		before += "\n"
+	}
+	if after != "" {
+		after += "\n"
+	}
+	dmp := diffmatchpatch.New()
+	a, b, lines := dmp.DiffLinesToChars(before, after)
+	diffs := dmp.DiffCharsToLines(dmp.DiffMain(a, b, false), lines)
+	var out []string
+	for _, d := range diffs {
+		prefix := ""
+		switch d.Type {
+		case diffmatchpatch.DiffDelete:
+			prefix = "- "
+		case diffmatchpatch.DiffInsert:
+			prefix = "+ "
+		default:
+			continue
+		}
+		for _, line := range strings.Split(strings.TrimSuffix(d.Text, "\n"), "\n") {
+			if len(out) == max {
+				return strings.Join(append(out, "..."), "\n")
+			}
+			out = append(out, prefix+line)
+		}
+	}
+	return strings.Join(out, "\n")
+}
+
+// RevertEdits reverts every page in a domain that was edited by the source
+// between from and to, back to its state at from. Pages that were also
+// edited by someone else since then are skipped so that their work is kept.
+// The token has to be the one of the plan for the revert, so that only what
+// was checked is changed.
+func (fs *FileSystem) RevertEdits(domain, source string, from, to time.Time, token string) (reverted int, skipped int, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	plan, err := fs.planRevert(domain, source, from, to)
+	if err != nil {
+		return
+	}
+	if token != plan.Token {
+		err = errors.New("the pages changed since the dry run, check it again")
+		return
+	}
+	skipped = plan.Skipped
+	if len(plan.Pages) == 0 {
+		return
+	}
+
+	_, err = fs.snapshotDomain(domain)
+	if err != nil {
+		return
+	}
+
+	for _, p := range plan.Pages {
+		var files []File
+		files, err = fs.getAny(p.ID, domain)
+		if err != nil {
+			return
+		}
+		f := files[0]
+		f.Data = p.data
+		f.Domain = domain
+		err = fs.save(f)
+		if err != nil {
+			return
+		}
+		reverted++
+	}
+	return
+}
+
+// GetVersionAuthors returns who saved each version of a file, by the hash
+// of the version, for the versions that were saved by users who were
+// signed in. A version that was saved more than once is theirs who saved
+// it last.
+func (fs *FileSystem) GetVersionAuthors(id string) (authors map[string]string, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+
+	rows, err := fs.db.Query(`SELECT version, author FROM edits
+	WHERE fsid = ? AND author != '' AND version != '' ORDER BY created, id`, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "GetVersionAuthors")
+	}
+	defer rows.Close()
+	authors = make(map[string]string)
+	for rows.Next() {
+		var version, author string
+		err = rows.Scan(&version, &author)
+		if err != nil {
+			return nil, errors.Wrap(err, "get rows of GetVersionAuthors")
+		}
+		authors[version] = author
+	}
+	err = rows.Err()
+	return
+}
@@ -0,0 +1,295 @@
+package db
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/schollz/versionedtext"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// ErasedText replaces text that is erased
+const ErasedText = "[erased]"
+
+// Erase replaces a text with the ErasedText in every page of a domain, in
+// every version of them and everywhere else that their text is kept, so
+// that text that should never have been saved is gone for good. It
+// returns how many pages had the text. An empty domain erases the text
+// from every domain. Dumps of the database still have the text until
+// the database is dumped again.
+func (fs *FileSystem) Erase(domain, text string) (erased int, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	if strings.TrimSpace(text) == "" {
+		return 0, errors.New("need text to erase")
+	}
+	// pages that are not indexed yet are indexed first, so that their
+	// text is in the database to be erased
+	err = fs.flushIndex()
+	if err != nil {
+		return
+	}
+
+	tx, err := fs.db.Begin()
+	if err != nil {
+		return 0, errors.Wrap(err, "begin Erase")
+	}
+	erased, err = fs.erasePages(tx, domain, text)
+	if err == nil {
+		err = fs.eraseSnapshots(tx, domain, text)
+	}
+	if err == nil {
+		err = fs.eraseResponses(tx, domain, text)
+	}
+	if err == nil {
+		for _, column := range []string{"quote", "prefix", "suffix", "comment"} {
+			_, err = tx.Exec(`UPDATE annotations SET `+column+` = REPLACE(`+column+`, ?, ?)
+			WHERE domainid IN (SELECT id FROM domains WHERE ? = '' OR name = ?)`, text, ErasedText, domain, domain)
+			if err != nil {
+				err = errors.Wrap(err, "erase annotations")
+				break
+			}
+		}
+	}
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return 0, errors.Wrap(err, "commit Erase")
+	}
+
+	// the search index keeps what was replaced until it is merged, and
+	// the database file keeps it in its free pages until it is vacuumed
+	_, err = fs.db.Exec(`INSERT INTO fts(fts) VALUES('optimize')`)
+	if err != nil {
+		return erased, errors.Wrap(err, "optimize search index")
+	}
+	_, err = fs.db.Exec(`VACUUM`)
+	if err != nil {
+		return erased, errors.Wrap(err, "vacuum")
+	}
+	_, err = fs.db.Exec(`PRAGMA wal_checkpoint(TRUNCATE)`)
+	if err != nil {
+		err = errors.Wrap(err, "checkpoint")
+	}
+	return
+}
+
+// erasePages erases a text from the pages of a domain, with their history
+// and the search index, time entries, cards and tags that come from them
+func (fs *FileSystem) erasePages(tx *sql.Tx, domain, text string) (erased int, err error) {
+	type page struct {
+		id       string
+		domainid int
+		slug     string
+		data     string
+		history  []byte
+	}
+	var pages []page
+	rows, err := tx.Query(`
+	SELECT fs.id, fs.domainid, fs.slug, fts.data, fs.history FROM fs
+	INNER JOIN fts ON fs.id=fts.id
+	INNER JOIN domains ON fs.domainid=domains.id
+	WHERE ? = '' OR domains.name = ?`, domain, domain)
+	if err != nil {
+		return 0, errors.Wrap(err, "erasePages")
+	}
+	for rows.Next() {
+		var p page
+		err = rows.Scan(&p.id, &p.domainid, &p.slug, &p.data, &p.history)
+		if err != nil {
+			rows.Close()
+			return 0, errors.Wrap(err, "get rows of erasePages")
+		}
+		pages = append(pages, p)
+	}
+	rows.Close()
+
+	for _, p := range pages {
+		var history versionedtext.VersionedText
+		if len(p.history) > 0 {
+			history, err = decodeHistory(p.history)
+			if err != nil {
+				return 0, errors.Wrap(err, "could not parse history of "+p.id)
+			}
+		}
+		history, changed := eraseHistory(history, text)
+		if !changed && !strings.Contains(p.data, text) {
+			continue
+		}
+		erased++
+		historyBytes, errEncode := encodeHistory(history)
+		if errEncode != nil {
+			return 0, errEncode
+		}
+		_, err = tx.Exec(`UPDATE fs SET history = ? WHERE id = ?`, historyBytes, p.id)
+		if err != nil {
+			return 0, errors.Wrap(err, "erase history")
+		}
+		// pages in the trash are not in the search index
+		if p.data == "" {
+			continue
+		}
+		data := strings.Replace(p.data, text, ErasedText, -1)
+		_, err = tx.Exec(`UPDATE fts SET data = ?, title = ? WHERE id = ?`, data, pageTitle(data), p.id)
+		if err != nil {
+			return 0, errors.Wrap(err, "erase search index")
+		}
+		err = fs.setLanguage(tx, p.id, data)
+		if err == nil {
+			err = fs.setTimeEntries(tx, p.id, p.domainid, data)
+		}
+		if err == nil {
+			err = fs.setCards(tx, p.id, p.domainid, data)
+		}
+		if err == nil {
+			err = fs.setTags(tx, p.id, p.domainid, data)
+		}
+		if err == nil {
+			err = fs.setBlobRefs(tx, p.id, p.domainid, data)
+		}
+		if err == nil {
+			err = fs.setReadability(tx, p.id, p.domainid, data)
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// eraseHistory erases a text from every version of a history, keeping
+// when each version was made
+func eraseHistory(history versionedtext.VersionedText, text string) (erased versionedtext.VersionedText, changed bool) {
+	vs := versions(history)
+	if len(vs) != len(history.Diffs) {
+		// a history that can not be rebuilt only keeps what it is now
+		vs = nil
+	}
+	dmp := diffmatchpatch.New()
+	erased = versionedtext.VersionedText{
+		CurrentText: strings.Replace(history.CurrentText, text, ErasedText, -1),
+		Diffs:       make(map[int64]string),
+	}
+	changed = erased.CurrentText != history.CurrentText || vs == nil && len(history.Diffs) > 0
+	lastText := ""
+	for _, v := range vs {
+		data := strings.Replace(v.Data, text, ErasedText, -1)
+		if data != v.Data {
+			changed = true
+		}
+		erased.Diffs[v.Timestamp] = dmp.DiffToDelta(dmp.DiffMain(lastText, data, false))
+		lastText = data
+	}
+	if vs == nil && erased.CurrentText != "" {
+		erased.Diffs[history.LastEditTime()] = dmp.DiffToDelta(dmp.DiffMain("", erased.CurrentText, false))
+	}
+	if !changed {
+		return history, false
+	}
+	return
+}
+
+// eraseSnapshots erases a text from the snapshots of a domain
+func (fs *FileSystem) eraseSnapshots(tx *sql.Tx, domain, text string) (err error) {
+	return eraseJSON(tx, "snapshots", domain, text, func(data string) (string, error) {
+		var sfiles []snapshotFile
+		err := json.Unmarshal([]byte(data), &sfiles)
+		if err != nil {
+			return "", errors.Wrap(err, "could not parse snapshot")
+		}
+		for i := range sfiles {
+			sfiles[i].Data = strings.Replace(sfiles[i].Data, text, ErasedText, -1)
+		}
+		b, err := json.Marshal(sfiles)
+		return string(b), err
+	})
+}
+
+// eraseResponses erases a text from the responses to the forms of a
+// domain
+func (fs *FileSystem) eraseResponses(tx *sql.Tx, domain, text string) (err error) {
+	return eraseJSON(tx, "responses", domain, text, func(data string) (string, error) {
+		var fields map[string]string
+		err := json.Unmarshal([]byte(data), &fields)
+		if err != nil {
+			return "", errors.Wrap(err, "could not parse response")
+		}
+		for k, v := range fields {
+			fields[k] = strings.Replace(v, text, ErasedText, -1)
+		}
+		b, err := json.Marshal(fields)
+		return string(b), err
+	})
+}
+
+// eraseJSON rewrites the JSON data of the rows of a table of a domain
+// that have a text, which is searched for as it is encoded
+func eraseJSON(tx *sql.Tx, table, domain, text string, erase func(data string) (string, error)) (err error) {
+	encoded, _ := json.Marshal(text)
+	rows, err := tx.Query(`SELECT id, data FROM `+table+`
+	WHERE domainid IN (SELECT id FROM domains WHERE ? = '' OR name = ?)
+	AND INSTR(data, ?) > 0`, domain, domain, strings.Trim(string(encoded), `"`))
+	if err != nil {
+		return errors.Wrap(err, "erase "+table)
+	}
+	changes := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var data string
+		err = rows.Scan(&id, &data)
+		if err != nil {
+			rows.Close()
+			return errors.Wrap(err, "get rows of erase "+table)
+		}
+		changes[id], err = erase(data)
+		if err != nil {
+			rows.Close()
+			return
+		}
+	}
+	rows.Close()
+	for id, data := range changes {
+		_, err = tx.Exec(`UPDATE `+table+` SET data = ? WHERE id = ?`, data, id)
+		if err != nil {
+			return errors.Wrap(err, "erase "+table)
+		}
+	}
+	return
+}
+
+// ShredDump overwrites the dump of the database with random bytes and
+// removes it, so that what was erased is not left on the disk in it
+func (fs *FileSystem) ShredDump() (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	name := fs.name + ".sql.gz"
+	stat, err := os.Stat(name)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return
+	}
+	fi, err := os.OpenFile(name, os.O_WRONLY, 0)
+	if err != nil {
+		return
+	}
+	_, err = io.CopyN(fi, rand.Reader, stat.Size())
+	if err == nil {
+		err = fi.Sync()
+	}
+	fi.Close()
+	if err != nil {
+		return errors.Wrap(err, "shred dump")
+	}
+	return os.Remove(name)
+}
@@ -0,0 +1,312 @@
+package db
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// AnonymousDomain is a domain that no account has a role in, with when a
+// page in it was last changed or somebody last logged in to it, and when
+// it was flagged to be removed, which is zero until it is
+type AnonymousDomain struct {
+	Name     string
+	LastUsed time.Time
+	Expiring time.Time
+}
+
+// GetAnonymousDomains returns the domains, besides the public one, that
+// no account has a role in
+func (fs *FileSystem) GetAnonymousDomains() (domains []AnonymousDomain, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+
+	rows, err := fs.db.Query(`
+	SELECT name, expiring FROM domains
+	WHERE
+		name != 'public'
+		AND id NOT IN (SELECT domainid FROM roles)
+		AND id NOT IN (SELECT domainid FROM keys WHERE userid != 0)
+	ORDER BY name`)
+	if err != nil {
+		return nil, errors.Wrap(err, "GetAnonymousDomains")
+	}
+	defer rows.Close()
+	byName := make(map[string]int)
+	for rows.Next() {
+		var d AnonymousDomain
+		var expiring *time.Time
+		err = rows.Scan(&d.Name, &expiring)
+		if err != nil {
+			return nil, errors.Wrap(err, "get rows of GetAnonymousDomains")
+		}
+		if expiring != nil {
+			d.Expiring = *expiring
+		}
+		byName[d.Name] = len(domains)
+		domains = append(domains, d)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.Wrap(err, "GetAnonymousDomains")
+	}
+
+	// pages with nothing in them are made just by opening a domain, so
+	// they are not counted as using it
+	pages, err := fs.db.Query(`
+	SELECT domains.name, fs.id, fs.modified, fts.data FROM fs
+	INNER JOIN fts ON fs.id=fts.id
+	INNER JOIN domains ON fs.domainid=domains.id`)
+	if err != nil {
+		return nil, errors.Wrap(err, "GetAnonymousDomains")
+	}
+	defer pages.Close()
+	for pages.Next() {
+		var name, id, data string
+		var modified time.Time
+		err = pages.Scan(&name, &id, &modified, &data)
+		if err != nil {
+			return nil, errors.Wrap(err, "get pages of GetAnonymousDomains")
+		}
+		if pending, ok := fs.pendingData(id); ok {
+			data = pending
+		}
+		i, ok := byName[name]
+		if ok && data != "" && modified.After(domains[i].LastUsed) {
+			domains[i].LastUsed = modified
+		}
+	}
+	err = pages.Err()
+	if err != nil {
+		return nil, errors.Wrap(err, "GetAnonymousDomains")
+	}
+
+	keys, err := fs.db.Query(`
+	SELECT domains.name, keys.lastused FROM keys
+	INNER JOIN domains ON keys.domainid=domains.id`)
+	if err != nil {
+		return nil, errors.Wrap(err, "GetAnonymousDomains")
+	}
+	defer keys.Close()
+	for keys.Next() {
+		var name string
+		var lastUsed *time.Time
+		err = keys.Scan(&name, &lastUsed)
+		if err != nil {
+			return nil, errors.Wrap(err, "get keys of GetAnonymousDomains")
+		}
+		i, ok := byName[name]
+		if ok && lastUsed != nil && lastUsed.After(domains[i].LastUsed) {
+			domains[i].LastUsed = *lastUsed
+		}
+	}
+	err = keys.Err()
+	if err != nil {
+		err = errors.Wrap(err, "GetAnonymousDomains")
+	}
+	return
+}
+
+// SetDomainExpiring flags a domain to be removed, from a time, or takes
+// the flag away when the time is zero
+func (fs *FileSystem) SetDomainExpiring(domain string, expiring time.Time) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	var value interface{}
+	if !expiring.IsZero() {
+		value = expiring.UTC()
+	}
+	_, err = fs.db.Exec(`UPDATE domains SET expiring = ? WHERE name = ?`, value, domain)
+	if err != nil {
+		err = errors.Wrap(err, "SetDomainExpiring")
+	}
+	return
+}
+
+// GetDomainExpiring returns when a domain was flagged to be removed, which
+// is zero if it is not
+func (fs *FileSystem) GetDomainExpiring(domain string) (expiring time.Time, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+
+	var t *time.Time
+	err = fs.db.QueryRow(`SELECT expiring FROM domains WHERE name = ?`, domain).Scan(&t)
+	if err != nil {
+		return expiring, errors.Wrap(err, "GetDomainExpiring")
+	}
+	if t != nil {
+		expiring = *t
+	}
+	return
+}
+
+// DeletePlan is what deleting a domain would remove, which is shown before
+// anything is deleted. Its token confirms the deletion, and only does while
+// the pages, uploads and keys of the domain stay the same.
+type DeletePlan struct {
+	Domain string `json:"domain"`
+	// Pages does not count the pages in the trash, which are deleted too
+	Pages       int    `json:"pages"`
+	Trash       int    `json:"trash"`
+	PageBytes   int64  `json:"page_bytes"`
+	Uploads     int    `json:"uploads"`
+	UploadBytes int64  `json:"upload_bytes"`
+	Keys        int    `json:"keys"`
+	Token       string `json:"token"`
+}
+
+// PlanDeleteDomain shows what deleting a domain would remove, without
+// deleting anything
+func (fs *FileSystem) PlanDeleteDomain(domain string) (plan DeletePlan, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+	return fs.planDeleteDomain(domain)
+}
+
+func (fs *FileSystem) planDeleteDomain(domain string) (plan DeletePlan, err error) {
+	plan = DeletePlan{Domain: domain}
+	domainid, _, _, _ := fs.getDomainFromName(domain)
+	if domainid == 0 || domain == "public" {
+		err = errors.New("domain does not exist")
+		return
+	}
+
+	token := sha256.New()
+	fmt.Fprintf(token, "%s\n%d\n", domain, domainid)
+	rows, err := fs.db.Query(`SELECT id, deleted, LENGTH(history), modified FROM fs WHERE domainid = ? ORDER BY id`, domainid)
+	if err != nil {
+		return plan, errors.Wrap(err, "planDeleteDomain")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			id       string
+			deleted  bool
+			size     int64
+			modified *time.Time
+		)
+		err = rows.Scan(&id, &deleted, &size, &modified)
+		if err != nil {
+			return plan, errors.Wrap(err, "get rows of planDeleteDomain")
+		}
+		if deleted {
+			plan.Trash++
+		} else {
+			plan.Pages++
+		}
+		plan.PageBytes += size
+		fmt.Fprintf(token, "page %s %v %d", id, deleted, size)
+		if modified != nil {
+			fmt.Fprintf(token, " %d", modified.UnixNano())
+		}
+		fmt.Fprintln(token)
+	}
+	err = rows.Err()
+	if err != nil {
+		return plan, errors.Wrap(err, "planDeleteDomain")
+	}
+
+	blobs, err := fs.db.Query(`SELECT blobs.id, blobs.size FROM blobs
+	INNER JOIN blob_domains ON blobs.id=blob_domains.blobid
+	WHERE blob_domains.domainid = ? ORDER BY blobs.id`, domainid)
+	if err != nil {
+		return plan, errors.Wrap(err, "planDeleteDomain")
+	}
+	defer blobs.Close()
+	for blobs.Next() {
+		var id string
+		var size int64
+		err = blobs.Scan(&id, &size)
+		if err != nil {
+			return plan, errors.Wrap(err, "get uploads of planDeleteDomain")
+		}
+		plan.Uploads++
+		plan.UploadBytes += size
+		fmt.Fprintf(token, "upload %s %d\n", id, size)
+	}
+	err = blobs.Err()
+	if err != nil {
+		return plan, errors.Wrap(err, "planDeleteDomain")
+	}
+
+	err = fs.db.QueryRow(`SELECT COUNT(*) FROM keys WHERE domainid = ?`, domainid).Scan(&plan.Keys)
+	if err != nil {
+		return plan, errors.Wrap(err, "planDeleteDomain")
+	}
+	fmt.Fprintf(token, "keys %d\n", plan.Keys)
+	plan.Token = fmt.Sprintf("%x", token.Sum(nil))[:16]
+	return
+}
+
+// DeleteDomain removes a domain, its pages and everything about them, and
+// its logins, for good. The uploads of its pages are kept, since other
+// pages can use them too, but no longer belong to it. The token has to be
+// the one of the plan for the deletion, so that only what was checked is
+// removed.
+func (fs *FileSystem) DeleteDomain(domain, token string) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	plan, err := fs.planDeleteDomain(domain)
+	if err != nil {
+		return
+	}
+	if token != plan.Token {
+		return errors.New("the domain changed since the dry run, check it again")
+	}
+
+	domainid, _, _, _ := fs.getDomainFromName(domain)
+	if domainid == 0 {
+		return errors.New("domain does not exist")
+	}
+	ids, err := fs.getAllFromPreparedQuerySingleString(`SELECT id FROM fs WHERE domainid = ?`, domainid)
+	if err != nil {
+		return
+	}
+
+	tx, err := fs.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin DeleteDomain")
+	}
+	for _, id := range ids {
+		err = deletePage(tx, id)
+		if err != nil {
+			tx.Rollback()
+			return errors.Wrap(err, "exec DeleteDomain")
+		}
+	}
+	_, err = tx.Exec(`DELETE FROM feed_items WHERE feedid IN (SELECT id FROM feeds WHERE domainid = ?)`, domainid)
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "exec DeleteDomain")
+	}
+	for _, table := range []string{
+		"fs", "keys", "clicks", "annotations", "responses", "edits", "times",
+		"cards", "stopwords", "synonyms", "tags", "roles", "feeds", "mirrors",
+		"snapshots", "blob_domains", "blob_refs", "translations", "readability",
+		"redirects", "not_found", "links", "remote_backups", "remote_backup_pages",
+		"tokens", "blob_egress", "content_reports", "domain_renames",
+	} {
+		_, err = tx.Exec(`DELETE FROM `+table+` WHERE domainid = ?`, domainid)
+		if err != nil {
+			tx.Rollback()
+			return errors.Wrap(err, "exec DeleteDomain")
+		}
+	}
+	_, err = tx.Exec(`DELETE FROM domains WHERE id = ?`, domainid)
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "exec DeleteDomain")
+	}
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrap(err, "commit DeleteDomain")
+	}
+	for _, id := range ids {
+		fs.unqueueIndex(id)
+	}
+	return
+}
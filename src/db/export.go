@@ -0,0 +1,145 @@
+package db
+
+import (
+	"archive/zip"
+	"io"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// ExportDomain writes the pages of a domain to a zip archive as markdown
+// files named by their slugs, with when they were created and modified in
+// their front matter, and the uploads that the domain has or that its
+// pages link to in uploads/ID/NAME. The links to uploads are changed to
+// the files in the archive, so the pages can be read without rwtxt.
+func (fs *FileSystem) ExportDomain(domain string, w io.Writer) (err error) {
+	_, _, err = fs.GetDomainFromName(domain)
+	if err != nil {
+		return
+	}
+	files, err := fs.GetAllCtx(allPages(), domain)
+	if err != nil {
+		return
+	}
+	uploads, err := fs.ListBlobs(domain)
+	if err != nil {
+		return
+	}
+
+	// the file of each upload in the archive, by its id
+	blobs := make(map[string]string)
+	for _, b := range uploads {
+		blobs[b.ID] = exportBlobName(b)
+	}
+	for _, f := range files {
+		for _, match := range uploadRef.FindAllStringSubmatch(f.Data, -1) {
+			if _, ok := blobs[match[1]]; ok {
+				continue
+			}
+			b, errInfo := fs.BlobInfo(match[1])
+			if errInfo != nil {
+				// links to uploads that were removed are left as they are
+				continue
+			}
+			blobs[b.ID] = exportBlobName(b)
+		}
+	}
+
+	z := zip.NewWriter(w)
+	names := make(map[string]bool)
+	for _, f := range files {
+		name := f.Slug
+		if name == "" || names[name] {
+			name = f.ID
+		}
+		names[name] = true
+
+		meta, body := utils.ParseFrontMatter(f.Data)
+		meta["created"] = f.Created.UTC().Format(time.RFC3339)
+		meta["modified"] = f.Modified.UTC().Format(time.RFC3339)
+		body = uploadRef.ReplaceAllStringFunc(body, func(link string) string {
+			// the names of the files are escaped, so that names with
+			// spaces can be linked to
+			if file, ok := blobs[uploadRef.FindStringSubmatch(link)[1]]; ok {
+				return path.Dir(file) + "/" + url.PathEscape(path.Base(file))
+			}
+			return link
+		})
+		err = writeZipFile(z, name+".md", f.Modified, strings.NewReader(exportFrontMatter(meta)+body))
+		if err != nil {
+			return
+		}
+	}
+
+	ids := make([]string, 0, len(blobs))
+	for id := range blobs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	now := time.Now()
+	for _, id := range ids {
+		rs, errOpen := fs.OpenBlob(id)
+		if errOpen != nil {
+			return errOpen
+		}
+		err = writeZipFile(z, blobs[id], now, rs)
+		rs.Close()
+		if err != nil {
+			return
+		}
+	}
+	err = z.Close()
+	if err != nil {
+		return errors.Wrap(err, "ExportDomain")
+	}
+	return
+}
+
+// exportBlobName returns the file of an upload in an exported domain
+func exportBlobName(b Blob) string {
+	name := path.Base(b.Name)
+	if name == "" || name == "." || name == "/" {
+		name = b.ID
+	}
+	return "uploads/" + b.ID + "/" + name
+}
+
+// exportFrontMatter returns the front matter of an exported page, with
+// its keys in order
+func exportFrontMatter(meta map[string]string) string {
+	keys := make([]string, 0, len(meta))
+	for key := range meta {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteString("---\n")
+	for _, key := range keys {
+		b.WriteString(key + ": " + meta[key] + "\n")
+	}
+	b.WriteString("---\n")
+	return b.String()
+}
+
+// writeZipFile writes a file to a zip archive
+func writeZipFile(z *zip.Writer, name string, modified time.Time, r io.Reader) (err error) {
+	f, err := z.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: modified,
+	})
+	if err != nil {
+		return errors.Wrap(err, "writeZipFile")
+	}
+	_, err = io.Copy(f, r)
+	if err != nil {
+		return errors.Wrap(err, "writeZipFile")
+	}
+	return
+}
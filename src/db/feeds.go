@@ -0,0 +1,132 @@
+package db
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Feed is an RSS or Atom feed whose entries are saved as pages of a domain
+type Feed struct {
+	ID      int       `json:"id"`
+	Domain  string    `json:"domain"`
+	URL     string    `json:"url"`
+	Tag     string    `json:"tag"`
+	Created time.Time `json:"created"`
+	Polled  time.Time `json:"polled"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// AddFeed subscribes a domain to a feed, whose entries are tagged with tag
+func (fs *FileSystem) AddFeed(domain, url, tag string) (feed Feed, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	url = strings.TrimSpace(url)
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		err = errors.New("feed needs an http or https url")
+		return
+	}
+	domainid, _, _, _ := fs.getDomainFromName(domain)
+	if domainid == 0 {
+		err = errors.New("domain " + domain + " does not exist")
+		return
+	}
+	feed = Feed{Domain: domain, URL: url, Tag: strings.TrimSpace(tag), Created: time.Now().UTC()}
+	res, err := fs.db.Exec(`INSERT INTO feeds (domainid, url, tag, created, polled, error) VALUES (?,?,?,?,?,'')`,
+		domainid, feed.URL, feed.Tag, feed.Created, feed.Polled)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			err = errors.New(domain + " is already subscribed to " + url)
+			return
+		}
+		err = errors.Wrap(err, "exec AddFeed")
+		return
+	}
+	id, _ := res.LastInsertId()
+	feed.ID = int(id)
+	return
+}
+
+// GetFeeds returns the feeds of a domain, or of every domain when the
+// domain is empty
+func (fs *FileSystem) GetFeeds(domain string) (feeds []Feed, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+
+	rows, err := fs.db.Query(`
+	SELECT feeds.id, domains.name, feeds.url, feeds.tag, feeds.created, feeds.polled, feeds.error FROM feeds
+	INNER JOIN domains ON feeds.domainid=domains.id
+	WHERE ? = '' OR domains.name = ?
+	ORDER BY feeds.id`, domain, domain)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	feeds = []Feed{}
+	for rows.Next() {
+		var f Feed
+		err = rows.Scan(&f.ID, &f.Domain, &f.URL, &f.Tag, &f.Created, &f.Polled, &f.Error)
+		if err != nil {
+			err = errors.Wrap(err, "get rows of feeds")
+			return
+		}
+		feeds = append(feeds, f)
+	}
+	err = rows.Err()
+	return
+}
+
+// DeleteFeed unsubscribes a domain from a feed. The pages that were saved
+// from it are kept.
+func (fs *FileSystem) DeleteFeed(domain string, id int) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	domainid, _, _, _ := fs.getDomainFromName(domain)
+	res, err := fs.db.Exec(`DELETE FROM feeds WHERE id = ? AND domainid = ?`, id, domainid)
+	if err != nil {
+		return errors.Wrap(err, "exec DeleteFeed")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New("feed does not exist")
+	}
+	_, err = fs.db.Exec(`DELETE FROM feed_items WHERE feedid = ?`, id)
+	if err != nil {
+		return errors.Wrap(err, "exec DeleteFeed items")
+	}
+	return
+}
+
+// SetFeedPolled records when a feed was polled, and the error polling it
+func (fs *FileSystem) SetFeedPolled(id int, polled time.Time, pollError string) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+	_, err = fs.db.Exec(`UPDATE feeds SET polled = ?, error = ? WHERE id = ?`, polled.UTC(), pollError, id)
+	if err != nil {
+		err = errors.Wrap(err, "exec SetFeedPolled")
+	}
+	return
+}
+
+// HasFeedItem returns whether an entry of a feed was already saved
+func (fs *FileSystem) HasFeedItem(feedid int, guid string) (has bool) {
+	fs.RLock()
+	defer fs.RUnlock()
+	var n int
+	fs.db.QueryRow(`SELECT COUNT(*) FROM feed_items WHERE feedid = ? AND guid = ?`, feedid, guid).Scan(&n)
+	return n > 0
+}
+
+// AddFeedItem records that an entry of a feed was saved as a file
+func (fs *FileSystem) AddFeedItem(feedid int, guid, fileid string) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+	_, err = fs.db.Exec(`INSERT OR IGNORE INTO feed_items (feedid, guid, fsid, created) VALUES (?,?,?,?)`,
+		feedid, guid, fileid, time.Now().UTC())
+	if err != nil {
+		err = errors.Wrap(err, "exec AddFeedItem")
+	}
+	return
+}
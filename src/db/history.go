@@ -0,0 +1,114 @@
+package db
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+	"github.com/schollz/versionedtext"
+)
+
+// encodeHistory marshals the history of a file and compresses it, since the
+// diffs of pages that are edited often are most of the database
+func encodeHistory(history versionedtext.VersionedText) (b []byte, err error) {
+	historyBytes, err := json.Marshal(history)
+	if err != nil {
+		return
+	}
+	var buf bytes.Buffer
+	gz, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return
+	}
+	_, err = gz.Write(historyBytes)
+	if err != nil {
+		return
+	}
+	err = gz.Close()
+	b = buf.Bytes()
+	return
+}
+
+// decodeHistory reads a history, which is JSON in databases that were
+// not compressed yet
+func decodeHistory(b []byte) (history versionedtext.VersionedText, err error) {
+	if len(b) > 2 && b[0] == 0x1f && b[1] == 0x8b {
+		var gz *gzip.Reader
+		gz, err = gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return
+		}
+		b, err = ioutil.ReadAll(gz)
+		if err != nil {
+			return
+		}
+	}
+	err = json.Unmarshal(b, &history)
+	return
+}
+
+// LoadHistory reads the History of a file that was listed, which is
+// only read for files that are gotten one at a time
+func (f *File) LoadHistory() (err error) {
+	if len(f.history) == 0 {
+		return
+	}
+	f.History, err = decodeHistory(f.history)
+	if err != nil {
+		return errors.Wrap(err, "could not parse history")
+	}
+	f.history = nil
+	return
+}
+
+// loadHistories reads the History of each of the files
+func loadHistories(files []File) (err error) {
+	for i := range files {
+		err = files[i].LoadHistory()
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// compressHistory compresses the histories that older versions of rwtxt
+// saved as JSON
+func (fs *FileSystem) compressHistory() (err error) {
+	compressed := 0
+	for {
+		var ids []string
+		ids, err = fs.getAllFromPreparedQuerySingleString(`SELECT id FROM fs WHERE typeof(history) = 'text' AND history != '' LIMIT 100`)
+		if err != nil || len(ids) == 0 {
+			break
+		}
+		for _, id := range ids {
+			var historyBytes []byte
+			err = fs.db.QueryRow(`SELECT history FROM fs WHERE id = ?`, id).Scan(&historyBytes)
+			if err != nil {
+				return errors.Wrap(err, "get history")
+			}
+			var history versionedtext.VersionedText
+			history, err = decodeHistory(historyBytes)
+			if err != nil {
+				return errors.Wrap(err, "could not parse history of "+id)
+			}
+			historyBytes, err = encodeHistory(history)
+			if err != nil {
+				return
+			}
+			_, err = fs.db.Exec(`UPDATE fs SET history = ? WHERE id = ?`, historyBytes, id)
+			if err != nil {
+				return errors.Wrap(err, "compress history")
+			}
+			compressed++
+		}
+	}
+	if compressed > 0 {
+		log.Infof("compressed the history of %d pages", compressed)
+	}
+	return
+}
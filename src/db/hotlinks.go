@@ -0,0 +1,92 @@
+package db
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// HotlinkPolicy is how a domain keeps other sites from embedding its
+// uploads. With Referer, uploads asked for from a page of another site are
+// refused, unless the site is one of the allowed hosts or their
+// subdomains. With Signed, uploads are only served from the links in its
+// pages, which are signed and expire. Members of the domain are never
+// refused.
+type HotlinkPolicy struct {
+	Referer bool     `json:"referer"`
+	Allow   []string `json:"allow,omitempty"`
+	Signed  bool     `json:"signed"`
+}
+
+// AllowsReferer returns whether an upload can be asked for from a page at
+// the referer, on a server at the host. Requests without a referer, like
+// the ones of people who open an upload, are allowed.
+func (p HotlinkPolicy) AllowsReferer(referer, host string) bool {
+	if !p.Referer || referer == "" {
+		return true
+	}
+	u, err := url.Parse(referer)
+	if err != nil {
+		return false
+	}
+	refererHost := strings.ToLower(u.Hostname())
+	if strings.EqualFold(u.Host, host) || strings.EqualFold(refererHost, strings.Split(host, ":")[0]) {
+		return true
+	}
+	for _, allowed := range p.Allow {
+		if refererHost == allowed || strings.HasSuffix(refererHost, "."+allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetHotlinkPolicy changes how a domain keeps other sites from embedding
+// its uploads. The allowed hosts can be given as hosts or urls.
+func (fs *FileSystem) SetHotlinkPolicy(domain string, policy HotlinkPolicy) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+	var allow []string
+	for _, host := range policy.Allow {
+		host = strings.ToLower(strings.TrimSpace(host))
+		if strings.Contains(host, "://") {
+			u, errURL := url.Parse(host)
+			if errURL != nil {
+				return errors.New("could not read " + host)
+			}
+			host = u.Hostname()
+		}
+		host = strings.Trim(strings.Split(host, "/")[0], ".")
+		if host != "" {
+			allow = append(allow, host)
+		}
+	}
+	res, err := fs.db.Exec(`UPDATE domains SET hotlink_referer = ?, hotlink_allow = ?, hotlink_signed = ? WHERE name = ?`,
+		policy.Referer, strings.Join(allow, ","), policy.Signed, utils.NormalizeDomain(domain))
+	if err != nil {
+		return errors.Wrap(err, "SetHotlinkPolicy")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New("domain does not exist")
+	}
+	return
+}
+
+// GetHotlinkPolicy returns how a domain keeps other sites from embedding
+// its uploads
+func (fs *FileSystem) GetHotlinkPolicy(domain string) (policy HotlinkPolicy, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+	var allow string
+	err = fs.db.QueryRow(`SELECT hotlink_referer, hotlink_allow, hotlink_signed FROM domains WHERE name = ?`, utils.NormalizeDomain(domain)).Scan(
+		&policy.Referer, &allow, &policy.Signed)
+	if err != nil {
+		return policy, errors.Wrap(err, "GetHotlinkPolicy")
+	}
+	if allow != "" {
+		policy.Allow = strings.Split(allow, ",")
+	}
+	return
+}
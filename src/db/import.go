@@ -0,0 +1,290 @@
+package db
+
+import (
+	"archive/zip"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// ImportResult is what importing markdown files into a domain did, with
+// the slugs of the pages that were made or changed
+type ImportResult struct {
+	Created   []string `json:"created"`
+	Updated   []string `json:"updated"`
+	Unchanged []string `json:"unchanged"`
+	Uploads   int      `json:"uploads"`
+}
+
+// importLink finds the targets of the links and images in markdown
+var importLink = regexp.MustCompile(`\]\(([^)\s]+)\)`)
+
+// importDates are the layouts of the dates in front matter that are
+// understood
+var importDates = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// importFile is a markdown file of an import, with the slug of its page
+type importFile struct {
+	*zip.File
+	slug string
+}
+
+// ImportDomain makes pages in a domain from the markdown files in a zip
+// archive, like the ones of ExportDomain or a folder of notes. The slug of
+// each page comes from the name of its file, and a page that has the slug
+// already is changed instead. The dates come from "created" (or "date")
+// and "modified" (or "updated" or "lastmod") in the front matter, or else
+// from when the file was modified. The other files are uploaded to the
+// domain, and the links to them and to the other markdown files are
+// changed to their uploads and pages. Hidden files, like the settings of
+// Obsidian, are skipped.
+func (fs *FileSystem) ImportDomain(domain string, r io.Reader) (imported ImportResult, err error) {
+	_, _, err = fs.GetDomainFromName(domain)
+	if err != nil {
+		return
+	}
+	spooled, size, err := spoolBlob(r, nil)
+	if err != nil {
+		return
+	}
+	defer spooled.Close()
+	z, err := zip.NewReader(spooled, size)
+	if err != nil {
+		return imported, errors.Wrap(err, "ImportDomain")
+	}
+
+	// the link to each file of the archive that is imported, by its path
+	links := make(map[string]string)
+	var pages []importFile
+	slugs := make(map[string]bool)
+	for _, file := range z.File {
+		name := path.Clean(strings.Replace(file.Name, `\`, "/", -1))
+		if file.FileInfo().IsDir() || hiddenImport(name) {
+			continue
+		}
+		if strings.ToLower(path.Ext(name)) != ".md" {
+			var link string
+			link, err = fs.importUpload(domain, file)
+			if err != nil {
+				return
+			}
+			links[name] = link
+			imported.Uploads++
+			continue
+		}
+		slug := UnreservedSlug(utils.Slugify(strings.TrimSuffix(path.Base(name), path.Ext(name))))
+		if slug == "" {
+			slug = "page"
+		}
+		// files with the same name in different folders get their own
+		// pages
+		unique := slug
+		for n := 2; slugs[unique]; n++ {
+			unique = slug + "-" + strconv.Itoa(n)
+		}
+		slugs[unique] = true
+		links[name] = "/" + domain + "/" + unique
+		pages = append(pages, importFile{File: file, slug: unique})
+	}
+
+	for _, p := range pages {
+		var status string
+		status, err = fs.importPage(domain, p, links)
+		if err != nil {
+			return
+		}
+		switch status {
+		case "created":
+			imported.Created = append(imported.Created, p.slug)
+		case "updated":
+			imported.Updated = append(imported.Updated, p.slug)
+		default:
+			imported.Unchanged = append(imported.Unchanged, p.slug)
+		}
+	}
+	return
+}
+
+// hiddenImport returns whether a file of an import is hidden, or is in a
+// hidden folder
+func hiddenImport(name string) bool {
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") || part == "__MACOSX" {
+			return true
+		}
+	}
+	return false
+}
+
+// importUpload uploads a file of an import to a domain, and returns the
+// link to it
+func (fs *FileSystem) importUpload(domain string, file *zip.File) (link string, err error) {
+	rc, err := file.Open()
+	if err != nil {
+		return "", errors.Wrap(err, "importUpload")
+	}
+	defer rc.Close()
+
+	// the type is found from the name, or else from the start of the file
+	name := path.Base(file.Name)
+	var r io.Reader = rc
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		head := make([]byte, 512)
+		n, _ := io.ReadFull(rc, head)
+		contentType = http.DetectContentType(head[:n])
+		r = io.MultiReader(strings.NewReader(string(head[:n])), rc)
+	}
+	id, size, err := fs.StoreBlob(name, r)
+	if err != nil {
+		return
+	}
+	err = fs.AddBlob(domain, Blob{ID: id, Name: name, ContentType: contentType, Size: size}, nil)
+	if err != nil {
+		return
+	}
+	return "/uploads/" + id + "?filename=" + url.QueryEscape(name), nil
+}
+
+// importPage makes or changes the page of a markdown file of an import,
+// and returns whether it was "created", "updated" or "unchanged"
+func (fs *FileSystem) importPage(domain string, p importFile, links map[string]string) (status string, err error) {
+	rc, err := p.Open()
+	if err != nil {
+		return "", errors.Wrap(err, "importPage")
+	}
+	b, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return "", errors.Wrap(err, "importPage")
+	}
+	data := strings.Replace(string(b), "\r\n", "\n", -1)
+
+	meta, _ := utils.ParseFrontMatter(data)
+	modified := p.Modified
+	if t, ok := importDate(meta, "modified", "updated", "lastmod", "last_modified_at"); ok {
+		modified = t
+	}
+	if modified.IsZero() {
+		modified = time.Now()
+	}
+	created := modified
+	if t, ok := importDate(meta, "created", "date"); ok {
+		created = t
+	}
+	// the dates of the page are kept by the page, so the ones that
+	// ExportDomain writes are not kept in its text
+	data = removeFrontMatter(data, "created", "modified")
+
+	dir := path.Dir(path.Clean(strings.Replace(p.Name, `\`, "/", -1)))
+	data = importLink.ReplaceAllStringFunc(data, func(match string) string {
+		target := importLink.FindStringSubmatch(match)[1]
+		if strings.Contains(target, ":") || strings.HasPrefix(target, "/") || strings.HasPrefix(target, "#") {
+			return match
+		}
+		unescaped, errUnescape := url.PathUnescape(target)
+		if errUnescape != nil {
+			return match
+		}
+		if link, ok := links[path.Join(dir, unescaped)]; ok {
+			return "](" + link + ")"
+		}
+		return match
+	})
+
+	f := File{
+		ID:      utils.UUID(),
+		Slug:    p.slug,
+		Domain:  domain,
+		Data:    data,
+		Created: created.UTC(),
+	}
+	status = "created"
+	existing, _ := fs.GetCtx(allPages(), p.slug, domain)
+	if len(existing) > 0 {
+		if existing[0].Data == data {
+			return "unchanged", nil
+		}
+		f.ID = existing[0].ID
+		f.Created = existing[0].Created
+		status = "updated"
+	}
+	err = fs.Save(f)
+	if err != nil {
+		return
+	}
+
+	fs.Lock()
+	defer fs.Unlock()
+	_, err = fs.db.Exec(`UPDATE fs SET modified = ? WHERE id = ?`, modified.UTC(), f.ID)
+	if err != nil {
+		return "", errors.Wrap(err, "exec importPage")
+	}
+	return
+}
+
+// importDate returns the first date in the front matter of a page that has
+// one of the keys
+func importDate(meta map[string]string, keys ...string) (t time.Time, ok bool) {
+	for _, key := range keys {
+		value := strings.Trim(meta[key], `"'`)
+		if value == "" {
+			continue
+		}
+		for _, layout := range importDates {
+			parsed, err := time.Parse(layout, value)
+			if err == nil {
+				return parsed, true
+			}
+		}
+	}
+	return
+}
+
+// removeFrontMatter removes keys from the front matter of a page, and the
+// front matter when nothing is left in it
+func removeFrontMatter(data string, keys ...string) string {
+	meta, body := utils.ParseFrontMatter(data)
+	if len(meta) == 0 {
+		return data
+	}
+	lines := strings.Split(strings.TrimLeft(data, " \t\r\n"), "\n")
+	kept := []string{lines[0]}
+	for i := 1; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if line == "---" {
+			break
+		}
+		removed := false
+		for _, key := range keys {
+			parts := strings.SplitN(line, ":", 2)
+			if len(parts) == 2 && strings.ToLower(strings.TrimSpace(parts[0])) == key {
+				removed = true
+			}
+		}
+		if !removed {
+			kept = append(kept, lines[i])
+		}
+	}
+	if len(kept) == 1 {
+		return strings.TrimLeft(body, "\n")
+	}
+	return strings.Join(kept, "\n") + "\n---\n" + body
+}
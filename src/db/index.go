@@ -0,0 +1,204 @@
+package db
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+)
+
+// IndexDelay is how long changes to pages wait to be indexed for search,
+// so that the saves of a page that is being typed are indexed once
+var IndexDelay = 2 * time.Second
+
+// searchIndex keeps the changes to pages that are not indexed yet. Reads
+// of pages use the changes, so only searches are behind.
+type searchIndex struct {
+	// pending is the newest data of each page, by its id
+	pending map[string]pendingPage
+	timer   *time.Timer
+	sync.Mutex
+	// flushing lets one flush run at a time. A flush also locks the pages
+	// it indexes, so that they are indexed in the order they were saved.
+	flushing sync.Mutex
+	// publishing makes the commit of a change and the change to the
+	// pending data one step for reads of pages, so that a read never has
+	// the text of one version and the history of another
+	publishing sync.RWMutex
+}
+
+type pendingPage struct {
+	domainid int
+	slug     string
+	data     string
+}
+
+// queueIndex indexes the slug and data of a page after the IndexDelay,
+// replacing the data that was queued before
+func (fs *FileSystem) queueIndex(id string, domainid int, slug string, data string) {
+	fs.index.Lock()
+	defer fs.index.Unlock()
+	if fs.index.pending == nil {
+		fs.index.pending = make(map[string]pendingPage)
+	}
+	fs.index.pending[id] = pendingPage{domainid, slug, data}
+	if fs.index.timer == nil {
+		fs.index.timer = time.AfterFunc(IndexDelay, func() {
+			if err := fs.FlushIndex(); err != nil {
+				log.Error(err)
+			}
+		})
+	}
+}
+
+// unqueueIndex drops the queued data of a page that was indexed with its
+// save
+func (fs *FileSystem) unqueueIndex(id string) {
+	fs.index.Lock()
+	defer fs.index.Unlock()
+	delete(fs.index.pending, id)
+}
+
+// pendingData returns the data of a page that is not indexed yet
+func (fs *FileSystem) pendingData(id string) (data string, ok bool) {
+	fs.index.Lock()
+	defer fs.index.Unlock()
+	p, ok := fs.index.pending[id]
+	return p.data, ok
+}
+
+// IndexPending returns how many pages of a domain are not indexed yet, so
+// searches can say that they are catching up
+func (fs *FileSystem) IndexPending(domain string) (pending int) {
+	fs.RLock()
+	domainid, _, _, _ := fs.getDomainFromName(domain)
+	fs.RUnlock()
+
+	fs.index.Lock()
+	defer fs.index.Unlock()
+	for _, p := range fs.index.pending {
+		if p.domainid == domainid {
+			pending++
+		}
+	}
+	return
+}
+
+// FlushIndex indexes the changes that are waiting
+func (fs *FileSystem) FlushIndex() (err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+	return fs.flushIndex()
+}
+
+func (fs *FileSystem) flushIndex() (err error) {
+	fs.index.flushing.Lock()
+	defer fs.index.flushing.Unlock()
+
+	fs.index.Lock()
+	ids := make([]string, 0, len(fs.index.pending))
+	for id := range fs.index.pending {
+		ids = append(ids, id)
+	}
+	if fs.index.timer != nil {
+		fs.index.timer.Stop()
+		fs.index.timer = nil
+	}
+	fs.index.Unlock()
+	if len(ids) == 0 {
+		return
+	}
+
+	// the pages are locked like they are for saves, and their data is
+	// taken once they are, since a save could have changed it before
+	defer fs.lockFiles(ids)()
+	fs.index.Lock()
+	batch := make(map[string]pendingPage, len(ids))
+	for _, id := range ids {
+		if p, ok := fs.index.pending[id]; ok {
+			batch[id] = p
+		}
+	}
+	fs.index.Unlock()
+	if len(batch) == 0 {
+		return
+	}
+
+	tx, err := fs.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin flushIndex")
+	}
+	stmt, err := tx.Prepare("UPDATE fts SET data=?,slug=?,title=? WHERE id=?")
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "stmt flushIndex")
+	}
+	defer stmt.Close()
+	stmtIndexed, err := tx.Prepare("UPDATE fs SET indexed=1 WHERE id=?")
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "stmt flushIndex")
+	}
+	defer stmtIndexed.Close()
+	for id, p := range batch {
+		_, err = stmt.Exec(p.data, p.slug, pageTitle(p.data), id)
+		if err == nil {
+			_, err = stmtIndexed.Exec(id)
+		}
+		if err != nil {
+			tx.Rollback()
+			return errors.Wrap(err, "exec flushIndex")
+		}
+		err = fs.setLanguage(tx, id, p.data)
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+	}
+	fs.index.publishing.Lock()
+	defer fs.index.publishing.Unlock()
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrap(err, "commit flushIndex")
+	}
+	log.Debugf("indexed %d pages", len(batch))
+
+	fs.index.Lock()
+	defer fs.index.Unlock()
+	for id := range batch {
+		delete(fs.index.pending, id)
+	}
+	return
+}
+
+// recoverIndex indexes the pages whose changes were still waiting to be
+// indexed when rwtxt stopped without closing the database, from the
+// newest version in their history
+func (fs *FileSystem) recoverIndex() (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	ids, err := fs.getAllFromPreparedQuerySingleString(`SELECT id FROM fs WHERE indexed = 0`)
+	if err != nil || len(ids) == 0 {
+		return
+	}
+	for _, id := range ids {
+		var slug string
+		var historyBytes []byte
+		err = fs.db.QueryRow(`SELECT slug,history FROM fs WHERE id = ?`, id).Scan(&slug, &historyBytes)
+		if err != nil {
+			return errors.Wrap(err, "get history")
+		}
+		history, errDecode := decodeHistory(historyBytes)
+		if errDecode != nil {
+			return errors.Wrap(errDecode, "could not parse history of "+id)
+		}
+		fs.queueIndex(id, 0, slug, history.GetCurrent())
+	}
+	err = fs.flushIndex()
+	if err == nil {
+		log.Infof("indexed %d pages that were not indexed yet", len(ids))
+	}
+	return
+}
@@ -0,0 +1,165 @@
+package db
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+)
+
+// CheckIntegrity returns the problems that sqlite finds in the database,
+// which is empty if the database is fine
+func (fs *FileSystem) CheckIntegrity() (problems []string, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+
+	rows, err := fs.db.Query(`PRAGMA integrity_check`)
+	if err != nil {
+		return nil, errors.Wrap(err, "integrity check")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var result string
+		if err = rows.Scan(&result); err != nil {
+			return nil, errors.Wrap(err, "integrity check")
+		}
+		if result != "ok" {
+			problems = append(problems, result)
+		}
+	}
+	err = rows.Err()
+	return
+}
+
+// CheckDump makes sure that the last dump of the database can be read
+// to the end, and returns its size
+func (fs *FileSystem) CheckDump() (size int64, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+
+	fi, err := os.Open(fs.name + ".sql.gz")
+	if err != nil {
+		return
+	}
+	defer fi.Close()
+	stat, err := fi.Stat()
+	if err != nil {
+		return
+	}
+	size = stat.Size()
+	gz, err := gzip.NewReader(fi)
+	if err != nil {
+		return size, errors.Wrap(err, "dump is not gzipped")
+	}
+	r := bufio.NewReader(gz)
+	last := ""
+	for {
+		line, errRead := r.ReadString('\n')
+		if strings.TrimSpace(line) != "" {
+			last = strings.TrimSpace(line)
+		}
+		if errRead == io.EOF {
+			break
+		} else if errRead != nil {
+			return size, errors.Wrap(errRead, "dump is corrupt")
+		}
+	}
+	if last != "COMMIT;" {
+		err = errors.New("dump is incomplete")
+	}
+	return
+}
+
+// ValidateDump restores the last dump into a new database, to make sure
+// that it can be restored, and returns how many pages it has
+func (fs *FileSystem) ValidateDump() (pages int, err error) {
+	fs.RLock()
+	dump, err := readDump(fs.name + ".sql.gz")
+	fs.RUnlock()
+	if err != nil {
+		return
+	}
+
+	tmp, err := ioutil.TempFile("", "rwtxt-restore")
+	if err != nil {
+		return
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	defer os.Remove(tmp.Name() + ".sql.gz")
+	restored, err := New(tmp.Name())
+	if err != nil {
+		return
+	}
+	defer restored.Close()
+
+	// the dump only has the rows, so the rows that a new database starts
+	// with are deleted first
+	tables, err := restored.getAllFromPreparedQuerySingleString(`SELECT name FROM sqlite_master
+	WHERE type = 'table' AND name NOT LIKE 'sqlite_%' AND name NOT LIKE 'fts_%'`)
+	if err != nil {
+		return
+	}
+	for _, table := range tables {
+		_, err = restored.db.Exec(`DELETE FROM "` + table + `"`)
+		if err != nil {
+			return 0, errors.Wrap(err, "emptying "+table)
+		}
+	}
+	_, err = restored.db.Exec(dump)
+	if err != nil {
+		return 0, errors.Wrap(err, "restoring dump")
+	}
+	problems, err := restored.CheckIntegrity()
+	if err != nil {
+		return
+	}
+	if len(problems) > 0 {
+		return 0, errors.New("restored dump has problems: " + strings.Join(problems, "; "))
+	}
+	err = restored.db.QueryRow(`SELECT COUNT(*) FROM fs`).Scan(&pages)
+	return
+}
+
+// loadDump fills a new database with the rows of the dump of the database
+// that it replaces, when there is one. The migrations bring the rows of a
+// dump of an older version up to date after.
+func (fs *FileSystem) loadDump() (err error) {
+	name := fs.name + ".sql.gz"
+	if _, errStat := os.Stat(name); errStat != nil {
+		return nil
+	}
+	dump, err := readDump(name)
+	if err != nil {
+		return errors.Wrap(err, "loading "+name)
+	}
+	_, err = fs.db.Exec(dump)
+	if err != nil {
+		return errors.Wrap(err, "loading "+name)
+	}
+	log.Infof("loaded %s", name)
+	return
+}
+
+// readDump returns the SQL in a gzipped dump
+func readDump(name string) (dump string, err error) {
+	fi, err := os.Open(name)
+	if err != nil {
+		return
+	}
+	defer fi.Close()
+	gz, err := gzip.NewReader(fi)
+	if err != nil {
+		return "", errors.Wrap(err, "dump is not gzipped")
+	}
+	b, err := ioutil.ReadAll(gz)
+	if err != nil {
+		return "", errors.Wrap(err, "dump is corrupt")
+	}
+	return string(b), nil
+}
@@ -0,0 +1,267 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// Language is a language of the pages of a domain, with how many pages
+// are in it
+type Language struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// languageTag is a language tag like "en", "pt-br" or "zh-hant"
+var languageTag = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})*$`)
+
+// CleanLanguage returns a language tag in lowercase, or nothing when it is
+// not one
+func CleanLanguage(lang string) string {
+	lang = strings.ToLower(strings.Replace(strings.TrimSpace(lang), "_", "-", -1))
+	if !languageTag.MatchString(lang) {
+		return ""
+	}
+	return lang
+}
+
+// pageLanguage returns the language of a text, which is the "lang" of its
+// front matter, and nothing when it does not have one
+func pageLanguage(data string) string {
+	meta, _ := utils.ParseFrontMatter(data)
+	if meta["lang"] == "" {
+		return CleanLanguage(meta["language"])
+	}
+	return CleanLanguage(meta["lang"])
+}
+
+// unspacedLanguages are the languages that are written without spaces
+// between their words, so that their words can not be told apart
+var unspacedLanguages = map[string]bool{
+	"zh": true, "ja": true, "ko": true, "th": true, "lo": true, "km": true, "my": true,
+}
+
+// unspaced returns whether a letter is of a script that is written
+// without spaces between words
+func unspaced(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul,
+		unicode.Thai, unicode.Lao, unicode.Khmer, unicode.Myanmar)
+}
+
+// indexWords returns what is indexed for search besides the text of a
+// page, which depends on its language. The letters of languages that are
+// written without spaces are indexed one by one, since the search index
+// would otherwise take a whole sentence of them as one word. Other
+// languages only need their text.
+func indexWords(lang, data string) string {
+	if !unspacedLanguages[strings.SplitN(lang, "-", 2)[0]] {
+		return ""
+	}
+	return spaceLetters(data)
+}
+
+// spaceLetters puts spaces around the letters of scripts that are written
+// without spaces, so that each one is a word
+func spaceLetters(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		if unspaced(r) {
+			b.WriteRune(' ')
+			b.WriteRune(r)
+			b.WriteRune(' ')
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// unspacedTerm returns the search for a word of a script that is written
+// without spaces in the pages that were indexed letter by letter, and
+// nothing for other words
+func unspacedTerm(word string) string {
+	if strings.IndexFunc(word, unspaced) < 0 {
+		return ""
+	}
+	return `words:"` + strings.Join(strings.Fields(spaceLetters(strings.Replace(word, `"`, "", -1))), " ") + `"`
+}
+
+// effectiveLanguage returns the language of a page that is saved with a
+// text, which is its own or else the one of its domain
+func effectiveLanguage(tx *sql.Tx, id string, data string) (lang string, err error) {
+	lang = pageLanguage(data)
+	if lang != "" {
+		return
+	}
+	err = tx.QueryRow(`SELECT IFNULL(domains.lang,'') FROM fs
+	INNER JOIN domains ON fs.domainid=domains.id WHERE fs.id = ?`, id).Scan(&lang)
+	if err == sql.ErrNoRows {
+		err = nil
+	} else if err != nil {
+		err = errors.Wrap(err, "get language of domain")
+	}
+	return
+}
+
+// setLanguage keeps the language of a page that is saved with a text, and
+// indexes the words of it that depend on the language
+func (fs *FileSystem) setLanguage(tx *sql.Tx, id string, data string) (err error) {
+	_, err = tx.Exec(`UPDATE fs SET lang = ? WHERE id = ?`, pageLanguage(data), id)
+	if err != nil {
+		return errors.Wrap(err, "exec setLanguage")
+	}
+	lang, err := effectiveLanguage(tx, id, data)
+	if err != nil {
+		return
+	}
+	_, err = tx.Exec(`UPDATE fts SET words = ? WHERE id = ?`, indexWords(lang, data), id)
+	if err != nil {
+		return errors.Wrap(err, "exec setLanguage")
+	}
+	return
+}
+
+// SetDomainLanguage sets the language of the pages of a domain that do
+// not have their own, and indexes them again for it
+func (fs *FileSystem) SetDomainLanguage(domain, lang string) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	if strings.TrimSpace(lang) != "" && CleanLanguage(lang) == "" {
+		return errors.New("'" + lang + "' is not a language")
+	}
+	lang = CleanLanguage(lang)
+	domainid, _, _, err := fs.getDomainFromName(domain)
+	if err != nil {
+		return
+	}
+	err = fs.flushIndex()
+	if err != nil {
+		return
+	}
+
+	tx, err := fs.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin SetDomainLanguage")
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	_, err = tx.Exec(`UPDATE domains SET lang = ? WHERE id = ?`, lang, domainid)
+	if err != nil {
+		return errors.Wrap(err, "exec SetDomainLanguage")
+	}
+	rows, err := tx.Query(`SELECT fs.id, fts.data FROM fs INNER JOIN fts ON fs.id=fts.id
+	WHERE fs.domainid = ? AND fs.lang = '' AND LENGTH(fts.data) > 0`, domainid)
+	if err != nil {
+		return errors.Wrap(err, "SetDomainLanguage")
+	}
+	words := make(map[string]string)
+	for rows.Next() {
+		var id, data string
+		err = rows.Scan(&id, &data)
+		if err != nil {
+			rows.Close()
+			return errors.Wrap(err, "get rows of SetDomainLanguage")
+		}
+		words[id] = indexWords(lang, data)
+	}
+	rows.Close()
+	for id, w := range words {
+		_, err = tx.Exec(`UPDATE fts SET words = ? WHERE id = ?`, w, id)
+		if err != nil {
+			return errors.Wrap(err, "exec SetDomainLanguage")
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrap(err, "commit SetDomainLanguage")
+	}
+	return
+}
+
+// GetDomainLanguage returns the language of the pages of a domain that do
+// not have their own, which is nothing when it is not set
+func (fs *FileSystem) GetDomainLanguage(domain string) (lang string, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+	err = fs.db.QueryRow(`SELECT IFNULL(lang,'') FROM domains WHERE name = ?`, domain).Scan(&lang)
+	if err == sql.ErrNoRows {
+		err = nil
+	} else if err != nil {
+		err = errors.Wrap(err, "GetDomainLanguage")
+	}
+	return
+}
+
+// GetByLanguage returns the pages of a domain in a language, the most
+// recently modified first. The pages without their own language are in
+// the language of the domain.
+func (fs *FileSystem) GetByLanguage(domain, lang string) (files []File, err error) {
+	return fs.GetByLanguageCtx(context.Background(), domain, lang)
+}
+
+// GetByLanguageCtx returns the pages of a domain in a language like
+// GetByLanguage, without the ones that are not listed for who reads with
+// the context
+func (fs *FileSystem) GetByLanguageCtx(ctx context.Context, domain, lang string) (files []File, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+	return fs.getFiles(ctx, `
+	SELECT fs.id,fs.slug,fs.created,fs.modified,fts.data,fs.history,fs.views,fs.archived FROM fs
+	INNER JOIN fts ON fs.id=fts.id
+	INNER JOIN domains ON fs.domainid=domains.id
+	WHERE
+		domains.name = ?
+		AND LENGTH(fts.data) > 0
+		AND fs.archived = 0
+		AND (fs.lang = ? OR (fs.lang = '' AND IFNULL(domains.lang,'') = ?))
+		AND (? = 0 OR fs.visibility = '')
+	ORDER BY fs.modified DESC`, domain, CleanLanguage(lang), CleanLanguage(lang), publicReader(ctx))
+}
+
+// ListLanguages returns the languages of the pages of a domain, the ones
+// of the most pages first. Pages without a language of their own or of
+// their domain are left out.
+func (fs *FileSystem) ListLanguages(domain string) (languages []Language, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+
+	rows, err := fs.db.Query(`
+	SELECT CASE WHEN fs.lang = '' THEN IFNULL(domains.lang,'') ELSE fs.lang END AS language, COUNT(*) FROM fs
+	INNER JOIN fts ON fs.id=fts.id
+	INNER JOIN domains ON fs.domainid=domains.id
+	WHERE
+		domains.name = ?
+		AND LENGTH(fts.data) > 0
+		AND fs.archived = 0
+	GROUP BY language
+	HAVING language != ''
+	ORDER BY COUNT(*) DESC, language`, domain)
+	if err != nil {
+		return nil, errors.Wrap(err, "ListLanguages")
+	}
+	defer rows.Close()
+	languages = []Language{}
+	for rows.Next() {
+		var l Language
+		err = rows.Scan(&l.Name, &l.Count)
+		if err != nil {
+			return nil, errors.Wrap(err, "get rows of ListLanguages")
+		}
+		languages = append(languages, l)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.Wrap(err, "ListLanguages")
+	}
+	return
+}
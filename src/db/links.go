@@ -0,0 +1,183 @@
+package db
+
+import (
+	"database/sql"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// pageLink finds the targets of the links in markdown
+var pageLink = regexp.MustCompile(`\]\(\s*<?([^)\s>]+)>?(?:\s+"[^"]*")?\s*\)`)
+
+// pageLinks returns the pages of its domain that a page links to, by
+// their ids or slugs, once each. The links are to /DOMAIN/PAGE, to PAGE
+// alone, or [[Page]] links.
+func pageLinks(domain, data string) (targets []string) {
+	seen := make(map[string]bool)
+	for _, match := range pageLink.FindAllStringSubmatch(data, -1) {
+		target := match[1]
+		if i := strings.IndexAny(target, "?#"); i >= 0 {
+			target = target[:i]
+		}
+		if unescaped, err := url.PathUnescape(target); err == nil {
+			target = unescaped
+		}
+		if strings.HasPrefix(target, "/") {
+			parts := strings.Split(strings.Trim(target, "/"), "/")
+			if len(parts) != 2 || utils.NormalizeDomain(parts[0]) != domain {
+				continue
+			}
+			target = parts[1]
+		} else if strings.Contains(target, ":") || strings.Contains(target, "/") {
+			continue
+		}
+		// links to versions of pages are links to the pages
+		target = strings.TrimSpace(strings.SplitN(target, "@", 2)[0])
+		if target == "" || IsReservedSlug(utils.NormalizeSlug(target)) || seen[target] {
+			continue
+		}
+		seen[target] = true
+		targets = append(targets, target)
+	}
+	for _, slug := range utils.WikiLinks(data) {
+		// pages named like the pages of rwtxt have slugs that are not
+		slug = UnreservedSlug(slug)
+		if seen[slug] {
+			continue
+		}
+		seen[slug] = true
+		targets = append(targets, slug)
+	}
+	return
+}
+
+// setLinks keeps the links of a page to the other pages of its domain.
+// Each link is kept with the id of the page it is to, when there is one,
+// and with the slug it was made with, so that links to pages that are
+// written later are found too.
+func (fs *FileSystem) setLinks(tx *sql.Tx, fileid string, domainid int, domain, data string) (err error) {
+	_, err = tx.Exec(`DELETE FROM links WHERE from_id = ?`, fileid)
+	if err != nil {
+		return errors.Wrap(err, "setLinks")
+	}
+	for _, target := range pageLinks(domain, data) {
+		var toID string
+		toSlug := utils.NormalizeSlug(target)
+		err = tx.QueryRow(`SELECT id FROM fs WHERE domainid = ? AND (id = ? OR slug = ?) ORDER BY id = ? DESC LIMIT 1`,
+			domainid, target, toSlug, target).Scan(&toID)
+		if err != nil && err != sql.ErrNoRows {
+			return errors.Wrap(err, "setLinks")
+		}
+		if toID == fileid {
+			continue
+		}
+		if toID == target {
+			toSlug = ""
+		}
+		_, err = tx.Exec(`INSERT INTO links (from_id, to_id, to_slug, domainid) VALUES (?, ?, ?, ?)`,
+			fileid, toID, toSlug, domainid)
+		if err != nil {
+			return errors.Wrap(err, "setLinks")
+		}
+	}
+	return nil
+}
+
+// indexLinks finds the links of the pages that were saved before links
+// were kept
+func (fs *FileSystem) indexLinks() (err error) {
+	rows, err := fs.db.Query(`SELECT fs.id, fs.domainid, domains.name, fts.data FROM fs
+	INNER JOIN fts ON fs.id=fts.id
+	INNER JOIN domains ON fs.domainid=domains.id
+	WHERE LENGTH(fts.data) > 0`)
+	if err != nil {
+		return errors.Wrap(err, "indexLinks")
+	}
+	type page struct {
+		id       string
+		domainid int
+		domain   string
+		data     string
+	}
+	var pages []page
+	for rows.Next() {
+		var p page
+		err = rows.Scan(&p.id, &p.domainid, &p.domain, &p.data)
+		if err != nil {
+			rows.Close()
+			return errors.Wrap(err, "get rows of indexLinks")
+		}
+		pages = append(pages, p)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil || len(pages) == 0 {
+		return
+	}
+
+	tx, err := fs.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin indexLinks")
+	}
+	for _, p := range pages {
+		err = fs.setLinks(tx, p.id, p.domainid, p.domain, p.data)
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrap(err, "commit indexLinks")
+	}
+	return
+}
+
+// GetBacklinks returns the pages of a domain that link to a page, by its
+// id or slug, the most recently modified first. Pages that are empty or in
+// the trash are left out.
+func (fs *FileSystem) GetBacklinks(id, domain string) (files []File, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+
+	domain = utils.NormalizeDomain(domain)
+	var slug string
+	err = fs.db.QueryRow(`SELECT fs.id, fs.slug FROM fs INNER JOIN domains ON fs.domainid=domains.id
+	WHERE domains.name = ? AND (fs.id = ? OR fs.slug = ?) ORDER BY fs.id = ? DESC LIMIT 1`,
+		domain, id, utils.NormalizeSlug(id), id).Scan(&id, &slug)
+	if err == sql.ErrNoRows {
+		return []File{}, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "GetBacklinks")
+	}
+
+	rows, err := fs.db.Query(`SELECT DISTINCT fs.id, fs.slug, fs.modified FROM links
+	INNER JOIN fs ON links.from_id=fs.id
+	INNER JOIN fts ON fs.id=fts.id
+	INNER JOIN domains ON links.domainid=domains.id
+	WHERE domains.name = ? AND fs.deleted = 0 AND LENGTH(fts.data) > 0 AND fs.id != ?
+		AND (links.to_id = ? OR (links.to_slug != '' AND links.to_slug = ?))
+	ORDER BY fs.modified DESC`, domain, id, id, slug)
+	if err != nil {
+		return nil, errors.Wrap(err, "GetBacklinks")
+	}
+	defer rows.Close()
+	files = []File{}
+	for rows.Next() {
+		f := File{Domain: domain}
+		err = rows.Scan(&f.ID, &f.Slug, &f.Modified)
+		if err != nil {
+			return nil, errors.Wrap(err, "get rows of GetBacklinks")
+		}
+		files = append(files, f)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.Wrap(err, "GetBacklinks")
+	}
+	return
+}
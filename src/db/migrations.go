@@ -0,0 +1,204 @@
+package db
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// migration brings the tables of a database made by an older version up to
+// the layout of the next one, or fills in what older versions did not keep
+// about their pages. Migrations are applied once the tables are made, so
+// tables that did not exist yet already have the current layout and rows
+// to fill in are only there in older databases and dumps, and they can be
+// applied again without harm.
+type migration struct {
+	version int
+	name    string
+	apply   func(fs *FileSystem) error
+}
+
+// migrations are applied in order when a database is opened, and each one
+// that is applied is recorded in the schema_version table. New migrations
+// are added to the end and never change once released.
+var migrations = []migration{
+	{1, "fs archived, indexed, deleted and lang", func(fs *FileSystem) error {
+		return fs.addColumns("fs", [][2]string{
+			{"archived", "INTEGER DEFAULT 0"},
+			{"indexed", "INTEGER DEFAULT 1"},
+			{"deleted", "INTEGER DEFAULT 0"},
+			{"lang", "TEXT DEFAULT ''"},
+		})
+	}},
+	{2, "search index in fts5 with slugs, titles and words", func(fs *FileSystem) error {
+		return fs.migrateFTS()
+	}},
+	{3, "fs visibility and sensitive", func(fs *FileSystem) error {
+		return fs.addColumns("fs", [][2]string{
+			{"visibility", "TEXT DEFAULT ''"},
+			{"sensitive", "INTEGER DEFAULT 0"},
+		})
+	}},
+	{4, "domain settings", func(fs *FileSystem) error {
+		return fs.addColumns("domains", [][2]string{
+			{"expiring", "TIMESTAMP"},
+			{"history_versions", "INTEGER DEFAULT 0"},
+			{"history_days", "INTEGER DEFAULT 0"},
+			{"lang", "TEXT DEFAULT ''"},
+			{"slug_transliterate", "INTEGER DEFAULT 0"},
+			{"slug_length", "INTEGER DEFAULT 0"},
+			{"slug_suffix", "INTEGER DEFAULT 0"},
+			{"slug_date", "INTEGER DEFAULT 0"},
+			{"noindex", "INTEGER DEFAULT 0"},
+			{"readonly", "INTEGER DEFAULT 0"},
+			{"sensitive", "INTEGER DEFAULT 0"},
+			{"bandwidth_cap", "INTEGER DEFAULT 0"},
+			{"bandwidth_placeholder", "TEXT DEFAULT ''"},
+			{"hotlink_referer", "INTEGER DEFAULT 0"},
+			{"hotlink_allow", "TEXT DEFAULT ''"},
+			{"hotlink_signed", "INTEGER DEFAULT 0"},
+		})
+	}},
+	{5, "keys for users and roles", func(fs *FileSystem) error {
+		return fs.addColumns("keys", [][2]string{
+			{"userid", "INTEGER DEFAULT 0"},
+			{"role", "TEXT DEFAULT 'admin'"},
+			{"created", "TIMESTAMP"},
+			{"remember", "INTEGER DEFAULT 1"},
+		})
+	}},
+	{6, "blob types, sizes and stores", func(fs *FileSystem) error {
+		return fs.addColumns("blobs", [][2]string{
+			{"content_type", "TEXT DEFAULT ''"},
+			{"size", "INTEGER DEFAULT 0"},
+			{"created", "TIMESTAMP"},
+			{"store", "TEXT DEFAULT 'sqlite'"},
+			{"chunked", "INTEGER DEFAULT 0"},
+		})
+	}},
+	{7, "edit authors and versions", func(fs *FileSystem) error {
+		return fs.addColumns("edits", [][2]string{
+			{"author", "TEXT DEFAULT ''"},
+			{"version", "TEXT DEFAULT ''"},
+		})
+	}},
+	{8, "not found domains and referrers", func(fs *FileSystem) error {
+		return fs.addColumns("not_found", [][2]string{
+			{"domainid", "INTEGER DEFAULT 0"},
+			{"referrer", "TEXT DEFAULT ''"},
+		})
+	}},
+	{9, "search slugs, titles and words of pages from dumps", func(fs *FileSystem) error {
+		return fs.indexSearch()
+	}},
+	{10, "uploads of pages", func(fs *FileSystem) error {
+		return fs.indexBlobRefs()
+	}},
+	{11, "links of pages", func(fs *FileSystem) error {
+		return fs.indexLinks()
+	}},
+	{12, "tags of pages", func(fs *FileSystem) error {
+		return fs.indexTags()
+	}},
+	{13, "readability of pages", func(fs *FileSystem) error {
+		return fs.indexReadability()
+	}},
+	{14, "normalized domain names and slugs", func(fs *FileSystem) error {
+		return fs.normalizeNames()
+	}},
+	{15, "compressed histories", func(fs *FileSystem) error {
+		return fs.compressHistory()
+	}},
+	{16, "nonces of form responses", func(fs *FileSystem) error {
+		return fs.addColumns("responses", [][2]string{
+			{"nonce", "TEXT DEFAULT ''"},
+		})
+	}},
+}
+
+// SchemaVersion returns the version of the layout of the database
+func (fs *FileSystem) SchemaVersion() (version int, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+	return fs.schemaVersion()
+}
+
+func (fs *FileSystem) schemaVersion() (version int, err error) {
+	err = fs.db.QueryRow(`SELECT IFNULL(MAX(version),0) FROM schema_version`).Scan(&version)
+	if err != nil {
+		err = errors.Wrap(err, "getting schema version")
+	}
+	return
+}
+
+// migrate applies the migrations that the database does not have yet
+func (fs *FileSystem) migrate() (err error) {
+	current, err := fs.schemaVersion()
+	if err != nil {
+		return
+	}
+	latest := migrations[len(migrations)-1].version
+	if current > latest {
+		err = fmt.Errorf("database has schema version %d, which is newer than %d", current, latest)
+		return
+	}
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		err = m.apply(fs)
+		if err != nil {
+			err = errors.Wrap(err, fmt.Sprintf("migration %d (%s)", m.version, m.name))
+			return
+		}
+		_, err = fs.db.Exec(`INSERT OR REPLACE INTO schema_version (version, name, applied) VALUES (?, ?, ?)`, m.version, m.name, time.Now())
+		if err != nil {
+			err = errors.Wrap(err, "recording migration")
+			return
+		}
+	}
+	return
+}
+
+// addColumns adds the columns, given as names and definitions, that an
+// existing table does not have yet
+func (fs *FileSystem) addColumns(table string, columns [][2]string) (err error) {
+	rows, err := fs.db.Query(`PRAGMA table_info(` + table + `)`)
+	if err != nil {
+		return errors.Wrap(err, "getting columns of "+table)
+	}
+	have := make(map[string]bool)
+	for rows.Next() {
+		var (
+			cid, notNull, pk int
+			name, kind       string
+			dflt             interface{}
+		)
+		err = rows.Scan(&cid, &name, &kind, &notNull, &dflt, &pk)
+		if err != nil {
+			rows.Close()
+			return errors.Wrap(err, "scanning columns of "+table)
+		}
+		have[name] = true
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return errors.Wrap(err, "getting columns of "+table)
+	}
+	if len(have) == 0 {
+		// the table does not exist
+		return nil
+	}
+	for _, c := range columns {
+		if have[c[0]] {
+			continue
+		}
+		_, err = fs.db.Exec("ALTER TABLE " + table + " ADD COLUMN " + c[0] + " " + c[1])
+		if err != nil {
+			return errors.Wrap(err, "adding "+table+"."+c[0])
+		}
+	}
+	return nil
+}
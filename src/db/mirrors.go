@@ -0,0 +1,138 @@
+package db
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// Mirror is another rwtxt that a domain is copied to. A mirror with a url
+// pushes the changes of the domain to the remote domain of that rwtxt, and
+// a mirror without one accepts the changes that another rwtxt pushes to
+// the domain. Both sides sign the changes with the same secret.
+type Mirror struct {
+	ID           int       `json:"id"`
+	Domain       string    `json:"domain"`
+	URL          string    `json:"url,omitempty"`
+	RemoteDomain string    `json:"remote_domain,omitempty"`
+	Secret       string    `json:"secret,omitempty"`
+	Created      time.Time `json:"created"`
+	Pushed       time.Time `json:"pushed"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// AddMirror adds a mirror to a domain. A mirror that accepts pushes always
+// gets a new secret, and a mirror that pushes needs the secret that the
+// rwtxt it pushes to made.
+func (fs *FileSystem) AddMirror(m Mirror) (mirror Mirror, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	m.URL = strings.TrimRight(strings.TrimSpace(m.URL), "/")
+	if m.URL != "" && !strings.HasPrefix(m.URL, "http://") && !strings.HasPrefix(m.URL, "https://") {
+		err = errors.New("mirror needs an http or https url")
+		return
+	}
+	m.RemoteDomain = strings.ToLower(strings.TrimSpace(m.RemoteDomain))
+	if m.URL != "" && m.RemoteDomain == "" {
+		m.RemoteDomain = m.Domain
+	}
+	if m.URL == "" {
+		m.Secret = utils.UUID() + utils.UUID() + utils.UUID()
+	} else if m.Secret == "" {
+		err = errors.New("mirror needs the secret of the domain it pushes to")
+		return
+	}
+	domainid, _, _, _ := fs.getDomainFromName(m.Domain)
+	if domainid == 0 {
+		err = errors.New("domain " + m.Domain + " does not exist")
+		return
+	}
+	m.Created = time.Now().UTC()
+	m.Pushed = time.Time{}
+	res, err := fs.db.Exec(`INSERT INTO mirrors (domainid, url, remotedomain, secret, created, pushed, error) VALUES (?,?,?,?,?,?,'')`,
+		domainid, m.URL, m.RemoteDomain, m.Secret, m.Created, m.Pushed)
+	if err != nil {
+		err = errors.Wrap(err, "exec AddMirror")
+		return
+	}
+	id, _ := res.LastInsertId()
+	m.ID = int(id)
+	mirror = m
+	return
+}
+
+// GetMirrors returns the mirrors of a domain, or of every domain when the
+// domain is empty
+func (fs *FileSystem) GetMirrors(domain string) (mirrors []Mirror, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+
+	rows, err := fs.db.Query(`
+	SELECT mirrors.id, domains.name, mirrors.url, mirrors.remotedomain, mirrors.secret, mirrors.created, mirrors.pushed, mirrors.error FROM mirrors
+	INNER JOIN domains ON mirrors.domainid=domains.id
+	WHERE ? = '' OR domains.name = ?
+	ORDER BY mirrors.id`, domain, domain)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	mirrors = []Mirror{}
+	for rows.Next() {
+		var m Mirror
+		err = rows.Scan(&m.ID, &m.Domain, &m.URL, &m.RemoteDomain, &m.Secret, &m.Created, &m.Pushed, &m.Error)
+		if err != nil {
+			err = errors.Wrap(err, "get rows of mirrors")
+			return
+		}
+		mirrors = append(mirrors, m)
+	}
+	err = rows.Err()
+	return
+}
+
+// DeleteMirror removes a mirror of a domain
+func (fs *FileSystem) DeleteMirror(domain string, id int) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	domainid, _, _, _ := fs.getDomainFromName(domain)
+	res, err := fs.db.Exec(`DELETE FROM mirrors WHERE id = ? AND domainid = ?`, id, domainid)
+	if err != nil {
+		return errors.Wrap(err, "exec DeleteMirror")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New("mirror does not exist")
+	}
+	return
+}
+
+// SetMirrorPushed records the time up to which the changes of a domain
+// were pushed to a mirror, or the error pushing them
+func (fs *FileSystem) SetMirrorPushed(id int, pushed time.Time, pushError string) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+	if pushError != "" {
+		_, err = fs.db.Exec(`UPDATE mirrors SET error = ? WHERE id = ?`, pushError, id)
+	} else {
+		_, err = fs.db.Exec(`UPDATE mirrors SET pushed = ?, error = '' WHERE id = ?`, pushed.UTC(), id)
+	}
+	if err != nil {
+		err = errors.Wrap(err, "exec SetMirrorPushed")
+	}
+	return
+}
+
+// ChangedSince returns the ids of the files of a domain that were saved,
+// or deleted, after a time, oldest first
+func (fs *FileSystem) ChangedSince(domain string, since time.Time) (ids []string, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+	return fs.getAllFromPreparedQuerySingleString(`
+	SELECT fs.id FROM fs
+	INNER JOIN domains ON fs.domainid=domains.id
+	WHERE domains.name = ? AND fs.modified > ?
+	ORDER BY fs.modified`, domain, since.UTC())
+}
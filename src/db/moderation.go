@@ -0,0 +1,199 @@
+package db
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// ReportReasons are why pages can be reported
+var ReportReasons = []string{"spam", "illegal", "abuse", "copyright", "other"}
+
+// The statuses of content reports. Reports are open until the page is
+// hidden while it is reviewed, put in the trash, or the report dismissed.
+const (
+	ReportOpen      = "open"
+	ReportHidden    = "hidden"
+	ReportDeleted   = "deleted"
+	ReportDismissed = "dismissed"
+)
+
+// maxReportDetails is how long what a reporter says about a page can be
+const maxReportDetails = 2000
+
+// ContentReport is a report that a page of a domain should not be on the
+// instance, for its admin to review. Reporter is a hash of who reported
+// it, so that they can not report a page many times.
+type ContentReport struct {
+	ID       int       `json:"id"`
+	Domain   string    `json:"domain"`
+	Page     string    `json:"page"`
+	Slug     string    `json:"slug"`
+	Reason   string    `json:"reason"`
+	Details  string    `json:"details,omitempty"`
+	Reporter string    `json:"-"`
+	Status   string    `json:"status"`
+	Created  time.Time `json:"created"`
+	Resolved time.Time `json:"resolved,omitempty"`
+}
+
+// AddContentReport reports a page of a domain, returning the report. A
+// page that the same reporter reported already and that was not reviewed
+// yet has its report returned instead of another.
+func (fs *FileSystem) AddContentReport(domain, id, reason, details, reporter string) (r ContentReport, err error) {
+	reason = strings.ToLower(strings.TrimSpace(reason))
+	valid := false
+	for _, known := range ReportReasons {
+		if reason == known {
+			valid = true
+		}
+	}
+	if !valid {
+		return r, errors.Errorf("reason should be one of %s", strings.Join(ReportReasons, ", "))
+	}
+	details = strings.TrimSpace(details)
+	if len(details) > maxReportDetails {
+		details = details[:maxReportDetails]
+	}
+
+	fs.Lock()
+	defer fs.Unlock()
+	domain = utils.NormalizeDomain(domain)
+	domainid, _, _, _ := fs.getDomainFromName(domain)
+	files, err := fs.getCtx(context.Background(), id, domain)
+	if domainid == 0 || err != nil {
+		return r, errors.New("page does not exist")
+	}
+	f := files[0]
+
+	var existing int
+	err = fs.db.QueryRow(`SELECT id FROM content_reports WHERE fsid = ? AND reporter = ? AND status = ?`,
+		f.ID, reporter, ReportOpen).Scan(&existing)
+	if err == nil {
+		return fs.getContentReport(existing)
+	}
+	res, err := fs.db.Exec(`INSERT INTO content_reports (domainid, fsid, slug, reason, details, reporter, status, created, resolved) VALUES (?,?,?,?,?,?,?,?,?)`,
+		domainid, f.ID, f.Slug, reason, details, reporter, ReportOpen, time.Now().UTC(), time.Time{})
+	if err != nil {
+		return r, errors.Wrap(err, "exec AddContentReport")
+	}
+	lastID, _ := res.LastInsertId()
+	return fs.getContentReport(int(lastID))
+}
+
+// contentReportColumns are the columns of a report, for scanContentReport
+const contentReportColumns = `content_reports.id, COALESCE(domains.name, ''), fsid, slug, reason, details, reporter, status, content_reports.created, resolved FROM content_reports
+	LEFT JOIN domains ON content_reports.domainid=domains.id`
+
+// scanContentReport reads a report from its columns
+func scanContentReport(scan func(...interface{}) error) (r ContentReport, err error) {
+	err = scan(&r.ID, &r.Domain, &r.Page, &r.Slug, &r.Reason, &r.Details, &r.Reporter, &r.Status, &r.Created, &r.Resolved)
+	return
+}
+
+// GetContentReports returns the reports that have a status, or that are
+// open or hidden when it is empty, the oldest first
+func (fs *FileSystem) GetContentReports(status string) (reports []ContentReport, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+	query := `SELECT ` + contentReportColumns + ` WHERE status = ? ORDER BY content_reports.id`
+	args := []interface{}{status}
+	if status == "" {
+		query = `SELECT ` + contentReportColumns + ` WHERE status IN (?, ?) ORDER BY content_reports.id`
+		args = []interface{}{ReportOpen, ReportHidden}
+	}
+	rows, err := fs.db.Query(query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "GetContentReports")
+	}
+	defer rows.Close()
+	reports = []ContentReport{}
+	for rows.Next() {
+		var r ContentReport
+		r, err = scanContentReport(rows.Scan)
+		if err != nil {
+			return nil, errors.Wrap(err, "get rows of GetContentReports")
+		}
+		reports = append(reports, r)
+	}
+	err = rows.Err()
+	return
+}
+
+func (fs *FileSystem) getContentReport(id int) (r ContentReport, err error) {
+	r, err = scanContentReport(fs.db.QueryRow(`SELECT `+contentReportColumns+` WHERE content_reports.id = ?`, id).Scan)
+	if err != nil {
+		err = errors.Wrap(err, "report does not exist")
+	}
+	return
+}
+
+// ResolveContentReport acts on a report: "hide" makes the page private
+// while it is reviewed, "delete" puts it in the trash and "dismiss" keeps
+// it, showing it again if it was hidden. Deleting or dismissing resolves
+// every report of the page.
+func (fs *FileSystem) ResolveContentReport(id int, action string) (r ContentReport, err error) {
+	fs.RLock()
+	r, err = fs.getContentReport(id)
+	// the visibility that the page had before it was hidden, when it is
+	var hidden bool
+	var visibility string
+	if err == nil {
+		errHidden := fs.db.QueryRow(`SELECT visibility FROM content_reports WHERE fsid = ? AND status = ? LIMIT 1`,
+			r.Page, ReportHidden).Scan(&visibility)
+		hidden = errHidden == nil
+	}
+	fs.RUnlock()
+	if err != nil {
+		return
+	}
+	if r.Status != ReportOpen && r.Status != ReportHidden {
+		return r, errors.New("report was resolved already")
+	}
+
+	status := ""
+	switch action {
+	case "hide":
+		if hidden {
+			return r, errors.New("page is hidden already")
+		}
+		var files []File
+		files, err = fs.GetCtx(allPages(), r.Page, r.Domain)
+		if err != nil {
+			return
+		}
+		visibility = files[0].Visibility
+		err = fs.SetVisibility(r.Domain, r.Page, VisibilityPrivate)
+		status = ReportHidden
+	case "delete":
+		err = fs.Delete(r.Page, r.Domain)
+		status = ReportDeleted
+	case "dismiss":
+		// pages that were hidden for a report are shown as they were
+		if hidden {
+			err = fs.SetVisibility(r.Domain, r.Page, visibility)
+		}
+		status = ReportDismissed
+	default:
+		return r, errors.New("action should be hide, delete or dismiss")
+	}
+	if err != nil {
+		return
+	}
+
+	fs.Lock()
+	defer fs.Unlock()
+	if status == ReportHidden {
+		_, err = fs.db.Exec(`UPDATE content_reports SET status = ?, visibility = ? WHERE id = ?`, status, visibility, id)
+	} else {
+		_, err = fs.db.Exec(`UPDATE content_reports SET status = ?, resolved = ? WHERE fsid = ? AND status IN (?, ?)`,
+			status, time.Now().UTC(), r.Page, ReportOpen, ReportHidden)
+	}
+	if err != nil {
+		return r, errors.Wrap(err, "exec ResolveContentReport")
+	}
+	return fs.getContentReport(id)
+}
@@ -0,0 +1,111 @@
+package db
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// normalizeNames normalizes the names of the domains and the slugs of the
+// pages that were saved before they were normalized. Only the names with
+// uppercase or letters that are not ASCII can change, so only those are
+// looked at. Domains whose normalized name another domain has already get
+// that name with a number after it, since they can not be merged.
+func (fs *FileSystem) normalizeNames() (err error) {
+	type name struct {
+		id   string
+		from string
+	}
+	changed := func(query string) (names []name, err error) {
+		rows, err := fs.db.Query(query)
+		if err != nil {
+			return nil, errors.Wrap(err, "normalizeNames")
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var n name
+			err = rows.Scan(&n.id, &n.from)
+			if err != nil {
+				return nil, errors.Wrap(err, "get rows of normalizeNames")
+			}
+			names = append(names, n)
+		}
+		return names, rows.Err()
+	}
+	domains, err := changed(`SELECT id, name FROM domains WHERE name != LOWER(TRIM(name)) OR name GLOB '*[^ -~]*'`)
+	if err != nil {
+		return
+	}
+	slugs, err := changed(`SELECT id, slug FROM fs WHERE slug != LOWER(TRIM(slug)) OR slug GLOB '*[^ -~]*'`)
+	if err != nil {
+		return
+	}
+
+	tx, err := fs.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin normalizeNames")
+	}
+	defer tx.Rollback()
+	renamed := 0
+	for _, d := range domains {
+		to := utils.NormalizeDomain(d.from)
+		if to == d.from {
+			continue
+		}
+		// a domain whose normalized name is taken gets the first name
+		// with a number after it that is free, which its admins are told
+		// about in its audit log
+		name := to
+		for n := 2; ; n++ {
+			var taken bool
+			err = tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM domains WHERE name = ?)
+			OR EXISTS(SELECT 1 FROM domain_renames WHERE name = ?)`, name, name).Scan(&taken)
+			if err != nil {
+				return errors.Wrap(err, "normalizeNames")
+			}
+			if !taken {
+				break
+			}
+			name = fmt.Sprintf("%s-%d", to, n)
+		}
+		_, err = tx.Exec(`UPDATE domains SET name = ? WHERE id = ?`, name, d.id)
+		if err != nil {
+			return errors.Wrap(err, "exec normalizeNames")
+		}
+		if name != to {
+			detail := fmt.Sprintf("renamed from %s to %s, since %s is another domain", d.from, name, to)
+			log.Warnf("the domain %s", detail)
+			_, err = tx.Exec(`INSERT INTO audit (created, event, domain, page, source, detail) VALUES (?,?,?,?,?,?)`,
+				time.Now().UTC(), "domain.renamed", name, "", "", detail)
+			if err != nil {
+				return errors.Wrap(err, "exec normalizeNames")
+			}
+		}
+		renamed++
+	}
+	for _, s := range slugs {
+		to := utils.NormalizeSlug(s.from)
+		if to == s.from {
+			continue
+		}
+		_, err = tx.Exec(`UPDATE fs SET slug = ? WHERE id = ?`, to, s.id)
+		if err == nil {
+			_, err = tx.Exec(`UPDATE fts SET slug = ? WHERE id = ?`, to, s.id)
+		}
+		if err != nil {
+			return errors.Wrap(err, "exec normalizeNames")
+		}
+		renamed++
+	}
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrap(err, "commit normalizeNames")
+	}
+	if renamed > 0 {
+		log.Infof("normalized %d names of domains and slugs of pages", renamed)
+	}
+	return
+}
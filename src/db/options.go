@@ -0,0 +1,191 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+// Options are the pragmas that every connection to the database is opened
+// with, and the store that blobs are kept in
+type Options struct {
+	// JournalMode is delete, truncate, persist, memory, wal or off
+	JournalMode string
+	// Synchronous is off, normal, full or extra
+	Synchronous string
+	// CacheSize is the number of pages to cache, or KiB when negative
+	CacheSize int
+	// MmapSize is the number of bytes of the database to memory map
+	MmapSize int64
+	// BusyTimeout is how long to wait for a lock held by another connection
+	BusyTimeout time.Duration
+	// ForeignKeys enforces foreign key constraints
+	ForeignKeys bool
+	// Blobs keeps the data of new uploads, which is the database when it
+	// is nil
+	Blobs BlobStore
+}
+
+// DefaultOptions are the defaults of the sqlite3 driver, which rwtxt has
+// always used
+var DefaultOptions = Options{
+	JournalMode: "delete",
+	Synchronous: "normal",
+	CacheSize:   -2000,
+	BusyTimeout: 5 * time.Second,
+}
+
+// Profiles are options for common needs. "durable" never loses a saved
+// page, "fast" can lose the last saves when the computer (not rwtxt)
+// crashes, and "memory" keeps as much as it can in memory and can corrupt
+// the database when anything crashes, so it is only for databases that
+// can be thrown away.
+var Profiles = map[string]Options{
+	"durable": {
+		JournalMode: "wal",
+		Synchronous: "full",
+		CacheSize:   -2000,
+		BusyTimeout: 10 * time.Second,
+		ForeignKeys: true,
+	},
+	"fast": {
+		JournalMode: "wal",
+		Synchronous: "normal",
+		CacheSize:   -64000,
+		MmapSize:    256 << 20,
+		BusyTimeout: 5 * time.Second,
+	},
+	"memory": {
+		JournalMode: "memory",
+		Synchronous: "off",
+		CacheSize:   -256000,
+		MmapSize:    1 << 30,
+		BusyTimeout: 5 * time.Second,
+	},
+}
+
+// ParseOptions reads options from a profile and pragmas that override it,
+// separated by commas, like "fast,cache_size=-128000,busy_timeout=30s".
+// Pragmas without a profile override the defaults.
+func ParseOptions(s string) (o Options, err error) {
+	o = DefaultOptions
+	for i, setting := range strings.Split(s, ",") {
+		setting = strings.ToLower(strings.TrimSpace(setting))
+		if setting == "" {
+			continue
+		}
+		kv := strings.SplitN(setting, "=", 2)
+		if len(kv) == 1 {
+			profile, ok := Profiles[setting]
+			if !ok {
+				err = errors.New("unknown profile " + setting + ", use one of " + strings.Join(profileNames(), ", "))
+				return
+			} else if i > 0 {
+				err = errors.New("the profile " + setting + " needs to be before the pragmas")
+				return
+			}
+			o = profile
+			continue
+		}
+		key, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		switch key {
+		case "journal_mode":
+			o.JournalMode = value
+		case "synchronous":
+			o.Synchronous = value
+		case "cache_size":
+			o.CacheSize, err = strconv.Atoi(value)
+		case "mmap_size":
+			o.MmapSize, err = strconv.ParseInt(value, 10, 64)
+		case "busy_timeout":
+			o.BusyTimeout, err = time.ParseDuration(value)
+		case "foreign_keys":
+			o.ForeignKeys, err = strconv.ParseBool(value)
+		default:
+			err = errors.New("unknown pragma " + key)
+		}
+		if err != nil {
+			err = errors.Wrap(err, "parsing "+key)
+			return
+		}
+	}
+	err = o.validate()
+	return
+}
+
+func profileNames() (names []string) {
+	for name := range Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+func (o Options) validate() (err error) {
+	switch o.JournalMode {
+	case "delete", "truncate", "persist", "memory", "wal", "off":
+	default:
+		return errors.New("journal_mode must be delete, truncate, persist, memory, wal or off")
+	}
+	switch o.Synchronous {
+	case "off", "normal", "full", "extra":
+	default:
+		return errors.New("synchronous must be off, normal, full or extra")
+	}
+	if o.MmapSize < 0 || o.BusyTimeout < 0 {
+		return errors.New("mmap_size and busy_timeout cannot be negative")
+	}
+	return
+}
+
+// dsn returns the name of the database with the options that the sqlite3
+// driver sets itself, since it sets the journal mode and synchronous level
+// of every connection it opens. Transactions take the write lock when they
+// begin, so that saves running at the same time wait for each other for
+// the busy timeout, instead of failing when they both need to write.
+func (o Options) dsn(name string) string {
+	separator := "?"
+	if strings.Contains(name, "?") {
+		separator = "&"
+	}
+	foreignKeys := 0
+	if o.ForeignKeys {
+		foreignKeys = 1
+	}
+	return fmt.Sprintf("%s%s_txlock=immediate&_journal_mode=%s&_synchronous=%s&_busy_timeout=%d&_foreign_keys=%d",
+		name, separator, strings.ToUpper(o.JournalMode), strings.ToUpper(o.Synchronous),
+		o.BusyTimeout/time.Millisecond, foreignKeys)
+}
+
+var drivers = struct {
+	names map[string]string
+	sync.Mutex
+}{names: make(map[string]string)}
+
+// driverName returns a sqlite3 driver that sets the options the driver
+// does not know about on each connection it opens, since database/sql
+// keeps a pool of them
+func (o Options) driverName() string {
+	pragmas := fmt.Sprintf("PRAGMA cache_size = %d; PRAGMA mmap_size = %d;", o.CacheSize, o.MmapSize)
+	drivers.Lock()
+	defer drivers.Unlock()
+	if name, ok := drivers.names[pragmas]; ok {
+		return name
+	}
+	name := fmt.Sprintf("sqlite3_rwtxt_%d", len(drivers.names))
+	sql.Register(name, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) (err error) {
+			_, err = conn.Exec(pragmas, nil)
+			return
+		},
+	})
+	drivers.names[pragmas] = name
+	return name
+}
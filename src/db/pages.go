@@ -0,0 +1,136 @@
+package db
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/schollz/versionedtext"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// Page is a file with the full text of every version in its history. It
+// is the JSON that is used to back up and restore pages, and is described
+// by docs/page.schema.json.
+type Page struct {
+	ID       string        `json:"id"`
+	Domain   string        `json:"domain"`
+	Slug     string        `json:"slug"`
+	Created  time.Time     `json:"created"`
+	Modified time.Time     `json:"modified"`
+	Archived bool          `json:"archived"`
+	Data     string        `json:"data"`
+	History  []PageVersion `json:"history,omitempty"`
+}
+
+// PageVersion is the text of a page at one point in its history
+type PageVersion struct {
+	Timestamp time.Time `json:"timestamp"`
+	Data      string    `json:"data"`
+}
+
+// GetPage returns a file as a page, with its history if asked for
+func (fs *FileSystem) GetPage(id, domain string, withHistory bool) (p Page, err error) {
+	return fs.GetPageCtx(context.Background(), id, domain, withHistory)
+}
+
+// GetPageCtx returns a file as a page like GetPage, unless it is private
+// and the context is not of a member of the domain
+func (fs *FileSystem) GetPageCtx(ctx context.Context, id, domain string, withHistory bool) (p Page, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+
+	files, err := fs.getCtx(ctx, id, domain)
+	if err != nil {
+		return
+	}
+	f := files[0]
+	p = Page{
+		ID:       f.ID,
+		Domain:   domain,
+		Slug:     f.Slug,
+		Created:  f.Created,
+		Modified: f.Modified,
+		Archived: f.Archived,
+		Data:     f.Data,
+	}
+	if withHistory {
+		p.History = []PageVersion{}
+		for _, v := range versions(f.History) {
+			p.History = append(p.History, PageVersion{
+				Timestamp: time.Unix(0, v.Timestamp).UTC(),
+				Data:      v.Data,
+			})
+		}
+	}
+	return
+}
+
+// PutPage saves a page in a domain. If the page has a history, it
+// replaces the history of the file, otherwise the page is saved as a new
+// version of the file.
+func (fs *FileSystem) PutPage(domain string, p Page) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	if p.ID == "" {
+		return errors.New("page needs an id")
+	}
+	files, _ := fs.getAny(p.ID, domain)
+	if len(files) == 0 || files[0].ID != p.ID {
+		// ids are unique across domains
+		exists, errExists := fs.idExists(p.ID)
+		if errExists != nil {
+			return errExists
+		}
+		if exists {
+			return errors.New("id is used in another domain")
+		}
+	}
+	if p.Created.IsZero() {
+		p.Created = time.Now().UTC()
+	}
+
+	f := File{
+		ID:      p.ID,
+		Domain:  domain,
+		Slug:    p.Slug,
+		Created: p.Created,
+		Data:    p.Data,
+	}
+	if len(p.History) == 0 {
+		err = fs.save(f)
+	} else {
+		f.History = pageHistory(p)
+		err = fs.write(f)
+	}
+	if err != nil {
+		return
+	}
+
+	_, err = fs.db.Exec(`UPDATE fs SET archived = ? WHERE id = ?`, p.Archived, p.ID)
+	if err != nil {
+		err = errors.Wrap(err, "exec PutPage")
+	}
+	return
+}
+
+// pageHistory rebuilds the history of a file from the versions of a page,
+// ending with the data of the page
+func pageHistory(p Page) (history versionedtext.VersionedText) {
+	sort.Slice(p.History, func(i, j int) bool {
+		return p.History[i].Timestamp.Before(p.History[j].Timestamp)
+	})
+	dmp := diffmatchpatch.New()
+	history = versionedtext.VersionedText{Diffs: make(map[int64]string)}
+	for _, v := range p.History {
+		if v.Data == history.CurrentText && len(history.Diffs) > 0 {
+			continue
+		}
+		history.Diffs[v.Timestamp.UnixNano()] = dmp.DiffToDelta(dmp.DiffMain(history.CurrentText, v.Data, true))
+		history.CurrentText = v.Data
+	}
+	history.Update(p.Data)
+	return
+}
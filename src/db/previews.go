@@ -0,0 +1,72 @@
+package db
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Preview is the cached title, description and image of an external link
+type Preview struct {
+	URL         string
+	Title       string
+	Description string
+	Image       string
+	Fetched     time.Time
+}
+
+// GetPreview returns the cached preview of a link
+func (fs *FileSystem) GetPreview(url string) (p Preview, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+
+	stmt, err := fs.db.Prepare("SELECT url,title,description,image,fetched FROM previews WHERE url = ?")
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	err = stmt.QueryRow(url).Scan(&p.URL, &p.Title, &p.Description, &p.Image, &p.Fetched)
+	return
+}
+
+// SavePreview caches the preview of a link
+func (fs *FileSystem) SavePreview(p Preview) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	tx, err := fs.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin SavePreview")
+	}
+	stmt, err := tx.Prepare(`
+	INSERT OR REPLACE INTO
+		previews
+	(
+		url,
+		title,
+		description,
+		image,
+		fetched
+	)
+		VALUES
+	(
+		?,
+		?,
+		?,
+		?,
+		?
+	)`)
+	if err != nil {
+		return errors.Wrap(err, "stmt SavePreview")
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(p.URL, p.Title, p.Description, p.Image, time.Now().UTC())
+	if err != nil {
+		return errors.Wrap(err, "exec SavePreview")
+	}
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrap(err, "commit SavePreview")
+	}
+	return
+}
@@ -0,0 +1,252 @@
+package db
+
+import (
+	"database/sql"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// Readability is how easy the text of a page is to read, with the
+// Flesch reading ease (higher is easier) and the Flesch-Kincaid grade
+// level, which are made for English
+type Readability struct {
+	Words           int              `json:"words"`
+	Sentences       int              `json:"sentences"`
+	Syllables       int              `json:"syllables"`
+	LongestSentence int              `json:"longest_sentence"`
+	ReadingEase     float64          `json:"reading_ease"`
+	GradeLevel      float64          `json:"grade_level"`
+	SentenceLengths []SentenceLength `json:"sentence_lengths"`
+}
+
+// SentenceLength is how many sentences have a number of words, like
+// "11-20" or "41+"
+type SentenceLength struct {
+	Words     string `json:"words"`
+	Sentences int    `json:"sentences"`
+}
+
+// sentenceLengths are the most words of the sentences in each group of
+// the sentence lengths, besides the last group, which has the longer ones
+var sentenceLengths = []int{10, 20, 30, 40}
+
+var (
+	readabilityCode  = regexp.MustCompile("(?s)```.*?(```|$)|`[^`\n]*`")
+	readabilityImage = regexp.MustCompile(`!\[[^\]]*\]\([^)]*\)`)
+	readabilityLink  = regexp.MustCompile(`\[([^\]]*)\]\([^)]*\)`)
+	readabilityHTML  = regexp.MustCompile(`<[^>]+>`)
+	readabilityItem  = regexp.MustCompile(`^\s*([-*+>]|\d+[.)])\s+`)
+	sentenceEnd      = regexp.MustCompile(`[.!?…]+["')\]]*(\s+|$)`)
+)
+
+// sentences returns the sentences of the text of a page, without its
+// front matter, code, headings and tables. Paragraphs and list items end
+// a sentence even without a full stop.
+func sentences(data string) (found []string) {
+	_, body := utils.ParseFrontMatter(data)
+	body = readabilityCode.ReplaceAllString(body, "")
+	body = readabilityImage.ReplaceAllString(body, "")
+	body = readabilityLink.ReplaceAllString(body, "$1")
+	body = readabilityHTML.ReplaceAllString(body, "")
+
+	var block []string
+	endBlock := func() {
+		text := strings.Join(block, " ")
+		block = nil
+		for _, s := range sentenceEnd.Split(text, -1) {
+			if len(readabilityWords(s)) > 0 {
+				found = append(found, s)
+			}
+		}
+	}
+	for _, line := range strings.Split(body, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, "|") || timeLine.MatchString(trimmed):
+			endBlock()
+		case readabilityItem.MatchString(line):
+			endBlock()
+			block = append(block, readabilityItem.ReplaceAllString(line, ""))
+		default:
+			block = append(block, trimmed)
+		}
+	}
+	endBlock()
+	return
+}
+
+// readabilityWords returns the words of a sentence
+func readabilityWords(sentence string) (words []string) {
+	for _, word := range strings.FieldsFunc(sentence, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+	}) {
+		word = strings.Trim(word, "'")
+		if word != "" {
+			words = append(words, word)
+		}
+	}
+	return
+}
+
+// syllables guesses the syllables of an English word by its groups of
+// vowels, where an e at the end is silent unless it follows an l
+func syllables(word string) (count int) {
+	word = strings.ToLower(word)
+	vowel := false
+	for _, r := range word {
+		isVowel := strings.ContainsRune("aeiouy", r)
+		if isVowel && !vowel {
+			count++
+		}
+		vowel = isVowel
+	}
+	if count > 1 && strings.HasSuffix(word, "e") && !strings.HasSuffix(word, "le") && !strings.HasSuffix(word, "ee") {
+		count--
+	}
+	if count == 0 {
+		count = 1
+	}
+	return
+}
+
+// measureReadability counts the words, sentences and syllables of the
+// text of a page, and the sentences in each group of sentence lengths
+func measureReadability(data string) (r Readability, lengths []int) {
+	lengths = make([]int, len(sentenceLengths)+1)
+	for _, s := range sentences(data) {
+		words := readabilityWords(s)
+		r.Sentences++
+		r.Words += len(words)
+		for _, word := range words {
+			r.Syllables += syllables(word)
+		}
+		if len(words) > r.LongestSentence {
+			r.LongestSentence = len(words)
+		}
+		group := len(sentenceLengths)
+		for i, most := range sentenceLengths {
+			if len(words) <= most {
+				group = i
+				break
+			}
+		}
+		lengths[group]++
+	}
+	r.score(lengths)
+	return
+}
+
+// score sets the scores of the counts, and the sentence lengths from the
+// number of sentences in each group
+func (r *Readability) score(lengths []int) {
+	r.SentenceLengths = make([]SentenceLength, len(lengths))
+	least := 1
+	for i := range lengths {
+		if i < len(sentenceLengths) {
+			r.SentenceLengths[i].Words = strconv.Itoa(least) + "-" + strconv.Itoa(sentenceLengths[i])
+			least = sentenceLengths[i] + 1
+		} else {
+			r.SentenceLengths[i].Words = strconv.Itoa(least) + "+"
+		}
+		r.SentenceLengths[i].Sentences = lengths[i]
+	}
+	if r.Words == 0 || r.Sentences == 0 {
+		return
+	}
+	wordsPerSentence := float64(r.Words) / float64(r.Sentences)
+	syllablesPerWord := float64(r.Syllables) / float64(r.Words)
+	r.ReadingEase = math.Round((206.835-1.015*wordsPerSentence-84.6*syllablesPerWord)*10) / 10
+	r.GradeLevel = math.Round((0.39*wordsPerSentence+11.8*syllablesPerWord-15.59)*10) / 10
+}
+
+// setReadability measures the text of a page, as part of the transaction
+// that saves it
+func (fs *FileSystem) setReadability(tx *sql.Tx, fileid string, domainid int, data string) (err error) {
+	r, lengths := measureReadability(data)
+	counts := make([]string, len(lengths))
+	for i, n := range lengths {
+		counts[i] = strconv.Itoa(n)
+	}
+	_, err = tx.Exec(`INSERT OR REPLACE INTO readability (fsid, domainid, words, sentences, syllables, longest, lengths) VALUES (?,?,?,?,?,?,?)`,
+		fileid, domainid, r.Words, r.Sentences, r.Syllables, r.LongestSentence, strings.Join(counts, ","))
+	if err != nil {
+		return errors.Wrap(err, "exec setReadability")
+	}
+	return
+}
+
+// indexReadability measures the pages that were saved before pages were
+// measured
+func (fs *FileSystem) indexReadability() (err error) {
+	rows, err := fs.db.Query(`SELECT fs.id,fs.domainid,fts.data FROM fs INNER JOIN fts ON fs.id=fts.id`)
+	if err != nil {
+		return errors.Wrap(err, "indexReadability")
+	}
+	type page struct {
+		id       string
+		domainid int
+		data     string
+	}
+	var pages []page
+	for rows.Next() {
+		var p page
+		err = rows.Scan(&p.id, &p.domainid, &p.data)
+		if err != nil {
+			rows.Close()
+			return errors.Wrap(err, "get rows of indexReadability")
+		}
+		pages = append(pages, p)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil || len(pages) == 0 {
+		return
+	}
+
+	tx, err := fs.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin indexReadability")
+	}
+	for _, p := range pages {
+		err = fs.setReadability(tx, p.id, p.domainid, p.data)
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrap(err, "commit indexReadability")
+	}
+	log.Infof("measured %d pages", len(pages))
+	return
+}
+
+// GetReadability returns how easy the text of a page is to read, as it
+// was when the page was last saved
+func (fs *FileSystem) GetReadability(fileid string) (r Readability, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+
+	var counts string
+	err = fs.db.QueryRow(`SELECT words, sentences, syllables, longest, lengths FROM readability WHERE fsid = ?`, fileid).Scan(
+		&r.Words, &r.Sentences, &r.Syllables, &r.LongestSentence, &counts)
+	if err != nil {
+		return r, errors.Wrap(err, "GetReadability")
+	}
+	lengths := make([]int, len(sentenceLengths)+1)
+	for i, count := range strings.Split(counts, ",") {
+		if i < len(lengths) {
+			lengths[i], _ = strconv.Atoi(count)
+		}
+	}
+	r.score(lengths)
+	return
+}
@@ -0,0 +1,67 @@
+package db
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// ReadOnlyError is the error of changing a page or adding an upload to a
+// domain that is read-only
+type ReadOnlyError struct {
+	Domain string
+}
+
+func (e ReadOnlyError) Error() string {
+	return "the domain " + e.Domain + " is read-only, an admin can make it writable in its options"
+}
+
+// SetDomainReadOnly sets whether a domain is read-only, which keeps its
+// pages and uploads as they are while they can still be read and searched,
+// for finished projects and imported archives
+func (fs *FileSystem) SetDomainReadOnly(domain string, readonly bool) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	domainid, _, _, _ := fs.getDomainFromName(domain)
+	if domainid == 0 || domain == "public" {
+		return errors.New("domain does not exist")
+	}
+	value := 0
+	if readonly {
+		value = 1
+	}
+	_, err = fs.db.Exec(`UPDATE domains SET readonly = ? WHERE id = ?`, value, domainid)
+	if err != nil {
+		return errors.Wrap(err, "SetDomainReadOnly")
+	}
+	return
+}
+
+// GetDomainReadOnly returns whether a domain is read-only
+func (fs *FileSystem) GetDomainReadOnly(domain string) (readonly bool, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+	return fs.getDomainReadOnly(utils.NormalizeDomain(domain))
+}
+
+func (fs *FileSystem) getDomainReadOnly(domain string) (readonly bool, err error) {
+	err = fs.db.QueryRow(`SELECT readonly FROM domains WHERE name = ?`, domain).Scan(&readonly)
+	if err == sql.ErrNoRows {
+		err = nil
+	} else if err != nil {
+		err = errors.Wrap(err, "GetDomainReadOnly")
+	}
+	return
+}
+
+// checkWritable returns a ReadOnlyError when a domain is read-only
+func (fs *FileSystem) checkWritable(domain string) (err error) {
+	domain = utils.NormalizeDomain(domain)
+	readonly, err := fs.getDomainReadOnly(domain)
+	if err == nil && readonly {
+		err = ReadOnlyError{Domain: domain}
+	}
+	return
+}
@@ -0,0 +1,432 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"io"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// maxNotFound is how many of the paths that were not found are kept, the
+// ones seen last
+const maxNotFound = 1000
+
+// Redirect is an old URL, or a pattern of them with "*", that goes to a
+// page of a domain
+type Redirect struct {
+	Pattern string `json:"pattern"`
+	Domain  string `json:"domain"`
+	Slug    string `json:"slug"`
+	Hits    int    `json:"hits"`
+}
+
+// NotFound is a path that was asked for and that is not a page or a
+// redirect, with the domain it is in, if the domain exists, and the last
+// page that linked to it
+type NotFound struct {
+	Path     string    `json:"path"`
+	Domain   string    `json:"domain"`
+	Hits     int       `json:"hits"`
+	Referrer string    `json:"referrer"`
+	First    time.Time `json:"first"`
+	Last     time.Time `json:"last"`
+}
+
+// RedirectPath returns the path of an old URL the way redirects are kept
+// and found, without the host, the query or a slash at the end, unescaped
+// and in lowercase
+func RedirectPath(oldURL string) string {
+	oldURL = strings.TrimSpace(oldURL)
+	if u, err := url.Parse(oldURL); err == nil {
+		if u.Host != "" || u.Scheme != "" {
+			oldURL = u.EscapedPath()
+		} else {
+			oldURL = strings.SplitN(oldURL, "?", 2)[0]
+		}
+	}
+	if unescaped, err := url.PathUnescape(oldURL); err == nil {
+		oldURL = unescaped
+	}
+	oldURL = strings.ToLower(oldURL)
+	if !strings.HasPrefix(oldURL, "/") {
+		oldURL = "/" + oldURL
+	}
+	if len(oldURL) > 1 {
+		oldURL = strings.TrimRight(oldURL, "/")
+	}
+	if oldURL == "" {
+		oldURL = "/"
+	}
+	return oldURL
+}
+
+// absoluteRedirect returns whether the target of a redirect is a URL or a
+// path, and not a slug of the domain
+func absoluteRedirect(target string) bool {
+	return strings.HasPrefix(target, "/") || strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://")
+}
+
+// ImportRedirects adds the redirects in a CSV file, with an old URL and
+// the slug of the page it goes to on each line, to a domain. The old URLs
+// can have "*" in them, and the text that each "*" matches takes the place
+// of the "*" in the same place of the slug, so that "/blog/*.html" and "*"
+// send "/blog/my-post.html" to "my-post". A line that starts with "#" or
+// that has no slug, and a first line that is a heading, are skipped. The redirects of old URLs
+// that were imported before are changed, and the paths that were not found
+// that now have redirects are forgotten.
+func (fs *FileSystem) ImportRedirects(domain string, r io.Reader) (imported int, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	domainid, _, _, err := fs.getDomainFromName(domain)
+	if err != nil {
+		return
+	}
+	if domainid == 0 {
+		return 0, errors.New("domain does not exist")
+	}
+
+	csvReader := csv.NewReader(r)
+	csvReader.Comment = '#'
+	csvReader.FieldsPerRecord = -1
+	csvReader.TrimLeadingSpace = true
+
+	tx, err := fs.db.Begin()
+	if err != nil {
+		return 0, errors.Wrap(err, "begin ImportRedirects")
+	}
+	defer tx.Rollback()
+	now := time.Now().UTC()
+	for line := 1; ; line++ {
+		record, errRead := csvReader.Read()
+		if errRead == io.EOF {
+			break
+		}
+		if errRead != nil {
+			return 0, errors.Wrap(errRead, "ImportRedirects")
+		}
+		if len(record) < 2 {
+			return 0, errors.Errorf("line %d should have an old URL and a slug", line)
+		}
+		oldURL, target := strings.TrimSpace(record[0]), strings.TrimSpace(record[1])
+		if line == 1 && !strings.HasPrefix(oldURL, "/") && !strings.Contains(oldURL, "://") {
+			// the heading of the columns
+			continue
+		}
+		if !absoluteRedirect(target) {
+			target = utils.NormalizeSlug(target)
+		}
+		if target == "" {
+			// an old URL that was not found, whose slug is still missing
+			continue
+		}
+		if oldURL == "" {
+			return 0, errors.Errorf("line %d should have an old URL", line)
+		}
+		pattern := RedirectPath(oldURL)
+
+		var owner int
+		err = tx.QueryRow(`SELECT domainid FROM redirects WHERE pattern = ?`, pattern).Scan(&owner)
+		if err == nil && owner != domainid {
+			return 0, errors.Errorf("line %d: %s is redirected by another domain", line, pattern)
+		}
+		_, err = tx.Exec(`INSERT OR REPLACE INTO redirects (pattern, domainid, slug, hits, created)
+		VALUES (?, ?, ?, COALESCE((SELECT hits FROM redirects WHERE pattern = ?), 0), ?)`,
+			pattern, domainid, target, pattern, now)
+		if err != nil {
+			return 0, errors.Wrap(err, "exec ImportRedirects")
+		}
+		imported++
+	}
+
+	// the paths that were not found and that are redirected now
+	redirects, err := queryRedirects(tx, `SELECT redirects.pattern, domains.name, redirects.slug, redirects.hits FROM redirects
+	INNER JOIN domains ON redirects.domainid=domains.id WHERE redirects.domainid = ?`, domainid)
+	if err != nil {
+		return 0, err
+	}
+	var paths []string
+	rows, err := tx.Query(`SELECT path FROM not_found`)
+	if err != nil {
+		return 0, errors.Wrap(err, "ImportRedirects")
+	}
+	for rows.Next() {
+		var p string
+		err = rows.Scan(&p)
+		if err != nil {
+			rows.Close()
+			return 0, errors.Wrap(err, "get rows of ImportRedirects")
+		}
+		paths = append(paths, p)
+	}
+	rows.Close()
+	for _, p := range paths {
+		for _, redirect := range redirects {
+			if _, ok := matchRedirect(redirect.Pattern, p); ok {
+				_, err = tx.Exec(`DELETE FROM not_found WHERE path = ?`, p)
+				if err != nil {
+					return 0, errors.Wrap(err, "exec ImportRedirects")
+				}
+				break
+			}
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, errors.Wrap(err, "commit ImportRedirects")
+	}
+	return
+}
+
+// ListRedirects returns the redirects to the pages of a domain, the ones
+// that are used most first
+func (fs *FileSystem) ListRedirects(domain string) (redirects []Redirect, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+	return queryRedirects(fs.db, `SELECT redirects.pattern, domains.name, redirects.slug, redirects.hits FROM redirects
+	INNER JOIN domains ON redirects.domainid=domains.id WHERE domains.name = ?
+	ORDER BY redirects.hits DESC, redirects.pattern`, utils.NormalizeDomain(domain))
+}
+
+// DeleteRedirect removes a redirect of a domain, by its old URL
+func (fs *FileSystem) DeleteRedirect(domain, pattern string) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+	res, err := fs.db.Exec(`DELETE FROM redirects WHERE pattern = ? AND domainid IN (SELECT id FROM domains WHERE name = ?)`,
+		RedirectPath(pattern), utils.NormalizeDomain(domain))
+	if err != nil {
+		return errors.Wrap(err, "DeleteRedirect")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New("redirect does not exist")
+	}
+	return
+}
+
+// DeleteRedirects removes the redirects to the pages of a domain
+func (fs *FileSystem) DeleteRedirects(domain string) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+	_, err = fs.db.Exec(`DELETE FROM redirects WHERE domainid IN (SELECT id FROM domains WHERE name = ?)`, utils.NormalizeDomain(domain))
+	if err != nil {
+		return errors.Wrap(err, "DeleteRedirects")
+	}
+	return
+}
+
+// redirectSlug sends the old path of a page whose slug changed to its new
+// slug, and the redirects that went to its old slug to the new one too,
+// so that links to the page keep working after it is renamed
+func (fs *FileSystem) redirectSlug(tx *sql.Tx, domainid int, domain, oldSlug, newSlug string) (err error) {
+	if oldSlug == "" || oldSlug == newSlug || newSlug == "" {
+		return
+	}
+	_, err = tx.Exec(`UPDATE redirects SET slug = ? WHERE domainid = ? AND slug = ?`, newSlug, domainid, oldSlug)
+	if err != nil {
+		return errors.Wrap(err, "redirectSlug")
+	}
+	// the new path is a page again
+	_, err = tx.Exec(`DELETE FROM redirects WHERE domainid = ? AND pattern = ?`, domainid, RedirectPath("/"+domain+"/"+newSlug))
+	if err != nil {
+		return errors.Wrap(err, "redirectSlug")
+	}
+	pattern := RedirectPath("/" + domain + "/" + oldSlug)
+	_, err = tx.Exec(`INSERT OR REPLACE INTO redirects (pattern, domainid, slug, hits, created)
+	VALUES (?, ?, ?, COALESCE((SELECT hits FROM redirects WHERE pattern = ?), 0), ?)`,
+		pattern, domainid, newSlug, pattern, time.Now().UTC())
+	if err != nil {
+		return errors.Wrap(err, "redirectSlug")
+	}
+	_, err = tx.Exec(`DELETE FROM not_found WHERE path = ?`, pattern)
+	if err != nil {
+		return errors.Wrap(err, "redirectSlug")
+	}
+	return
+}
+
+// FindRedirect returns where a path that is not a page goes, or "" if it
+// has no redirect. An old URL that was imported goes before the patterns,
+// and the longest pattern that matches goes before the others.
+func (fs *FileSystem) FindRedirect(oldPath string) (location string, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	p := RedirectPath(oldPath)
+	redirects, err := queryRedirects(fs.db, `SELECT redirects.pattern, domains.name, redirects.slug, redirects.hits FROM redirects
+	INNER JOIN domains ON redirects.domainid=domains.id
+	WHERE redirects.pattern = ? OR redirects.pattern LIKE '%*%'
+	ORDER BY redirects.pattern = ? DESC, LENGTH(redirects.pattern) DESC, redirects.pattern`, p, p)
+	if err != nil {
+		return
+	}
+	for _, redirect := range redirects {
+		matches, ok := matchRedirect(redirect.Pattern, p)
+		if !ok {
+			continue
+		}
+		_, err = fs.db.Exec(`UPDATE redirects SET hits = hits + 1 WHERE pattern = ?`, redirect.Pattern)
+		if err != nil {
+			return "", errors.Wrap(err, "exec FindRedirect")
+		}
+		return redirectLocation(redirect, matches), nil
+	}
+	return
+}
+
+// matchRedirect returns the text that each "*" of a pattern matches in a
+// path, and whether the path matches
+func matchRedirect(pattern, p string) (matches []string, ok bool) {
+	if !strings.Contains(pattern, "*") {
+		return nil, pattern == p
+	}
+	parts := strings.Split(pattern, "*")
+	for i := range parts {
+		parts[i] = regexp.QuoteMeta(parts[i])
+	}
+	re, err := regexp.Compile("^" + strings.Join(parts, "(.*)") + "$")
+	if err != nil {
+		return nil, false
+	}
+	found := re.FindStringSubmatch(p)
+	if found == nil {
+		return nil, false
+	}
+	return found[1:], true
+}
+
+// redirectLocation returns where a redirect goes, with the text that the
+// "*" of its pattern matched in place of the "*" of its slug
+func redirectLocation(redirect Redirect, matches []string) string {
+	absolute := absoluteRedirect(redirect.Slug)
+	parts := strings.Split(redirect.Slug, "*")
+	target := parts[0]
+	for i, part := range parts[1:] {
+		var match string
+		if i < len(matches) {
+			match = matches[i]
+			if !absolute {
+				match = utils.Slugify(strings.Replace(match, "/", " ", -1))
+			}
+		}
+		target += match + part
+	}
+	if absolute {
+		return target
+	}
+	return "/" + redirect.Domain + "/" + url.PathEscape(target)
+}
+
+// RecordNotFound counts a path that was asked for and that is not a page
+// or a redirect, with the domain it is in and the page that linked to it,
+// so that the old URLs and broken links that are still missing can be
+// found. The paths that were seen last are kept, up to maxNotFound of each
+// domain.
+func (fs *FileSystem) RecordNotFound(oldPath, referrer string) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	p := RedirectPath(oldPath)
+	domainid, _, _, _ := fs.getDomainFromName(strings.SplitN(strings.TrimPrefix(p, "/"), "/", 2)[0])
+	referrer = strings.TrimSpace(referrer)
+	now := time.Now().UTC()
+	res, err := fs.db.Exec(`UPDATE not_found SET hits = hits + 1, last = ?, domainid = ?,
+	referrer = CASE WHEN ? != '' THEN ? ELSE referrer END WHERE path = ?`, now, domainid, referrer, referrer, p)
+	if err != nil {
+		return errors.Wrap(err, "exec RecordNotFound")
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return
+	}
+	_, err = fs.db.Exec(`INSERT INTO not_found (path, hits, first, last, domainid, referrer) VALUES (?, 1, ?, ?, ?, ?)`,
+		p, now, now, domainid, referrer)
+	if err != nil {
+		return errors.Wrap(err, "exec RecordNotFound")
+	}
+	_, err = fs.db.Exec(`DELETE FROM not_found WHERE domainid = ? AND path NOT IN
+	(SELECT path FROM not_found WHERE domainid = ? ORDER BY last DESC LIMIT `+strconv.Itoa(maxNotFound)+`)`, domainid, domainid)
+	if err != nil {
+		return errors.Wrap(err, "exec RecordNotFound")
+	}
+	return
+}
+
+// GetNotFound returns the paths that were not found, the ones asked for
+// most first
+func (fs *FileSystem) GetNotFound(limit int) (paths []NotFound, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+	return fs.getNotFound(`1 = 1`, limit)
+}
+
+// GetDomainNotFound returns the paths of the pages of a domain that were
+// not found, the ones asked for most first
+func (fs *FileSystem) GetDomainNotFound(domain string, limit int) (paths []NotFound, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+	return fs.getNotFound(`domains.name = ?`, utils.NormalizeDomain(domain), limit)
+}
+
+// getNotFound returns the paths that were not found with a condition on
+// them and their domain
+func (fs *FileSystem) getNotFound(where string, args ...interface{}) (paths []NotFound, err error) {
+	rows, err := fs.db.Query(`SELECT not_found.path, COALESCE(domains.name, ''), not_found.hits,
+	COALESCE(not_found.referrer, ''), not_found.first, not_found.last FROM not_found
+	LEFT JOIN domains ON not_found.domainid=domains.id
+	WHERE `+where+`
+	ORDER BY not_found.hits DESC, not_found.last DESC LIMIT ?`, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "GetNotFound")
+	}
+	defer rows.Close()
+	paths = []NotFound{}
+	for rows.Next() {
+		var p NotFound
+		err = rows.Scan(&p.Path, &p.Domain, &p.Hits, &p.Referrer, &p.First, &p.Last)
+		if err != nil {
+			return nil, errors.Wrap(err, "get rows of GetNotFound")
+		}
+		paths = append(paths, p)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.Wrap(err, "GetNotFound")
+	}
+	return
+}
+
+// querier is a database or a transaction
+type querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// queryRedirects returns the redirects of a query of their pattern, domain,
+// slug and hits
+func queryRedirects(q querier, query string, args ...interface{}) (redirects []Redirect, err error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "queryRedirects")
+	}
+	defer rows.Close()
+	redirects = []Redirect{}
+	for rows.Next() {
+		var r Redirect
+		err = rows.Scan(&r.Pattern, &r.Domain, &r.Slug, &r.Hits)
+		if err != nil {
+			return nil, errors.Wrap(err, "get rows of queryRedirects")
+		}
+		redirects = append(redirects, r)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.Wrap(err, "queryRedirects")
+	}
+	return
+}
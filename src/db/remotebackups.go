@@ -0,0 +1,94 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RemoteBackup is what was pushed of a domain to a backup on another
+// rwtxt: until when its changes were pushed, and the version of each page
+// that was, by its id
+type RemoteBackup struct {
+	Domain   string
+	Target   string
+	Pushed   time.Time
+	Versions map[string]string
+}
+
+// GetRemoteBackup returns what was pushed of a domain to a backup, which is
+// nothing when it was never pushed
+func (fs *FileSystem) GetRemoteBackup(domain, target string) (b RemoteBackup, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+
+	domainid, _, _, _ := fs.getDomainFromName(domain)
+	if domainid == 0 {
+		err = errors.New("domain " + domain + " does not exist")
+		return
+	}
+	b = RemoteBackup{Domain: domain, Target: target, Versions: make(map[string]string)}
+	err = fs.db.QueryRow(`SELECT pushed FROM remote_backups WHERE domainid = ? AND target = ?`, domainid, target).Scan(&b.Pushed)
+	if err == sql.ErrNoRows {
+		err = nil
+	} else if err != nil {
+		err = errors.Wrap(err, "GetRemoteBackup")
+		return
+	}
+
+	rows, err := fs.db.Query(`SELECT fsid, version FROM remote_backup_pages WHERE domainid = ? AND target = ?`, domainid, target)
+	if err != nil {
+		err = errors.Wrap(err, "GetRemoteBackup")
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, version string
+		err = rows.Scan(&id, &version)
+		if err != nil {
+			err = errors.Wrap(err, "get rows of GetRemoteBackup")
+			return
+		}
+		b.Versions[id] = version
+	}
+	err = rows.Err()
+	return
+}
+
+// SetRemoteBackupPage records the version of a page that was pushed to a
+// backup, as soon as it is, so that a push that stops halfway does not push
+// it again
+func (fs *FileSystem) SetRemoteBackupPage(domain, target, id, version string) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	domainid, _, _, _ := fs.getDomainFromName(domain)
+	if domainid == 0 {
+		return errors.New("domain " + domain + " does not exist")
+	}
+	_, err = fs.db.Exec(`INSERT OR REPLACE INTO remote_backup_pages (domainid, target, fsid, version) VALUES (?,?,?,?)`,
+		domainid, target, id, version)
+	if err != nil {
+		return errors.Wrap(err, "SetRemoteBackupPage")
+	}
+	return
+}
+
+// SetRemoteBackupPushed records the time up to which the changes of a
+// domain were pushed to a backup
+func (fs *FileSystem) SetRemoteBackupPushed(domain, target string, pushed time.Time) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	domainid, _, _, _ := fs.getDomainFromName(domain)
+	if domainid == 0 {
+		return errors.New("domain " + domain + " does not exist")
+	}
+	_, err = fs.db.Exec(`INSERT OR REPLACE INTO remote_backups (domainid, target, pushed) VALUES (?,?,?)`,
+		domainid, target, pushed.UTC())
+	if err != nil {
+		return errors.Wrap(err, "SetRemoteBackupPushed")
+	}
+	return
+}
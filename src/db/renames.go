@@ -0,0 +1,89 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// RenameDomain gives a domain a new name. Its pages, members and settings
+// stay with it, and its old name is kept so that links to it can be sent
+// to the new one, which also keeps anybody else from taking the old name
+// until the domain is deleted.
+func (fs *FileSystem) RenameDomain(oldName, newName string) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	oldName = utils.NormalizeDomain(oldName)
+	newName = utils.NormalizeDomain(newName)
+	domainid, _, _, _ := fs.getDomainFromName(oldName)
+	if domainid == 0 || oldName == "public" {
+		return errors.New("domain " + oldName + " does not exist")
+	}
+	if newName == "" || newName == "public" {
+		return errors.New("need a new domain name")
+	}
+	if newName == oldName {
+		return errors.New("domain is called " + newName + " already")
+	}
+	if id, _, _, _ := fs.getDomainFromName(newName); id != 0 {
+		return errors.New("domain " + newName + " already exists")
+	}
+	// a domain can take back one of its old names, but not the old name of
+	// another domain
+	var renamedid int
+	err = fs.db.QueryRow(`SELECT domainid FROM domain_renames WHERE name = ?`, newName).Scan(&renamedid)
+	if err == nil && renamedid != domainid {
+		return errors.New("domain " + newName + " was renamed, and its name is still used for its links")
+	} else if err != nil && err != sql.ErrNoRows {
+		return errors.Wrap(err, "RenameDomain")
+	}
+
+	tx, err := fs.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin RenameDomain")
+	}
+	_, err = tx.Exec(`UPDATE domains SET name = ? WHERE id = ?`, newName, domainid)
+	if err == nil {
+		_, err = tx.Exec(`DELETE FROM domain_renames WHERE name = ?`, newName)
+	}
+	if err == nil {
+		// the audit log keeps the names of domains, so it follows it
+		_, err = tx.Exec(`UPDATE audit SET domain = ? WHERE domain = ?`, newName, oldName)
+	}
+	if err == nil {
+		_, err = tx.Exec(`INSERT OR REPLACE INTO domain_renames (name, domainid, created) VALUES (?,?,?)`,
+			oldName, domainid, time.Now().UTC())
+	}
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "exec RenameDomain")
+	}
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrap(err, "commit RenameDomain")
+	}
+	return
+}
+
+// RenamedDomain returns the name that a domain which was renamed from a
+// name has now, or nothing when no domain was
+func (fs *FileSystem) RenamedDomain(name string) (newName string, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+	return fs.renamedDomain(utils.NormalizeDomain(name))
+}
+
+func (fs *FileSystem) renamedDomain(name string) (newName string, err error) {
+	err = fs.db.QueryRow(`SELECT domains.name FROM domain_renames
+	INNER JOIN domains ON domain_renames.domainid=domains.id
+	WHERE domain_renames.name = ?`, name).Scan(&newName)
+	if err == sql.ErrNoRows {
+		err = nil
+	} else if err != nil {
+		err = errors.Wrap(err, "RenamedDomain")
+	}
+	return
+}
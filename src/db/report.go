@@ -0,0 +1,462 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// Report is what can be cleaned up across every domain: pages with
+// nothing in them, pages that no other page links to, pages in the trash,
+// uploads that no page uses and domains that nobody has written in for a
+// while
+type Report struct {
+	EmptyPages  []ReportPage
+	Orphans     []ReportPage
+	Trash       []ReportPage
+	UnusedBlobs []ReportBlob
+	IdleDomains []ReportDomain
+	NotFound    []NotFound
+}
+
+// ReportPage is a page in a report
+type ReportPage struct {
+	ID       string
+	Domain   string
+	Slug     string
+	Modified time.Time
+}
+
+// ReportBlob is an upload in a report
+type ReportBlob struct {
+	ID   string
+	Name string
+	Size int
+}
+
+// ReportDomain is a domain in a report, with when one of its pages was
+// last modified, which is zero if it has none
+type ReportDomain struct {
+	Name     string
+	Modified time.Time
+	Pages    int
+}
+
+// reportPage is a page with every text it had, to find the links and
+// uploads in it
+type reportPage struct {
+	ReportPage
+	data    string
+	texts   string
+	deleted bool
+}
+
+// reportPages returns every page of every domain
+func (fs *FileSystem) reportPages() (pages []reportPage, err error) {
+	rows, err := fs.db.Query(`
+	SELECT fs.id,domains.name,fs.slug,fs.modified,fs.deleted,fts.data,fs.history FROM fs
+	INNER JOIN fts ON fs.id=fts.id
+	INNER JOIN domains ON fs.domainid=domains.id`)
+	if err != nil {
+		return nil, errors.Wrap(err, "reportPages")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p reportPage
+		var historyBytes []byte
+		err = rows.Scan(&p.ID, &p.Domain, &p.Slug, &p.Modified, &p.deleted, &p.data, &historyBytes)
+		if err != nil {
+			return nil, errors.Wrap(err, "get rows of reportPages")
+		}
+		if data, ok := fs.pendingData(p.ID); ok {
+			p.data = data
+		}
+		// the diffs of every version are kept, so that an upload that an
+		// older version or a page in the trash uses still counts as used
+		texts := []string{p.data}
+		if len(historyBytes) > 0 {
+			history, errDecode := decodeHistory(historyBytes)
+			if errDecode != nil {
+				return nil, errors.Wrap(errDecode, "could not parse history")
+			}
+			texts = append(texts, history.CurrentText)
+			for _, diff := range history.Diffs {
+				texts = append(texts, diff)
+			}
+		}
+		p.texts = strings.Join(texts, "\n")
+		pages = append(pages, p)
+	}
+	err = rows.Err()
+	if err != nil {
+		err = errors.Wrap(err, "reportPages")
+	}
+	return
+}
+
+// isEmpty returns whether a page has nothing in it besides its front
+// matter
+func (p reportPage) isEmpty() bool {
+	_, body := utils.ParseFrontMatter(p.data)
+	return strings.TrimSpace(body) == ""
+}
+
+// linksTo returns whether a page links to another, by its path, or by its
+// slug when they are in the same domain
+func (p reportPage) linksTo(other reportPage) bool {
+	data := strings.ToLower(p.data)
+	for _, link := range []string{
+		"/" + other.Domain + "/" + strings.ToLower(other.Slug),
+		"/" + other.Domain + "/" + other.ID,
+	} {
+		if strings.Contains(data, link) {
+			return true
+		}
+	}
+	return p.Domain == other.Domain && other.Slug != "" && strings.Contains(data, "]("+strings.ToLower(other.Slug)+")")
+}
+
+// GetReport returns what can be cleaned up, with the domains that nobody
+// has written in since before idleSince
+func (fs *FileSystem) GetReport(idleSince time.Time) (report Report, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+
+	pages, err := fs.reportPages()
+	if err != nil {
+		return
+	}
+	report.EmptyPages = fs.emptyPages(pages)
+	report.Orphans = fs.orphans(pages)
+	for _, p := range pages {
+		if p.deleted {
+			report.Trash = append(report.Trash, p.ReportPage)
+		}
+	}
+	report.UnusedBlobs, err = fs.unusedBlobs(pages)
+	if err != nil {
+		return
+	}
+
+	names, err := fs.getAllFromPreparedQuerySingleString(`SELECT name FROM domains WHERE name != 'public' ORDER BY name`)
+	if err != nil {
+		return
+	}
+	domains := make(map[string]*ReportDomain)
+	for _, name := range names {
+		domains[name] = &ReportDomain{Name: name}
+	}
+	for _, p := range pages {
+		d, ok := domains[p.Domain]
+		if !ok || p.deleted {
+			continue
+		}
+		d.Pages++
+		if p.Modified.After(d.Modified) {
+			d.Modified = p.Modified
+		}
+	}
+	for _, name := range names {
+		if domains[name].Modified.Before(idleSince) {
+			report.IdleDomains = append(report.IdleDomains, *domains[name])
+		}
+	}
+	report.NotFound, err = fs.getNotFound(`1 = 1`, 100)
+	return
+}
+
+// emptyPages returns the pages that are not in the trash and have nothing
+// in them
+func (fs *FileSystem) emptyPages(pages []reportPage) (empty []ReportPage) {
+	for _, p := range pages {
+		if !p.deleted && p.isEmpty() {
+			empty = append(empty, p.ReportPage)
+		}
+	}
+	return
+}
+
+// orphans returns the pages that are not in the trash, have something in
+// them and are not linked to from any other page
+func (fs *FileSystem) orphans(pages []reportPage) (orphans []ReportPage) {
+	for _, p := range pages {
+		if p.deleted || p.isEmpty() {
+			continue
+		}
+		linked := false
+		for _, other := range pages {
+			if other.ID != p.ID && !other.deleted && other.linksTo(p) {
+				linked = true
+				break
+			}
+		}
+		if !linked {
+			orphans = append(orphans, p.ReportPage)
+		}
+	}
+	return
+}
+
+// unusedBlobs returns the uploads that no version of any page uses
+func (fs *FileSystem) unusedBlobs(pages []reportPage) (blobs []ReportBlob, err error) {
+	rows, err := fs.db.Query(`SELECT id, name, CASE WHEN chunked = 1 THEN size ELSE LENGTH(data) END FROM blobs ORDER BY name`)
+	if err != nil {
+		return nil, errors.Wrap(err, "unusedBlobs")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var b ReportBlob
+		err = rows.Scan(&b.ID, &b.Name, &b.Size)
+		if err != nil {
+			return nil, errors.Wrap(err, "get rows of unusedBlobs")
+		}
+		used := false
+		for _, p := range pages {
+			if strings.Contains(p.texts, b.ID) {
+				used = true
+				break
+			}
+		}
+		if !used {
+			blobs = append(blobs, b)
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		err = errors.Wrap(err, "unusedBlobs")
+	}
+	return
+}
+
+// RemoveEmptyPages removes the pages of every domain that have nothing in
+// them, returning how many were removed
+func (fs *FileSystem) RemoveEmptyPages() (removed int, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	pages, err := fs.reportPages()
+	if err != nil {
+		return
+	}
+	tx, err := fs.db.Begin()
+	if err != nil {
+		return 0, errors.Wrap(err, "begin RemoveEmptyPages")
+	}
+	for _, p := range fs.emptyPages(pages) {
+		err = deletePage(tx, p.ID)
+		if err != nil {
+			tx.Rollback()
+			return 0, errors.Wrap(err, "exec RemoveEmptyPages")
+		}
+		fs.unqueueIndex(p.ID)
+		removed++
+	}
+	err = tx.Commit()
+	if err != nil {
+		return 0, errors.Wrap(err, "commit RemoveEmptyPages")
+	}
+	return
+}
+
+// RemoveUnusedBlobs removes the uploads that no version of any page uses,
+// returning how many were removed
+func (fs *FileSystem) RemoveUnusedBlobs() (removed int, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	pages, err := fs.reportPages()
+	if err != nil {
+		return
+	}
+	blobs, err := fs.unusedBlobs(pages)
+	if err != nil {
+		return
+	}
+	tx, err := fs.db.Begin()
+	if err != nil {
+		return 0, errors.Wrap(err, "begin RemoveUnusedBlobs")
+	}
+	stores := make(map[string]string)
+	for _, b := range blobs {
+		stores[b.ID], err = removeBlob(tx, b.ID)
+		if err != nil {
+			tx.Rollback()
+			return 0, errors.Wrap(err, "exec RemoveUnusedBlobs")
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return 0, errors.Wrap(err, "commit RemoveUnusedBlobs")
+	}
+	fs.deleteStoredBlobs(stores)
+	removed = len(blobs)
+	return
+}
+
+// BlobCollection is what collecting the unused uploads did: the uploads
+// that were taken from a domain since none of its pages links to them,
+// the uploads that were removed since no domain has them anymore, and
+// the bytes that removing them reclaimed
+type BlobCollection struct {
+	Released  []ReportBlob
+	Removed   []ReportBlob
+	Reclaimed int64
+}
+
+// blobClaim is an upload that a domain has
+type blobClaim struct {
+	ReportBlob
+	domainid int
+	domain   string
+	created  *time.Time
+}
+
+// GarbageCollectBlobs collects the uploads of a domain, or of every domain
+// when it is empty, that no version of its pages links to and that were
+// uploaded before the grace period. An upload is taken from the domain,
+// and it is removed, with its data, once no domain has it. An upload that
+// pages of other domains link to is kept by the last domain that has it,
+// since uploads that belong to no domain can be read by anyone.
+func (fs *FileSystem) GarbageCollectBlobs(domain string, grace time.Duration) (collected BlobCollection, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	if domain != "" {
+		domainid, _, _, _ := fs.getDomainFromName(domain)
+		if domainid == 0 {
+			return collected, errors.New("domain does not exist")
+		}
+	}
+	pages, err := fs.reportPages()
+	if err != nil {
+		return
+	}
+	used := func(id, domain string) bool {
+		for _, p := range pages {
+			if (domain == "" || p.Domain == domain) && strings.Contains(p.texts, id) {
+				return true
+			}
+		}
+		return false
+	}
+	uploadedBefore := time.Now().Add(-grace)
+
+	rows, err := fs.db.Query(`
+	SELECT blobs.id, blobs.name, CASE WHEN blobs.chunked = 1 THEN blobs.size ELSE LENGTH(blobs.data) END,
+	blob_domains.domainid, domains.name, blob_domains.created FROM blob_domains
+	INNER JOIN blobs ON blobs.id=blob_domains.blobid
+	INNER JOIN domains ON blob_domains.domainid=domains.id
+	ORDER BY blobs.name, domains.name`)
+	if err != nil {
+		return collected, errors.Wrap(err, "GarbageCollectBlobs")
+	}
+	var claims []blobClaim
+	domainsOf := make(map[string]int)
+	for rows.Next() {
+		var c blobClaim
+		err = rows.Scan(&c.ID, &c.Name, &c.Size, &c.domainid, &c.domain, &c.created)
+		if err != nil {
+			rows.Close()
+			return collected, errors.Wrap(err, "get rows of GarbageCollectBlobs")
+		}
+		claims = append(claims, c)
+		domainsOf[c.ID]++
+	}
+	rows.Close()
+	err = rows.Err()
+	if err != nil {
+		return collected, errors.Wrap(err, "GarbageCollectBlobs")
+	}
+
+	var remove []ReportBlob
+	tx, err := fs.db.Begin()
+	if err != nil {
+		return collected, errors.Wrap(err, "begin GarbageCollectBlobs")
+	}
+	for _, c := range claims {
+		if domain != "" && c.domain != domain {
+			continue
+		}
+		if (c.created != nil && c.created.After(uploadedBefore)) || used(c.ID, c.domain) {
+			continue
+		}
+		if domainsOf[c.ID] == 1 && used(c.ID, "") {
+			continue
+		}
+		_, err = tx.Exec(`DELETE FROM blob_domains WHERE blobid = ? AND domainid = ?`, c.ID, c.domainid)
+		if err == nil {
+			_, err = tx.Exec(`DELETE FROM blob_refs WHERE blobid = ? AND domainid = ?`, c.ID, c.domainid)
+		}
+		if err != nil {
+			tx.Rollback()
+			return collected, errors.Wrap(err, "exec GarbageCollectBlobs")
+		}
+		collected.Released = append(collected.Released, c.ReportBlob)
+		domainsOf[c.ID]--
+		if domainsOf[c.ID] == 0 {
+			remove = append(remove, c.ReportBlob)
+		}
+	}
+
+	// uploads from before they belonged to domains are only collected
+	// across every domain
+	if domain == "" {
+		var unclaimed []ReportBlob
+		unclaimed, err = fs.unclaimedBlobs(tx, uploadedBefore)
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+		for _, b := range unclaimed {
+			if !used(b.ID, "") {
+				remove = append(remove, b)
+			}
+		}
+	}
+
+	stores := make(map[string]string)
+	for _, b := range remove {
+		stores[b.ID], err = removeBlob(tx, b.ID)
+		if err != nil {
+			tx.Rollback()
+			return BlobCollection{}, errors.Wrap(err, "exec GarbageCollectBlobs")
+		}
+		collected.Removed = append(collected.Removed, b)
+		collected.Reclaimed += int64(b.Size)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return BlobCollection{}, errors.Wrap(err, "commit GarbageCollectBlobs")
+	}
+	fs.deleteStoredBlobs(stores)
+	return
+}
+
+// unclaimedBlobs returns the uploads that no domain has, which were
+// uploaded before a time or before uploads had a time
+func (fs *FileSystem) unclaimedBlobs(tx *sql.Tx, uploadedBefore time.Time) (blobs []ReportBlob, err error) {
+	rows, err := tx.Query(`
+	SELECT id, name, CASE WHEN chunked = 1 THEN size ELSE LENGTH(data) END FROM blobs
+	WHERE id NOT IN (SELECT blobid FROM blob_domains) AND (created IS NULL OR created < ?)
+	ORDER BY name`, uploadedBefore.UTC())
+	if err != nil {
+		return nil, errors.Wrap(err, "unclaimedBlobs")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var b ReportBlob
+		err = rows.Scan(&b.ID, &b.Name, &b.Size)
+		if err != nil {
+			return nil, errors.Wrap(err, "get rows of unclaimedBlobs")
+		}
+		blobs = append(blobs, b)
+	}
+	err = rows.Err()
+	if err != nil {
+		err = errors.Wrap(err, "unclaimedBlobs")
+	}
+	return
+}
@@ -0,0 +1,147 @@
+package db
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ReservedSlugs are the slugs that rwtxt routes after the name of a domain,
+// like /notes/list, so that a page with one of them could never be seen.
+// Routes that are added there have to be added here too.
+var ReservedSlugs = []string{
+	"archived",
+	"export.zip",
+	"feed.xml",
+	"history",
+	"list",
+	"map",
+	"new",
+	"review",
+	"rss.xml",
+	"sitemap.xml",
+	"tags",
+	"takeout",
+	"time",
+	"trash",
+	"uploads",
+}
+
+// ReservedSlugError is the error of saving a page with a reserved slug
+type ReservedSlugError struct {
+	Slug string
+}
+
+func (e ReservedSlugError) Error() string {
+	return "the slug " + e.Slug + " is reserved for rwtxt, use another one"
+}
+
+// IsReservedSlug returns whether a slug is reserved. Routes are found
+// whatever their case, so the slugs are too.
+func IsReservedSlug(slug string) bool {
+	slug = strings.ToLower(strings.TrimSpace(slug))
+	for _, reserved := range ReservedSlugs {
+		if slug == reserved {
+			return true
+		}
+	}
+	return false
+}
+
+// UnreservedSlug returns a slug that is not reserved, which is the slug
+// itself unless it is reserved, for slugs that are made from titles
+func UnreservedSlug(slug string) string {
+	if IsReservedSlug(slug) {
+		return slug + "-page"
+	}
+	return slug
+}
+
+// RenamedSlug is a page that had a reserved slug, with the slug it has
+// instead
+type RenamedSlug struct {
+	ID     string `json:"id"`
+	Domain string `json:"domain"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+}
+
+// RenameReservedSlugs gives the pages that have reserved slugs, which were
+// saved before the slugs were reserved, slugs that are not reserved and
+// that no other page of their domain has. The pages are only found, and
+// not renamed, in a dry run.
+func (fs *FileSystem) RenameReservedSlugs(dryRun bool) (renamed []RenamedSlug, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+	fs.index.flushing.Lock()
+	defer fs.index.flushing.Unlock()
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ReservedSlugs)), ",")
+	args := make([]interface{}, len(ReservedSlugs))
+	for i, slug := range ReservedSlugs {
+		args[i] = slug
+	}
+	rows, err := fs.db.Query(`
+	SELECT fs.id, domains.name, fs.slug FROM fs
+	INNER JOIN domains ON fs.domainid=domains.id
+	WHERE LOWER(TRIM(fs.slug)) IN (`+placeholders+`)
+	ORDER BY domains.name, fs.slug, fs.id`, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "RenameReservedSlugs")
+	}
+	for rows.Next() {
+		var r RenamedSlug
+		err = rows.Scan(&r.ID, &r.Domain, &r.From)
+		if err != nil {
+			rows.Close()
+			return nil, errors.Wrap(err, "get rows of RenameReservedSlugs")
+		}
+		renamed = append(renamed, r)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, errors.Wrap(err, "RenameReservedSlugs")
+	}
+
+	tx, err := fs.db.Begin()
+	if err != nil {
+		return nil, errors.Wrap(err, "begin RenameReservedSlugs")
+	}
+	defer tx.Rollback()
+	for i, r := range renamed {
+		// the slug is made unique in the transaction, so that pages of a
+		// domain that are renamed together do not get the same slug
+		base := UnreservedSlug(strings.ToLower(strings.TrimSpace(r.From)))
+		r.To = base
+		for n := 2; ; n++ {
+			var taken bool
+			err = tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM fs INNER JOIN domains ON fs.domainid=domains.id
+			WHERE domains.name = ? AND (fs.slug = ? OR fs.id = ?))`, r.Domain, r.To, r.To).Scan(&taken)
+			if err != nil {
+				return nil, errors.Wrap(err, "RenameReservedSlugs")
+			}
+			if !taken {
+				break
+			}
+			r.To = base + "-" + strconv.Itoa(n)
+		}
+		renamed[i] = r
+		_, err = tx.Exec(`UPDATE fs SET slug = ? WHERE id = ?`, r.To, r.ID)
+		if err == nil {
+			_, err = tx.Exec(`UPDATE fts SET slug = ? WHERE id = ?`, r.To, r.ID)
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "exec RenameReservedSlugs")
+		}
+	}
+	if dryRun {
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, errors.Wrap(err, "commit RenameReservedSlugs")
+	}
+	return
+}
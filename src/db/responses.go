@@ -0,0 +1,101 @@
+package db
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Response is a submission of the form on a page
+type Response struct {
+	ID      int
+	FileID  string
+	Data    map[string]string
+	Created time.Time
+}
+
+// AddResponse saves a submission of the form on the file with the given id.
+// The nonce comes with the form that was shown, and a form with a nonce
+// can only be submitted once.
+func (fs *FileSystem) AddResponse(fileid, domain string, data map[string]string, source, nonce string) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	domainid, _, _, _ := fs.getDomainFromName(domain)
+	if domainid == 0 {
+		return errors.New("domain does not exist")
+	}
+	dataBytes, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+	tx, err := fs.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin AddResponse")
+	}
+	defer tx.Rollback()
+	if nonce != "" {
+		var submitted int
+		err = tx.QueryRow(`SELECT COUNT(*) FROM responses WHERE fsid = ? AND nonce = ?`, fileid, nonce).Scan(&submitted)
+		if err != nil {
+			return errors.Wrap(err, "nonce AddResponse")
+		}
+		if submitted > 0 {
+			return errors.New("form was already submitted")
+		}
+	}
+	stmt, err := tx.Prepare(`INSERT INTO responses (fsid, domainid, data, source, nonce, created) VALUES (?,?,?,?,?,?)`)
+	if err != nil {
+		return errors.Wrap(err, "stmt AddResponse")
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(fileid, domainid, string(dataBytes), source, nonce, time.Now().UTC())
+	if err != nil {
+		return errors.Wrap(err, "exec AddResponse")
+	}
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrap(err, "commit AddResponse")
+	}
+	return
+}
+
+// GetResponses returns the submissions of the form on a file, oldest first
+func (fs *FileSystem) GetResponses(fileid, domain string) (responses []Response, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+
+	stmt, err := fs.db.Prepare(`
+	SELECT responses.id, responses.fsid, responses.data, responses.created FROM responses
+	INNER JOIN domains ON responses.domainid=domains.id
+	WHERE responses.fsid = ? AND domains.name = ?
+	ORDER BY responses.created`)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query(fileid, domain)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	responses = []Response{}
+	for rows.Next() {
+		var r Response
+		var data string
+		err = rows.Scan(&r.ID, &r.FileID, &data, &r.Created)
+		if err != nil {
+			err = errors.Wrap(err, "get rows of responses")
+			return
+		}
+		err = json.Unmarshal([]byte(data), &r.Data)
+		if err != nil {
+			err = errors.Wrap(err, "could not parse response")
+			return
+		}
+		responses = append(responses, r)
+	}
+	err = rows.Err()
+	return
+}
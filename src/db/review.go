@@ -0,0 +1,105 @@
+package db
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var taskLine = regexp.MustCompile(`(?m)^\s*[-*+]\s+\[([ xX])\]\s+(.+?)\s*$`)
+
+// ReviewPage is a page that was created or edited during a review
+type ReviewPage struct {
+	ID         string
+	Slug       string
+	Words      int
+	WordsAdded int
+}
+
+// ReviewTask is a task that was checked off during a review
+type ReviewTask struct {
+	Task string
+	ID   string
+	Slug string
+}
+
+// Review summarizes what happened in a domain during a period
+type Review struct {
+	Domain     string
+	From       time.Time
+	To         time.Time
+	Created    []ReviewPage
+	Edited     []ReviewPage
+	Completed  []ReviewTask
+	WordsAdded int
+	OpenTasks  int
+}
+
+// Review compares every page in a domain at the start and end of a
+// period, to find the pages that were created or edited, the words that
+// were written and the tasks (like "- [x] task") that were completed
+func (fs *FileSystem) Review(domain string, from, to time.Time) (review Review, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+
+	review = Review{Domain: domain, From: from, To: to}
+	files, err := fs.getAllWithEmpty(domain)
+	if err != nil {
+		err = errors.Wrap(err, "getting files")
+		return
+	}
+	for _, f := range files {
+		before, errData := dataAtTime(f, from)
+		if errData != nil {
+			err = errors.Wrap(errData, "rebuilding "+f.ID)
+			return
+		}
+		after, errData := dataAtTime(f, to)
+		if errData != nil {
+			err = errors.Wrap(errData, "rebuilding "+f.ID)
+			return
+		}
+
+		done := make(map[string]bool)
+		for task, checked := range tasks(before) {
+			done[task] = checked
+		}
+		for task, checked := range tasks(after) {
+			if !checked {
+				review.OpenTasks++
+			} else if !done[task] {
+				review.Completed = append(review.Completed, ReviewTask{Task: task, ID: f.ID, Slug: f.Slug})
+			}
+		}
+
+		if before == after {
+			continue
+		}
+		page := ReviewPage{
+			ID:    f.ID,
+			Slug:  f.Slug,
+			Words: len(strings.Fields(after)),
+		}
+		page.WordsAdded = page.Words - len(strings.Fields(before))
+		if page.WordsAdded > 0 {
+			review.WordsAdded += page.WordsAdded
+		}
+		if before == "" || (f.Created.After(from) && !f.Created.After(to)) {
+			review.Created = append(review.Created, page)
+		} else {
+			review.Edited = append(review.Edited, page)
+		}
+	}
+	return
+}
+
+// tasks returns the tasks in a text, and whether each is checked
+func tasks(data string) (t map[string]bool) {
+	t = make(map[string]bool)
+	for _, match := range taskLine.FindAllStringSubmatch(data, -1) {
+		t[match[2]] = match[1] != " "
+	}
+	return
+}
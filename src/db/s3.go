@@ -0,0 +1,292 @@
+package db
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// S3Store keeps the data of blobs as objects in an S3 bucket, of Amazon
+// or of anything that speaks S3 like MinIO
+type S3Store struct {
+	// Endpoint is the url of the server, and the bucket is in its path.
+	// It is empty for Amazon, where the bucket is in the host.
+	Endpoint  string
+	Bucket    string
+	Prefix    string
+	Region    string
+	AccessKey string
+	SecretKey string
+	// SessionToken is for temporary keys
+	SessionToken string
+	Client       *http.Client
+}
+
+// ParseS3Store returns the store of an url like
+// "s3://bucket/prefix?endpoint=https://minio.example.com&region=us-east-1",
+// with the keys in AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// AWS_SESSION_TOKEN
+func ParseS3Store(description string) (s *S3Store, err error) {
+	u, err := url.Parse(description)
+	if err != nil {
+		return nil, errors.Wrap(err, "ParseS3Store")
+	}
+	if u.Host == "" {
+		return nil, errors.New("need a bucket to keep blobs in, like s3://bucket")
+	}
+	s = &S3Store{
+		Endpoint:     strings.TrimSuffix(u.Query().Get("endpoint"), "/"),
+		Bucket:       u.Host,
+		Prefix:       strings.Trim(u.Path, "/"),
+		Region:       u.Query().Get("region"),
+		AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		Client:       &http.Client{Timeout: 10 * time.Minute},
+	}
+	if s.Region == "" {
+		s.Region = "us-east-1"
+	}
+	if s.AccessKey == "" || s.SecretKey == "" {
+		return nil, errors.New("need AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY to keep blobs in S3")
+	}
+	return
+}
+
+// Name is "s3"
+func (s *S3Store) Name() string {
+	return "s3"
+}
+
+// objectURL returns the url of the object of a blob
+func (s *S3Store) objectURL(id string) string {
+	key := id
+	if s.Prefix != "" {
+		key = s.Prefix + "/" + id
+	}
+	if s.Endpoint == "" {
+		return "https://" + s.Bucket + ".s3." + s.Region + ".amazonaws.com/" + s3Escape(key)
+	}
+	return s.Endpoint + "/" + s3Escape(s.Bucket) + "/" + s3Escape(key)
+}
+
+// do signs and sends a request for the object of a blob, and returns the
+// response when it has one of the statuses that are expected
+func (s *S3Store) do(method, id string, body io.Reader, size int64, header http.Header, expected ...int) (resp *http.Response, err error) {
+	req, err := http.NewRequest(method, s.objectURL(id), body)
+	if err != nil {
+		return nil, errors.Wrap(err, method)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	// the data is not hashed before it is sent, which S3 allows since it
+	// is sent over https, and which keeps big blobs from being read twice
+	payloadHash := "UNSIGNED-PAYLOAD"
+	if body == nil {
+		payloadHash = emptyPayloadHash
+	}
+	s.sign(req, payloadHash, time.Now())
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err = client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, method)
+	}
+	for _, status := range expected {
+		if resp.StatusCode == status {
+			return
+		}
+	}
+	message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	resp.Body.Close()
+	return nil, errors.Errorf("%s %s: %s %s", method, id, resp.Status, strings.TrimSpace(string(message)))
+}
+
+// Put uploads the data of a blob as an object
+func (s *S3Store) Put(id string, r io.Reader, size int64) (err error) {
+	// the reader is closed by whoever gave it, not by the request
+	resp, err := s.do("PUT", id, ioutil.NopCloser(r), size, http.Header{"Content-Type": {"application/octet-stream"}}, http.StatusOK)
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+	return
+}
+
+// Open returns a reader of the object of a blob, which gets what is read
+// from where it was seeked to
+func (s *S3Store) Open(id string, size int64) (ReadSeekCloser, error) {
+	return &s3Reader{s: s, id: id, size: size}, nil
+}
+
+// Delete removes the object of a blob
+func (s *S3Store) Delete(id string) (err error) {
+	resp, err := s.do("DELETE", id, nil, 0, nil, http.StatusNoContent, http.StatusOK, http.StatusNotFound)
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+	return
+}
+
+// s3Reader reads an object from an offset, and gets it again from where it
+// is seeked to
+type s3Reader struct {
+	s      *S3Store
+	id     string
+	size   int64
+	offset int64
+	body   io.ReadCloser
+}
+
+func (sr *s3Reader) Read(p []byte) (read int, err error) {
+	if sr.offset >= sr.size {
+		return 0, io.EOF
+	}
+	if sr.body == nil {
+		resp, errGet := sr.s.do("GET", sr.id, nil, 0, http.Header{"Range": {fmt.Sprintf("bytes=%d-", sr.offset)}}, http.StatusPartialContent, http.StatusOK)
+		if errGet != nil {
+			return 0, errGet
+		}
+		sr.body = resp.Body
+	}
+	read, err = sr.body.Read(p)
+	sr.offset += int64(read)
+	if err == io.EOF && sr.offset < sr.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return
+}
+
+func (sr *s3Reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += sr.offset
+	case io.SeekEnd:
+		offset += sr.size
+	default:
+		return 0, errors.New("seek with an unknown whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("seek to before the start of the blob")
+	}
+	if offset != sr.offset {
+		sr.Close()
+	}
+	sr.offset = offset
+	return offset, nil
+}
+
+func (sr *s3Reader) Close() (err error) {
+	if sr.body != nil {
+		err = sr.body.Close()
+		sr.body = nil
+	}
+	return
+}
+
+// emptyPayloadHash is the hash of nothing, which requests without a body
+// are signed with
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// sign signs a request with Signature Version 4, with the host, the range
+// and the x-amz headers
+func (s *S3Store) sign(req *http.Request, payloadHash string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for key, values := range req.Header {
+		key = strings.ToLower(key)
+		if key == "range" || strings.HasPrefix(key, "x-amz-") {
+			headers[key] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	var names []string
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := day + "/" + s.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), day)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.AccessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// s3Escape escapes the segments of a key the way S3 signs them, which is
+// everything but letters, digits and -._~
+func s3Escape(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		var b strings.Builder
+		for _, c := range []byte(segment) {
+			if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("-._~", c) >= 0 {
+				b.WriteByte(c)
+			} else {
+				fmt.Fprintf(&b, "%%%02X", c)
+			}
+		}
+		segments[i] = b.String()
+	}
+	return strings.Join(segments, "/")
+}
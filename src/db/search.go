@@ -0,0 +1,371 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"html"
+	"html/template"
+	"regexp"
+	"strings"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+)
+
+// ftsWord is a word that FTS5 searches for as it is
+var ftsWord = regexp.MustCompile(`^[\p{L}\p{N}_]+\*?$`)
+
+// ftsTerm quotes a word or phrase unless FTS5 searches for it as it is
+func ftsTerm(word string) string {
+	if ftsWord.MatchString(word) {
+		return word
+	}
+	return `"` + strings.Replace(word, `"`, `""`, -1) + `"`
+}
+
+// isFTSOperator returns whether a word of a search is FTS5 syntax
+func isFTSOperator(word string) bool {
+	switch word {
+	case "AND", "OR", "NOT", "(", ")":
+		return true
+	}
+	return false
+}
+
+// titleWeight is how much more a match in the slug or title of a page
+// counts than a match in its body, when ranking searches
+const titleWeight = 10.0
+
+// pageTitle returns the first heading of a page, which is indexed apart
+// from the body so that it can be ranked higher
+func pageTitle(data string) string {
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "#") {
+			return strings.TrimSpace(strings.TrimLeft(line, "#"))
+		}
+	}
+	return ""
+}
+
+// migrateFTS moves the search index of databases made by older versions,
+// which is in FTS4 or does not have the slugs, titles and the words that
+// depend on the language of the pages, to the current one
+func (fs *FileSystem) migrateFTS() (err error) {
+	var sqlStmt string
+	err = fs.db.QueryRow(`SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'fts'`).Scan(&sqlStmt)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil || strings.Contains(strings.ToLower(sqlStmt), "words") {
+		return
+	}
+
+	tx, err := fs.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin migrateFTS")
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	_, err = tx.Exec(`CREATE VIRTUAL TABLE fts_new USING fts5 (id UNINDEXED,data,slug,title,words)`)
+	if err != nil {
+		return errors.Wrap(err, "exec migrateFTS")
+	}
+	rows, err := tx.Query(`SELECT fts.id,fts.data,IFNULL(fs.slug,'') FROM fts LEFT JOIN fs ON fs.id=fts.id`)
+	if err != nil {
+		return errors.Wrap(err, "query migrateFTS")
+	}
+	type ftsRow struct {
+		id, data, slug string
+	}
+	var ftsRows []ftsRow
+	for rows.Next() {
+		var r ftsRow
+		err = rows.Scan(&r.id, &r.data, &r.slug)
+		if err != nil {
+			rows.Close()
+			return errors.Wrap(err, "scan migrateFTS")
+		}
+		ftsRows = append(ftsRows, r)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return errors.Wrap(err, "query migrateFTS")
+	}
+	for _, r := range ftsRows {
+		lang := pageLanguage(r.data)
+		_, err = tx.Exec(`INSERT INTO fts_new (id,data,slug,title,words) VALUES (?,?,?,?,?)`, r.id, r.data, r.slug, pageTitle(r.data), indexWords(lang, r.data))
+		if err == nil {
+			_, err = tx.Exec(`UPDATE fs SET lang = ? WHERE id = ?`, lang, r.id)
+		}
+		if err != nil {
+			return errors.Wrap(err, "insert migrateFTS")
+		}
+	}
+	_, err = tx.Exec(`
+	DROP TABLE fts;
+	ALTER TABLE fts_new RENAME TO fts;
+	`)
+	if err != nil {
+		return errors.Wrap(err, "exec migrateFTS")
+	}
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrap(err, "commit migrateFTS")
+	}
+	log.Infof("rebuilt the search index of %d pages with their slugs, titles and languages", len(ftsRows))
+	return
+}
+
+// indexSearch fills in the slugs, titles and words in the search index of
+// the pages that were loaded from the dump of an older version, which
+// only has their text
+func (fs *FileSystem) indexSearch() (err error) {
+	rows, err := fs.db.Query(`SELECT fts.id, fts.data, IFNULL(fs.slug,'') FROM fts
+	LEFT JOIN fs ON fs.id=fts.id WHERE fts.title IS NULL OR fts.words IS NULL`)
+	if err != nil {
+		return errors.Wrap(err, "indexSearch")
+	}
+	type page struct {
+		id, data, slug string
+	}
+	var pages []page
+	for rows.Next() {
+		var p page
+		err = rows.Scan(&p.id, &p.data, &p.slug)
+		if err != nil {
+			rows.Close()
+			return errors.Wrap(err, "get rows of indexSearch")
+		}
+		pages = append(pages, p)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil || len(pages) == 0 {
+		return
+	}
+
+	tx, err := fs.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin indexSearch")
+	}
+	for _, p := range pages {
+		_, err = tx.Exec(`UPDATE fts SET slug = ?, title = ? WHERE id = ?`, p.slug, pageTitle(p.data), p.id)
+		if err == nil {
+			err = fs.setLanguage(tx, p.id, p.data)
+		}
+		if err != nil {
+			tx.Rollback()
+			return errors.Wrap(err, "exec indexSearch")
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrap(err, "commit indexSearch")
+	}
+	log.Infof("indexed the slugs, titles and words of %d pages", len(pages))
+	return
+}
+
+// FindRanked finds files like Find, with the best matches first, and
+// with how many times each matched and a snippet of the best match.
+// Matches in the slug or title count more than those in the body. The
+// files that only matched in their history come after the others.
+func (fs *FileSystem) FindRanked(text string, domain string) (files []File, err error) {
+	return fs.FindRankedCtx(context.Background(), text, domain)
+}
+
+// FindRankedCtx finds files like FindRanked, and stops when the context
+// is done
+func (fs *FileSystem) FindRankedCtx(ctx context.Context, text string, domain string) (files []File, err error) {
+	files, _, err = fs.FindRankedOffsetCtx(ctx, text, domain, -1, 0)
+	return
+}
+
+// FindRankedOffset finds files like FindRanked, but returns only num of
+// them after skipping offset of them, and how many were found in total. A
+// negative num returns all of them.
+func (fs *FileSystem) FindRankedOffset(text string, domain string, num int, offset int) (files []File, total int, err error) {
+	return fs.FindRankedOffsetCtx(context.Background(), text, domain, num, offset)
+}
+
+// FindRankedOffsetCtx finds files like FindRankedOffset, and stops when
+// the context is done
+func (fs *FileSystem) FindRankedOffsetCtx(ctx context.Context, text string, domain string, num int, offset int) (files []File, total int, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+	defer func() {
+		if err == nil {
+			fs.hideSensitiveSnippets(ctx, files)
+		}
+	}()
+
+	includeArchived := 0
+	if strings.Contains(text, "include:archived") {
+		text = strings.TrimSpace(strings.Replace(text, "include:archived", "", -1))
+		includeArchived = 1
+	}
+	// the files that matched as they are now and the ones that matched
+	// in their history are found apart, so they are paged together after
+	queryNum, queryOffset := num, offset
+	includeHistory := strings.Contains(text, "include:history")
+	if includeHistory {
+		text = strings.TrimSpace(strings.Replace(text, "include:history", "", -1))
+		queryNum, queryOffset = -1, 0
+	}
+
+	rows, err := fs.db.QueryContext(ctx, `
+		SELECT fs.id,fs.slug,fs.created,fs.modified,fts.data,fs.history,fs.views,fs.archived,
+			snippet(fts,1,char(2),char(3),'...',15),highlight(fts,1,char(2),char(3)) FROM fts 
+			INNER JOIN fs ON fs.id=fts.id 
+			INNER JOIN domains ON fs.domainid=domains.id
+			WHERE fts MATCH ?
+			AND domains.name = ?
+			AND fs.archived <= ?
+			AND (? = 0 OR fs.visibility = '')
+			ORDER BY bm25(fts,0,1,?,?,1) LIMIT ? OFFSET ?`, fs.searchQuery(text, domain), domain, includeArchived, publicReader(ctx), titleWeight, titleWeight, queryNum, queryOffset)
+	if err != nil {
+		err = errors.Wrap(err, "FindRanked")
+		return
+	}
+	defer rows.Close()
+	files = []File{}
+	for rows.Next() {
+		var f File
+		f, err = scanMatch(rows)
+		if err != nil {
+			return
+		}
+		files = append(files, f)
+	}
+	err = rows.Err()
+	if err != nil {
+		err = errors.Wrap(err, "FindRanked")
+		return
+	}
+	if includeHistory {
+		var older []File
+		older, err = fs.findHistory(ctx, text, domain, includeArchived, files)
+		if err != nil {
+			return
+		}
+		files = append(files, older...)
+		total = len(files)
+		files = pageFiles(files, num, offset)
+		return
+	}
+	total, err = fs.countMatches(ctx, text, domain, includeArchived, num, offset, len(files))
+	return
+}
+
+// FindEach finds files like Find, and calls fn with each one as soon as
+// it is found, in no particular order, so that the first ones can be shown
+// before the whole index is searched. It stops at the first error from fn.
+func (fs *FileSystem) FindEach(ctx context.Context, text string, domain string, fn func(f File) error) (err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+
+	includeArchived := 0
+	if strings.Contains(text, "include:archived") {
+		text = strings.TrimSpace(strings.Replace(text, "include:archived", "", -1))
+		includeArchived = 1
+	}
+
+	rows, err := fs.db.QueryContext(ctx, `
+		SELECT fs.id,fs.slug,fs.created,fs.modified,fts.data,fs.history,fs.views,fs.archived,
+			snippet(fts,1,char(2),char(3),'...',15),highlight(fts,1,char(2),char(3)) FROM fts 
+			INNER JOIN fs ON fs.id=fts.id 
+			INNER JOIN domains ON fs.domainid=domains.id
+			WHERE fts MATCH ?
+			AND domains.name = ?
+			AND fs.archived <= ?
+			AND (? = 0 OR fs.visibility = '')`, fs.searchQuery(text, domain), domain, includeArchived, publicReader(ctx))
+	if err != nil {
+		err = errors.Wrap(err, "FindEach")
+		return
+	}
+	defer rows.Close()
+	// who is not a member of the domain does not see the snippets of its
+	// sensitive pages
+	var isSensitive map[string]bool
+	if publicReader(ctx) == 1 {
+		isSensitive, _ = fs.sensitiveIDs()
+	}
+	for rows.Next() {
+		var f File
+		f, err = scanMatch(rows)
+		if err != nil {
+			return
+		}
+		if isSensitive[f.ID] {
+			f.Snippet, f.DataHTML = "", ""
+		}
+		err = fn(f)
+		if err != nil {
+			return
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		err = errors.Wrap(err, "FindEach")
+	}
+	return
+}
+
+// scanMatch reads a file that was found, with its snippet and how many
+// times it matched
+func scanMatch(rows *sql.Rows) (f File, err error) {
+	var history []byte
+	var snippet, highlighted string
+	err = rows.Scan(
+		&f.ID,
+		&f.Slug,
+		&f.Created,
+		&f.Modified,
+		&f.Data,
+		&history,
+		&f.Views,
+		&f.Archived,
+		&snippet,
+		&highlighted,
+	)
+	if err != nil {
+		err = errors.Wrap(err, "get rows of match")
+		return
+	}
+	if len(history) > 0 {
+		f.History, err = decodeHistory(history)
+		if err != nil {
+			err = errors.Wrap(err, "could not parse history")
+			return
+		}
+	}
+	// matches are marked with control characters, so that the snippets
+	// can be escaped before they are highlighted
+	f.Matches = strings.Count(highlighted, "\x02")
+	f.Snippet = template.HTML(strings.NewReplacer("\x02", "<b>", "\x03", "</b>").Replace(html.EscapeString(snippet)))
+	f.DataHTML = f.Snippet
+	f.Language = pageLanguage(f.Data)
+	return
+}
+
+// countMatches returns how many files match a search in total. It is only
+// counted when the search was limited, since otherwise every file was
+// found already.
+func (fs *FileSystem) countMatches(ctx context.Context, text string, domain string, includeArchived int, num int, offset int, found int) (total int, err error) {
+	if num < 0 {
+		total = offset + found
+		return
+	}
+	return fs.count(ctx, `
+		SELECT COUNT(*) FROM fts 
+			INNER JOIN fs ON fs.id=fts.id 
+			INNER JOIN domains ON fs.domainid=domains.id
+			WHERE fts MATCH ?
+			AND domains.name = ?
+			AND fs.archived <= ?
+			AND (? = 0 OR fs.visibility = '')`, fs.searchQuery(text, domain), domain, includeArchived, publicReader(ctx))
+}
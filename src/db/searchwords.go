@@ -0,0 +1,211 @@
+package db
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// SearchWords are the words that the searches of a domain leave out, and
+// the groups of words that they treat as the same, like "k8s" and
+// "kubernetes". They are applied to searches rather than to the index, so
+// changing them does not need the pages to be indexed again.
+type SearchWords struct {
+	StopWords []string   `json:"stop_words"`
+	Synonyms  [][]string `json:"synonyms"`
+}
+
+// GetSearchWords returns the stop words and synonyms of a domain
+func (fs *FileSystem) GetSearchWords(domain string) (sw SearchWords, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+	return fs.getSearchWords(domain)
+}
+
+func (fs *FileSystem) getSearchWords(domain string) (sw SearchWords, err error) {
+	sw.StopWords, err = fs.getAllFromPreparedQuerySingleString(`
+	SELECT stopwords.word FROM stopwords
+	INNER JOIN domains ON stopwords.domainid=domains.id
+	WHERE domains.name = ?
+	ORDER BY stopwords.word`, domain)
+	if err != nil {
+		return
+	}
+
+	rows, err := fs.db.Query(`
+	SELECT synonyms.grp, synonyms.word FROM synonyms
+	INNER JOIN domains ON synonyms.domainid=domains.id
+	WHERE domains.name = ?
+	ORDER BY synonyms.grp, synonyms.rowid`, domain)
+	if err != nil {
+		return sw, errors.Wrap(err, "getSearchWords")
+	}
+	defer rows.Close()
+	lastGroup := -1
+	for rows.Next() {
+		var group int
+		var word string
+		err = rows.Scan(&group, &word)
+		if err != nil {
+			return sw, errors.Wrap(err, "get rows of synonyms")
+		}
+		if group != lastGroup {
+			sw.Synonyms = append(sw.Synonyms, []string{})
+			lastGroup = group
+		}
+		sw.Synonyms[len(sw.Synonyms)-1] = append(sw.Synonyms[len(sw.Synonyms)-1], word)
+	}
+	err = rows.Err()
+	if err != nil {
+		err = errors.Wrap(err, "getSearchWords")
+	}
+	return
+}
+
+// SetSearchWords replaces the stop words and synonyms of a domain. Words
+// are kept in lowercase, and groups of synonyms need at least two words.
+func (fs *FileSystem) SetSearchWords(domain string, sw SearchWords) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	domainid, _, _, _ := fs.getDomainFromName(domain)
+	if domainid == 0 {
+		return errors.New("domain does not exist")
+	}
+
+	tx, err := fs.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin SetSearchWords")
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	_, err = tx.Exec(`DELETE FROM stopwords WHERE domainid = ?`, domainid)
+	if err == nil {
+		_, err = tx.Exec(`DELETE FROM synonyms WHERE domainid = ?`, domainid)
+	}
+	if err != nil {
+		return errors.Wrap(err, "exec delete search words")
+	}
+	for _, word := range cleanWords(sw.StopWords) {
+		_, err = tx.Exec(`INSERT INTO stopwords (domainid, word) VALUES (?,?)`, domainid, word)
+		if err != nil {
+			return errors.Wrap(err, "exec insert stop word")
+		}
+	}
+	for i, group := range sw.Synonyms {
+		words := cleanWords(group)
+		if len(words) < 2 {
+			continue
+		}
+		for _, word := range words {
+			_, err = tx.Exec(`INSERT INTO synonyms (domainid, grp, word) VALUES (?,?,?)`, domainid, i, word)
+			if err != nil {
+				return errors.Wrap(err, "exec insert synonym")
+			}
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrap(err, "commit SetSearchWords")
+	}
+	return
+}
+
+// cleanWords returns words in lowercase, once each
+func cleanWords(words []string) (cleaned []string) {
+	seen := make(map[string]bool)
+	for _, word := range words {
+		word = strings.ToLower(strings.Join(strings.Fields(word), " "))
+		if word == "" || seen[word] {
+			continue
+		}
+		seen[word] = true
+		cleaned = append(cleaned, word)
+	}
+	return
+}
+
+// searchQuery turns a search of a domain into a query for FTS5, with the
+// stop words and synonyms of the domain
+func (fs *FileSystem) searchQuery(text, domain string) string {
+	sw, err := fs.getSearchWords(domain)
+	if err != nil {
+		sw = SearchWords{}
+	}
+	return sw.query(text)
+}
+
+// query turns a search into a query for FTS5. Stop words are left out,
+// unless the search has nothing else, and each word that has synonyms
+// matches any of them. Words that FTS5 would read as syntax, like
+// "rwtxt.db" or "key:value", are quoted, since FTS4 searched for them as
+// they were. Searches with quotes are left alone, since they are already
+// written for FTS5.
+func (sw SearchWords) query(text string) string {
+	if strings.Contains(text, `"`) {
+		return text
+	}
+	stop := make(map[string]bool)
+	for _, word := range sw.StopWords {
+		stop[word] = true
+	}
+	synonyms := make(map[string][]string)
+	for _, group := range sw.Synonyms {
+		for _, word := range group {
+			synonyms[word] = append(synonyms[word], group...)
+		}
+	}
+
+	var terms []string
+	for _, word := range strings.Fields(text) {
+		lower := strings.ToLower(word)
+		if isFTSOperator(word) {
+			terms = append(terms, word)
+		} else if stop[lower] {
+			continue
+		} else if group, ok := synonyms[lower]; ok {
+			group = cleanWords(group)
+			alternatives := make([]string, len(group))
+			for i, synonym := range group {
+				alternatives[i] = ftsTerm(synonym)
+			}
+			terms = append(terms, "("+strings.Join(alternatives, " OR ")+")")
+		} else if unspaced := unspacedTerm(word); unspaced != "" {
+			// pages in languages that are written without spaces are
+			// searched letter by letter
+			terms = append(terms, "("+ftsTerm(word)+" OR "+unspaced+")")
+		} else {
+			terms = append(terms, ftsTerm(word))
+		}
+	}
+	terms = trimOperators(terms)
+	if len(terms) == 0 && len(stop) > 0 {
+		return SearchWords{}.query(text)
+	}
+	return strings.Join(terms, " ")
+}
+
+// trimOperators removes the AND, OR and NOT that were left with nothing
+// on one side when stop words were left out
+func trimOperators(terms []string) []string {
+	binary := func(term string) bool {
+		return term == "AND" || term == "OR" || term == "NOT"
+	}
+	var trimmed []string
+	for _, term := range terms {
+		if binary(term) && (len(trimmed) == 0 || binary(trimmed[len(trimmed)-1]) || trimmed[len(trimmed)-1] == "(") {
+			continue
+		}
+		if term == ")" && len(trimmed) > 0 && binary(trimmed[len(trimmed)-1]) {
+			trimmed = trimmed[:len(trimmed)-1]
+		}
+		trimmed = append(trimmed, term)
+	}
+	for len(trimmed) > 0 && binary(trimmed[len(trimmed)-1]) {
+		trimmed = trimmed[:len(trimmed)-1]
+	}
+	return trimmed
+}
@@ -0,0 +1,113 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/pkg/errors"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// SetSensitive marks whether a page of a domain is sensitive, so that who
+// is not a member of the domain is warned before they see it
+func (fs *FileSystem) SetSensitive(domain, id string, sensitive bool) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	domainid, _, _, _ := fs.getDomainFromName(domain)
+	if domainid == 0 {
+		return errors.New("domain " + domain + " does not exist")
+	}
+	res, err := fs.db.Exec(`UPDATE fs SET sensitive = ? WHERE domainid = ? AND (id = ? OR slug = ?)`,
+		sensitive, domainid, id, utils.NormalizeSlug(id))
+	if err != nil {
+		return errors.Wrap(err, "SetSensitive")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New("no files with that slug or id")
+	}
+	return
+}
+
+// SetDomainSensitive marks whether every page of a domain is sensitive
+func (fs *FileSystem) SetDomainSensitive(domain string, sensitive bool) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	domainid, _, _, _ := fs.getDomainFromName(domain)
+	if domainid == 0 || domain == "public" {
+		return errors.New("domain does not exist")
+	}
+	_, err = fs.db.Exec(`UPDATE domains SET sensitive = ? WHERE id = ?`, sensitive, domainid)
+	if err != nil {
+		return errors.Wrap(err, "SetDomainSensitive")
+	}
+	return
+}
+
+// GetDomainSensitive returns whether every page of a domain is sensitive
+func (fs *FileSystem) GetDomainSensitive(domain string) (sensitive bool, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+	err = fs.db.QueryRow(`SELECT sensitive FROM domains WHERE name = ?`, utils.NormalizeDomain(domain)).Scan(&sensitive)
+	if err == sql.ErrNoRows {
+		err = nil
+	} else if err != nil {
+		err = errors.Wrap(err, "GetDomainSensitive")
+	}
+	return
+}
+
+// sensitiveIDs returns the ids of the files that are sensitive, or whose
+// domain is, which are few
+func (fs *FileSystem) sensitiveIDs() (isSensitive map[string]bool, err error) {
+	ids, err := fs.getAllFromPreparedQuerySingleString(`SELECT fs.id FROM fs
+	INNER JOIN domains ON fs.domainid=domains.id
+	WHERE fs.sensitive = 1 OR domains.sensitive = 1`)
+	if err != nil {
+		return nil, errors.Wrap(err, "sensitiveIDs")
+	}
+	isSensitive = make(map[string]bool)
+	for _, id := range ids {
+		isSensitive[id] = true
+	}
+	return
+}
+
+// FilterSensitive returns the files that are not sensitive, for the feeds
+// that show pages to who has not been warned about them
+func (fs *FileSystem) FilterSensitive(files []File) (shown []File) {
+	if len(files) == 0 {
+		return files
+	}
+	fs.RLock()
+	defer fs.RUnlock()
+	isSensitive, err := fs.sensitiveIDs()
+	if err != nil || len(isSensitive) == 0 {
+		return files
+	}
+	shown = []File{}
+	for _, f := range files {
+		if !isSensitive[f.ID] {
+			shown = append(shown, f)
+		}
+	}
+	return
+}
+
+// hideSensitiveSnippets removes the snippets of the sensitive files that
+// a search found, when who searched is not a member of their domain
+func (fs *FileSystem) hideSensitiveSnippets(ctx context.Context, files []File) {
+	if publicReader(ctx) == 0 || len(files) == 0 {
+		return
+	}
+	isSensitive, err := fs.sensitiveIDs()
+	if err != nil {
+		return
+	}
+	for i := range files {
+		if isSensitive[files[i].ID] {
+			files[i].Snippet, files[i].DataHTML = "", ""
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package db
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// MaxSitemapPages is how many pages a sitemap can have
+const MaxSitemapPages = 50000
+
+// SetDomainNoIndex sets whether search engines are asked not to index a
+// domain, for domains that are public but unlisted
+func (fs *FileSystem) SetDomainNoIndex(domain string, noindex bool) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	domainid, _, _, _ := fs.getDomainFromName(domain)
+	if domainid == 0 {
+		return errors.New("domain does not exist")
+	}
+	value := 0
+	if noindex {
+		value = 1
+	}
+	_, err = fs.db.Exec(`UPDATE domains SET noindex = ? WHERE id = ?`, value, domainid)
+	if err != nil {
+		return errors.Wrap(err, "SetDomainNoIndex")
+	}
+	return
+}
+
+// GetDomainNoIndex returns whether search engines are asked not to index
+// a domain
+func (fs *FileSystem) GetDomainNoIndex(domain string) (noindex bool, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+	err = fs.db.QueryRow(`SELECT noindex FROM domains WHERE name = ?`, utils.NormalizeDomain(domain)).Scan(&noindex)
+	if err == sql.ErrNoRows {
+		err = nil
+	} else if err != nil {
+		err = errors.Wrap(err, "GetDomainNoIndex")
+	}
+	return
+}
+
+// IndexedDomains returns the names of the domains that search engines can
+// index, which are public and not unlisted. The public domain, which
+// anyone can write to, is not indexed.
+func (fs *FileSystem) IndexedDomains() (domains []string, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+	domains, err = fs.getAllFromPreparedQuerySingleString(`SELECT name FROM domains
+	WHERE ispublic = 1 AND noindex = 0 AND name != 'public' ORDER BY name`)
+	if err != nil {
+		err = errors.Wrap(err, "IndexedDomains")
+	}
+	return
+}
+
+// SitemapPages returns the id, slug and when it was modified of the pages
+// of a domain that are not empty, archived, in the trash, unlisted or
+// private, the most recently modified first
+func (fs *FileSystem) SitemapPages(domain string) (files []File, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+
+	rows, err := fs.db.Query(`SELECT fs.id, fs.slug, fs.modified FROM fs
+	INNER JOIN fts ON fs.id=fts.id
+	INNER JOIN domains ON fs.domainid=domains.id
+	WHERE domains.name = ? AND fs.deleted = 0 AND fs.archived = 0 AND fs.visibility = '' AND LENGTH(fts.data) > 0
+	ORDER BY fs.modified DESC LIMIT ?`, utils.NormalizeDomain(domain), MaxSitemapPages)
+	if err != nil {
+		return nil, errors.Wrap(err, "SitemapPages")
+	}
+	defer rows.Close()
+	files = []File{}
+	for rows.Next() {
+		f := File{Domain: domain}
+		err = rows.Scan(&f.ID, &f.Slug, &f.Modified)
+		if err != nil {
+			return nil, errors.Wrap(err, "get rows of SitemapPages")
+		}
+		files = append(files, f)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.Wrap(err, "SitemapPages")
+	}
+	return
+}
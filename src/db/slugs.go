@@ -0,0 +1,80 @@
+package db
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// SlugOptions are how a domain makes the slugs of its pages from their
+// titles. MaxLength is the most characters of the title in a slug, and
+// zero does not cut it. The suffix comes from the id of the page, so it
+// stays the same as the page is edited, and the date is the day the page
+// was created. The zero value makes slugs the way the editor does.
+type SlugOptions struct {
+	Transliterate bool `json:"transliterate"`
+	MaxLength     int  `json:"max_length"`
+	Suffix        bool `json:"suffix"`
+	DatePrefix    bool `json:"date_prefix"`
+}
+
+// Slug returns the slug of a page with a title, which is empty when the
+// title has no letters to make one from
+func (o SlugOptions) Slug(title, id string, created time.Time) (slug string) {
+	if o.Transliterate {
+		title = utils.Transliterate(title)
+	}
+	slug = utils.Slugify(title)
+	if o.MaxLength > 0 && len(slug) > o.MaxLength {
+		// cut between words when there is a word that fits
+		cut := slug[:o.MaxLength]
+		if i := strings.LastIndex(cut, "-"); i > 0 && slug[o.MaxLength] != '-' {
+			cut = cut[:i]
+		}
+		slug = strings.Trim(cut, "-")
+	}
+	if slug == "" {
+		return
+	}
+	if o.DatePrefix {
+		slug = created.Format("2006-01-02") + "-" + slug
+	}
+	if o.Suffix {
+		slug += "-" + fmt.Sprintf("%x", sha256.Sum256([]byte(id)))[:6]
+	}
+	return
+}
+
+// SetSlugOptions changes how a domain makes the slugs of its pages
+func (fs *FileSystem) SetSlugOptions(domain string, options SlugOptions) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+	if options.MaxLength < 0 {
+		return errors.New("slugs can not be shorter than nothing")
+	}
+	res, err := fs.db.Exec(`UPDATE domains SET slug_transliterate = ?, slug_length = ?, slug_suffix = ?, slug_date = ? WHERE name = ?`,
+		options.Transliterate, options.MaxLength, options.Suffix, options.DatePrefix, domain)
+	if err != nil {
+		return errors.Wrap(err, "SetSlugOptions")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New("domain does not exist")
+	}
+	return
+}
+
+// GetSlugOptions returns how a domain makes the slugs of its pages
+func (fs *FileSystem) GetSlugOptions(domain string) (options SlugOptions, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+	err = fs.db.QueryRow(`SELECT slug_transliterate, slug_length, slug_suffix, slug_date FROM domains WHERE name = ?`, domain).Scan(
+		&options.Transliterate, &options.MaxLength, &options.Suffix, &options.DatePrefix)
+	if err != nil {
+		err = errors.Wrap(err, "GetSlugOptions")
+	}
+	return
+}
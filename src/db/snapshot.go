@@ -0,0 +1,187 @@
+package db
+
+import (
+	"encoding/json"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+)
+
+// Snapshot is a record of every page in a domain at a point in time
+type Snapshot struct {
+	ID      int
+	Domain  string
+	Created time.Time
+	Files   []File
+}
+
+// snapshotFile is the part of a File that is kept in a snapshot
+type snapshotFile struct {
+	ID   string `json:"id"`
+	Slug string `json:"slug"`
+	Data string `json:"data"`
+}
+
+// SnapshotDomain records the current state of every page in a domain
+func (fs *FileSystem) SnapshotDomain(domain string) (snapshot Snapshot, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+	return fs.snapshotDomain(domain)
+}
+
+func (fs *FileSystem) snapshotDomain(domain string) (snapshot Snapshot, err error) {
+	domainid, _, _, _ := fs.getDomainFromName(domain)
+	if domainid == 0 {
+		err = errors.New("domain does not exist")
+		return
+	}
+
+	files, err := fs.getAllWithEmpty(domain)
+	if err != nil {
+		return
+	}
+	sfiles := make([]snapshotFile, len(files))
+	for i, f := range files {
+		sfiles[i] = snapshotFile{ID: f.ID, Slug: f.Slug, Data: f.Data}
+	}
+	dataBytes, err := json.Marshal(sfiles)
+	if err != nil {
+		return
+	}
+
+	snapshot = Snapshot{
+		Domain:  domain,
+		Created: time.Now().UTC(),
+		Files:   files,
+	}
+
+	tx, err := fs.db.Begin()
+	if err != nil {
+		return snapshot, errors.Wrap(err, "begin SnapshotDomain")
+	}
+	stmt, err := tx.Prepare(`INSERT INTO snapshots (domainid, created, data) VALUES (?,?,?)`)
+	if err != nil {
+		return snapshot, errors.Wrap(err, "stmt SnapshotDomain")
+	}
+	defer stmt.Close()
+	res, err := stmt.Exec(domainid, snapshot.Created, string(dataBytes))
+	if err != nil {
+		return snapshot, errors.Wrap(err, "exec SnapshotDomain")
+	}
+	err = tx.Commit()
+	if err != nil {
+		return snapshot, errors.Wrap(err, "commit SnapshotDomain")
+	}
+	id, _ := res.LastInsertId()
+	snapshot.ID = int(id)
+	return
+}
+
+// GetSnapshots returns the snapshots of a domain, newest first
+func (fs *FileSystem) GetSnapshots(domain string) (snapshots []Snapshot, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+
+	stmt, err := fs.db.Prepare(`
+	SELECT snapshots.id, snapshots.created, snapshots.data FROM snapshots
+	INNER JOIN domains ON snapshots.domainid=domains.id
+	WHERE domains.name = ?
+	ORDER BY snapshots.created DESC`)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query(domain)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	snapshots = []Snapshot{}
+	for rows.Next() {
+		var data string
+		snapshot := Snapshot{Domain: domain}
+		err = rows.Scan(&snapshot.ID, &snapshot.Created, &data)
+		if err != nil {
+			err = errors.Wrap(err, "get rows of snapshot")
+			return
+		}
+		var sfiles []snapshotFile
+		err = json.Unmarshal([]byte(data), &sfiles)
+		if err != nil {
+			err = errors.Wrap(err, "could not parse snapshot")
+			return
+		}
+		snapshot.Files = make([]File, len(sfiles))
+		for i, sf := range sfiles {
+			snapshot.Files[i] = File{ID: sf.ID, Slug: sf.Slug, Data: sf.Data, Domain: domain}
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	err = rows.Err()
+	return
+}
+
+// RestoreDomainTo rolls every page in a domain back to its state at the
+// given time using the version history of each page. Pages created after
+// that time are emptied. A snapshot of the domain is taken first so that
+// the restore itself can be undone.
+func (fs *FileSystem) RestoreDomainTo(domain string, ts time.Time) (restored int, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	_, err = fs.snapshotDomain(domain)
+	if err != nil {
+		return
+	}
+
+	files, err := fs.getAllWithEmpty(domain)
+	if err != nil {
+		return
+	}
+	for _, f := range files {
+		data, errData := dataAtTime(f, ts)
+		if errData != nil {
+			log.Debugf("could not rebuild %s: %s", f.ID, errData.Error())
+			continue
+		}
+		if data == f.Data {
+			continue
+		}
+		f.Data = data
+		f.Domain = domain
+		err = fs.save(f)
+		if err != nil {
+			return
+		}
+		restored++
+	}
+	return
+}
+
+// dataAtTime returns the contents of a file at the given time
+func dataAtTime(f File, ts time.Time) (data string, err error) {
+	err = f.LoadHistory()
+	if err != nil {
+		return
+	}
+	snapshots := f.History.GetSnapshots()
+	if len(snapshots) == 0 {
+		return f.Data, nil
+	}
+	if ts.UnixNano() < snapshots[0] {
+		return "", nil
+	}
+	return f.History.GetPreviousByTimestamp(ts.UnixNano())
+}
+
+// getAllWithEmpty returns all the files for a given domain, including empty ones
+func (fs *FileSystem) getAllWithEmpty(domain string) (files []File, err error) {
+	return fs.getAllFromPreparedQuery(`
+	SELECT fs.id,fs.slug,fs.created,fs.modified,fts.data,fs.history,fs.views,fs.archived FROM fs 
+	INNER JOIN fts ON fs.id=fts.id 
+	INNER JOIN domains ON fs.domainid=domains.id
+	WHERE 
+		domains.name = ?
+	ORDER BY fs.modified DESC`, domain)
+}
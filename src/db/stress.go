@@ -0,0 +1,172 @@
+package db
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// StressOptions are how hard Stress works a database: how many workers
+// save, get and find how many pages of a domain, and for how long
+type StressOptions struct {
+	Domain   string
+	Pages    int
+	Workers  int
+	Duration time.Duration
+}
+
+// StressReport is what Stress did, with the operations that failed and
+// the problems it found in what was saved
+type StressReport struct {
+	Saves    int      `json:"saves"`
+	Gets     int      `json:"gets"`
+	Finds    int      `json:"finds"`
+	Errors   []string `json:"errors"`
+	Problems []string `json:"problems"`
+}
+
+// OK returns whether every operation worked and nothing was wrong
+func (r StressReport) OK() bool {
+	return len(r.Errors) == 0 && len(r.Problems) == 0
+}
+
+// stressWords are the words that the pages of a stress test are written
+// with, and searched for
+var stressWords = []string{"apple", "banana", "cherry", "damson", "elder", "fig", "grape", "hazel"}
+
+// Stress saves, gets and finds pages of a domain from many workers at
+// once, and then checks that every save is a version of its page, that
+// every page that was read had the text of the newest version in its
+// history, and that the index has the text of each page. The domain is
+// made if it does not exist, and its pages are left for looking at.
+func (fs *FileSystem) Stress(o StressOptions) (report StressReport, err error) {
+	if o.Domain == "" || o.Pages < 1 || o.Workers < 1 {
+		return report, errors.New("stress test needs a domain, pages and workers")
+	}
+	o.Domain = utils.NormalizeDomain(o.Domain)
+	if _, _, errDomain := fs.GetDomainFromName(o.Domain); errDomain != nil {
+		err = fs.SetDomain(o.Domain, fmt.Sprintf("%d", time.Now().UnixNano()))
+		if err != nil {
+			return
+		}
+	}
+
+	ids := make([]string, o.Pages)
+	slugs := make(map[string]string)
+	for i := range ids {
+		f := fs.NewFile(fmt.Sprintf("stress-%d", i), fmt.Sprintf("# stress %d\n\n%s", i, stressWords[i%len(stressWords)]))
+		f.Domain = o.Domain
+		err = fs.Save(f)
+		if err != nil {
+			return
+		}
+		ids[i] = f.ID
+		slugs[f.ID] = f.Slug
+	}
+
+	var mu sync.Mutex
+	// saved is how many versions each page has from the stress test
+	saved := make(map[string]int)
+	for _, id := range ids {
+		saved[id] = 1
+	}
+	fail := func(list *[]string, format string, args ...interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		if len(*list) < 100 {
+			*list = append(*list, fmt.Sprintf(format, args...))
+		}
+	}
+
+	deadline := time.Now().Add(o.Duration)
+	var wg sync.WaitGroup
+	for w := 0; w < o.Workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			random := rand.New(rand.NewSource(time.Now().UnixNano() + int64(w)))
+			for n := 0; time.Now().Before(deadline); n++ {
+				id := ids[random.Intn(len(ids))]
+				word := stressWords[random.Intn(len(stressWords))]
+				switch op := random.Intn(20); {
+				case op < 10:
+					// every save is new text, so it is a version
+					f := File{
+						ID:     id,
+						Domain: o.Domain,
+						Slug:   slugs[id],
+						Data:   fmt.Sprintf("# worker %d edit %d\n\n%s", w, n, word),
+					}
+					errSave := fs.Save(f)
+					mu.Lock()
+					report.Saves++
+					if errSave == nil {
+						saved[id]++
+					}
+					mu.Unlock()
+					if errSave != nil {
+						fail(&report.Errors, "save %s: %s", id, errSave.Error())
+					}
+				case op < 17:
+					files, errGet := fs.Get(id, o.Domain)
+					mu.Lock()
+					report.Gets++
+					mu.Unlock()
+					if errGet != nil {
+						fail(&report.Errors, "get %s: %s", id, errGet.Error())
+					} else if files[0].Data != files[0].History.GetCurrent() {
+						fail(&report.Problems, "get %s: text is not the newest version in its history", id)
+					}
+				default:
+					_, errFind := fs.Find(word, o.Domain)
+					mu.Lock()
+					report.Finds++
+					mu.Unlock()
+					if errFind != nil {
+						fail(&report.Errors, "find %s: %s", word, errFind.Error())
+					}
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	err = fs.FlushIndex()
+	if err != nil {
+		return
+	}
+	for _, id := range ids {
+		files, errGet := fs.Get(id, o.Domain)
+		if errGet != nil {
+			fail(&report.Problems, "page %s: %s", id, errGet.Error())
+			continue
+		}
+		f := files[0]
+		if f.History.NumEdits() != saved[id] {
+			fail(&report.Problems, "page %s: has %d versions instead of %d", id, f.History.NumEdits(), saved[id])
+		}
+		var indexed string
+		errGet = fs.db.QueryRow(`SELECT data FROM fts WHERE id = ?`, id).Scan(&indexed)
+		if errGet != nil {
+			fail(&report.Problems, "page %s: not in the index: %s", id, errGet.Error())
+		} else if indexed != f.History.GetCurrent() {
+			fail(&report.Problems, "page %s: index does not have the newest version", id)
+		}
+	}
+	var pages, indexedPages int
+	err = fs.db.QueryRow(`SELECT COUNT(*) FROM fs WHERE domainid = (SELECT id FROM domains WHERE name = ?)`, o.Domain).Scan(&pages)
+	if err == nil {
+		err = fs.db.QueryRow(`SELECT COUNT(*) FROM fts WHERE id IN (SELECT fs.id FROM fs INNER JOIN domains ON fs.domainid=domains.id WHERE domains.name = ?)`, o.Domain).Scan(&indexedPages)
+	}
+	if err != nil {
+		return report, errors.Wrap(err, "count pages")
+	}
+	if pages != indexedPages {
+		fail(&report.Problems, "%d pages but %d in the index", pages, indexedPages)
+	}
+	return
+}
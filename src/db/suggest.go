@@ -0,0 +1,103 @@
+package db
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// SuggestSlugs returns the pages of a domain whose slugs are like a slug
+// that was not found, the closest first, for a "did you mean" of the page
+// that is not found. A slug is like another when a few letters of it are
+// different, which is a third of its letters at most, or when one has the
+// other in it.
+func (fs *FileSystem) SuggestSlugs(domain, slug string, limit int) (files []File, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+
+	slug = utils.NormalizeSlug(slug)
+	rows, err := fs.db.Query(`SELECT fs.id, fs.slug, fs.modified FROM fs
+	INNER JOIN fts ON fs.id=fts.id
+	INNER JOIN domains ON fs.domainid=domains.id
+	WHERE domains.name = ? AND fs.slug != '' AND fs.deleted = 0 AND fs.archived = 0
+		AND LENGTH(fts.data) > 0`, utils.NormalizeDomain(domain))
+	if err != nil {
+		return nil, errors.Wrap(err, "SuggestSlugs")
+	}
+	defer rows.Close()
+
+	type suggestion struct {
+		File
+		distance int
+	}
+	var suggestions []suggestion
+	maxDistance := len([]rune(slug)) / 3
+	if maxDistance < 2 {
+		maxDistance = 2
+	}
+	for rows.Next() {
+		var s suggestion
+		var modified time.Time
+		err = rows.Scan(&s.ID, &s.Slug, &modified)
+		if err != nil {
+			return nil, errors.Wrap(err, "get rows of SuggestSlugs")
+		}
+		s.Modified = modified
+		s.Domain = domain
+		candidate := strings.ToLower(s.Slug)
+		s.distance = editDistance(slug, candidate)
+		contains := (len(slug) >= 3 && strings.Contains(candidate, slug)) ||
+			(len(candidate) >= 3 && strings.Contains(slug, candidate))
+		if s.distance <= maxDistance || contains {
+			suggestions = append(suggestions, s)
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.Wrap(err, "SuggestSlugs")
+	}
+
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].distance != suggestions[j].distance {
+			return suggestions[i].distance < suggestions[j].distance
+		}
+		return suggestions[i].Modified.After(suggestions[j].Modified)
+	})
+	files = []File{}
+	for i := 0; i < len(suggestions) && i < limit; i++ {
+		files = append(files, suggestions[i].File)
+	}
+	return
+}
+
+// editDistance returns how many letters have to be added, removed or
+// changed to make one text the other
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = previous[j-1] + cost
+			if previous[j]+1 < current[j] {
+				current[j] = previous[j] + 1
+			}
+			if current[j-1]+1 < current[j] {
+				current[j] = current[j-1] + 1
+			}
+		}
+		previous, current = current, previous
+	}
+	return previous[len(rb)]
+}
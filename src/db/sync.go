@@ -0,0 +1,245 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// serverDevice is the device that the saves that do not come from a
+// device that syncs, like the editor, are counted for
+const serverDevice = "server"
+
+// VersionVector counts the saves of a page on each device, so that two
+// versions can be told apart as one coming after the other or as edited
+// at the same time on different devices
+type VersionVector map[string]int
+
+// Descends returns whether the version has every save of another
+func (v VersionVector) Descends(other VersionVector) bool {
+	for device, counter := range other {
+		if v[device] < counter {
+			return false
+		}
+	}
+	return true
+}
+
+// merge returns the version with the saves of both versions
+func (v VersionVector) merge(other VersionVector) (merged VersionVector) {
+	merged = make(VersionVector, len(v)+len(other))
+	for device, counter := range v {
+		merged[device] = counter
+	}
+	for device, counter := range other {
+		if counter > merged[device] {
+			merged[device] = counter
+		}
+	}
+	return
+}
+
+// SyncResult is the version of a page after a device synced it
+type SyncResult struct {
+	ID      string        `json:"id"`
+	Slug    string        `json:"slug"`
+	Data    string        `json:"data"`
+	Version VersionVector `json:"version"`
+	// Status is "current" when the device had no changes, "saved" when its
+	// changes were made to the version on the server, "merged" when they
+	// were merged with changes made at the same time on other devices, and
+	// "conflict" when they could not be merged. After a conflict, the page
+	// is not changed, and the device has to make its changes to the data
+	// and version that are returned.
+	Status string `json:"status"`
+}
+
+// setVersion saves the version vector of a file, as part of the
+// transaction that saves the file. Saves without a version are counted as
+// saves on the server.
+func (fs *FileSystem) setVersion(tx *sql.Tx, fileid string, version VersionVector) (err error) {
+	if version == nil {
+		_, err = tx.Exec(`INSERT INTO clocks (fsid, device, counter) VALUES (?,?,1)
+		ON CONFLICT(fsid, device) DO UPDATE SET counter = counter + 1`, fileid, serverDevice)
+		if err != nil {
+			err = errors.Wrap(err, "exec setVersion")
+		}
+		return
+	}
+	_, err = tx.Exec(`DELETE FROM clocks WHERE fsid = ?`, fileid)
+	if err != nil {
+		return errors.Wrap(err, "exec delete clocks")
+	}
+	stmt, err := tx.Prepare(`INSERT INTO clocks (fsid, device, counter) VALUES (?,?,?)`)
+	if err != nil {
+		return errors.Wrap(err, "stmt setVersion")
+	}
+	defer stmt.Close()
+	for device, counter := range version {
+		_, err = stmt.Exec(fileid, device, counter)
+		if err != nil {
+			return errors.Wrap(err, "exec setVersion")
+		}
+	}
+	return
+}
+
+func (fs *FileSystem) getVersion(fileid string) (version VersionVector, err error) {
+	rows, err := fs.db.Query(`SELECT device, counter FROM clocks WHERE fsid = ?`, fileid)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	version = make(VersionVector)
+	for rows.Next() {
+		var device string
+		var counter int
+		err = rows.Scan(&device, &counter)
+		if err != nil {
+			err = errors.Wrap(err, "get rows of clocks")
+			return
+		}
+		version[device] = counter
+	}
+	err = rows.Err()
+	return
+}
+
+// GetVersion returns a page with its version vector, for a device to
+// start syncing it from
+func (fs *FileSystem) GetVersion(id, domain string) (result SyncResult, err error) {
+	return fs.GetVersionCtx(context.Background(), id, domain)
+}
+
+// GetVersionCtx returns a page with its version vector like GetVersion,
+// unless it is private and the context is not of a member of the domain
+func (fs *FileSystem) GetVersionCtx(ctx context.Context, id, domain string) (result SyncResult, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+
+	files, err := fs.getCtx(ctx, id, domain)
+	if err != nil {
+		return
+	}
+	result = SyncResult{ID: files[0].ID, Slug: files[0].Slug, Data: files[0].Data, Status: "current"}
+	result.Version, err = fs.getVersion(files[0].ID)
+	return
+}
+
+// SyncPage saves the changes that a device made to a page. The device
+// sends the version it last synced and its data then (the base), along
+// with its data now. Changes to the newest version are saved, changes to
+// an older version are merged with the changes that were made since, and
+// changes that can not be merged are refused, so the result only depends
+// on what was synced before and never on which device saves last.
+func (fs *FileSystem) SyncPage(domain, id, slug, device string, version VersionVector, base, data string) (result SyncResult, err error) {
+	device = strings.TrimSpace(device)
+	if device == "" || device == serverDevice {
+		err = errors.New("device needs a name other than " + serverDevice)
+		return
+	}
+	if id == "" {
+		err = errors.New("page needs an id")
+		return
+	}
+
+	fs.RLock()
+	defer fs.RUnlock()
+	defer fs.lockFile(id)()
+
+	f := File{ID: id, Slug: slug, Domain: domain, Created: time.Now().UTC()}
+	saved := false
+	if files, errGet := fs.getAny(id, domain); errGet == nil && files[0].ID == id {
+		saved = true
+		f = files[0]
+		f.Domain = domain
+	} else if exists, _ := fs.idExists(id); exists {
+		err = errors.New("id is used in another domain")
+		return
+	}
+	current, err := fs.getVersion(id)
+	if err != nil {
+		return
+	}
+	result = SyncResult{ID: id, Slug: f.Slug, Data: f.Data, Version: current, Status: "current"}
+
+	merged := data
+	status := "saved"
+	if saved && !version.Descends(current) && f.Data != base {
+		// the page changed on other devices since this device synced it
+		dmp := diffmatchpatch.New()
+		dmp.DiffTimeout = 0
+		merged, err = mergeChanges(dmp, base, data, f.Data)
+		if err != nil {
+			result.Status = "conflict"
+			err = nil
+			return
+		}
+		status = "merged"
+	}
+	if saved && merged == f.Data && (slug == "" || slug == f.Slug) {
+		return
+	}
+
+	f.Data = merged
+	if slug != "" {
+		f.Slug = slug
+	}
+	f.version = current.merge(version)
+	f.version[device]++
+	err = fs.save(f)
+	if err != nil {
+		return
+	}
+	result = SyncResult{ID: id, Slug: f.Slug, Data: f.Data, Version: f.version, Status: status}
+	return
+}
+
+// EditPage saves the changes that an editor made to a page while others
+// may be editing it too. The editor sends the data it started from (the
+// base) along with its data now, and its changes are merged with the
+// changes that were saved since. Changes that can not be merged are saved
+// as they are, and the version they replaced stays in the history. It
+// returns the page as it was saved, and whether it was merged.
+func (fs *FileSystem) EditPage(f File, base string) (saved File, merged bool, err error) {
+	if f.ID == "" {
+		err = errors.New("page needs an id")
+		return
+	}
+
+	fs.RLock()
+	defer fs.RUnlock()
+	defer fs.lockFile(f.ID)()
+
+	if files, errGet := fs.getAny(f.ID, f.Domain); errGet == nil && files[0].ID == f.ID && files[0].Data != base {
+		// the page changed since the editor started from it
+		dmp := diffmatchpatch.New()
+		dmp.DiffTimeout = 0
+		data, errMerge := mergeChanges(dmp, base, f.Data, files[0].Data)
+		if errMerge == nil {
+			f.Data = data
+			merged = true
+		}
+	}
+	err = fs.save(f)
+	saved = f
+	return
+}
+
+// mergeChanges makes the changes from the base to the data of a device to
+// the data on the server, failing if any of them do not apply
+func mergeChanges(dmp *diffmatchpatch.DiffMatchPatch, base, data, server string) (merged string, err error) {
+	patches := dmp.PatchMake(base, dmp.DiffMain(base, data, false))
+	merged, applied := dmp.PatchApply(patches, server)
+	for _, ok := range applied {
+		if !ok {
+			err = errors.New("changes conflict")
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,216 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"strings"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// Tag is a tag of a domain, with how many pages have it
+type Tag struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// pageTags returns the tags of a text, which are its #hashtags and the
+// ones in the "tags" of its front matter
+func pageTags(data string) (tags []string) {
+	meta, body := utils.ParseFrontMatter(data)
+	tags = cleanTags(strings.FieldsFunc(meta["tags"], func(r rune) bool {
+		return r == ',' || r == ' '
+	}))
+	return cleanTags(append(tags, utils.Hashtags(body)...))
+}
+
+// cleanTags returns tags in lowercase without their "#", once each
+func cleanTags(tags []string) (cleaned []string) {
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimLeft(strings.TrimSpace(tag), "#"))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		cleaned = append(cleaned, tag)
+	}
+	return
+}
+
+// setTags replaces the tags of a page that come from its text. Tags that
+// were set with SetTags are kept.
+func (fs *FileSystem) setTags(tx *sql.Tx, fileid string, domainid int, data string) (err error) {
+	return insertTags(tx, fileid, domainid, pageTags(data), false)
+}
+
+// indexTags tags the pages that were saved before there were tags
+func (fs *FileSystem) indexTags() (err error) {
+	rows, err := fs.db.Query(`SELECT fs.id,fs.domainid,fts.data FROM fs INNER JOIN fts ON fs.id=fts.id WHERE LENGTH(fts.data) > 0`)
+	if err != nil {
+		return errors.Wrap(err, "indexTags")
+	}
+	type page struct {
+		id       string
+		domainid int
+		data     string
+	}
+	var pages []page
+	for rows.Next() {
+		var p page
+		err = rows.Scan(&p.id, &p.domainid, &p.data)
+		if err != nil {
+			rows.Close()
+			return errors.Wrap(err, "get rows of indexTags")
+		}
+		pages = append(pages, p)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil || len(pages) == 0 {
+		return
+	}
+
+	tx, err := fs.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin indexTags")
+	}
+	for _, p := range pages {
+		err = fs.setTags(tx, p.id, p.domainid, p.data)
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrap(err, "commit indexTags")
+	}
+	log.Infof("tagged %d pages", len(pages))
+	return
+}
+
+// insertTags replaces the tags of a page that are manual, or that are not
+func insertTags(tx *sql.Tx, fileid string, domainid int, tags []string, manual bool) (err error) {
+	_, err = tx.Exec(`DELETE FROM tags WHERE fsid = ? AND manual = ?`, fileid, manual)
+	if err != nil {
+		return errors.Wrap(err, "exec delete tags")
+	}
+	stmt, err := tx.Prepare(`INSERT OR IGNORE INTO tags (fsid, domainid, tag, manual) VALUES (?,?,?,?)`)
+	if err != nil {
+		return errors.Wrap(err, "stmt setTags")
+	}
+	defer stmt.Close()
+	for _, tag := range tags {
+		_, err = stmt.Exec(fileid, domainid, tag, manual)
+		if err != nil {
+			return errors.Wrap(err, "exec setTags")
+		}
+	}
+	return
+}
+
+// SetTags sets the tags of a page, besides the #hashtags in its text,
+// which stay as they are
+func (fs *FileSystem) SetTags(fileid string, tags []string) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	var domainid int
+	err = fs.db.QueryRow(`SELECT domainid FROM fs WHERE id = ?`, fileid).Scan(&domainid)
+	if err == sql.ErrNoRows {
+		return errors.New("page does not exist")
+	} else if err != nil {
+		return errors.Wrap(err, "get domain of page")
+	}
+
+	tx, err := fs.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin SetTags")
+	}
+	err = insertTags(tx, fileid, domainid, cleanTags(tags), true)
+	if err != nil {
+		tx.Rollback()
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrap(err, "commit SetTags")
+	}
+	return
+}
+
+// GetTags returns the tags of a page
+func (fs *FileSystem) GetTags(fileid string) (tags []string, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+	return fs.getAllFromPreparedQuerySingleString(`SELECT DISTINCT tag FROM tags WHERE fsid = ? ORDER BY tag`, fileid)
+}
+
+// GetByTag returns the pages of a domain that have a tag, the most
+// recently modified first
+func (fs *FileSystem) GetByTag(domain, tag string) (files []File, err error) {
+	return fs.GetByTagCtx(context.Background(), domain, tag)
+}
+
+// GetByTagCtx returns the pages of a domain that have a tag like GetByTag,
+// without the ones that are not listed for who reads with the context
+func (fs *FileSystem) GetByTagCtx(ctx context.Context, domain, tag string) (files []File, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+	return fs.getFiles(ctx, `
+	SELECT fs.id,fs.slug,fs.created,fs.modified,fts.data,fs.history,fs.views,fs.archived FROM fs
+	INNER JOIN fts ON fs.id=fts.id
+	INNER JOIN domains ON fs.domainid=domains.id
+	WHERE
+		domains.name = ?
+		AND LENGTH(fts.data) > 0
+		AND fs.archived = 0
+		AND fs.id IN (SELECT fsid FROM tags WHERE tag = ?)
+		AND (? = 0 OR fs.visibility = '')
+	ORDER BY fs.modified DESC`, domain, strings.ToLower(strings.TrimLeft(tag, "#")), publicReader(ctx))
+}
+
+// ListTags returns the tags of a domain, the ones of the most pages first
+func (fs *FileSystem) ListTags(domain string) (tags []Tag, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+
+	rows, err := fs.db.Query(`
+	SELECT tags.tag, COUNT(DISTINCT tags.fsid) FROM tags
+	INNER JOIN fs ON fs.id=tags.fsid
+	INNER JOIN fts ON fs.id=fts.id
+	INNER JOIN domains ON tags.domainid=domains.id
+	WHERE
+		domains.name = ?
+		AND LENGTH(fts.data) > 0
+		AND fs.archived = 0
+	GROUP BY tags.tag`, domain)
+	if err != nil {
+		return nil, errors.Wrap(err, "ListTags")
+	}
+	defer rows.Close()
+	tags = []Tag{}
+	for rows.Next() {
+		var t Tag
+		err = rows.Scan(&t.Name, &t.Count)
+		if err != nil {
+			return nil, errors.Wrap(err, "get rows of ListTags")
+		}
+		tags = append(tags, t)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.Wrap(err, "ListTags")
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].Count != tags[j].Count {
+			return tags[i].Count > tags[j].Count
+		}
+		return tags[i].Name < tags[j].Name
+	})
+	return
+}
@@ -0,0 +1,204 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var timeLine = regexp.MustCompile(`(?m)@time\s+(\S+)(.*)$`)
+var timeTag = regexp.MustCompile(`#([\w\-]+)`)
+var timeDay = regexp.MustCompile(`\b(\d{4}-\d{2}-\d{2})\b`)
+
+// TimeEntry is time spent, written in a page as a line like
+//
+//	@time 1h30m 2018-11-03 #client #design sketches for the homepage
+//
+// where the day and the tags are optional
+type TimeEntry struct {
+	ID       int
+	FileID   string
+	Slug     string
+	Day      time.Time
+	Duration time.Duration
+	Tags     []string
+	Note     string
+	line     string
+}
+
+// TimeTotal is the time spent on one tag, page or week
+type TimeTotal struct {
+	Key     string `json:"key"`
+	Seconds int64  `json:"seconds"`
+}
+
+// Duration returns the total as a duration
+func (t TimeTotal) Duration() time.Duration {
+	return time.Duration(t.Seconds) * time.Second
+}
+
+// parseTimeEntries finds the time entries in a text. Entries without a day
+// are on the given day.
+func parseTimeEntries(data string, day time.Time) (entries []TimeEntry) {
+	for _, match := range timeLine.FindAllStringSubmatch(data, -1) {
+		d, err := time.ParseDuration(match[1])
+		if err != nil || d <= 0 {
+			continue
+		}
+		e := TimeEntry{
+			Day:      day,
+			Duration: d,
+			line:     strings.TrimSpace(match[0]),
+		}
+		rest := match[2]
+		if dayMatch := timeDay.FindString(rest); dayMatch != "" {
+			if t, err := time.Parse("2006-01-02", dayMatch); err == nil {
+				e.Day = t
+			}
+			rest = strings.Replace(rest, dayMatch, "", 1)
+		}
+		for _, tag := range timeTag.FindAllStringSubmatch(rest, -1) {
+			e.Tags = append(e.Tags, strings.ToLower(tag[1]))
+		}
+		e.Note = strings.Join(strings.Fields(timeTag.ReplaceAllString(rest, "")), " ")
+		entries = append(entries, e)
+	}
+	return
+}
+
+// setTimeEntries replaces the time entries of a file with the ones in its
+// data, as part of the transaction that saves the file. Entries without a
+// day keep the day they were first written.
+func (fs *FileSystem) setTimeEntries(tx *sql.Tx, fileid string, domainid int, data string) (err error) {
+	firstSeen := make(map[string][]time.Time)
+	rows, err := tx.Query(`SELECT line, day FROM times WHERE fsid = ?`, fileid)
+	if err != nil {
+		return errors.Wrap(err, "get times")
+	}
+	for rows.Next() {
+		var line string
+		var day time.Time
+		if err = rows.Scan(&line, &day); err != nil {
+			rows.Close()
+			return errors.Wrap(err, "scan times")
+		}
+		firstSeen[line] = append(firstSeen[line], day)
+	}
+	rows.Close()
+
+	now := time.Now().UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	entries := parseTimeEntries(data, today)
+
+	_, err = tx.Exec(`DELETE FROM times WHERE fsid = ?`, fileid)
+	if err != nil {
+		return errors.Wrap(err, "exec delete times")
+	}
+	stmt, err := tx.Prepare(`INSERT INTO times (fsid, domainid, day, seconds, tags, note, line) VALUES (?,?,?,?,?,?,?)`)
+	if err != nil {
+		return errors.Wrap(err, "stmt setTimeEntries")
+	}
+	defer stmt.Close()
+	for _, e := range entries {
+		if !timeDay.MatchString(e.line) && len(firstSeen[e.line]) > 0 {
+			e.Day = firstSeen[e.line][0]
+			firstSeen[e.line] = firstSeen[e.line][1:]
+		}
+		_, err = stmt.Exec(fileid, domainid, e.Day, int64(e.Duration.Seconds()), strings.Join(e.Tags, ","), e.Note, e.line)
+		if err != nil {
+			return errors.Wrap(err, "exec setTimeEntries")
+		}
+	}
+	return
+}
+
+// GetTimeEntries returns the time entries in a domain, newest first
+func (fs *FileSystem) GetTimeEntries(domain string) (entries []TimeEntry, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+
+	stmt, err := fs.db.Prepare(`
+	SELECT times.id, times.fsid, fs.slug, times.day, times.seconds, times.tags, times.note FROM times
+	INNER JOIN fs ON times.fsid=fs.id
+	INNER JOIN domains ON times.domainid=domains.id
+	WHERE domains.name = ? AND fs.deleted = 0
+	ORDER BY times.day DESC, times.id`)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query(domain)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	entries = []TimeEntry{}
+	for rows.Next() {
+		var e TimeEntry
+		var seconds int64
+		var tags string
+		err = rows.Scan(&e.ID, &e.FileID, &e.Slug, &e.Day, &seconds, &tags, &e.Note)
+		if err != nil {
+			err = errors.Wrap(err, "get rows of times")
+			return
+		}
+		e.Duration = time.Duration(seconds) * time.Second
+		if tags != "" {
+			e.Tags = strings.Split(tags, ",")
+		}
+		entries = append(entries, e)
+	}
+	err = rows.Err()
+	return
+}
+
+// GetTimeReport totals the time spent in a domain by "tag", "page" or
+// "week". Weeks are newest first, everything else is the most time first.
+func (fs *FileSystem) GetTimeReport(domain, by string) (totals []TimeTotal, err error) {
+	entries, err := fs.GetTimeEntries(domain)
+	if err != nil {
+		return
+	}
+	seconds := make(map[string]int64)
+	for _, e := range entries {
+		var keys []string
+		switch by {
+		case "tag":
+			keys = e.Tags
+			if len(keys) == 0 {
+				keys = []string{"untagged"}
+			}
+		case "page":
+			keys = []string{e.Slug}
+		case "week":
+			year, week := e.Day.ISOWeek()
+			keys = []string{fmt.Sprintf("%d-W%02d", year, week)}
+		default:
+			err = errors.New("can only report by tag, page or week")
+			return
+		}
+		for _, key := range keys {
+			seconds[key] += int64(e.Duration.Seconds())
+		}
+	}
+
+	totals = []TimeTotal{}
+	for key, s := range seconds {
+		totals = append(totals, TimeTotal{Key: key, Seconds: s})
+	}
+	sort.Slice(totals, func(i, j int) bool {
+		if by == "week" {
+			return totals[i].Key > totals[j].Key
+		}
+		if totals[i].Seconds == totals[j].Seconds {
+			return totals[i].Key < totals[j].Key
+		}
+		return totals[i].Seconds > totals[j].Seconds
+	})
+	return
+}
@@ -0,0 +1,196 @@
+package db
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// The scopes of API tokens. Tokens that can write can also read, and
+// tokens of admins can also change the settings of their domain.
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+	ScopeAdmin = "admin"
+)
+
+// tokenPrefix starts every API token, so that they are easy to tell from
+// keys and to find when they leak
+const tokenPrefix = "rwtxt_"
+
+// Token is an API token of a domain, which automation uses instead of the
+// key of the domain. Only the hash of a token is kept, so it is only shown
+// when it is made.
+type Token struct {
+	ID       int       `json:"id"`
+	Domain   string    `json:"domain"`
+	Name     string    `json:"name"`
+	Scopes   []string  `json:"scopes"`
+	Created  time.Time `json:"created"`
+	Expires  time.Time `json:"expires,omitempty"`
+	LastUsed time.Time `json:"last_used,omitempty"`
+}
+
+// hashToken returns the hash that a token is kept as
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateToken makes an API token for a domain with some of the scopes,
+// which expires after the expiry, or never when it is zero. The token is
+// returned once and cannot be read again.
+func (fs *FileSystem) CreateToken(domain string, scopes []string, expiry time.Duration) (token string, t Token, err error) {
+	return fs.CreateNamedToken(domain, "", scopes, expiry)
+}
+
+// CreateNamedToken makes an API token like CreateToken, with a name that
+// says what it is for
+func (fs *FileSystem) CreateNamedToken(domain, name string, scopes []string, expiry time.Duration) (token string, t Token, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	domain = utils.NormalizeDomain(domain)
+	domainid, _, _, _ := fs.getDomainFromName(domain)
+	if domainid == 0 || domain == "public" {
+		err = errors.New("domain " + domain + " does not exist")
+		return
+	}
+	if len(scopes) == 0 {
+		err = errors.New("token needs a scope")
+		return
+	}
+	seen := make(map[string]bool)
+	t = Token{Domain: domain, Name: strings.TrimSpace(name), Scopes: []string{}, Created: time.Now().UTC()}
+	for _, scope := range scopes {
+		scope = strings.ToLower(strings.TrimSpace(scope))
+		if scope != ScopeRead && scope != ScopeWrite && scope != ScopeAdmin {
+			err = fmt.Errorf("scope %s must be read, write or admin", scope)
+			return
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			t.Scopes = append(t.Scopes, scope)
+		}
+	}
+	if expiry < 0 {
+		err = errors.New("expiry cannot be negative")
+		return
+	} else if expiry > 0 {
+		t.Expires = t.Created.Add(expiry)
+	}
+
+	b := make([]byte, 32)
+	_, err = rand.Read(b)
+	if err != nil {
+		return
+	}
+	token = tokenPrefix + hex.EncodeToString(b)
+	res, err := fs.db.Exec(`INSERT INTO tokens (domainid, name, hash, scopes, created, expires, lastused) VALUES (?,?,?,?,?,?,?)`,
+		domainid, t.Name, hashToken(token), strings.Join(t.Scopes, ","), t.Created, t.Expires, time.Time{})
+	if err != nil {
+		err = errors.Wrap(err, "exec CreateToken")
+		return
+	}
+	id, _ := res.LastInsertId()
+	t.ID = int(id)
+	return
+}
+
+// ListTokens returns the API tokens of a domain, with when each was last
+// used, the newest first. Tokens that expired are listed until they are
+// revoked.
+func (fs *FileSystem) ListTokens(domain string) (tokens []Token, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+
+	domain = utils.NormalizeDomain(domain)
+	rows, err := fs.db.Query(`SELECT tokens.id, tokens.name, tokens.scopes, tokens.created, tokens.expires, tokens.lastused FROM tokens
+	INNER JOIN domains ON tokens.domainid=domains.id
+	WHERE domains.name = ? ORDER BY tokens.id DESC`, domain)
+	if err != nil {
+		return nil, errors.Wrap(err, "ListTokens")
+	}
+	defer rows.Close()
+	tokens = []Token{}
+	for rows.Next() {
+		t := Token{Domain: domain}
+		var scopes string
+		err = rows.Scan(&t.ID, &t.Name, &scopes, &t.Created, &t.Expires, &t.LastUsed)
+		if err != nil {
+			return nil, errors.Wrap(err, "get rows of ListTokens")
+		}
+		t.Scopes = strings.Split(scopes, ",")
+		tokens = append(tokens, t)
+	}
+	err = rows.Err()
+	return
+}
+
+// RevokeToken deletes an API token of a domain, so that it stops working
+func (fs *FileSystem) RevokeToken(domain string, id int) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	res, err := fs.db.Exec(`DELETE FROM tokens WHERE id = ? AND domainid = (SELECT id FROM domains WHERE name = ?)`,
+		id, utils.NormalizeDomain(domain))
+	if err != nil {
+		return errors.Wrap(err, "exec RevokeToken")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New("token does not exist")
+	}
+	return
+}
+
+// tokenRole returns the domain of an API token that has not expired, and
+// the role that its scopes give it there, and records that it was used
+func (fs *FileSystem) tokenRole(token string) (domain, role string) {
+	if !strings.HasPrefix(token, tokenPrefix) {
+		return
+	}
+	var id int
+	var scopes string
+	var expires, lastUsed time.Time
+	err := fs.db.QueryRow(`SELECT tokens.id, domains.name, tokens.scopes, tokens.expires, tokens.lastused FROM tokens
+	INNER JOIN domains ON tokens.domainid=domains.id
+	WHERE tokens.hash = ?`, hashToken(token)).Scan(&id, &domain, &scopes, &expires, &lastUsed)
+	if err == sql.ErrNoRows || (err == nil && !expires.IsZero() && time.Now().After(expires)) {
+		return "", ""
+	} else if err != nil {
+		return "", ""
+	}
+	role = RoleReader
+	for _, scope := range strings.Split(scopes, ",") {
+		if scope == ScopeAdmin {
+			role = RoleAdmin
+		} else if scope == ScopeWrite && role != RoleAdmin {
+			role = RoleEditor
+		}
+	}
+	// when a token was last used is only kept to the minute, so that
+	// tokens that are used a lot are not written each time
+	if time.Since(lastUsed) > time.Minute {
+		go fs.touchToken(id)
+	}
+	return
+}
+
+// touchToken records when a token was last used. The reads that check
+// tokens only hold the read lock, so it runs on its own once they are done.
+func (fs *FileSystem) touchToken(id int) {
+	fs.Lock()
+	defer fs.Unlock()
+	_, err := fs.db.Exec(`UPDATE tokens SET lastused = ? WHERE id = ?`, time.Now().UTC(), id)
+	if err != nil {
+		log.Debugf("could not record the use of token %d: %s", id, err.Error())
+	}
+}
@@ -0,0 +1,105 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Translation is a page that was translated into another language, which
+// is the translation of the version of the page that has the Version
+type Translation struct {
+	ID            string    `json:"id"`
+	Language      string    `json:"language"`
+	TranslationID string    `json:"translation_id"`
+	Version       string    `json:"version"`
+	Translated    time.Time `json:"translated"`
+}
+
+// Stale returns whether a page changed since it was translated, when it
+// has the data now
+func (t Translation) Stale(data string) bool {
+	return t.Version != VersionHash(data)
+}
+
+// SetTranslation keeps that a page was translated into a language, as it
+// was at a version, and which page has the translation
+func (fs *FileSystem) SetTranslation(id, lang, translationID, version string) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	lang = CleanLanguage(lang)
+	if lang == "" {
+		return errors.New("need a language to translate into")
+	}
+	var domainid int
+	err = fs.db.QueryRow(`SELECT domainid FROM fs WHERE id = ?`, id).Scan(&domainid)
+	if err == sql.ErrNoRows {
+		return errors.New("page does not exist")
+	} else if err != nil {
+		return errors.Wrap(err, "get domain of page")
+	}
+	_, err = fs.db.Exec(`INSERT OR REPLACE INTO translations (fsid, lang, domainid, translationid, version, translated) VALUES (?,?,?,?,?,?)`,
+		id, lang, domainid, translationID, version, time.Now().UTC())
+	if err != nil {
+		return errors.Wrap(err, "exec SetTranslation")
+	}
+	return
+}
+
+// GetTranslation returns the translation of a page into a language
+func (fs *FileSystem) GetTranslation(id, lang string) (t Translation, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+	ts, err := fs.getTranslations(`WHERE fsid = ? AND lang = ?`, id, CleanLanguage(lang))
+	if err != nil {
+		return
+	}
+	if len(ts) == 0 {
+		return t, errors.New("page is not translated into " + lang)
+	}
+	return ts[0], nil
+}
+
+// GetTranslations returns the translations of a page, by their language
+func (fs *FileSystem) GetTranslations(id string) (ts []Translation, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+	return fs.getTranslations(`WHERE fsid = ? ORDER BY lang`, id)
+}
+
+// TranslationOf returns which page a page is a translation of, if it is
+// one
+func (fs *FileSystem) TranslationOf(translationID string) (t Translation, ok bool, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+	ts, err := fs.getTranslations(`WHERE translationid = ?`, translationID)
+	if err != nil || len(ts) == 0 {
+		return
+	}
+	return ts[0], true, nil
+}
+
+// getTranslations returns the translations that a condition is true for
+func (fs *FileSystem) getTranslations(where string, args ...interface{}) (ts []Translation, err error) {
+	rows, err := fs.db.Query(`SELECT fsid, lang, translationid, version, translated FROM translations `+where, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "getTranslations")
+	}
+	defer rows.Close()
+	ts = []Translation{}
+	for rows.Next() {
+		var t Translation
+		err = rows.Scan(&t.ID, &t.Language, &t.TranslationID, &t.Version, &t.Translated)
+		if err != nil {
+			return nil, errors.Wrap(err, "get rows of getTranslations")
+		}
+		ts = append(ts, t)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.Wrap(err, "getTranslations")
+	}
+	return
+}
@@ -0,0 +1,195 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Delete puts a page in the trash, where it stays until it is restored or
+// the trash is purged. Pages in the trash are left out of the lists and
+// searches of their domain, but keep their history.
+func (fs *FileSystem) Delete(id, domain string) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	if err = fs.checkWritable(domain); err != nil {
+		return
+	}
+	files, err := fs.getAny(id, domain)
+	if err != nil {
+		return
+	}
+	if len(files) > 1 {
+		return errors.New("more than one page has that slug")
+	}
+	if files[0].Data == "" {
+		return errors.New("page is empty")
+	}
+
+	tx, err := fs.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin Delete")
+	}
+	_, err = tx.Exec(`UPDATE fs SET deleted = 1, modified = ? WHERE id = ?`, time.Now().UTC(), files[0].ID)
+	if err == nil {
+		_, err = tx.Exec(`UPDATE fts SET data = '', slug = '', title = '', words = '' WHERE id = ?`, files[0].ID)
+	}
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "exec Delete")
+	}
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrap(err, "commit Delete")
+	}
+	fs.unqueueIndex(files[0].ID)
+	fs.notifyWatchers(files[0].ID, VersionHash(""))
+	files[0].Domain = domain
+	fs.notifyDomainWatchers(EventDelete, files[0], files[0].Slug)
+	return
+}
+
+// Restore takes a page out of the trash, as it was when it was deleted
+func (fs *FileSystem) Restore(id, domain string) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	if err = fs.checkWritable(domain); err != nil {
+		return
+	}
+	var slug string
+	var historyBytes []byte
+	err = fs.db.QueryRow(`
+	SELECT fs.slug, fs.history FROM fs
+	INNER JOIN domains ON fs.domainid=domains.id
+	WHERE fs.id = ? AND domains.name = ? AND fs.deleted = 1`, id, domain).Scan(&slug, &historyBytes)
+	if err == sql.ErrNoRows {
+		return errors.New("page is not in the trash")
+	} else if err != nil {
+		return errors.Wrap(err, "get page in trash")
+	}
+	history, err := decodeHistory(historyBytes)
+	if err != nil {
+		return errors.Wrap(err, "could not parse history")
+	}
+	data := history.GetCurrent()
+
+	tx, err := fs.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin Restore")
+	}
+	_, err = tx.Exec(`UPDATE fs SET deleted = 0, modified = ? WHERE id = ?`, time.Now().UTC(), id)
+	if err == nil {
+		_, err = tx.Exec(`UPDATE fts SET data = ?, slug = ?, title = ? WHERE id = ?`, data, slug, pageTitle(data), id)
+	}
+	if err == nil {
+		err = fs.setLanguage(tx, id, data)
+	}
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "exec Restore")
+	}
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrap(err, "commit Restore")
+	}
+	fs.notifyWatchers(id, VersionHash(data))
+	fs.notifyDomainWatchers(EventCreate, File{ID: id, Domain: domain, Slug: slug, Data: data}, slug)
+	return
+}
+
+// GetTrash returns the pages in the trash of a domain, the most recently
+// deleted first
+func (fs *FileSystem) GetTrash(domain string) (files []File, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+	return fs.getAllFromPreparedQuery(`
+	SELECT fs.id,fs.slug,fs.created,fs.modified,fts.data,fs.history,fs.views,fs.archived FROM fs
+	INNER JOIN fts ON fs.id=fts.id
+	INNER JOIN domains ON fs.domainid=domains.id
+	WHERE
+		domains.name = ?
+		AND fs.deleted = 1
+	ORDER BY fs.modified DESC`, domain)
+}
+
+// PurgeTrash removes the pages that were put in the trash of a domain
+// before a time, and everything about them, for good. An empty domain
+// purges the trash of every domain.
+func (fs *FileSystem) PurgeTrash(domain string, olderThan time.Time) (purged int, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	ids, err := fs.getAllFromPreparedQuerySingleString(`
+	SELECT fs.id FROM fs
+	INNER JOIN domains ON fs.domainid=domains.id
+	WHERE fs.deleted = 1 AND fs.modified < ? AND (? = '' OR domains.name = ?)`, olderThan.UTC(), domain, domain)
+	if err != nil || len(ids) == 0 {
+		return
+	}
+
+	tx, err := fs.db.Begin()
+	if err != nil {
+		return 0, errors.Wrap(err, "begin PurgeTrash")
+	}
+	for _, id := range ids {
+		err = deletePage(tx, id)
+		if err != nil {
+			tx.Rollback()
+			return 0, errors.Wrap(err, "exec PurgeTrash")
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return 0, errors.Wrap(err, "commit PurgeTrash")
+	}
+	for _, id := range ids {
+		fs.unqueueIndex(id)
+	}
+	purged = len(ids)
+	return
+}
+
+// pageTables are the tables with rows about a single page, with the column
+// that has its id
+var pageTables = [][2]string{
+	{"fs", "id"},
+	{"fts", "id"},
+	{"tags", "fsid"},
+	{"times", "fsid"},
+	{"readability", "fsid"},
+	{"cards", "fsid"},
+	{"clocks", "fsid"},
+	{"similar", "fsid"},
+	{"similar", "fsid_similar"},
+	{"links", "from_id"},
+	{"blob_refs", "fsid"},
+	{"edits", "fsid"},
+	{"annotations", "fsid"},
+	{"responses", "fsid"},
+	{"translations", "fsid"},
+	{"translations", "translationid"},
+	{"content_reports", "fsid"},
+	{"remote_backup_pages", "fsid"},
+}
+
+// deletePage removes a page and every row about it for good, for the
+// purges of the trash, of empty pages and of domains alike. The links of
+// other pages to it are kept by the slug they were made with, and the
+// items of feeds that made it are kept, so that they are not made again.
+func deletePage(tx *sql.Tx, id string) (err error) {
+	for _, c := range pageTables {
+		_, err = tx.Exec(`DELETE FROM `+c[0]+` WHERE `+c[1]+` = ?`, id)
+		if err != nil {
+			return
+		}
+	}
+	_, err = tx.Exec(`DELETE FROM links WHERE to_id = ? AND to_slug = ''`, id)
+	if err != nil {
+		return
+	}
+	_, err = tx.Exec(`UPDATE OR REPLACE links SET to_id = '' WHERE to_id = ?`, id)
+	return
+}
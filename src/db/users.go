@@ -0,0 +1,329 @@
+package db
+
+import (
+	"database/sql"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// The roles a user can have in a domain. Readers can read the pages of a
+// domain that is private, editors can also write them, and admins, who
+// own the domain, can also change its settings and its members.
+const (
+	RoleReader = "reader"
+	RoleEditor = "editor"
+	RoleAdmin  = "admin"
+)
+
+// Member is a user who has a role in a domain
+type Member struct {
+	Name   string `json:"name"`
+	Role   string `json:"role"`
+	Active bool   `json:"active"`
+}
+
+// User is an account that was provisioned, with its role in each domain
+type User struct {
+	ID      int               `json:"-"`
+	Name    string            `json:"name"`
+	Active  bool              `json:"active"`
+	Roles   map[string]string `json:"roles"`
+	Created time.Time         `json:"created"`
+}
+
+// SetUser creates or updates a user and replaces their roles. The password
+// is only changed when it is given. Domains that do not exist yet are made.
+// Keys of the user that no longer match their roles are deleted, so that
+// disabling a user logs them out everywhere.
+func (fs *FileSystem) SetUser(u User, password string) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+	return fs.setUser(u, password)
+}
+
+func (fs *FileSystem) setUser(u User, password string) (err error) {
+	u.Name = strings.ToLower(strings.TrimSpace(u.Name))
+	if u.Name == "" {
+		return errors.New("user needs a name")
+	}
+	for domain, role := range u.Roles {
+		if role != RoleReader && role != RoleEditor && role != RoleAdmin {
+			return errors.New("role in " + domain + " must be reader, editor or admin")
+		}
+		if domain == "public" {
+			return errors.New("cannot have a role in public")
+		}
+	}
+	hashedPassword := ""
+	if password != "" {
+		hashedPassword, err = utils.HashPassword(password)
+		if err != nil {
+			return
+		}
+	}
+	domainids := make(map[string]int)
+	for domain := range u.Roles {
+		domainid, _, _, _ := fs.getDomainFromName(domain)
+		if domainid == 0 {
+			// nobody knows the password, so the domain is only used by
+			// its users until someone sets one
+			err = fs.setDomain(domain, utils.UUID())
+			if err != nil {
+				return
+			}
+			domainid, _, _, _ = fs.getDomainFromName(domain)
+		}
+		domainids[domain] = domainid
+	}
+
+	tx, err := fs.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin SetUser")
+	}
+	_, err = tx.Exec(`INSERT OR IGNORE INTO users (name, hashed_pass, active, created) VALUES (?,'',1,?)`, u.Name, time.Now().UTC())
+	if err == nil {
+		_, err = tx.Exec(`UPDATE users SET active = ? WHERE name = ?`, u.Active, u.Name)
+	}
+	if err == nil && hashedPassword != "" {
+		_, err = tx.Exec(`UPDATE users SET hashed_pass = ? WHERE name = ?`, hashedPassword, u.Name)
+	}
+	if err == nil {
+		err = tx.QueryRow(`SELECT id FROM users WHERE name = ?`, u.Name).Scan(&u.ID)
+	}
+	if err == nil {
+		_, err = tx.Exec(`DELETE FROM roles WHERE userid = ?`, u.ID)
+	}
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "exec SetUser")
+	}
+	for domain, role := range u.Roles {
+		_, err = tx.Exec(`INSERT INTO roles (userid, domainid, role) VALUES (?,?,?)`, u.ID, domainids[domain], role)
+		if err != nil {
+			tx.Rollback()
+			return errors.Wrap(err, "exec SetUser roles")
+		}
+	}
+	if u.Active {
+		_, err = tx.Exec(`DELETE FROM keys WHERE userid = ? AND NOT EXISTS
+		(SELECT 1 FROM roles WHERE roles.userid = keys.userid AND roles.domainid = keys.domainid AND roles.role = keys.role)`, u.ID)
+	} else {
+		_, err = tx.Exec(`DELETE FROM keys WHERE userid = ?`, u.ID)
+	}
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "exec SetUser keys")
+	}
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrap(err, "commit SetUser")
+	}
+	return
+}
+
+// GetUser returns a user and their roles
+func (fs *FileSystem) GetUser(name string) (u User, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+	u, _, err = fs.getUser(strings.ToLower(strings.TrimSpace(name)))
+	return
+}
+
+func (fs *FileSystem) getUser(name string) (u User, hashedPassword string, err error) {
+	err = fs.db.QueryRow(`SELECT id, name, hashed_pass, active, created FROM users WHERE name = ?`, name).Scan(&u.ID, &u.Name, &hashedPassword, &u.Active, &u.Created)
+	if err == sql.ErrNoRows {
+		err = errors.New("user " + name + " does not exist")
+		return
+	} else if err != nil {
+		err = errors.Wrap(err, "get user")
+		return
+	}
+
+	rows, err := fs.db.Query(`
+	SELECT domains.name, roles.role FROM roles
+	INNER JOIN domains ON roles.domainid=domains.id
+	WHERE roles.userid = ?`, u.ID)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	u.Roles = make(map[string]string)
+	for rows.Next() {
+		var domain, role string
+		err = rows.Scan(&domain, &role)
+		if err != nil {
+			err = errors.Wrap(err, "get rows of roles")
+			return
+		}
+		u.Roles[domain] = role
+	}
+	err = rows.Err()
+	return
+}
+
+// GetUsers returns all the users, sorted by name
+func (fs *FileSystem) GetUsers() (users []User, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+
+	rows, err := fs.db.Query(`SELECT name FROM users`)
+	if err != nil {
+		return
+	}
+	var names []string
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			rows.Close()
+			return
+		}
+		names = append(names, name)
+	}
+	rows.Close()
+	sort.Strings(names)
+
+	users = []User{}
+	for _, name := range names {
+		var u User
+		u, _, err = fs.getUser(name)
+		if err != nil {
+			return
+		}
+		users = append(users, u)
+	}
+	return
+}
+
+// HasUsers returns whether any user was made, who can log in with their
+// name and password
+func (fs *FileSystem) HasUsers() (has bool) {
+	fs.RLock()
+	defer fs.RUnlock()
+	fs.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM users)`).Scan(&has)
+	return
+}
+
+// CheckUser checks the password of a user who is active
+func (fs *FileSystem) CheckUser(name, password string) (u User, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+	u, hashedPassword, err := fs.getUser(strings.ToLower(strings.TrimSpace(name)))
+	if err != nil {
+		return
+	}
+	if hashedPassword == "" || utils.CheckPasswordHash(hashedPassword, password) != nil {
+		err = errors.New("incorrect username or password")
+		return
+	}
+	if !u.Active {
+		err = errors.New("user " + u.Name + " is disabled")
+	}
+	return
+}
+
+// KeyRole returns the role that a key, or an API token, has in its domain
+func (fs *FileSystem) KeyRole(key string) (role string) {
+	fs.RLock()
+	defer fs.RUnlock()
+	err := fs.db.QueryRow(`SELECT role FROM keys WHERE key = ?`, key).Scan(&role)
+	if err == sql.ErrNoRows {
+		_, role = fs.tokenRole(key)
+	}
+	return
+}
+
+// CheckReadKey checks that it is a valid key for reading a domain, which
+// the keys of readers, and every API token, are too
+func (fs *FileSystem) CheckReadKey(key string) (domain string, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+	err = fs.db.QueryRow(`SELECT domains.name FROM keys
+	INNER JOIN domains ON keys.domainid=domains.id
+	WHERE keys.key = ?`, key).Scan(&domain)
+	if err == sql.ErrNoRows {
+		if domain, _ = fs.tokenRole(key); domain != "" {
+			err = nil
+		}
+	}
+	if err == nil && domain == "" {
+		err = errors.New("no such key")
+	}
+	return
+}
+
+// KeyUser returns the name of the user that a key was made for, which is
+// empty for the keys of domain passwords
+func (fs *FileSystem) KeyUser(key string) (name string) {
+	fs.RLock()
+	defer fs.RUnlock()
+	fs.db.QueryRow(`SELECT users.name FROM keys INNER JOIN users ON keys.userid=users.id WHERE keys.key = ?`, key).Scan(&name)
+	return
+}
+
+// GetMembers returns the users who have a role in a domain, sorted by name
+func (fs *FileSystem) GetMembers(domain string) (members []Member, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+
+	rows, err := fs.db.Query(`SELECT users.name, roles.role, users.active FROM roles
+	INNER JOIN users ON roles.userid=users.id
+	INNER JOIN domains ON roles.domainid=domains.id
+	WHERE domains.name = ? ORDER BY users.name`, utils.NormalizeDomain(domain))
+	if err != nil {
+		return nil, errors.Wrap(err, "GetMembers")
+	}
+	defer rows.Close()
+	members = []Member{}
+	for rows.Next() {
+		var m Member
+		err = rows.Scan(&m.Name, &m.Role, &m.Active)
+		if err != nil {
+			return nil, errors.Wrap(err, "get rows of GetMembers")
+		}
+		members = append(members, m)
+	}
+	err = rows.Err()
+	return
+}
+
+// SetMember gives a user who exists a role in a domain, keeping their roles
+// in the other domains. Users are only made by the admin of the instance
+// and by provisioning, so that the admin of a domain can not take the name
+// of someone who logs in with LDAP or SAML.
+func (fs *FileSystem) SetMember(domain, name, role string) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	domain = utils.NormalizeDomain(domain)
+	if domainid, _, _, _ := fs.getDomainFromName(domain); domainid == 0 {
+		return errors.New("domain does not exist")
+	}
+	u, _, err := fs.getUser(strings.ToLower(strings.TrimSpace(name)))
+	if err != nil {
+		return
+	}
+	u.Roles[domain] = role
+	return fs.setUser(u, "")
+}
+
+// RemoveMember takes the role of a user in a domain away, and logs them
+// out of it
+func (fs *FileSystem) RemoveMember(domain, name string) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	domain = utils.NormalizeDomain(domain)
+	u, _, err := fs.getUser(strings.ToLower(strings.TrimSpace(name)))
+	if err != nil {
+		return
+	}
+	if _, ok := u.Roles[domain]; !ok {
+		return errors.New("user " + u.Name + " is not a member of " + domain)
+	}
+	delete(u.Roles, domain)
+	return fs.setUser(u, "")
+}
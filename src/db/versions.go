@@ -0,0 +1,314 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"html"
+	"html/template"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/schollz/versionedtext"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// Version is the text of a file at one point in its history
+type Version struct {
+	Timestamp int64
+	Hash      string
+	Data      string
+	// Diff is the lines that changed since the version before, when the
+	// versions are listed with GetVersions
+	Diff string
+}
+
+// historyDiffLines is how many changed lines are shown for each version
+const historyDiffLines = 200
+
+// VersionHash returns the hash that identifies a version of a text
+func VersionHash(data string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(data)))[:12]
+}
+
+// versions rebuilds every version in a history, oldest first
+func versions(history versionedtext.VersionedText) (vs []Version) {
+	dmp := diffmatchpatch.New()
+	lastText := ""
+	for _, timestamp := range history.GetSnapshots() {
+		diffs, err := dmp.DiffFromDelta(lastText, history.Diffs[timestamp])
+		if err != nil {
+			break
+		}
+		lastText = dmp.DiffText2(diffs)
+		vs = append(vs, Version{
+			Timestamp: timestamp,
+			Hash:      VersionHash(lastText),
+			Data:      lastText,
+		})
+	}
+	return
+}
+
+// GetVersions returns every version of a file, the newest first, with
+// the lines that changed in each
+func (fs *FileSystem) GetVersions(id, domain string) (vs []Version, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+
+	files, err := fs.getAny(id, domain)
+	if err != nil {
+		return
+	}
+	all := versions(files[0].History)
+	vs = make([]Version, len(all))
+	before := ""
+	for i, v := range all {
+		v.Diff = lineDiff(before, v.Data, historyDiffLines)
+		before = v.Data
+		vs[len(all)-1-i] = v
+	}
+	return
+}
+
+// GetVersionAt returns a file with its data as it was at the version
+// saved at a timestamp, in nanoseconds
+func (fs *FileSystem) GetVersionAt(id, domain string, timestamp int64) (f File, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+	return fs.getVersionAt(id, domain, timestamp)
+}
+
+func (fs *FileSystem) getVersionAt(id, domain string, timestamp int64) (f File, err error) {
+	files, err := fs.getAny(id, domain)
+	if err != nil {
+		return
+	}
+	f = files[0]
+	for _, v := range versions(f.History) {
+		if v.Timestamp == timestamp {
+			f.Data = v.Data
+			return
+		}
+	}
+	err = errors.New("no version at that time")
+	return
+}
+
+// RevertTo saves the data of a file as it was at the version saved at a
+// timestamp as its newest version, so the versions after it stay in its
+// history
+func (fs *FileSystem) RevertTo(id, domain string, timestamp int64) (f File, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+
+	f, err = fs.getVersionAt(id, domain, timestamp)
+	if err != nil {
+		return
+	}
+	defer fs.lockFile(f.ID)()
+	f.Domain = domain
+	err = fs.save(f)
+	return
+}
+
+// GetVersionByHash returns a file with its data as it was at the version
+// with the given hash
+func (fs *FileSystem) GetVersionByHash(id, domain, hash string) (f File, err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+
+	files, err := fs.getAny(id, domain)
+	if err != nil {
+		return
+	}
+	f = files[0]
+	for _, v := range versions(f.History) {
+		if v.Hash == hash {
+			f.Data = v.Data
+			f.Language = pageLanguage(v.Data)
+			return
+		}
+	}
+	err = errors.New("no version with that hash")
+	return
+}
+
+// findHistory finds the files of a domain whose older versions match a
+// search, besides the ones that were found already as they are now. Each
+// file has the newest version that matched as its data and revision, and
+// the files with the most recent matches come first. A version matches
+// when it has every word of the search, or one of its synonyms.
+func (fs *FileSystem) findHistory(ctx context.Context, text string, domain string, includeArchived int, found []File) (files []File, err error) {
+	terms := fs.historyTerms(text, domain)
+	if len(terms) == 0 {
+		return
+	}
+	skip := make(map[string]bool)
+	for _, f := range found {
+		skip[f.ID] = true
+	}
+
+	candidates, err := fs.getFiles(ctx, `
+	SELECT fs.id,fs.slug,fs.created,fs.modified,fts.data,fs.history,fs.views,fs.archived FROM fs
+	INNER JOIN fts ON fs.id=fts.id
+	INNER JOIN domains ON fs.domainid=domains.id
+	WHERE
+		domains.name = ?
+		AND fs.deleted = 0
+		AND fs.archived <= ?
+		AND (? = 0 OR fs.visibility = '')`, domain, includeArchived, publicReader(ctx))
+	if err != nil {
+		return
+	}
+	matched := make(map[string]int64)
+	files = []File{}
+	for _, f := range candidates {
+		if skip[f.ID] {
+			continue
+		}
+		err = f.LoadHistory()
+		if err != nil {
+			return
+		}
+		vs := versions(f.History)
+		// the newest version is the file as it is now, which did not match
+		for i := len(vs) - 2; i >= 0; i-- {
+			lower := strings.ToLower(vs[i].Data)
+			if !hasTerms(lower, terms) {
+				continue
+			}
+			f.Data = vs[i].Data
+			f.Revision = vs[i].Hash
+			f.Matches = 0
+			for _, term := range terms {
+				for _, word := range term {
+					f.Matches += strings.Count(lower, word)
+				}
+			}
+			f.Snippet = historySnippet(vs[i].Data, terms)
+			f.DataHTML = f.Snippet
+			matched[f.ID] = vs[i].Timestamp
+			files = append(files, f)
+			break
+		}
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return matched[files[i].ID] > matched[files[j].ID]
+	})
+	return
+}
+
+// historyTerms returns the words of a search of history in lowercase, each
+// with its synonyms, leaving out the stop words and FTS5 syntax
+func (fs *FileSystem) historyTerms(text, domain string) (terms [][]string) {
+	sw, err := fs.getSearchWords(domain)
+	if err != nil {
+		sw = SearchWords{}
+	}
+	stop := make(map[string]bool)
+	for _, word := range sw.StopWords {
+		stop[word] = true
+	}
+	synonyms := make(map[string][]string)
+	for _, group := range sw.Synonyms {
+		for _, word := range group {
+			synonyms[word] = append(synonyms[word], group...)
+		}
+	}
+	for _, word := range strings.Fields(strings.NewReplacer(`"`, " ", "(", " ", ")", " ", "*", " ").Replace(text)) {
+		if isFTSOperator(word) {
+			continue
+		}
+		word = strings.ToLower(word)
+		if stop[word] {
+			continue
+		}
+		if group, ok := synonyms[word]; ok {
+			terms = append(terms, cleanWords(group))
+		} else {
+			terms = append(terms, []string{word})
+		}
+	}
+	return
+}
+
+// hasTerms returns whether a text in lowercase has one of the words of
+// each term
+func hasTerms(lower string, terms [][]string) bool {
+	for _, term := range terms {
+		has := false
+		for _, word := range term {
+			if strings.Contains(lower, word) {
+				has = true
+				break
+			}
+		}
+		if !has {
+			return false
+		}
+	}
+	return true
+}
+
+// historySnippet shows the words of a search around their first match in
+// a version, like the snippets of the search index
+func historySnippet(data string, terms [][]string) template.HTML {
+	words := strings.Fields(data)
+	first := 0
+	for i, word := range words {
+		if hasAnyTerm(strings.ToLower(word), terms) {
+			first = i
+			break
+		}
+	}
+	start, end := first-7, first+8
+	if start < 0 {
+		start = 0
+	}
+	if end > len(words) {
+		end = len(words)
+	}
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("...")
+	}
+	for i, word := range words[start:end] {
+		if i > 0 {
+			b.WriteString(" ")
+		}
+		if hasAnyTerm(strings.ToLower(word), terms) {
+			b.WriteString("<b>" + html.EscapeString(word) + "</b>")
+		} else {
+			b.WriteString(html.EscapeString(word))
+		}
+	}
+	if end < len(words) {
+		b.WriteString("...")
+	}
+	return template.HTML(b.String())
+}
+
+// hasAnyTerm returns whether a word in lowercase has any word of a search
+func hasAnyTerm(lower string, terms [][]string) bool {
+	for _, term := range terms {
+		if hasTerms(lower, [][]string{term}) {
+			return true
+		}
+	}
+	return false
+}
+
+// pageFiles returns num of the files after skipping offset of them. A
+// negative num returns all of them.
+func pageFiles(files []File, num int, offset int) []File {
+	if offset > len(files) {
+		offset = len(files)
+	}
+	files = files[offset:]
+	if num >= 0 && num < len(files) {
+		files = files[:num]
+	}
+	return files
+}
@@ -0,0 +1,118 @@
+package db
+
+import (
+	"context"
+	"strings"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// The visibilities of pages in a public domain. Unlisted pages can be read
+// by anyone who has their link, but are not listed or found, and private
+// pages can only be read by the members of the domain. Pages are listed by
+// default.
+const (
+	VisibilityListed   = ""
+	VisibilityUnlisted = "unlisted"
+	VisibilityPrivate  = "private"
+)
+
+// memberKey marks a context of a member of the domain that they read
+type memberKey struct{}
+
+// AsMember returns a context for reading a domain as one of its members.
+// Get, GetTopX, Find and the other reads of pages hide the pages that are
+// not listed unless they are given a context from AsMember, and the reads
+// that do not take a context always hide them.
+func AsMember(ctx context.Context) context.Context {
+	return context.WithValue(ctx, memberKey{}, true)
+}
+
+// publicReader returns 1 unless the context is of a member of the domain,
+// for the queries that hide pages from who is not
+func publicReader(ctx context.Context) int {
+	if member, _ := ctx.Value(memberKey{}).(bool); member {
+		return 0
+	}
+	return 1
+}
+
+// allPages is the context of the changes that the caller was already
+// allowed to make, which see every page
+func allPages() context.Context {
+	return AsMember(context.Background())
+}
+
+// SetVisibility changes whether a page of a domain is listed, unlisted or
+// private
+func (fs *FileSystem) SetVisibility(domain, id, visibility string) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	visibility = strings.ToLower(strings.TrimSpace(visibility))
+	if visibility == "listed" || visibility == "public" {
+		visibility = VisibilityListed
+	}
+	if visibility != VisibilityListed && visibility != VisibilityUnlisted && visibility != VisibilityPrivate {
+		return errors.New("visibility must be listed, unlisted or private")
+	}
+	domainid, _, _, _ := fs.getDomainFromName(domain)
+	if domainid == 0 {
+		return errors.New("domain " + domain + " does not exist")
+	}
+	res, err := fs.db.Exec(`UPDATE fs SET visibility = ? WHERE domainid = ? AND (id = ? OR slug = ?)`,
+		visibility, domainid, id, utils.NormalizeSlug(id))
+	if err != nil {
+		return errors.Wrap(err, "SetVisibility")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New("no files with that slug or id")
+	}
+	return
+}
+
+// FilterListed returns the files that are listed, when they are read by
+// someone who is not a member of their domain, or else all of them. When
+// it can not tell which are listed, it returns none.
+func (fs *FileSystem) FilterListed(ctx context.Context, files []File) (listed []File) {
+	if publicReader(ctx) == 0 || len(files) == 0 {
+		return files
+	}
+	fs.RLock()
+	defer fs.RUnlock()
+	// few pages are hidden, so they are all found at once
+	hidden, err := fs.getAllFromPreparedQuerySingleString(`SELECT id FROM fs WHERE visibility != ''`)
+	if err != nil {
+		log.Debugf("could not find hidden pages: %s", err.Error())
+		return []File{}
+	} else if len(hidden) == 0 {
+		return files
+	}
+	isHidden := make(map[string]bool)
+	for _, id := range hidden {
+		isHidden[id] = true
+	}
+	listed = []File{}
+	for _, f := range files {
+		if !isHidden[f.ID] {
+			listed = append(listed, f)
+		}
+	}
+	return
+}
+
+// loadVisibilities reads whether the files are listed, unlisted or
+// private, and whether they are sensitive
+func (fs *FileSystem) loadVisibilities(ctx context.Context, files []File) (err error) {
+	for i := range files {
+		err = fs.db.QueryRowContext(ctx, `SELECT fs.visibility, fs.sensitive OR domains.sensitive FROM fs
+		INNER JOIN domains ON fs.domainid=domains.id
+		WHERE fs.id = ?`, files[i].ID).Scan(&files[i].Visibility, &files[i].Sensitive)
+		if err != nil {
+			return errors.Wrap(err, "loadVisibilities")
+		}
+	}
+	return
+}
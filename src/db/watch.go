@@ -0,0 +1,164 @@
+package db
+
+import (
+	"sync"
+	"time"
+
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// The kinds of the changes to the pages of a domain. Pages are created when
+// they are first saved with text, or taken out of the trash, and deleted
+// when they are put in the trash or saved empty.
+const (
+	EventCreate = "create"
+	EventUpdate = "update"
+	EventDelete = "delete"
+)
+
+// domainEventsBuffer is how many changes a watcher of a domain can be
+// behind before it is stopped
+const domainEventsBuffer = 100
+
+// PageEvent is a change to a page of a domain
+type PageEvent struct {
+	Type    string    `json:"type"`
+	Domain  string    `json:"domain"`
+	ID      string    `json:"id"`
+	Slug    string    `json:"slug"`
+	OldSlug string    `json:"old_slug,omitempty"`
+	Title   string    `json:"title"`
+	Version string    `json:"version"`
+	Author  string    `json:"author,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// pageWatchers are the channels that are told when pages change, by the
+// ids of the pages, and when the pages of domains change, by the names of
+// the domains
+type pageWatchers struct {
+	sync.Mutex
+	pages   map[string]map[chan string]bool
+	domains map[string]map[chan PageEvent]bool
+}
+
+// Watch returns a channel that gets the version of a page each time it is
+// saved or put in the trash, and what stops it. A watcher that is behind
+// only gets the newest version.
+func (fs *FileSystem) Watch(id string) (changes <-chan string, stop func()) {
+	c := make(chan string, 1)
+	fs.watchers.Lock()
+	if fs.watchers.pages == nil {
+		fs.watchers.pages = make(map[string]map[chan string]bool)
+	}
+	if fs.watchers.pages[id] == nil {
+		fs.watchers.pages[id] = make(map[chan string]bool)
+	}
+	fs.watchers.pages[id][c] = true
+	fs.watchers.Unlock()
+
+	stop = func() {
+		fs.watchers.Lock()
+		defer fs.watchers.Unlock()
+		delete(fs.watchers.pages[id], c)
+		if len(fs.watchers.pages[id]) == 0 {
+			delete(fs.watchers.pages, id)
+		}
+	}
+	return c, stop
+}
+
+// WatchDomain returns a channel that gets each change to the pages of a
+// domain, and what stops it. A watcher that falls too far behind is
+// stopped, and its channel is closed, so that it can read the pages again
+// instead of missing changes.
+func (fs *FileSystem) WatchDomain(domain string) (events <-chan PageEvent, stop func()) {
+	domain = utils.NormalizeDomain(domain)
+	c := make(chan PageEvent, domainEventsBuffer)
+	fs.watchers.Lock()
+	if fs.watchers.domains == nil {
+		fs.watchers.domains = make(map[string]map[chan PageEvent]bool)
+	}
+	if fs.watchers.domains[domain] == nil {
+		fs.watchers.domains[domain] = make(map[chan PageEvent]bool)
+	}
+	fs.watchers.domains[domain][c] = true
+	fs.watchers.Unlock()
+
+	stop = func() {
+		fs.watchers.Lock()
+		defer fs.watchers.Unlock()
+		fs.stopDomainWatcher(domain, c)
+	}
+	return c, stop
+}
+
+// stopDomainWatcher closes the channel of a watcher of a domain, once
+func (fs *FileSystem) stopDomainWatcher(domain string, c chan PageEvent) {
+	if !fs.watchers.domains[domain][c] {
+		return
+	}
+	close(c)
+	delete(fs.watchers.domains[domain], c)
+	if len(fs.watchers.domains[domain]) == 0 {
+		delete(fs.watchers.domains, domain)
+	}
+}
+
+// Watching returns how many watchers pages and domains have
+func (fs *FileSystem) Watching() (n int) {
+	fs.watchers.Lock()
+	defer fs.watchers.Unlock()
+	for _, watchers := range fs.watchers.pages {
+		n += len(watchers)
+	}
+	for _, watchers := range fs.watchers.domains {
+		n += len(watchers)
+	}
+	return
+}
+
+// notifyWatchers tells the watchers of a page its new version, without
+// waiting for any of them
+func (fs *FileSystem) notifyWatchers(id, version string) {
+	fs.watchers.Lock()
+	defer fs.watchers.Unlock()
+	for c := range fs.watchers.pages[id] {
+		// the version that was not read yet is old now
+		select {
+		case <-c:
+		default:
+		}
+		select {
+		case c <- version:
+		default:
+		}
+	}
+}
+
+// notifyDomainWatchers tells the watchers of the domain of a page that it
+// changed, without waiting for any of them
+func (fs *FileSystem) notifyDomainWatchers(kind string, f File, oldSlug string) {
+	e := PageEvent{
+		Type:    kind,
+		Domain:  utils.NormalizeDomain(f.Domain),
+		ID:      f.ID,
+		Slug:    f.Slug,
+		Title:   pageTitle(f.Data),
+		Version: VersionHash(f.Data),
+		Author:  f.Author,
+		Time:    time.Now().UTC(),
+	}
+	if oldSlug != f.Slug {
+		e.OldSlug = oldSlug
+	}
+	fs.watchers.Lock()
+	defer fs.watchers.Unlock()
+	for c := range fs.watchers.domains[e.Domain] {
+		select {
+		case c <- e:
+		default:
+			fs.stopDomainWatcher(e.Domain, c)
+		}
+	}
+}
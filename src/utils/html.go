@@ -0,0 +1,275 @@
+package utils
+
+import (
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+var skipTags = map[atom.Atom]bool{
+	atom.Script:   true,
+	atom.Style:    true,
+	atom.Nav:      true,
+	atom.Header:   true,
+	atom.Footer:   true,
+	atom.Aside:    true,
+	atom.Form:     true,
+	atom.Noscript: true,
+	atom.Iframe:   true,
+	atom.Svg:      true,
+	atom.Button:   true,
+}
+
+var manyNewlines = regexp.MustCompile(`\n{3,}`)
+var manySpaces = regexp.MustCompile(`\s+`)
+
+// ExtractArticle finds the title and the node with the main readable
+// content of a web page, by finding the element whose paragraphs hold
+// the most text.
+func ExtractArticle(r io.Reader) (title string, content *html.Node, err error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return
+	}
+
+	scores := make(map[*html.Node]int)
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && skipTags[n.DataAtom] {
+			return
+		}
+		if n.Type == html.ElementNode {
+			switch n.DataAtom {
+			case atom.Title:
+				if title == "" {
+					title = strings.TrimSpace(nodeText(n))
+				}
+			case atom.H1:
+				// the heading of the article is better than the page title
+				title = strings.TrimSpace(nodeText(n))
+			case atom.P, atom.Pre, atom.Blockquote:
+				length := len(strings.TrimSpace(nodeText(n)))
+				if n.Parent != nil {
+					scores[n.Parent] += length
+					if n.Parent.Parent != nil {
+						scores[n.Parent.Parent] += length / 2
+					}
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	content = doc
+	best := 0
+	for n, score := range scores {
+		if score > best {
+			best = score
+			content = n
+		}
+	}
+	return
+}
+
+// ExtractPreview returns the title, description and image of a web page,
+// preferring its Open Graph tags
+func ExtractPreview(r io.Reader) (title, description, image string, err error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return
+	}
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.DataAtom {
+			case atom.Title:
+				if title == "" {
+					title = strings.TrimSpace(nodeText(n))
+				}
+			case atom.Meta:
+				key := getAttr(n, "property")
+				if key == "" {
+					key = getAttr(n, "name")
+				}
+				value := strings.TrimSpace(getAttr(n, "content"))
+				switch key {
+				case "og:title":
+					title = value
+				case "og:description":
+					description = value
+				case "description":
+					if description == "" {
+						description = value
+					}
+				case "og:image":
+					image = value
+				}
+			case atom.Body:
+				return
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	return
+}
+
+// NodeToMarkdown converts html to markdown. The rewrite function is
+// called with the tag and the url of every link and image, and returns
+// the url to use instead.
+func NodeToMarkdown(n *html.Node, rewrite func(tag, url string) string) string {
+	if rewrite == nil {
+		rewrite = func(tag, url string) string { return url }
+	}
+	var b strings.Builder
+	writeMarkdown(&b, n, rewrite, "")
+	lines := strings.Split(b.String(), "\n")
+	for i := range lines {
+		lines[i] = strings.TrimRight(lines[i], " ")
+	}
+	markdown := manyNewlines.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+	return strings.TrimSpace(markdown)
+}
+
+// HTMLToMarkdown converts a fragment of html to markdown
+func HTMLToMarkdown(s string) (markdown string, err error) {
+	nodes, err := html.ParseFragment(strings.NewReader(s), &html.Node{
+		Type:     html.ElementNode,
+		Data:     "body",
+		DataAtom: atom.Body,
+	})
+	if err != nil {
+		return
+	}
+	root := &html.Node{Type: html.ElementNode, Data: "div", DataAtom: atom.Div}
+	for _, node := range nodes {
+		root.AppendChild(node)
+	}
+	return NodeToMarkdown(root, nil), nil
+}
+
+func writeMarkdown(b *strings.Builder, n *html.Node, rewrite func(tag, url string) string, listPrefix string) {
+	switch n.Type {
+	case html.TextNode:
+		b.WriteString(manySpaces.ReplaceAllString(n.Data, " "))
+		return
+	case html.ElementNode, html.DocumentNode:
+	default:
+		return
+	}
+	if skipTags[n.DataAtom] {
+		return
+	}
+
+	children := func() {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			writeMarkdown(b, c, rewrite, listPrefix)
+		}
+	}
+	inline := func() string {
+		var inner strings.Builder
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			writeMarkdown(&inner, c, rewrite, listPrefix)
+		}
+		return strings.TrimSpace(inner.String())
+	}
+
+	switch n.DataAtom {
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		level := int(n.Data[1] - '0')
+		b.WriteString("\n\n" + strings.Repeat("#", level) + " " + inline() + "\n\n")
+	case atom.P, atom.Div, atom.Section, atom.Article, atom.Main, atom.Figure:
+		b.WriteString("\n\n")
+		children()
+		b.WriteString("\n\n")
+	case atom.Br:
+		b.WriteString("\n")
+	case atom.Hr:
+		b.WriteString("\n\n---\n\n")
+	case atom.A:
+		text := inline()
+		href := getAttr(n, "href")
+		if href == "" || text == "" {
+			b.WriteString(text)
+		} else {
+			b.WriteString("[" + text + "](" + rewrite("a", href) + ")")
+		}
+	case atom.Img:
+		src := getAttr(n, "src")
+		if src != "" {
+			b.WriteString("![" + getAttr(n, "alt") + "](" + rewrite("img", src) + ")")
+		}
+	case atom.Strong, atom.B:
+		if text := inline(); text != "" {
+			b.WriteString("**" + text + "**")
+		}
+	case atom.Em, atom.I:
+		if text := inline(); text != "" {
+			b.WriteString("*" + text + "*")
+		}
+	case atom.Code:
+		b.WriteString("`" + nodeText(n) + "`")
+	case atom.Pre:
+		b.WriteString("\n\n```\n" + strings.Trim(nodeText(n), "\n") + "\n```\n\n")
+	case atom.Ul, atom.Ol:
+		b.WriteString("\n\n")
+		i := 1
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.DataAtom != atom.Li {
+				continue
+			}
+			prefix := "- "
+			if n.DataAtom == atom.Ol {
+				prefix = strconv.Itoa(i) + ". "
+			}
+			var item strings.Builder
+			writeMarkdown(&item, c, rewrite, listPrefix+"  ")
+			b.WriteString(listPrefix + prefix + strings.TrimSpace(item.String()) + "\n")
+			i++
+		}
+		b.WriteString("\n")
+	case atom.Li:
+		children()
+	case atom.Blockquote:
+		lines := strings.Split(inline(), "\n")
+		b.WriteString("\n\n")
+		for _, line := range lines {
+			b.WriteString("> " + line + "\n")
+		}
+		b.WriteString("\n")
+	default:
+		children()
+	}
+}
+
+func nodeText(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && skipTags[c.DataAtom] {
+			continue
+		}
+		b.WriteString(nodeText(c))
+	}
+	return b.String()
+}
+
+func getAttr(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
@@ -0,0 +1,248 @@
+package utils
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// NormalizeSlug returns the slug of a page the way it is kept, which is in
+// lowercase, with the letters that are written with combining marks
+// composed, so that "Café" is the same page whether its é was typed as one
+// letter or as an e and an accent
+func NormalizeSlug(slug string) string {
+	return strings.ToLower(ComposeMarks(strings.TrimSpace(slug)))
+}
+
+// NormalizeDomain returns the name of a domain the way it is kept, which
+// is a normalized slug where names with letters that are not ASCII are
+// written in punycode, like "xn--caf-dma" for "café", the way domain names
+// of the internet are. The names are in cookies and urls, which are ASCII.
+func NormalizeDomain(domain string) string {
+	domain = NormalizeSlug(domain)
+	labels := strings.Split(domain, ".")
+	for i, label := range labels {
+		if !isASCII(label) {
+			labels[i] = "xn--" + punycode(label)
+		}
+	}
+	return strings.Join(labels, ".")
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// ComposeMarks writes the letters of the Latin, Greek and Cyrillic scripts
+// that are followed by combining marks as the letters with the marks, as
+// the NFC normalization of Unicode does for those scripts
+func ComposeMarks(text string) string {
+	if isASCII(text) {
+		return text
+	}
+	composed := make([]rune, 0, len(text))
+	for _, r := range text {
+		if n := len(composed); n > 0 && unicode.Is(unicode.Mn, r) {
+			if c, ok := compositions[[2]rune{composed[n-1], r}]; ok {
+				composed[n-1] = c
+				continue
+			}
+		}
+		composed = append(composed, r)
+	}
+	return string(composed)
+}
+
+// compositions are the letters with marks, by the letter and the
+// combining mark they are composed of
+var compositions = make(map[[2]rune]rune)
+
+func init() {
+	for mark, pairs := range composedLetters {
+		runes := []rune(pairs)
+		for i := 0; i+1 < len(runes); i += 2 {
+			compositions[[2]rune{runes[i], mark}] = runes[i+1]
+		}
+	}
+}
+
+// composedLetters are, for each combining mark, the letters that it is
+// composed with, each followed by the letter with the mark
+var composedLetters = map[rune]string{
+	// grave accent
+	0x0300: "AÀEÈIÌOÒUÙaàeèiìoòuùÜǛüǜNǸnǹЕЀИЍеѐиѝĒḔēḕ" +
+		"ŌṐōṑWẀwẁÂẦâầĂẰăằÊỀêềÔỒôồƠỜơờƯỪưừYỲyỳἀἂἁἃ" +
+		"ἈἊἉἋἐἒἑἓἘἚἙἛἠἢἡἣἨἪἩἫἰἲἱἳἸἺἹἻὀὂὁὃὈὊὉὋὐὒὑὓ" +
+		"ὙὛὠὢὡὣὨὪὩὫαὰεὲηὴιὶοὸυὺωὼΑᾺΕῈΗῊ᾿῍ϊῒΙῚ῾῝ϋῢ" +
+		"ΥῪ¨῭ΟῸΩῺ",
+	// acute accent
+	0x0301: "AÁEÉIÍOÓUÚYÝaáeéiíoóuúyýCĆcćLĹlĺNŃnńRŔrŕ" +
+		"SŚsśZŹzźÜǗüǘGǴgǵÅǺåǻÆǼæǽØǾøǿ¨΅ΑΆΕΈΗΉΙΊΟΌ" +
+		"ΥΎΩΏϊΐαάεέηήιίϋΰοόυύωώϒϓГЃКЌгѓкќÇḈçḉĒḖēḗ" +
+		"ÏḮïḯKḰkḱMḾmḿÕṌõṍŌṒōṓPṔpṕŨṸũṹWẂwẃÂẤâấĂẮăắ" +
+		"ÊẾêếÔỐôốƠỚơớƯỨưứἀἄἁἅἈἌἉἍἐἔἑἕἘἜἙἝἠἤἡἥἨἬἩἭ" +
+		"ἰἴἱἵἸἼἹἽὀὄὁὅὈὌὉὍὐὔὑὕὙὝὠὤὡὥὨὬὩὭ᾿῎῾῞",
+	// circumflex accent
+	0x0302: "AÂEÊIÎOÔUÛaâeêiîoôuûCĈcĉGĜgĝHĤhĥJĴjĵSŜsŝ" +
+		"WŴwŵYŶyŷZẐzẑẠẬạậẸỆẹệỌỘọộ",
+	// tilde
+	0x0303: "AÃNÑOÕaãnñoõIĨiĩUŨuũVṼvṽÂẪâẫĂẴăẵEẼeẽÊỄêễ" +
+		"ÔỖôỗƠỠơỡƯỮưữYỸyỹ",
+	// macron
+	0x0304: "AĀaāEĒeēIĪiīOŌoōUŪuūÜǕüǖÄǞäǟȦǠȧǡÆǢæǣǪǬǫǭ" +
+		"ÖȪöȫÕȬõȭȮȰȯȱYȲyȳИӢиӣУӮуӯGḠgḡḶḸḷḹṚṜṛṝαᾱΑᾹ" +
+		"ιῑΙῙυῡΥῩ",
+	// breve
+	0x0306: "AĂaăEĔeĕGĞgğIĬiĭOŎoŏUŬuŭУЎИЙийуўЖӁжӂАӐаӑ" +
+		"ЕӖеӗȨḜȩḝẠẶạặαᾰΑᾸιῐΙῘυῠΥῨ",
+	// dot above
+	0x0307: "CĊcċEĖeėGĠgġIİZŻzżAȦaȧOȮoȯBḂbḃDḊdḋFḞfḟHḢ" +
+		"hḣMṀmṁNṄnṅPṖpṗRṘrṙSṠsṡŚṤśṥŠṦšṧṢṨṣṩTṪtṫWẆ" +
+		"wẇXẊxẋYẎyẏſẛ",
+	// diaeresis
+	0x0308: "AÄEËIÏOÖUÜaäeëiïoöuüyÿYŸΙΪΥΫιϊυϋϒϔЕЁІЇеё" +
+		"іїАӒаӓӘӚәӛЖӜжӝЗӞзӟИӤиӥОӦоӧӨӪөӫЭӬэӭУӰуӱЧӴ" +
+		"чӵЫӸыӹHḦhḧÕṎõṏŪṺūṻWẄwẅXẌxẍtẗ",
+	// hook above
+	0x0309: "AẢaảÂẨâẩĂẲăẳEẺeẻÊỂêểIỈiỉOỎoỏÔỔôổƠỞơởUỦuủ" +
+		"ƯỬưửYỶyỷ",
+	// ring above
+	0x030A: "AÅaåUŮuůwẘyẙ",
+	// double acute accent
+	0x030B: "OŐoőUŰuűУӲуӳ",
+	// caron
+	0x030C: "CČcčDĎdďEĚeěLĽlľNŇnňRŘrřSŠsšTŤtťZŽzžAǍaǎ" +
+		"IǏiǐOǑoǒUǓuǔÜǙüǚGǦgǧKǨkǩƷǮʒǯjǰHȞhȟ",
+	// double grave accent
+	0x030F: "AȀaȁEȄeȅIȈiȉOȌoȍRȐrȑUȔuȕѴѶѵѷ",
+	// inverted breve
+	0x0311: "AȂaȃEȆeȇIȊiȋOȎoȏRȒrȓUȖuȗ",
+	// comma above
+	0x0313: "αἀΑἈεἐΕἘηἠΗἨιἰΙἸοὀΟὈυὐωὠΩὨρῤ",
+	// reversed comma above
+	0x0314: "αἁΑἉεἑΕἙηἡΗἩιἱΙἹοὁΟὉυὑΥὙωὡΩὩρῥΡῬ",
+	// horn
+	0x031B: "OƠoơUƯuư",
+	// dot below
+	0x0323: "BḄbḅDḌdḍHḤhḥKḲkḳLḶlḷMṂmṃNṆnṇRṚrṛSṢsṣTṬtṭ" +
+		"VṾvṿWẈwẉZẒzẓAẠaạEẸeẹIỊiịOỌoọƠỢơợUỤuụƯỰưự" +
+		"YỴyỵ",
+	// diaeresis below
+	0x0324: "UṲuṳ",
+	// ring below
+	0x0325: "AḀaḁ",
+	// comma below
+	0x0326: "SȘsșTȚtț",
+	// cedilla
+	0x0327: "CÇcçGĢgģKĶkķLĻlļNŅnņRŖrŗSŞsşTŢtţEȨeȩDḐdḑ" +
+		"HḨhḩ",
+	// ogonek
+	0x0328: "AĄaąEĘeęIĮiįUŲuųOǪoǫ",
+	// circumflex accent below
+	0x032D: "DḒdḓEḘeḙLḼlḽNṊnṋTṰtṱUṶuṷ",
+	// breve below
+	0x032E: "HḪhḫ",
+	// tilde below
+	0x0330: "EḚeḛIḬiḭUṴuṵ",
+	// macron below
+	0x0331: "BḆbḇDḎdḏKḴkḵLḺlḻNṈnṉRṞrṟTṮtṯZẔzẕhẖ",
+	// greek perispomeni
+	0x0342: "ἀἆἁἇἈἎἉἏἠἦἡἧἨἮἩἯἰἶἱἷἸἾἹἿὐὖὑὗὙὟὠὦὡὧὨὮὩὯαᾶ" +
+		"¨῁ηῆ᾿῏ιῖϊῗ῾῟υῦϋῧωῶ",
+	// greek ypogegrammeni
+	0x0345: "ἀᾀἁᾁἂᾂἃᾃἄᾄἅᾅἆᾆἇᾇἈᾈἉᾉἊᾊἋᾋἌᾌἍᾍἎᾎἏᾏἠᾐἡᾑἢᾒἣᾓ" +
+		"ἤᾔἥᾕἦᾖἧᾗἨᾘἩᾙἪᾚἫᾛἬᾜἭᾝἮᾞἯᾟὠᾠὡᾡὢᾢὣᾣὤᾤὥᾥὦᾦὧᾧ" +
+		"ὨᾨὩᾩὪᾪὫᾫὬᾬὭᾭὮᾮὯᾯὰᾲαᾳάᾴᾶᾷΑᾼὴῂηῃήῄῆῇΗῌὼῲωῳ" +
+		"ώῴῶῷΩῼ",
+}
+
+// punycode encodes a label that is not ASCII, as in RFC 3492
+func punycode(label string) string {
+	const (
+		base        = 36
+		tmin        = 1
+		tmax        = 26
+		skew        = 38
+		damp        = 700
+		initialBias = 72
+		initialN    = 128
+	)
+	digit := func(d int) byte {
+		if d < 26 {
+			return byte('a' + d)
+		}
+		return byte('0' + d - 26)
+	}
+	adapt := func(delta, points int, first bool) int {
+		if first {
+			delta /= damp
+		} else {
+			delta /= 2
+		}
+		delta += delta / points
+		k := 0
+		for delta > ((base-tmin)*tmax)/2 {
+			delta /= base - tmin
+			k += base
+		}
+		return k + (base-tmin+1)*delta/(delta+skew)
+	}
+
+	runes := []rune(label)
+	var out []byte
+	for _, r := range runes {
+		if r < utf8.RuneSelf {
+			out = append(out, byte(r))
+		}
+	}
+	basic := len(out)
+	handled := basic
+	if basic > 0 {
+		out = append(out, '-')
+	}
+	n, delta, bias := initialN, 0, initialBias
+	for handled < len(runes) {
+		m := int(unicode.MaxRune) + 1
+		for _, r := range runes {
+			if int(r) >= n && int(r) < m {
+				m = int(r)
+			}
+		}
+		delta += (m - n) * (handled + 1)
+		n = m
+		for _, r := range runes {
+			if int(r) < n {
+				delta++
+			}
+			if int(r) != n {
+				continue
+			}
+			q := delta
+			for k := base; ; k += base {
+				t := k - bias
+				if t < tmin {
+					t = tmin
+				} else if t > tmax {
+					t = tmax
+				}
+				if q < t {
+					break
+				}
+				out = append(out, digit(t+(q-t)%(base-t)))
+				q = (q - t) / (base - t)
+			}
+			out = append(out, digit(q))
+			bias = adapt(delta, handled+1, handled == basic)
+			delta = 0
+			handled++
+		}
+		delta++
+		n++
+	}
+	return string(out)
+}
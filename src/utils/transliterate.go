@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"strings"
+	"unicode"
+)
+
+// transliterations are the latin letters of the letters with accents and
+// of the Cyrillic and Greek letters, in lowercase
+var transliterations = make(map[rune]string)
+
+func init() {
+	for letters, latin := range map[string]string{
+		"àáâãäåāăą": "a", "æ": "ae", "çćĉċč": "c", "ďđð": "d",
+		"èéêëēĕėęě": "e", "ĝğġģ": "g", "ĥħ": "h", "ìíîïĩīĭįı": "i",
+		"ĳ": "ij", "ĵ": "j", "ķ": "k", "ĺļľŀł": "l", "ñńņň": "n",
+		"òóôõöøōŏő": "o", "œ": "oe", "ŕŗř": "r", "śŝşšș": "s", "ß": "ss",
+		"ţťŧț": "t", "ùúûüũūŭůűų": "u", "ŵ": "w", "ýÿŷ": "y", "źżž": "z",
+		"þ": "th",
+
+		"а": "a", "б": "b", "в": "v", "гґ": "g", "д": "d", "е": "e",
+		"ё": "yo", "є": "ye", "ж": "zh", "з": "z", "иі": "i", "ї": "yi",
+		"й": "y", "к": "k", "л": "l", "м": "m", "н": "n", "о": "o",
+		"п": "p", "р": "r", "с": "s", "т": "t", "у": "u", "ф": "f",
+		"х": "kh", "ц": "ts", "ч": "ch", "ш": "sh", "щ": "shch", "ъь": "",
+		"ы": "y", "э": "e", "ю": "yu", "я": "ya",
+
+		"αά": "a", "β": "v", "γ": "g", "δ": "d", "εέ": "e", "ζ": "z",
+		"ηή": "i", "θ": "th", "ιίϊΐ": "i", "κ": "k", "λ": "l", "μ": "m",
+		"ν": "n", "ξ": "x", "οό": "o", "π": "p", "ρ": "r", "σς": "s",
+		"τ": "t", "υύϋΰ": "y", "φ": "f", "χ": "ch", "ψ": "ps", "ωώ": "o",
+	} {
+		for _, r := range letters {
+			transliterations[r] = latin
+		}
+	}
+}
+
+// Transliterate writes the letters with accents and the Cyrillic and
+// Greek letters of a text in latin letters, in lowercase. Other scripts
+// are left as they are.
+func Transliterate(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		if latin, ok := transliterations[unicode.ToLower(r)]; ok {
+			b.WriteString(latin)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -6,6 +6,8 @@ import (
 	"encoding/hex"
 	"html/template"
 	"math/rand"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/microcosm-cc/bluemonday"
@@ -38,6 +40,151 @@ func RenderMarkdownToHTML(markdown string) template.HTML {
 	return template.HTML(html)
 }
 
+// ParseFrontMatter splits the "key: value" lines between two "---" lines
+// at the top of a page from the rest of the markdown. Keys are lowercase.
+func ParseFrontMatter(markdown string) (meta map[string]string, body string) {
+	meta = make(map[string]string)
+	body = markdown
+	trimmed := strings.TrimLeft(markdown, " \t\r\n")
+	if !strings.HasPrefix(trimmed, "---") {
+		return
+	}
+	lines := strings.Split(trimmed, "\n")
+	if strings.TrimSpace(lines[0]) != "---" {
+		return
+	}
+	for i := 1; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if line == "---" {
+			body = strings.Join(lines[i+1:], "\n")
+			return
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		meta[strings.ToLower(strings.TrimSpace(parts[0]))] = strings.TrimSpace(parts[1])
+	}
+	// no closing line, so it was not front matter
+	meta = make(map[string]string)
+	return
+}
+
+var nonSlugChars = regexp.MustCompile(`[^\w\-]+`)
+var manyDashes = regexp.MustCompile(`\-\-+`)
+
+// Slugify returns a slug made from the first line of the text that has
+// one, the same way the editor does
+func Slugify(text string) string {
+	_, body := ParseFrontMatter(text)
+	for _, line := range strings.Split(body, "\n") {
+		slug := strings.ToLower(line)
+		slug = strings.Join(strings.Fields(slug), "-")
+		slug = nonSlugChars.ReplaceAllString(slug, "")
+		slug = manyDashes.ReplaceAllString(slug, "-")
+		slug = strings.Trim(slug, "-")
+		if len(slug) > 1 {
+			return slug
+		}
+	}
+	return ""
+}
+
+// hashtag is a "#tag" that starts a word, so that headings, anchors like
+// "(#top)" and issue numbers like "#12" are not tags
+var hashtag = regexp.MustCompile(`(^|[\s,;])#(\p{L}[\p{L}\p{N}_\-]*)`)
+
+// Hashtags returns the "#tags" in markdown, lowercase and once each,
+// leaving out the ones in code
+func Hashtags(markdown string) (tags []string) {
+	seen := make(map[string]bool)
+	replaceHashtags(markdown, func(tag string) string {
+		tag = strings.ToLower(tag)
+		if !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+		return "#" + tag
+	})
+	return
+}
+
+// LinkHashtags makes the "#tags" in markdown, other than the ones in code,
+// into links to the urls that link returns for them
+func LinkHashtags(markdown string, link func(tag string) string) string {
+	return replaceHashtags(markdown, func(tag string) string {
+		return "[#" + tag + "](" + link(strings.ToLower(tag)) + ")"
+	})
+}
+
+// replaceHashtags replaces the "#tags" outside of code blocks and inline
+// code with what replace returns for them
+func replaceHashtags(markdown string, replace func(tag string) string) string {
+	return replaceOutsideCode(markdown, hashtag, func(m []string) string {
+		return m[1] + replace(m[2])
+	})
+}
+
+// wikiLink is a [[Page]] link, which can have a label as [[Page|label]]
+var wikiLink = regexp.MustCompile(`\[\[([^\[\]|\n]+)(?:\|([^\[\]\n]+))?\]\]`)
+
+// WikiLinks returns the slugs of the pages that the [[Page]] links in
+// markdown are to, once each, leaving out the ones in code
+func WikiLinks(markdown string) (slugs []string) {
+	seen := make(map[string]bool)
+	replaceOutsideCode(markdown, wikiLink, func(m []string) string {
+		slug := Slugify(m[1])
+		if slug != "" && !seen[slug] {
+			seen[slug] = true
+			slugs = append(slugs, slug)
+		}
+		return m[0]
+	})
+	return
+}
+
+// LinkWikiLinks makes the [[Page]] links in markdown, other than the ones
+// in code, into what link returns for the slug of their page and their
+// label, which is the name of the page unless one is given
+func LinkWikiLinks(markdown string, link func(slug, label string) string) string {
+	return replaceOutsideCode(markdown, wikiLink, func(m []string) string {
+		slug := Slugify(m[1])
+		if slug == "" {
+			return m[0]
+		}
+		label := strings.TrimSpace(m[2])
+		if label == "" {
+			label = strings.TrimSpace(m[1])
+		}
+		return link(slug, label)
+	})
+}
+
+// replaceOutsideCode replaces the matches of a pattern outside of code
+// blocks and inline code with what replace returns for their submatches
+func replaceOutsideCode(markdown string, pattern *regexp.Regexp, replace func(m []string) string) string {
+	lines := strings.Split(markdown, "\n")
+	inCode := false
+	for i, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inCode = !inCode
+			continue
+		}
+		if inCode {
+			continue
+		}
+		// the even parts are outside of inline code
+		parts := strings.Split(line, "`")
+		for j := 0; j < len(parts); j += 2 {
+			parts[j] = pattern.ReplaceAllStringFunc(parts[j], func(s string) string {
+				return replace(pattern.FindStringSubmatch(s))
+			})
+		}
+		lines[i] = strings.Join(parts, "`")
+	}
+	return strings.Join(lines, "\n")
+}
+
 var src = rand.NewSource(time.Now().UnixNano())
 
 const letterBytes = "abcdefghijklmnopqrstuvwxyz0123456789"
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"html/template"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+var uploadAttribute = regexp.MustCompile(`(src|href)="/uploads/(sha256-[0-9a-f]+)[^"]*"`)
+
+type standaloneRender struct {
+	Title    string
+	CSS      template.CSS
+	Rendered template.HTML
+	URL      string
+	Exported string
+}
+
+var standaloneTemplate = template.Must(template.New("standalone").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>{{.CSS}}</style>
+</head>
+<body>
+<div class="main">
+<div id="rendered">{{.Rendered}}</div>
+<p class="grayed smaller">Exported from <a href="{{.URL}}">{{.URL}}</a> on {{.Exported}}.</p>
+</div>
+</body>
+</html>
+`))
+
+// handleStandalone downloads a page as a single html file, with its
+// styles and uploads inlined so that it can be emailed or archived. It
+// links to the version it was made from, so that it can be cited.
+func (tr *TemplateRender) handleStandalone(w http.ResponseWriter, r *http.Request, f db.File, meta map[string]string, body string) (err error) {
+	tr.ReadOnly = true
+	rendered := addPreviews(utils.RenderMarkdownToHTML("\n\n" + body))
+	rendered = tr.addTables(rendered, body)
+	rendered = addCharts(rendered, body)
+	rendered = addMaps(rendered, meta)
+
+	var css []byte
+	for _, name := range []string{"assets/css/normalize.css", "assets/css/rwtxt.css"} {
+		b, errAsset := Asset(name + ".gz")
+		if errAsset == nil {
+			b, errAsset = gunzip(b)
+		}
+		if errAsset != nil {
+			log.Error(errAsset)
+			continue
+		}
+		css = append(css, b...)
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	sr := standaloneRender{
+		Title:    f.Slug,
+		CSS:      template.CSS(css),
+		Rendered: template.HTML(inlineUploads(string(rendered))),
+		URL:      scheme + "://" + r.Host + "/" + tr.Domain + "/" + f.ID + "@" + db.VersionHash(f.Data),
+		Exported: time.Now().UTC().Format("January 2, 2006"),
+	}
+
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+f.Slug+`.html"`)
+	gz := gzip.NewWriter(w)
+	defer gz.Close()
+	return standaloneTemplate.Execute(gz, sr)
+}
+
+// inlineUploads replaces links to uploads with data urls
+func inlineUploads(rendered string) string {
+	return uploadAttribute.ReplaceAllStringFunc(rendered, func(s string) string {
+		match := uploadAttribute.FindStringSubmatch(s)
+		name, data, err := readBlob(match[2])
+		if err != nil {
+			log.Debugf("could not inline %s: %s", match[2], err.Error())
+			return s
+		}
+		contentType := mime.TypeByExtension(path.Ext(name))
+		if contentType == "" {
+			contentType = http.DetectContentType(data)
+		}
+		var buf bytes.Buffer
+		buf.WriteString(match[1] + `="data:` + contentType + ";base64,")
+		buf.WriteString(base64.StdEncoding.EncodeToString(data))
+		buf.WriteString(`"`)
+		if match[1] == "href" {
+			buf.WriteString(` download="` + template.HTMLEscapeString(path.Base(name)) + `"`)
+		}
+		return buf.String()
+	})
+}
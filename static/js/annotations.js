@@ -0,0 +1,117 @@
+// annotations are highlights of the rendered page with optional comments
+(function () {
+    var rendered = document.getElementById("rendered");
+    if (rendered == null) {
+        return;
+    }
+    var api = "/api/v1/annotations";
+    var list = document.getElementById("annotations");
+
+    // highlight finds the quote in the page, preferring the place where
+    // the text before it matches the prefix
+    function highlight(annotation) {
+        var walker = document.createTreeWalker(rendered, NodeFilter.SHOW_TEXT, null, false);
+        var found = null;
+        var node;
+        while ((node = walker.nextNode())) {
+            var i = node.nodeValue.indexOf(annotation.quote);
+            while (i >= 0) {
+                if (found == null) {
+                    found = [node, i];
+                }
+                if (annotation.prefix && node.nodeValue.substring(0, i).endsWith(annotation.prefix)) {
+                    found = [node, i];
+                    break;
+                }
+                i = node.nodeValue.indexOf(annotation.quote, i + 1);
+            }
+        }
+        if (found == null) {
+            return;
+        }
+        var range = document.createRange();
+        range.setStart(found[0], found[1]);
+        range.setEnd(found[0], found[1] + annotation.quote.length);
+        var mark = document.createElement("mark");
+        mark.className = "annotation";
+        mark.title = annotation.comment || "";
+        range.surroundContents(mark);
+
+        if (annotation.comment && list != null) {
+            var item = document.createElement("li");
+            var quote = document.createElement("em");
+            quote.textContent = annotation.quote;
+            item.appendChild(quote);
+            item.appendChild(document.createTextNode(" " + annotation.comment));
+            list.appendChild(item);
+            list.style.display = "block";
+        }
+    }
+
+    fetch(api + "?domain=" + encodeURIComponent(window.rwtxt.domain) +
+            "&page=" + encodeURIComponent(window.rwtxt.file_id), {
+            credentials: "same-origin"
+        })
+        .then(function (response) {
+            return response.json();
+        })
+        .then(function (annotations) {
+            if (Array.isArray(annotations)) {
+                annotations.forEach(highlight);
+            }
+        });
+
+    // show a button to annotate when text is selected
+    var button = document.createElement("button");
+    button.id = "annotate";
+    button.textContent = "Annotate";
+    button.style.display = "none";
+    document.body.appendChild(button);
+
+    var selected = null;
+    rendered.addEventListener("mouseup", function () {
+        var selection = window.getSelection();
+        var quote = selection.toString().trim();
+        if (quote.length == 0 || selection.anchorNode.nodeType != Node.TEXT_NODE) {
+            button.style.display = "none";
+            return;
+        }
+        var text = selection.anchorNode.nodeValue;
+        var start = Math.min(selection.anchorOffset, selection.focusOffset);
+        selected = {
+            quote: quote,
+            prefix: text.substring(Math.max(0, start - 32), start)
+        };
+        button.style.display = "block";
+    });
+
+    button.addEventListener("click", function () {
+        if (selected == null) {
+            return;
+        }
+        var annotation = selected;
+        selected = null;
+        button.style.display = "none";
+        var comment = prompt("Comment (optional)");
+        if (comment == null) {
+            return;
+        }
+        annotation.comment = comment;
+        annotation.domain = window.rwtxt.domain;
+        annotation.domain_key = window.rwtxt.domain_key;
+        annotation.page = window.rwtxt.file_id;
+        fetch(api, {
+                method: "POST",
+                credentials: "same-origin",
+                body: JSON.stringify(annotation)
+            })
+            .then(function (response) {
+                return response.json();
+            })
+            .then(function (saved) {
+                if (saved.id) {
+                    highlight(saved);
+                }
+            });
+    });
+})();
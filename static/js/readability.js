@@ -0,0 +1,43 @@
+// readability shows how easy the text is to read, when its panel is open,
+// as it was when the text was last saved
+(function () {
+    var panel = document.getElementById("readability");
+    if (panel == null) {
+        return;
+    }
+    var stats = document.getElementById("readabilitystats");
+
+    function show() {
+        if (!panel.open) {
+            return;
+        }
+        fetch("/api/v1/readability?domain=" + encodeURIComponent(window.rwtxt.domain) +
+                "&domain_key=" + encodeURIComponent(window.rwtxt.domain_key) +
+                "&id=" + encodeURIComponent(window.rwtxt.file_id), {
+                credentials: "same-origin"
+            })
+            .then(function (response) {
+                return response.json();
+            })
+            .then(function (r) {
+                if (r.words == undefined) {
+                    stats.textContent = r.message || "";
+                    return;
+                }
+                var lines = [
+                    r.words + " words in " + r.sentences + " sentences, the longest with " + r.longest_sentence + " words",
+                    "Flesch reading ease " + r.reading_ease + ", Flesch-Kincaid grade level " + r.grade_level
+                ];
+                r.sentence_lengths.forEach(function (l) {
+                    lines.push(l.words + " words: " + l.sentences + " sentences");
+                });
+                stats.textContent = lines.join("\n");
+            });
+    }
+
+    panel.addEventListener("toggle", show);
+    document.addEventListener("rwtxt:edit", function () {
+        panel.style.display = "block";
+    });
+    document.addEventListener("rwtxt:saved", show);
+})();
@@ -0,0 +1,41 @@
+// readers of a page are told when it changes, with a banner to reload it
+(function () {
+    if (!window.EventSource || !window.rwtxt || !window.rwtxt.file_id) {
+        return;
+    }
+    var url = "/api/v1/" + encodeURIComponent(window.rwtxt.domain) +
+        "/events/" + encodeURIComponent(window.rwtxt.file_id) +
+        "?version=" + encodeURIComponent(window.rwtxt.version || "");
+    var events = new EventSource(url);
+    var banner;
+
+    events.addEventListener("changed", function (e) {
+        if (e.data == window.rwtxt.version) {
+            return;
+        }
+        var editable = document.getElementById("editable");
+        if (editable && editable.style.display != "none") {
+            // editors are the ones changing it, and reload when they are done
+            return;
+        }
+        showBanner();
+    });
+
+    function showBanner() {
+        if (banner) {
+            return;
+        }
+        banner = document.createElement("div");
+        banner.className = "updated-banner";
+        banner.appendChild(document.createTextNode("This page was updated. "));
+        var reload = document.createElement("a");
+        reload.href = window.location.pathname + window.location.search;
+        reload.textContent = "Reload";
+        reload.addEventListener("click", function (e) {
+            e.preventDefault();
+            window.location.reload();
+        });
+        banner.appendChild(reload);
+        document.body.insertBefore(banner, document.body.firstChild);
+    }
+})();
@@ -6,6 +6,8 @@ const socketMessageListener = (event) => {
 };
 const socketOpenListener = (event) => {
     // console.log('Connected');
+    CY.pending = 0;
+    CY.join();
     document.getElementById("connectedicon").style.display = 'inline-block';
     setTimeout(function () {
         document.getElementById("connectedicon").style.display = 'none';
@@ -38,7 +40,17 @@ function getParameterByName(name, url) {
 // slugify the current text
 function slugify(text) {
     var lines = text.split('\n');
-    for (var i = 0; i < lines.length; i++) {
+    var start = 0;
+    // skip front matter
+    if (lines.length > 0 && lines[0].trim() == "---") {
+        for (var j = 1; j < lines.length; j++) {
+            if (lines[j].trim() == "---") {
+                start = j + 1;
+                break;
+            }
+        }
+    }
+    for (var i = start; i < lines.length; i++) {
         var slug = lines[i].toString().toLowerCase()
             .replace(/\s+/g, '-') // Replace spaces with -
             .replace(/[^\w\-]+/g, '') // Remove all non-word chars
@@ -64,6 +76,10 @@ setTimeout(function () {
 }, 0);
 
 var CY = {};
+// the text that the editor started from, which the server has, and how
+// many edits were sent that the server has not answered yet
+CY.base = document.getElementById('editable').value;
+CY.pending = 0;
 CY.debounce = function (func, wait, immediate) {
     var timeout;
     return function () {
@@ -89,16 +105,81 @@ CY.contentEdited = function () {
     var markdown = document.getElementById("editable").value.replaceAll("<br>", "\n");
     var slug = slugify(markdown);
     socket.send(JSON.stringify({
+        "message": "edit",
         "id": window.rwtxt.file_id,
         "slug": slugify(markdown),
         "data": markdown,
+        "base": CY.base,
+        "domain": window.rwtxt.domain,
+        "domain_key": window.rwtxt.domain_key
+    }));
+    // the server merges the edit with the changes of the other editors,
+    // so the next edit starts from this one
+    CY.base = markdown;
+    CY.pending++;
+};
+
+// join the other editors of the page, to see their changes as they make
+// them
+CY.join = function () {
+    if (document.getElementById("editable").style.display == 'none' || window.rwtxt.file_id == "") {
+        return;
+    }
+    socket.send(JSON.stringify({
+        "message": "join",
+        "id": window.rwtxt.file_id,
         "domain": window.rwtxt.domain,
         "domain_key": window.rwtxt.domain_key
     }));
 };
 
+// setText replaces the text of the editor with the text of the server,
+// keeping the cursor where it was in the text around it
+CY.setText = function (text) {
+    var editor = document.getElementById("editable");
+    var old = editor.value;
+    if (old.trim() == text) {
+        return;
+    }
+    var start = editor.selectionStart;
+    var end = editor.selectionEnd;
+    var prefix = 0;
+    while (prefix < old.length && prefix < text.length && old[prefix] == text[prefix]) {
+        prefix++;
+    }
+    var shift = text.length - old.length;
+    if (start > prefix) {
+        start = Math.max(prefix, start + shift);
+    }
+    if (end > prefix) {
+        end = Math.max(prefix, end + shift);
+    }
+    editor.value = text;
+    editor.setSelectionRange(start, end);
+    autoExpand(editor);
+};
+
 CY.serverResponse = function (jsonString) {
     var data = JSON.parse(jsonString);
+    var editor = document.getElementById("editable");
+    if (data.message == "unique_slug" && data.id == window.rwtxt.file_id) {
+        // take the edits of others that were merged with this one, unless
+        // there are more edits to send that start from this one
+        CY.pending = Math.max(0, CY.pending - 1);
+        if (CY.pending == 0 && editor.value.trim() == CY.base.trim()) {
+            CY.base = data.data || "";
+            CY.setText(CY.base);
+        }
+    } else if (data.message == "changed" && data.id == window.rwtxt.file_id) {
+        if (CY.pending == 0 && editor.value.trim() == CY.base.trim()) {
+            CY.base = data.data || "";
+            CY.setText(CY.base);
+        } else if (CY.pending == 0) {
+            // edits that were not sent yet are merged with the change
+            CY.contentEdited();
+        }
+        return;
+    }
     if (data.message == "unique_slug") {
         var newwindowname = ""
         if (data.success) {
@@ -113,10 +194,12 @@ CY.serverResponse = function (jsonString) {
             document.title = newwindowname;
         }
         document.getElementById("saved").style.display = 'inline-block';
+        document.dispatchEvent(new Event("rwtxt:saved"));
         setTimeout(function () {
             document.getElementById("saved").style.display = 'none';
         }, 1000);
     } else if (data.message == "not saving") {
+        CY.pending = Math.max(0, CY.pending - 1);
         document.getElementById("notsaved").style.display = 'inline-block';
         setTimeout(function () {
             document.getElementById("notsaved").style.display = 'none';
@@ -138,6 +221,7 @@ CY.loadEditor = function () {
     editor.style.display = 'inline-block'; // needed to add brs at end
     editor.focus();
     autoExpand(document.getElementById("editable"));
+    document.dispatchEvent(new Event("rwtxt:edit"));
     // console.log('loading editor');
     showMessage();
 };
@@ -0,0 +1,90 @@
+// tables made from csv blocks can be sorted, and edited cell by cell
+(function () {
+    var tables = document.querySelectorAll("table.csv-table");
+    Array.prototype.forEach.call(tables, function (table) {
+        var headers = table.querySelectorAll("th");
+        Array.prototype.forEach.call(headers, function (th, column) {
+            th.addEventListener("click", function () {
+                sortTable(table, column, th);
+            });
+        });
+        if (table.dataset.editable == "yes") {
+            makeEditable(table);
+        }
+    });
+
+    function cellValue(row, column) {
+        var cell = row.children[column];
+        return cell ? cell.textContent.trim() : "";
+    }
+
+    function sortTable(table, column, th) {
+        var tbody = table.tBodies[0];
+        var rows = Array.prototype.slice.call(tbody.rows);
+        var ascending = th.dataset.sort != "asc";
+        rows.sort(function (a, b) {
+            var x = cellValue(a, column);
+            var y = cellValue(b, column);
+            var nx = parseFloat(x);
+            var ny = parseFloat(y);
+            var result = (!isNaN(nx) && !isNaN(ny)) ? nx - ny : x.localeCompare(y);
+            return ascending ? result : -result;
+        });
+        rows.forEach(function (row) {
+            tbody.appendChild(row);
+        });
+        Array.prototype.forEach.call(table.querySelectorAll("th"), function (other) {
+            delete other.dataset.sort;
+        });
+        th.dataset.sort = ascending ? "asc" : "desc";
+    }
+
+    function makeEditable(table) {
+        var cells = table.querySelectorAll("th, td");
+        Array.prototype.forEach.call(cells, function (cell) {
+            cell.contentEditable = "true";
+            cell.dataset.shown = cell.textContent;
+            cell.dataset.original = cell.dataset.formula || cell.textContent;
+            // the row in the csv is kept even when the table is sorted
+            cell.dataset.row = cell.parentNode.parentNode.tagName == "THEAD" ? 0 : cell.parentNode.sectionRowIndex + 1;
+            cell.addEventListener("focus", function () {
+                // formulas are edited, not their results
+                if (cell.dataset.formula) {
+                    cell.textContent = cell.dataset.formula;
+                }
+            });
+            cell.addEventListener("blur", function () {
+                if (cell.textContent == cell.dataset.original) {
+                    cell.textContent = cell.dataset.shown;
+                    return;
+                }
+                cell.dataset.original = cell.textContent;
+                cell.dataset.shown = cell.textContent;
+                fetch("/api/v1/table", {
+                    method: "POST",
+                    credentials: "same-origin",
+                    body: JSON.stringify({
+                        domain: window.rwtxt.domain,
+                        domain_key: window.rwtxt.domain_key,
+                        page: window.rwtxt.file_id,
+                        block: parseInt(table.dataset.block),
+                        row: parseInt(cell.dataset.row),
+                        column: cell.cellIndex,
+                        value: cell.textContent.trim()
+                    })
+                }).then(function (response) {
+                    // formulas are computed by the server, so show the new results
+                    if (response.ok && (table.querySelector("[data-formula]") || cell.textContent.trim().charAt(0) == "=")) {
+                        window.location.reload();
+                    }
+                });
+            });
+            cell.addEventListener("click", function (e) {
+                // editing a header should not sort
+                if (cell.tagName == "TH") {
+                    e.stopPropagation();
+                }
+            });
+        });
+    }
+})();
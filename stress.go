@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/schollz/rwtxt/src/db"
+)
+
+// stress saves, gets and finds pages from many workers at once, in a
+// database of its own unless one is given, and fails when any of it went
+// wrong, so that changes to saving can be checked under load
+func stress(args []string) (err error) {
+	flags := flag.NewFlagSet("stress", flag.ExitOnError)
+	database := flags.String("db", "", "database to stress, which gets a stress domain (default a new one that is removed after)")
+	domain := flags.String("domain", "stress", "domain to save the pages in")
+	pages := flags.Int("pages", 10, "number of pages the workers share")
+	workers := flags.Int("workers", 16, "number of workers saving, getting and finding pages at once")
+	duration := flags.Duration("duration", 10*time.Second, "how long the workers run")
+	indexDelay := flags.Duration("index-delay", 10*time.Millisecond, "how long changes wait to be indexed, short so that the index is flushed while pages are saved")
+	flags.Parse(args)
+
+	name := *database
+	if name == "" {
+		dir, errDir := ioutil.TempDir("", "rwtxt-stress")
+		if errDir != nil {
+			return errDir
+		}
+		defer os.RemoveAll(dir)
+		name = filepath.Join(dir, "stress.db")
+	}
+	db.IndexDelay = *indexDelay
+	fs, err = db.New(name, dbOptions)
+	if err != nil {
+		return
+	}
+	defer fs.Close()
+
+	fmt.Printf("stressing %d pages with %d workers for %s\n", *pages, *workers, *duration)
+	report, err := fs.Stress(db.StressOptions{
+		Domain:   *domain,
+		Pages:    *pages,
+		Workers:  *workers,
+		Duration: *duration,
+	})
+	if err != nil {
+		return
+	}
+	fmt.Printf("%d saves, %d gets and %d finds\n", report.Saves, report.Gets, report.Finds)
+	for _, e := range report.Errors {
+		fmt.Println("error:", e)
+	}
+	for _, p := range report.Problems {
+		fmt.Println("problem:", p)
+	}
+	if !report.OK() {
+		return fmt.Errorf("%d errors and %d problems", len(report.Errors), len(report.Problems))
+	}
+	fmt.Println("ok")
+	return
+}
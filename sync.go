@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// SyncRequest is the body of a request from a device to sync a page. The
+// version and base are what the device got the last time it synced the
+// page, and are empty for a page it made.
+type SyncRequest struct {
+	Domain    string           `json:"domain"`
+	DomainKey string           `json:"domain_key,omitempty"`
+	ID        string           `json:"id"`
+	Slug      string           `json:"slug,omitempty"`
+	Device    string           `json:"device"`
+	Version   db.VersionVector `json:"version"`
+	Base      string           `json:"base"`
+	Data      string           `json:"data"`
+}
+
+// handleSync returns a page with its version (GET), and saves the changes
+// that a device made to it (POST), merging them with the changes that
+// other devices made at the same time
+func (tr *TemplateRender) handleSync(w http.ResponseWriter, r *http.Request) (err error) {
+	var req SyncRequest
+	if r.Method == "POST" {
+		err = json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			return writeJSON(w, http.StatusBadRequest, Payload{Message: err.Error()})
+		}
+	} else {
+		req.Domain = r.FormValue("domain")
+		req.DomainKey = r.FormValue("domain_key")
+		req.ID = r.FormValue("id")
+	}
+	req.Domain = utils.NormalizeDomain(req.Domain)
+	if req.Domain == "" {
+		req.Domain = "public"
+	}
+
+	if r.Method != "POST" {
+		if !tr.canRead(req.Domain, req.DomainKey) {
+			return writeJSON(w, http.StatusForbidden, Payload{Message: "need to be logged in"})
+		}
+		result, errGet := fs.GetVersionCtx(tr.readContext(r, req.Domain, req.DomainKey), req.ID, req.Domain)
+		if errGet != nil {
+			return writeJSON(w, http.StatusNotFound, Payload{Message: errGet.Error()})
+		}
+		return writeJSON(w, http.StatusOK, result)
+	}
+
+	if !tr.canWrite(req.Domain, req.DomainKey) {
+		return writeJSON(w, http.StatusForbidden, Payload{Message: "need to be logged in"})
+	}
+	result, err := fs.SyncPage(req.Domain, req.ID, req.Slug, req.Device, req.Version, req.Base, req.Data)
+	if err != nil {
+		return writeJSON(w, http.StatusBadRequest, Payload{Message: err.Error()})
+	}
+	if result.Status == "conflict" {
+		return writeJSON(w, http.StatusConflict, result)
+	}
+	if result.Status != "current" {
+		audit(r, "page.synced", req.Domain, result.ID, req.Device)
+	}
+	return writeJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"io"
+	"log/syslog"
+)
+
+// openSyslog opens the system log for the audit log
+func openSyslog() (io.Writer, error) {
+	return syslog.New(syslog.LOG_NOTICE|syslog.LOG_AUTH, "rwtxt")
+}
@@ -0,0 +1,11 @@
+package main
+
+import (
+	"errors"
+	"io"
+)
+
+// openSyslog fails because there is no system log on windows
+func openSyslog() (io.Writer, error) {
+	return nil, errors.New("syslog is not available on windows, use a file for the audit log")
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"regexp"
+	"strings"
+
+	log "github.com/cihub/seelog"
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+var csvBlock = regexp.MustCompile("(?s)```csv[ \\t]*\\n(.*?)```")
+var renderedCSVBlock = regexp.MustCompile(`(?s)<pre><code class="language-csv">.*?</code></pre>`)
+
+type tableCell struct {
+	Value   string
+	Formula string
+}
+
+type tableRender struct {
+	Block    int
+	Header   []tableCell
+	Rows     [][]tableCell
+	Editable bool
+}
+
+var tableTemplate = template.Must(template.New("table").Parse(`<table class="csv-table" data-block="{{.Block}}"{{ if .Editable }} data-editable="yes"{{ end }}>
+<thead><tr>{{ range .Header }}<th{{ if .Formula }} data-formula="{{.Formula}}"{{ end }}>{{.Value}}</th>{{ end }}</tr></thead>
+<tbody>{{ range .Rows }}<tr>{{ range . }}<td{{ if .Formula }} class="formula" data-formula="{{.Formula}}"{{ end }}>{{.Value}}</td>{{ end }}</tr>
+{{ end }}</tbody>
+</table>`))
+
+// TableRequest is the body of a request to change a cell of a csv block
+type TableRequest struct {
+	Domain    string `json:"domain"`
+	DomainKey string `json:"domain_key,omitempty"`
+	Page      string `json:"page"`
+	Block     int    `json:"block"`
+	Row       int    `json:"row"`
+	Column    int    `json:"column"`
+	Value     string `json:"value"`
+}
+
+// parseCSVBlocks returns the records of every ```csv block in a page
+func parseCSVBlocks(markdown string) (tables [][][]string) {
+	for _, match := range csvBlock.FindAllStringSubmatch(markdown, -1) {
+		r := csv.NewReader(strings.NewReader(match[1]))
+		r.FieldsPerRecord = -1
+		r.TrimLeadingSpace = true
+		records, err := r.ReadAll()
+		if err != nil {
+			log.Debugf("could not parse csv: %s", err.Error())
+		}
+		tables = append(tables, records)
+	}
+	return
+}
+
+// addTables replaces the csv blocks in a rendered page with tables
+func (tr *TemplateRender) addTables(rendered template.HTML, markdown string) template.HTML {
+	tables := parseCSVBlocks(markdown)
+	if len(tables) == 0 {
+		return rendered
+	}
+	editable := tr.SignedIn && !tr.ReadOnly
+	i := 0
+	return template.HTML(renderedCSVBlock.ReplaceAllStringFunc(string(rendered), func(s string) string {
+		if i >= len(tables) || len(tables[i]) == 0 {
+			i++
+			return s
+		}
+		cells := evaluateTable(tables[i])
+		var buf bytes.Buffer
+		err := tableTemplate.Execute(&buf, tableRender{
+			Block:    i,
+			Header:   cells[0],
+			Rows:     cells[1:],
+			Editable: editable,
+		})
+		i++
+		if err != nil {
+			log.Error(err)
+			return s
+		}
+		return buf.String()
+	}))
+}
+
+// evaluateTable computes the formulas in a table
+func evaluateTable(records [][]string) (cells [][]tableCell) {
+	s := newSheet(records)
+	cells = make([][]tableCell, len(records))
+	for i := range records {
+		cells[i] = make([]tableCell, len(records[i]))
+		for j, cell := range records[i] {
+			cells[i][j].Value = s.display(i, j)
+			if isFormula(cell) {
+				cells[i][j].Formula = strings.TrimSpace(cell)
+			}
+		}
+	}
+	return
+}
+
+// setCSVCell changes one cell of a csv block in a page, where row 0 is the header
+func setCSVCell(markdown string, block, row, column int, value string) (string, error) {
+	matches := csvBlock.FindAllStringSubmatchIndex(markdown, -1)
+	if block < 0 || block >= len(matches) {
+		return markdown, errors.New("no such table")
+	}
+	start, end := matches[block][2], matches[block][3]
+	records := parseCSVBlocks(markdown)[block]
+	if row < 0 || column < 0 || row >= len(records) {
+		return markdown, errors.New("no such cell")
+	}
+	for len(records[row]) <= column {
+		records[row] = append(records[row], "")
+	}
+	records[row][column] = value
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.WriteAll(records)
+	if err := w.Error(); err != nil {
+		return markdown, err
+	}
+	return markdown[:start] + buf.String() + markdown[end:], nil
+}
+
+// handleTable changes a cell of a csv block in a page
+func (tr *TemplateRender) handleTable(w http.ResponseWriter, r *http.Request) (err error) {
+	var req TableRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return writeJSON(w, http.StatusBadRequest, Payload{Message: err.Error()})
+	}
+	req.Domain = utils.NormalizeDomain(req.Domain)
+	if !tr.canWrite(req.Domain, req.DomainKey) {
+		return writeJSON(w, http.StatusForbidden, Payload{Message: "need to be logged in"})
+	}
+	files, err := fs.GetCtx(db.AsMember(r.Context()), req.Page, req.Domain)
+	if err != nil {
+		return writeJSON(w, http.StatusNotFound, Payload{Message: err.Error()})
+	}
+	f := files[0]
+	f.Data, err = setCSVCell(f.Data, req.Block, req.Row, req.Column, req.Value)
+	if err != nil {
+		return writeJSON(w, http.StatusBadRequest, Payload{Message: err.Error()})
+	}
+	f.Domain = req.Domain
+	f.Source = remoteIP(r)
+	f.Author = tr.author(req.Domain, req.DomainKey)
+	err = fs.Save(f)
+	if err != nil {
+		return writeJSON(w, http.StatusInternalServerError, Payload{Message: err.Error()})
+	}
+	auditSave(r, f)
+	return writeJSON(w, http.StatusOK, Payload{ID: f.ID, Slug: f.Slug, Success: true})
+}
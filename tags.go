@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// TagsRequest is the body of a request to set the tags of a page
+type TagsRequest struct {
+	Domain    string   `json:"domain"`
+	DomainKey string   `json:"domain_key,omitempty"`
+	ID        string   `json:"id"`
+	Tags      []string `json:"tags"`
+}
+
+// tagLink is the page that lists the pages of a domain with a tag
+func tagLink(domain, tag string) string {
+	return "/" + domain + "/tags/" + url.PathEscape(tag)
+}
+
+// handleTags lists the tags of a domain at /DOMAIN/tags, and the pages
+// with a tag at /DOMAIN/tags/TAG
+func (tr *TemplateRender) handleTags(w http.ResponseWriter, r *http.Request) (err error) {
+	_, ispublic, _ := fs.GetDomainFromName(tr.Domain)
+	if !tr.SignedIn && !tr.Reader && !ispublic {
+		return tr.handleMain(w, r, "need to log in to list")
+	}
+	fields := strings.Split(r.URL.Path, "/")
+	if len(fields) > 3 && fields[3] != "" {
+		tag := strings.ToLower(fields[3])
+		files, errGet := fs.GetByTagCtx(tr.readContext(r, tr.Domain, ""), tr.Domain, tag)
+		if errGet != nil {
+			return tr.handleMain(w, r, errGet.Error())
+		}
+		for i := range files {
+			files[i].Data = ""
+			files[i].DataHTML = ""
+		}
+		return tr.handleList(w, r, "#"+tag, files, 0, len(files))
+	}
+
+	tr.Tags, err = fs.ListTags(tr.Domain)
+	if err != nil {
+		return tr.handleMain(w, r, err.Error())
+	}
+	return tr.handleList(w, r, "Tags", nil, 0, len(tr.Tags))
+}
+
+// handleTagsAPI lists the tags of a domain, or the pages with a tag when
+// one is given (GET), and sets the tags of a page besides its #hashtags
+// (POST)
+func (tr *TemplateRender) handleTagsAPI(w http.ResponseWriter, r *http.Request) (err error) {
+	var req TagsRequest
+	if r.Method == "POST" {
+		err = json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			return writeJSON(w, http.StatusBadRequest, Payload{Message: err.Error()})
+		}
+	} else {
+		req.Domain = r.FormValue("domain")
+		req.DomainKey = r.FormValue("domain_key")
+	}
+	req.Domain = utils.NormalizeDomain(req.Domain)
+	if req.Domain == "" {
+		req.Domain = "public"
+	}
+
+	if r.Method == "POST" {
+		if !tr.canWrite(req.Domain, req.DomainKey) {
+			return writeJSON(w, http.StatusForbidden, Payload{Message: "need to be logged in"})
+		}
+		files, errGet := fs.GetCtx(db.AsMember(r.Context()), req.ID, req.Domain)
+		if errGet != nil || len(files) == 0 {
+			return writeJSON(w, http.StatusNotFound, Payload{Message: "page does not exist"})
+		}
+		err = fs.SetTags(files[0].ID, req.Tags)
+		if err != nil {
+			return writeJSON(w, http.StatusBadRequest, Payload{Message: err.Error()})
+		}
+		audit(r, "page.tagged", req.Domain, files[0].ID, strings.Join(req.Tags, ","))
+		tags, errTags := fs.GetTags(files[0].ID)
+		if errTags != nil {
+			return writeJSON(w, http.StatusInternalServerError, Payload{Message: errTags.Error()})
+		}
+		return writeJSON(w, http.StatusOK, tags)
+	}
+
+	if req.Domain == "public" || !tr.canRead(req.Domain, req.DomainKey) {
+		return writeJSON(w, http.StatusForbidden, Payload{Message: "need to be logged in"})
+	}
+	if tag := r.FormValue("tag"); tag != "" {
+		files, errGet := fs.GetByTagCtx(tr.readContext(r, req.Domain, req.DomainKey), req.Domain, tag)
+		if errGet != nil {
+			return writeJSON(w, http.StatusInternalServerError, Payload{Message: errGet.Error()})
+		}
+		pages := make([]Payload, len(files))
+		for i, f := range files {
+			pages[i] = Payload{ID: f.ID, Slug: f.Slug, Domain: req.Domain, Success: true}
+		}
+		return writeJSON(w, http.StatusOK, pages)
+	}
+	tags, err := fs.ListTags(req.Domain)
+	if err != nil {
+		return writeJSON(w, http.StatusInternalServerError, Payload{Message: err.Error()})
+	}
+	return writeJSON(w, http.StatusOK, tags)
+}
+
+// linkTags makes the #hashtags of a page into links to the other pages
+// with them
+func linkTags(domain, body string) string {
+	if domain == "public" {
+		return body
+	}
+	return utils.LinkHashtags(body, func(tag string) string {
+		return tagLink(domain, tag)
+	})
+}
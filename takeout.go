@@ -0,0 +1,357 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/utils"
+	"github.com/schollz/versionedtext"
+)
+
+// blobID finds the uploads that a text uses, which are found in the
+// diffs of its history too
+var blobID = regexp.MustCompile(`sha256-[0-9a-f]+`)
+
+// takeoutPage is a page of a domain, as it is exported, with every
+// version of it
+type takeoutPage struct {
+	ID       string                      `json:"id"`
+	Slug     string                      `json:"slug"`
+	Created  time.Time                   `json:"created"`
+	Modified time.Time                   `json:"modified"`
+	Data     string                      `json:"data"`
+	Views    int                         `json:"views"`
+	Archived bool                        `json:"archived,omitempty"`
+	Deleted  bool                        `json:"deleted,omitempty"`
+	History  versionedtext.VersionedText `json:"history"`
+}
+
+// takeoutDomain is the settings of a domain, as they are exported
+type takeoutDomain struct {
+	Name          string           `json:"name"`
+	Public        bool             `json:"public"`
+	Exported      time.Time        `json:"exported"`
+	SearchWords   db.SearchWords   `json:"search_words"`
+	HistoryPolicy db.HistoryPolicy `json:"history_policy"`
+	Feeds         []db.Feed        `json:"feeds"`
+	Mirrors       []db.Mirror      `json:"mirrors"`
+	Users         []takeoutUser    `json:"users"`
+}
+
+// takeoutUser is an account with a role in a domain
+type takeoutUser struct {
+	Name   string `json:"name"`
+	Role   string `json:"role"`
+	Active bool   `json:"active"`
+}
+
+// takeoutReadme is the README.md of a takeout, which says what is in it
+const takeoutReadme = `# Takeout of the %s domain
+
+Everything that rwtxt keeps about the %s domain, as it was on %s.
+
+- domain.json: the settings of the domain, with its search words, how much
+  history it keeps, its feeds, its mirrors (without their secrets) and the
+  accounts that have a role in it
+- pages.json: every page, including the archived ones and the ones in the
+  trash, with its text now and its history. The history has the text now
+  as "CurrentText" and the changes of each version, by the time it was
+  saved in nanoseconds, as "Diffs", each a diff from the version before it
+  in the delta format of diff-match-patch
+- annotations.json, responses.json: the annotations of the pages and the
+  responses to their forms, by the id of their page
+- times.json, cards.json: the time entries and flash cards in the pages,
+  with how each card was reviewed
+- snapshots.json: the snapshots of the pages of the domain
+- analytics.json: how many times each page was viewed and how many times
+  each link out of the domain was followed
+- audit.json: the audit log of the domain, the newest event first
+- uploads.json: the uploads to the domain, with their type, size and when
+  they were uploaded
+- uploads/ID/NAME: every upload to the domain, and every upload that a
+  version of a page uses
+`
+
+// domainPages returns every page of a domain, including the archived ones
+// and the ones in the trash, with their history
+func domainPages(domain string) (pages []takeoutPage, err error) {
+	files, err := fs.GetAllCtx(db.AsMember(context.Background()), domain)
+	if err != nil {
+		return
+	}
+	archived, err := fs.GetArchived(domain)
+	if err != nil {
+		return
+	}
+	trash, err := fs.GetTrash(domain)
+	if err != nil {
+		return
+	}
+	pages = make([]takeoutPage, 0, len(files)+len(archived)+len(trash))
+	for i, f := range append(append(files, archived...), trash...) {
+		err = f.LoadHistory()
+		if err != nil {
+			return
+		}
+		p := takeoutPage{
+			ID:       f.ID,
+			Slug:     f.Slug,
+			Created:  f.Created,
+			Modified: f.Modified,
+			Data:     f.Data,
+			Views:    f.Views,
+			Archived: f.Archived,
+			History:  f.History,
+		}
+		// pages in the trash have their text in their history
+		if i >= len(files)+len(archived) {
+			p.Data = f.History.GetCurrent()
+			p.Deleted = true
+		}
+		pages = append(pages, p)
+	}
+	return
+}
+
+// handleTakeout sends everything about a domain as a zip archive, which
+// only its admins can get, at /DOMAIN/takeout
+func (tr *TemplateRender) handleTakeout(w http.ResponseWriter, r *http.Request) (err error) {
+	domainKey := r.FormValue("domain_key")
+	if domainKey == "" {
+		domainKey = tr.DomainKeys[tr.Domain]
+	}
+	if !tr.canWrite(tr.Domain, domainKey) {
+		return tr.handleMain(w, r, "need to be logged in to take out the domain")
+	}
+	if fs.KeyRole(domainKey) != db.RoleAdmin {
+		return tr.handleMain(w, r, "need to be an admin to take out the domain")
+	}
+
+	files, err := takeoutFiles(tr.Domain)
+	if err != nil {
+		return tr.handleMain(w, r, err.Error())
+	}
+	audit(r, "domain.takeout", tr.Domain, "", "")
+	writeTakeout(w, tr.Domain, files)
+	return nil
+}
+
+// handleDomainBackup sends the takeout of a domain to its admins through
+// the API, at /api/v1/DOMAIN/backup, with the key of an admin as
+// ?domain_key= or as a bearer token, so that backups of a domain can be
+// made without the dump of the whole database
+func (tr *TemplateRender) handleDomainBackup(w http.ResponseWriter, r *http.Request) (err error) {
+	if r.Method != "GET" {
+		return writeJSON(w, http.StatusMethodNotAllowed, Payload{Message: "download the backup with a GET"})
+	}
+	domain := utils.NormalizeDomain(strings.Split(strings.Trim(r.URL.Path, "/"), "/")[2])
+	domainKey := documentKey(r)
+	if !tr.canWrite(domain, domainKey) {
+		return writeJSON(w, http.StatusForbidden, Payload{Message: "need to be logged in"})
+	}
+	if fs.KeyRole(domainKey) != db.RoleAdmin {
+		return writeJSON(w, http.StatusForbidden, Payload{Message: "need to be an admin to back up the domain"})
+	}
+
+	files, err := takeoutFiles(domain)
+	if err != nil {
+		return writeJSON(w, http.StatusInternalServerError, Payload{Message: err.Error()})
+	}
+	audit(r, "domain.backup", domain, "", "")
+	writeTakeout(w, domain, files)
+	return nil
+}
+
+// writeTakeout sends the files of the takeout of a domain as a zip
+// archive
+func writeTakeout(w http.ResponseWriter, domain string, files map[string][]byte) {
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+domain+`-takeout-`+time.Now().Format("20060102")+`.zip"`)
+	w.Header().Set("Cache-Control", "no-store")
+	z := zip.NewWriter(w)
+	now := time.Now()
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f, errCreate := z.CreateHeader(&zip.FileHeader{
+			Name:     name,
+			Method:   zip.Deflate,
+			Modified: now,
+		})
+		if errCreate == nil {
+			_, errCreate = f.Write(files[name])
+		}
+		if errCreate != nil {
+			// the archive is already being sent, so it can only be cut
+			// short
+			log.Error(errCreate)
+			return
+		}
+	}
+	err := z.Close()
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+// takeoutFiles returns the files of the takeout of a domain, by their
+// names in the archive
+func takeoutFiles(domain string) (files map[string][]byte, err error) {
+	_, ispublic, err := fs.GetDomainFromName(domain)
+	if err != nil {
+		return
+	}
+	pages, err := domainPages(domain)
+	if err != nil {
+		return
+	}
+
+	settings := takeoutDomain{
+		Name:     domain,
+		Public:   ispublic,
+		Exported: time.Now().UTC(),
+		Users:    []takeoutUser{},
+	}
+	if settings.SearchWords, err = fs.GetSearchWords(domain); err != nil {
+		return
+	}
+	if settings.HistoryPolicy, err = fs.GetHistoryPolicy(domain); err != nil {
+		return
+	}
+	if settings.Feeds, err = fs.GetFeeds(domain); err != nil {
+		return
+	}
+	if settings.Mirrors, err = fs.GetMirrors(domain); err != nil {
+		return
+	}
+	for i := range settings.Mirrors {
+		settings.Mirrors[i].Secret = ""
+	}
+	users, err := fs.GetUsers()
+	if err != nil {
+		return
+	}
+	for _, u := range users {
+		if role, ok := u.Roles[domain]; ok {
+			settings.Users = append(settings.Users, takeoutUser{Name: u.Name, Role: role, Active: u.Active})
+		}
+	}
+
+	annotations := make(map[string][]db.Annotation)
+	responses := make(map[string][]db.Response)
+	views := make(map[string]int)
+	blobs := make(map[string]bool)
+	for _, p := range pages {
+		views[p.ID] = p.Views
+		var pageAnnotations []db.Annotation
+		if pageAnnotations, err = fs.GetAnnotations(p.ID, domain); err != nil {
+			return
+		}
+		if len(pageAnnotations) > 0 {
+			annotations[p.ID] = pageAnnotations
+		}
+		var pageResponses []db.Response
+		if pageResponses, err = fs.GetResponses(p.ID, domain); err != nil {
+			return
+		}
+		if len(pageResponses) > 0 {
+			responses[p.ID] = pageResponses
+		}
+		texts := []string{p.Data}
+		for _, diff := range p.History.Diffs {
+			texts = append(texts, diff)
+		}
+		for _, id := range blobID.FindAllString(strings.Join(texts, "\n"), -1) {
+			blobs[id] = true
+		}
+	}
+	times, err := fs.GetTimeEntries(domain)
+	if err != nil {
+		return
+	}
+	cards, err := fs.GetCards(domain)
+	if err != nil {
+		return
+	}
+	snapshots, err := fs.GetSnapshots(domain)
+	if err != nil {
+		return
+	}
+	clicks, err := fs.GetClicks(domain, -1)
+	if err != nil {
+		return
+	}
+	events, err := fs.GetAudit(domain, -1)
+	if err != nil {
+		return
+	}
+	uploads, err := fs.ListBlobs(domain)
+	if err != nil {
+		return
+	}
+	for _, b := range uploads {
+		blobs[b.ID] = true
+	}
+
+	files = make(map[string][]byte)
+	files["README.md"] = []byte(fmt.Sprintf(takeoutReadme, domain, domain, settings.Exported.Format("Jan 2 2006 15:04 MST")))
+	for name, v := range map[string]interface{}{
+		"domain.json":      settings,
+		"pages.json":       pages,
+		"annotations.json": annotations,
+		"responses.json":   responses,
+		"times.json":       times,
+		"cards.json":       cards,
+		"snapshots.json":   takeoutSnapshots(snapshots),
+		"analytics.json": map[string]interface{}{
+			"views":  views,
+			"clicks": clicks,
+		},
+		"audit.json":   events,
+		"uploads.json": uploads,
+	} {
+		files[name], err = json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return
+		}
+	}
+	for id := range blobs {
+		name, data, errBlob := readBlob(id)
+		if errBlob != nil {
+			log.Warnf("could not take out %s: %s", id, errBlob.Error())
+			continue
+		}
+		files["uploads/"+id+"/"+path.Base(name)] = data
+	}
+	return
+}
+
+// takeoutSnapshots returns the snapshots of a domain with only what they
+// keep of each page
+func takeoutSnapshots(snapshots []db.Snapshot) (taken []map[string]interface{}) {
+	taken = []map[string]interface{}{}
+	for _, s := range snapshots {
+		pages := make([]map[string]string, len(s.Files))
+		for i, f := range s.Files {
+			pages[i] = map[string]string{"id": f.ID, "slug": f.Slug, "data": f.Data}
+		}
+		taken = append(taken, map[string]interface{}{
+			"created": s.Created,
+			"pages":   pages,
+		})
+	}
+	return
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// timeReport is the time spent in a domain, totaled one way
+type timeReport struct {
+	By     string
+	Totals []db.TimeTotal
+}
+
+// handleTimeReport returns the time spent in a domain, totaled by "tag",
+// "page" or "week"
+func (tr *TemplateRender) handleTimeReport(w http.ResponseWriter, r *http.Request) (err error) {
+	domain := utils.NormalizeDomain(r.FormValue("domain"))
+	by := r.FormValue("by")
+	if by == "" {
+		by = "tag"
+	}
+	if domain == "public" || !tr.canRead(domain, r.FormValue("domain_key")) {
+		return writeJSON(w, http.StatusForbidden, Payload{Message: "need to be logged in"})
+	}
+	totals, err := fs.GetTimeReport(domain, by)
+	if err != nil {
+		return writeJSON(w, http.StatusBadRequest, Payload{Message: err.Error()})
+	}
+	return writeJSON(w, http.StatusOK, totals)
+}
+
+// handleTimes lists the time spent in a domain by tag, page and week,
+// along with the pages that have time in them
+func (tr *TemplateRender) handleTimes(w http.ResponseWriter, r *http.Request) (err error) {
+	for _, by := range []string{"tag", "page", "week"} {
+		totals, errReport := fs.GetTimeReport(tr.Domain, by)
+		if errReport != nil {
+			return tr.handleMain(w, r, errReport.Error())
+		}
+		tr.TimeReports = append(tr.TimeReports, timeReport{By: by, Totals: totals})
+	}
+
+	entries, err := fs.GetTimeEntries(tr.Domain)
+	if err != nil {
+		return tr.handleMain(w, r, err.Error())
+	}
+	var files []db.File
+	seen := make(map[string]bool)
+	for _, e := range entries {
+		if !seen[e.FileID] {
+			seen[e.FileID] = true
+			files = append(files, db.File{ID: e.FileID, Slug: e.Slug, Modified: e.Day})
+		}
+	}
+	return tr.handleList(w, r, "Time", files, 0, len(files))
+}
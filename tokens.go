@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// TokenRequest makes an API token with some of the scopes read, write and
+// admin, which expires after some days, or never when it is zero
+type TokenRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	Days   int      `json:"days,omitempty"`
+}
+
+// TokenResponse is an API token that was just made, the only time that
+// the token itself is shown
+type TokenResponse struct {
+	db.Token
+	Secret string `json:"token"`
+}
+
+// handleTokens makes an API token for a domain, or revokes one with
+// action=revoke, from the settings of the domain
+func (tr *TemplateRender) handleTokens(w http.ResponseWriter, r *http.Request) (err error) {
+	tr.DomainKey = strings.TrimSpace(strings.ToLower(r.FormValue("domain_key")))
+	tr.Domain = utils.NormalizeDomain(r.FormValue("domain"))
+	if tr.Domain == "public" || tr.Domain == "" {
+		tr.Domain = "public"
+		return tr.handleMain(w, r, "cannot modify public")
+	}
+
+	// check that the key is valid
+	domainFound, err := fs.CheckKey(tr.DomainKey)
+	if err != nil || tr.Domain != domainFound {
+		return tr.handleMain(w, r, "need to be logged in to change tokens")
+	}
+	if fs.KeyRole(tr.DomainKey) != db.RoleAdmin {
+		return tr.handleMain(w, r, "need to be an admin to change settings")
+	}
+
+	if r.FormValue("action") == "revoke" {
+		id, _ := strconv.Atoi(r.FormValue("id"))
+		err = fs.RevokeToken(tr.Domain, id)
+		if err != nil {
+			return tr.handleMain(w, r, err.Error())
+		}
+		audit(r, "token.revoked", tr.Domain, "", strconv.Itoa(id))
+		return tr.handleMain(w, r, "revoked the token")
+	}
+	days, _ := strconv.Atoi(r.FormValue("days"))
+	token, t, err := fs.CreateNamedToken(tr.Domain, r.FormValue("name"), strings.Split(r.FormValue("scope"), ","), time.Duration(days)*24*time.Hour)
+	if err != nil {
+		return tr.handleMain(w, r, err.Error())
+	}
+	audit(r, "token.created", tr.Domain, "", t.Name+" can "+strings.Join(t.Scopes, ","))
+	return tr.handleMain(w, r, "new token "+token+" (copy it now, it will not be shown again)")
+}
+
+// handleDomainTokens lists (GET) and makes (POST) the API tokens of a domain
+// at /api/v1/{domain}/tokens, and revokes one (DELETE) at
+// /api/v1/{domain}/tokens/{id}. Only admins of the domain can use it.
+func (tr *TemplateRender) handleDomainTokens(w http.ResponseWriter, r *http.Request) (err error) {
+	fields := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	domain := utils.NormalizeDomain(fields[2])
+	domainKey := documentKey(r)
+	if !tr.canWrite(domain, domainKey) {
+		return writeJSON(w, http.StatusForbidden, Payload{Message: "need to be logged in"})
+	}
+	if domainKey == "" {
+		domainKey = tr.DomainKeys[domain]
+	}
+	if domain == "public" || fs.KeyRole(domainKey) != db.RoleAdmin {
+		return writeJSON(w, http.StatusForbidden, Payload{Message: "need to be an admin to change tokens"})
+	}
+
+	if len(fields) == 5 {
+		if r.Method != "DELETE" {
+			return writeJSON(w, http.StatusMethodNotAllowed, Payload{Message: "use DELETE"})
+		}
+		id, errID := strconv.Atoi(fields[4])
+		if errID != nil {
+			return writeJSON(w, http.StatusBadRequest, Payload{Message: "token id must be a number"})
+		}
+		err = fs.RevokeToken(domain, id)
+		if err != nil {
+			return writeJSON(w, http.StatusNotFound, Payload{Message: err.Error()})
+		}
+		audit(r, "token.revoked", domain, "", fields[4])
+		return writeJSON(w, http.StatusOK, Payload{Success: true, Message: "revoked the token"})
+	}
+
+	switch r.Method {
+	case "GET":
+		tokens, errList := fs.ListTokens(domain)
+		if errList != nil {
+			return writeJSON(w, http.StatusInternalServerError, Payload{Message: errList.Error()})
+		}
+		return writeJSON(w, http.StatusOK, tokens)
+	case "POST":
+		var req TokenRequest
+		err = json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			return writeJSON(w, http.StatusBadRequest, Payload{Message: err.Error()})
+		}
+		token, t, errCreate := fs.CreateNamedToken(domain, req.Name, req.Scopes, time.Duration(req.Days)*24*time.Hour)
+		if errCreate != nil {
+			return writeJSON(w, http.StatusBadRequest, Payload{Message: errCreate.Error()})
+		}
+		audit(r, "token.created", domain, "", t.Name+" can "+strings.Join(t.Scopes, ","))
+		return writeJSON(w, http.StatusCreated, TokenResponse{Token: t, Secret: token})
+	default:
+		return writeJSON(w, http.StatusMethodNotAllowed, Payload{Message: "use GET or POST"})
+	}
+}
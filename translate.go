@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// translator is the command, or the url of a LibreTranslate server, that
+// translates pages into other languages
+var translator string
+
+// translatorKey is the api key for the translation server, if it needs one
+var translatorKey string
+
+// translateText translates markdown from a language into another. The
+// language it is from is "auto" when it is not known.
+func translateText(text, from, to string) (translated string, err error) {
+	if from == "" {
+		from = "auto"
+	}
+	if strings.HasPrefix(translator, "http://") || strings.HasPrefix(translator, "https://") {
+		return translateWithServer(text, from, to)
+	}
+
+	// the command is given the languages, and reads the markdown and
+	// writes its translation
+	cmd := exec.Command(translator, from, to)
+	cmd.Stdin = strings.NewReader(text)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if err != nil {
+		return "", fmt.Errorf("translator: %s %s", err.Error(), stderr.String())
+	}
+	return stdout.String(), nil
+}
+
+// translateWithServer translates markdown with the /translate api of a
+// LibreTranslate server
+func translateWithServer(text, from, to string) (translated string, err error) {
+	options := map[string]interface{}{
+		"q":      text,
+		"source": from,
+		"target": to,
+		"format": "text",
+	}
+	if translatorKey != "" {
+		options["api_key"] = translatorKey
+	}
+	body, err := json.Marshal(options)
+	if err != nil {
+		return
+	}
+	url := strings.TrimSuffix(translator, "/")
+	if !strings.HasSuffix(url, "/translate") {
+		url += "/translate"
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := http.Client{Timeout: 60 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	out, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return
+	}
+	var result struct {
+		TranslatedText string `json:"translatedText"`
+		Error          string `json:"error"`
+	}
+	err = json.Unmarshal(out, &result)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		if result.Error == "" {
+			result.Error = strings.TrimSpace(string(out))
+		}
+		return "", errors.New("translator: " + result.Error)
+	}
+	return result.TranslatedText, nil
+}
+
+// translationName is the name of the translation of a page into a
+// language, which is its slug, or its id when it has none, with the
+// language after it
+func translationName(f db.File, lang string) string {
+	if f.Slug == "" {
+		return f.ID + "." + lang
+	}
+	return f.Slug + "." + lang
+}
+
+// translationLink is the page with the translation of a page into a
+// language
+func translationLink(domain string, f db.File, lang string) string {
+	return "/" + domain + "/" + translationName(f, lang)
+}
+
+// translationPath returns the page that a page name like "slug.fr" is a
+// translation of, and the language
+func translationPath(ctx context.Context, domain, page string) (source db.File, lang string, ok bool) {
+	i := strings.LastIndex(page, ".")
+	if i <= 0 {
+		return
+	}
+	lang = db.CleanLanguage(page[i+1:])
+	if lang == "" {
+		return
+	}
+	files, err := fs.GetCtx(ctx, page[:i], domain)
+	if err != nil || len(files) != 1 {
+		return
+	}
+	return files[0], lang, true
+}
+
+// translatePage translates a page into a language, saving the translation
+// into the page that has it already or else into a new page
+func translatePage(r *http.Request, domain string, source db.File, lang string) (f db.File, err error) {
+	meta, body := utils.ParseFrontMatter(source.Data)
+	from := source.Language
+	if from == "" {
+		from, _ = fs.GetDomainLanguage(domain)
+	}
+	if from == lang {
+		return f, errors.New("page is in " + lang + " already")
+	}
+	translated, err := translateText(body, from, lang)
+	if err != nil {
+		return
+	}
+	header := "---\nlang: " + lang + "\n"
+	if meta["tags"] != "" {
+		header += "tags: " + meta["tags"] + "\n"
+	}
+	data := header + "---\n" + strings.TrimSpace(translated)
+
+	f = fs.NewFile(translationName(source, lang), data)
+	if t, errGet := fs.GetTranslation(source.ID, lang); errGet == nil {
+		files, errFile := fs.GetCtx(db.AsMember(context.Background()), t.TranslationID, domain)
+		if errFile == nil && len(files) == 1 {
+			// translating again replaces the translation, which keeps
+			// the one before in its history
+			f = files[0]
+			f.Data = data
+		}
+	}
+	f.Domain = domain
+	f.Source = remoteIP(r)
+	err = fs.Save(f)
+	if err != nil {
+		return
+	}
+	err = fs.SetTranslation(source.ID, lang, f.ID, db.VersionHash(source.Data))
+	if err != nil {
+		return
+	}
+	audit(r, "page.translated", domain, source.ID, lang)
+	return
+}
+
+// handleTranslation shows the translation of a page into a language, at
+// /DOMAIN/SLUG.LANG, and translates the page when it is not translated yet
+func (tr *TemplateRender) handleTranslation(w http.ResponseWriter, r *http.Request, source db.File, lang string) (err error) {
+	t, errGet := fs.GetTranslation(source.ID, lang)
+	if errGet == nil {
+		if exists, _ := fs.Exists(t.TranslationID, tr.Domain); exists {
+			tr.Page = t.TranslationID
+			return tr.handleViewEdit(w, r)
+		}
+	}
+	if translator == "" {
+		return tr.handleMain(w, r, "translating needs a translator")
+	}
+	if !tr.canWrite(tr.Domain, "") {
+		return tr.handleMain(w, r, "need to be logged in to translate")
+	}
+	_, err = translatePage(r, tr.Domain, source, lang)
+	if err != nil {
+		log.Error(err)
+		return tr.handleMain(w, r, "could not translate: "+err.Error())
+	}
+	http.Redirect(w, r, translationLink(tr.Domain, source, lang), http.StatusFound)
+	return nil
+}
+
+// handleTranslate translates a page into a language, or translates it
+// again when it changed since it was translated (POST)
+func (tr *TemplateRender) handleTranslate(w http.ResponseWriter, r *http.Request) (err error) {
+	domain := utils.NormalizeDomain(r.FormValue("domain"))
+	if domain == "" {
+		domain = "public"
+	}
+	tr.Domain = domain
+	if translator == "" {
+		return tr.handleMain(w, r, "translating needs a translator")
+	}
+	if r.Method != "POST" {
+		return tr.handleMain(w, r, "translate with a POST")
+	}
+	if !tr.canWrite(domain, r.FormValue("domain_key")) {
+		return tr.handleMain(w, r, "need to be logged in to translate")
+	}
+	lang := db.CleanLanguage(r.FormValue("lang"))
+	if lang == "" {
+		return tr.handleMain(w, r, "need a language to translate into, like \"fr\"")
+	}
+	files, err := fs.GetCtx(db.AsMember(r.Context()), r.FormValue("page"), domain)
+	if err != nil || len(files) != 1 {
+		return tr.handleMain(w, r, "page does not exist")
+	}
+	_, err = translatePage(r, domain, files[0], lang)
+	if err != nil {
+		log.Error(err)
+		return tr.handleMain(w, r, "could not translate: "+err.Error())
+	}
+	http.Redirect(w, r, translationLink(domain, files[0], lang), http.StatusFound)
+	return nil
+}
+
+// pageTranslation is a translation of a page as it is shown with the page
+type pageTranslation struct {
+	db.Translation
+	Link  string
+	Stale bool
+}
+
+// setTranslations shows the translations of a page with it, or the page
+// it is a translation of and whether that changed since
+func (tr *TemplateRender) setTranslations(ctx context.Context, f db.File) {
+	ts, _ := fs.GetTranslations(f.ID)
+	for _, t := range ts {
+		if exists, _ := fs.Exists(t.TranslationID, tr.Domain); !exists {
+			continue
+		}
+		tr.Translations = append(tr.Translations, pageTranslation{
+			Translation: t,
+			Link:        translationLink(tr.Domain, f, t.Language),
+			Stale:       t.Stale(f.Data),
+		})
+	}
+
+	t, ok, _ := fs.TranslationOf(f.ID)
+	if !ok {
+		return
+	}
+	files, err := fs.GetCtx(ctx, t.ID, tr.Domain)
+	if err != nil || len(files) != 1 {
+		return
+	}
+	tr.TranslationOf = &pageTranslation{
+		Translation: t,
+		Link:        "/" + tr.Domain + "/" + files[0].ID,
+		Stale:       t.Stale(files[0].Data),
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// trashLifetime is how long pages stay in the trash before they are
+// removed for good
+var trashLifetime = 30 * 24 * time.Hour
+
+// handleDelete puts the page with the form's id in the trash, or takes it
+// out when restore is "on"
+func (tr *TemplateRender) handleDelete(w http.ResponseWriter, r *http.Request) (err error) {
+	r.ParseForm()
+	tr.DomainKey = strings.TrimSpace(strings.ToLower(r.FormValue("domain_key")))
+	tr.Domain = utils.NormalizeDomain(r.FormValue("domain"))
+	id := r.FormValue("id")
+	restore := strings.TrimSpace(r.FormValue("restore")) == "on"
+
+	// check that the key is valid
+	domainFound, err := fs.CheckKey(tr.DomainKey)
+	if err != nil || tr.Domain != domainFound {
+		return tr.handleMain(w, r, "need to be logged in to delete")
+	}
+
+	if restore {
+		err = fs.Restore(id, tr.Domain)
+		if err != nil {
+			return tr.handleMain(w, r, err.Error())
+		}
+		audit(r, "page.restored", tr.Domain, id, "")
+		http.Redirect(w, r, "/"+tr.Domain+"/"+id, 302)
+		return nil
+	}
+	err = fs.Delete(id, tr.Domain)
+	if err != nil {
+		return tr.handleMain(w, r, err.Error())
+	}
+	audit(r, "page.deleted", tr.Domain, id, "")
+	return tr.handleMain(w, r, "moved the page to the trash")
+}
+
+// handleTrash lists the pages in the trash of a domain, which can be
+// restored
+func (tr *TemplateRender) handleTrash(w http.ResponseWriter, r *http.Request) (err error) {
+	if !tr.SignedIn {
+		return tr.handleMain(w, r, "need to log in to see the trash")
+	}
+	files, err := fs.GetTrash(tr.Domain)
+	if err != nil {
+		return tr.handleMain(w, r, err.Error())
+	}
+	tr.Trash = true
+	return tr.handleList(w, r, "Trash", files, 0, len(files))
+}
+
+// purgeTrash removes the pages that have been in the trash for longer
+// than the trashLifetime
+func purgeTrash() {
+	if trashLifetime <= 0 {
+		return
+	}
+	purged, err := fs.PurgeTrash("", time.Now().Add(-trashLifetime))
+	if err != nil {
+		log.Error(err)
+	} else if purged > 0 {
+		log.Infof("removed %d pages from the trash", purged)
+	}
+}
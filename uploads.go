@@ -0,0 +1,24 @@
+package main
+
+import (
+	"compress/gzip"
+	"net/http"
+)
+
+// handleUploadList lists the uploads of a domain at /DOMAIN/uploads,
+// which only who can read the domain can see
+func (tr *TemplateRender) handleUploadList(w http.ResponseWriter, r *http.Request) (err error) {
+	if !tr.canRead(tr.Domain, "") {
+		return tr.handleMain(w, r, "need to log in to see the uploads")
+	}
+	tr.Blobs, err = fs.ListBlobs(tr.Domain)
+	if err != nil {
+		return tr.handleMain(w, r, err.Error())
+	}
+	tr.Title = "Uploads"
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Set("Content-Type", "text/html")
+	gz := gzip.NewWriter(w)
+	defer gz.Close()
+	return uploadsTemplate.Execute(gz, tr)
+}
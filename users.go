@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// provisionToken is the bearer token that an identity system uses to
+// provision users
+var provisionToken string
+
+// UserRequest creates or changes a user. Fields that are left out keep
+// their value when patching.
+type UserRequest struct {
+	Name     string            `json:"name"`
+	Password string            `json:"password,omitempty"`
+	Active   *bool             `json:"active,omitempty"`
+	Roles    map[string]string `json:"roles,omitempty"`
+}
+
+// handleUsers lists (GET) and creates (POST) users at /api/v1/users, and
+// gets (GET), replaces (PUT), changes (PATCH) and disables (DELETE) a user
+// at /api/v1/users/{name}. Disabled users are kept for the audit log.
+func (tr *TemplateRender) handleUsers(w http.ResponseWriter, r *http.Request) (err error) {
+	if provisionToken == "" {
+		return writeJSON(w, http.StatusNotFound, Payload{Message: "provisioning is not enabled"})
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(provisionToken)) != 1 {
+		return writeJSON(w, http.StatusUnauthorized, Payload{Message: "need the provisioning token"})
+	}
+
+	name := strings.ToLower(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/users"), "/"))
+	if name == "" {
+		switch r.Method {
+		case "GET":
+			users, errGet := fs.GetUsers()
+			if errGet != nil {
+				return writeJSON(w, http.StatusInternalServerError, Payload{Message: errGet.Error()})
+			}
+			return writeJSON(w, http.StatusOK, users)
+		case "POST":
+			var req UserRequest
+			err = json.NewDecoder(r.Body).Decode(&req)
+			if err != nil {
+				return writeJSON(w, http.StatusBadRequest, Payload{Message: err.Error()})
+			}
+			req.Name = strings.ToLower(strings.TrimSpace(req.Name))
+			if _, errGet := fs.GetUser(req.Name); errGet == nil {
+				return writeJSON(w, http.StatusConflict, Payload{Message: "user " + req.Name + " already exists"})
+			}
+			return tr.setUser(w, r, db.User{Name: req.Name, Active: true}, req, "user.created", http.StatusCreated)
+		}
+		return writeJSON(w, http.StatusMethodNotAllowed, Payload{Message: "use GET or POST"})
+	}
+
+	u, err := fs.GetUser(name)
+	if err != nil {
+		return writeJSON(w, http.StatusNotFound, Payload{Message: err.Error()})
+	}
+	switch r.Method {
+	case "GET":
+		return writeJSON(w, http.StatusOK, u)
+	case "PUT", "PATCH":
+		var req UserRequest
+		err = json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			return writeJSON(w, http.StatusBadRequest, Payload{Message: err.Error()})
+		}
+		if r.Method == "PUT" {
+			// everything is replaced, so a missing field is its default
+			u.Active = true
+			u.Roles = map[string]string{}
+		}
+		req.Name = u.Name
+		return tr.setUser(w, r, u, req, "user.updated", http.StatusOK)
+	case "DELETE":
+		u.Active = false
+		return tr.setUser(w, r, u, UserRequest{Name: u.Name}, "user.disabled", http.StatusOK)
+	}
+	return writeJSON(w, http.StatusMethodNotAllowed, Payload{Message: "use GET, PUT, PATCH or DELETE"})
+}
+
+// setUser saves a user with the changes in a request and responds with it
+func (tr *TemplateRender) setUser(w http.ResponseWriter, r *http.Request, u db.User, req UserRequest, event string, status int) (err error) {
+	u.Name = req.Name
+	if req.Active != nil {
+		u.Active = *req.Active
+	}
+	if req.Roles != nil {
+		u.Roles = make(map[string]string)
+		for domain, role := range req.Roles {
+			u.Roles[utils.NormalizeDomain(domain)] = role
+		}
+	}
+	err = fs.SetUser(u, req.Password)
+	if err != nil {
+		return writeJSON(w, http.StatusBadRequest, Payload{Message: err.Error()})
+	}
+	if event == "user.updated" && !u.Active {
+		event = "user.disabled"
+	}
+	for domain := range u.Roles {
+		audit(r, event, domain, "", u.Name)
+	}
+	if len(u.Roles) == 0 {
+		audit(r, event, "", "", u.Name)
+	}
+	u, err = fs.GetUser(u.Name)
+	if err != nil {
+		return writeJSON(w, http.StatusInternalServerError, Payload{Message: err.Error()})
+	}
+	return writeJSON(w, status, u)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// readContext returns the context for reading the pages of a domain with
+// a key, or with the keys in the cookie, which shows its unlisted and
+// private pages only to the members of it
+func (tr *TemplateRender) readContext(r *http.Request, domain, domainKey string) context.Context {
+	if domain == "public" {
+		return db.AsMember(r.Context())
+	}
+	if domainKey == "" {
+		domainKey = tr.DomainKeys[domain]
+	}
+	if domainFound, err := fs.CheckReadKey(domainKey); err == nil && domainFound == domain {
+		return db.AsMember(r.Context())
+	}
+	return r.Context()
+}
+
+// handleVisibility makes pages of a domain listed, unlisted or private
+func (tr *TemplateRender) handleVisibility(w http.ResponseWriter, r *http.Request) (err error) {
+	r.ParseForm()
+	tr.DomainKey = strings.TrimSpace(strings.ToLower(r.FormValue("domain_key")))
+	tr.Domain = utils.NormalizeDomain(r.FormValue("domain"))
+	visibility := r.FormValue("visibility")
+	if tr.Domain == "public" || tr.Domain == "" {
+		tr.Domain = "public"
+		return tr.handleMain(w, r, "cannot modify public")
+	}
+
+	// check that the key is valid
+	domainFound, err := fs.CheckKey(tr.DomainKey)
+	if err != nil || tr.Domain != domainFound {
+		return tr.handleMain(w, r, "need to be logged in to change who can see pages")
+	}
+
+	id := r.FormValue("id")
+	err = fs.SetVisibility(tr.Domain, id, visibility)
+	if err != nil {
+		return tr.handleMain(w, r, err.Error())
+	}
+	if visibility == "" {
+		visibility = "listed"
+	}
+	audit(r, "page.updated", tr.Domain, id, "made "+visibility)
+	http.Redirect(w, r, "/"+tr.Domain+"/"+id, http.StatusFound)
+	return nil
+}
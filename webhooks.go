@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+)
+
+// webhook is the url that is sent the events that operators need to know
+// about, like failed dumps
+var webhook string
+
+// storageLimit is the size of the database, in megabytes, to warn about
+var storageLimit int64
+
+// dumpDatabase is whether the database is regularly dumped to a gzipped
+// SQL file next to it, as a backup
+var dumpDatabase = true
+
+// storageWarnings are the fractions of the storage limit that are warned
+// about when the database grows past them
+var storageWarnings = []float64{0.8, 0.9, 1}
+
+// lastStorageWarning is the largest fraction warned about, so that each is
+// only sent once until the database shrinks again
+var lastStorageWarning float64
+
+// Event is the JSON that is posted to the webhook
+type Event struct {
+	Event   string            `json:"event"`
+	Time    time.Time         `json:"time"`
+	Message string            `json:"message"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// sendEvent posts an event to the webhook, trying a few times if it fails
+func sendEvent(name, message string, details map[string]string) {
+	if webhook == "" {
+		return
+	}
+	body, err := json.Marshal(Event{
+		Event:   name,
+		Time:    time.Now().UTC(),
+		Message: message,
+		Details: details,
+	})
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	go func() {
+		client := http.Client{Timeout: 10 * time.Second}
+		for try := 0; try < 3; try++ {
+			if try > 0 {
+				time.Sleep(time.Duration(try*try) * 10 * time.Second)
+			}
+			resp, err := client.Post(webhook, "application/json", bytes.NewReader(body))
+			if err != nil {
+				log.Warnf("could not send %s: %s", name, err.Error())
+				continue
+			}
+			resp.Body.Close()
+			if resp.StatusCode < 300 {
+				return
+			}
+			log.Warnf("could not send %s: %s", name, resp.Status)
+		}
+	}()
+}
+
+// checkDatabase checks the database and its dump after a dump, and sends
+// events for the dump and for any problems it finds
+func checkDatabase(errDump error) {
+	problems, err := fs.CheckIntegrity()
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+	if len(problems) > 0 {
+		log.Errorf("integrity check failed: %s", strings.Join(problems, "; "))
+		sendEvent("integrity.failed", "integrity check of "+dbName+" found problems", map[string]string{
+			"problems": strings.Join(problems, "\n"),
+		})
+	}
+
+	if !dumpDatabase {
+		checkStorage()
+		return
+	}
+	if errDump == nil {
+		var size int64
+		size, errDump = fs.CheckDump()
+		if errDump == nil {
+			recordDump(size)
+			validateDump()
+			sendEvent("dump.succeeded", "dumped "+dbName, map[string]string{
+				"file": dbName + ".sql.gz",
+				"size": fmt.Sprint(size),
+			})
+		}
+	}
+	if errDump != nil {
+		sendEvent("dump.failed", "could not dump "+dbName, map[string]string{
+			"file":  dbName + ".sql.gz",
+			"error": errDump.Error(),
+		})
+	}
+
+	checkStorage()
+}
+
+// checkStorage sends an event when the database grows past one of the
+// warnings of the storage limit
+func checkStorage() {
+	if storageLimit <= 0 {
+		return
+	}
+	stat, err := os.Stat(dbName)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	size := stat.Size()
+	// the write-ahead log is part of the database until it is checkpointed
+	if wal, errWAL := os.Stat(dbName + "-wal"); errWAL == nil {
+		size += wal.Size()
+	}
+	used := float64(size) / float64(storageLimit*1024*1024)
+	warning := 0.0
+	for _, w := range storageWarnings {
+		if used >= w {
+			warning = w
+		}
+	}
+	if warning > lastStorageWarning {
+		sendEvent("storage.threshold", fmt.Sprintf("%s is using %.0f%% of its storage", dbName, used*100), map[string]string{
+			"size":  fmt.Sprint(size),
+			"limit": fmt.Sprint(storageLimit * 1024 * 1024),
+		})
+	}
+	lastStorageWarning = warning
+}
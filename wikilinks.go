@@ -0,0 +1,24 @@
+package main
+
+import (
+	log "github.com/cihub/seelog"
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// linkWikiLinks makes the [[Page]] links of a page into links to the pages
+// of its domain with their slugs. Links to pages that are not written yet
+// are red, and lead to the editor of the page.
+func linkWikiLinks(domain, body string) string {
+	return utils.LinkWikiLinks(body, func(slug, label string) string {
+		slug = db.UnreservedSlug(slug)
+		exists, err := fs.Exists(slug, domain)
+		if err != nil {
+			log.Debug(err)
+		}
+		if !exists {
+			return `<a href="/` + domain + `/` + slug + `" class="wikilink-new">` + label + `</a>`
+		}
+		return "[" + label + "](/" + domain + "/" + slug + ")"
+	})
+}